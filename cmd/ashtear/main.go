@@ -6,16 +6,48 @@ package main
 */
 import "C"
 import (
+	"time"
 	"unsafe"
 
+	"github.com/davidyorr/LuccaGB/internal/clock"
 	"github.com/davidyorr/LuccaGB/internal/gameboy"
 )
 
 var gb *gameboy.Gameboy
 
+// Runs must be reproducible, so cartridge clocks are driven by emulated time
+// starting from the unix epoch unless SetClockMode says otherwise.
+var clockKind = clock.KindEmulated
+var clockStart = time.Unix(0, 0)
+
+func newGameboy(model gameboy.Model) *gameboy.Gameboy {
+	gb := gameboy.New(model)
+	gb.SetClock(clock.New(clockKind, clockStart))
+
+	return gb
+}
+
 //export Init
 func Init() {
-	gb = gameboy.New()
+	gb = newGameboy(gameboy.ModelDmg)
+}
+
+// SetClockMode selects the wall time source for cartridge real-time clocks.
+// mode: 1 = real time, 2 = emulated (derived from T-cycles), 3 = fixed
+// start: unix seconds the emulated or fixed clock starts at
+//
+//export SetClockMode
+func SetClockMode(mode C.int, start C.longlong) {
+	kind := clock.Kind(mode)
+	if kind != clock.KindRealTime && kind != clock.KindEmulated && kind != clock.KindFixed {
+		return
+	}
+
+	clockKind = kind
+	clockStart = time.Unix(int64(start), 0)
+	if gb != nil {
+		gb.SetClock(clock.New(clockKind, clockStart))
+	}
 }
 
 //export Step
@@ -23,12 +55,17 @@ func Step(cycles C.int) {
 	gb.StepFrames(int(cycles))
 }
 
+// LoadRom returns 0 on success, or -1 if the ROM can't be run.
+//
 //export LoadRom
-func LoadRom(data *C.uint8_t, length C.int) {
-	gb = gameboy.New()
-
+func LoadRom(data *C.uint8_t, length C.int) C.int {
 	rom := C.GoBytes(unsafe.Pointer(data), length)
-	gb.LoadRom(rom)
+	gb = newGameboy(gameboy.ModelForRom(rom))
+	if _, err := gb.LoadRom(rom); err != nil {
+		return -1
+	}
+
+	return 0
 }
 
 //export SetJoypad
@@ -36,6 +73,14 @@ func SetJoypad(state C.uint8_t) {
 	gb.SetJoypadState(uint8(state))
 }
 
+// SetTilt sets the accelerometer input for tilt sensing carts, in g.
+// Positive x is tilted right, positive y is tilted down.
+//
+//export SetTilt
+func SetTilt(x C.double, y C.double) {
+	gb.SetTilt(float64(x), float64(y))
+}
+
 var frameCache [72 * 80]uint8
 
 //export GetFrame
@@ -66,6 +111,23 @@ func GetFrameNative() *C.uint8_t {
 	return (*C.uint8_t)(unsafe.Pointer(&nativeFrameCache[0]))
 }
 
+var colorFrameCache [144 * 160]uint16
+
+// GetFrameColor returns the frame in RGB555, for CGB games.
+//
+//export GetFrameColor
+func GetFrameColor() *C.uint16_t {
+	frame := gb.ColorFrameBuffer()
+	i := 0
+	for y := 0; y < 144; y++ {
+		for x := 0; x < 160; x++ {
+			colorFrameCache[i] = frame[y][x]
+			i++
+		}
+	}
+	return (*C.uint16_t)(unsafe.Pointer(&colorFrameCache[0]))
+}
+
 //export ReadMemory
 func ReadMemory(address C.uint16_t) C.uint8_t {
 	return C.uint8_t(gb.ReadMemory(uint16(address)))
@@ -92,15 +154,7 @@ type SerializedData struct {
 
 //export GetSerializedState
 func GetSerializedState(outLength *C.int) *C.uint8_t {
-	// Fast-forward to a safe boundary.
-	safetyLimit := 20
-	for !gb.IsSafeToSerialize() && safetyLimit > 0 {
-		gb.Step()
-		safetyLimit--
-	}
-
-	const BufferSize = 256 * 1024 // 256KB
-	buf := make([]byte, BufferSize)
+	buf := make([]byte, gameboy.StateBufferSize)
 	stateData := gb.SerializeState(buf)
 
 	// Allocate C memory and copy the data
@@ -118,10 +172,17 @@ func FreeSerializedState(ptr *C.uint8_t) {
 	C.free(unsafe.Pointer(ptr))
 }
 
+// LoadSerializedState returns 0 on success, or -1 if the state can't be loaded
+// into the running game, which is left as it was.
+//
 //export LoadSerializedState
-func LoadSerializedState(data *C.uint8_t, length C.int) {
+func LoadSerializedState(data *C.uint8_t, length C.int) C.int {
 	stateData := C.GoBytes(unsafe.Pointer(data), length)
-	gb.DeserializeState(stateData)
+	if err := gb.DeserializeState(stateData); err != nil {
+		return -1
+	}
+
+	return 0
 }
 
 func main() {}
@@ -7,6 +7,7 @@ import (
 	"unsafe"
 
 	"github.com/davidyorr/LuccaGB/internal/apu"
+	"github.com/davidyorr/LuccaGB/internal/camera"
 	"github.com/davidyorr/LuccaGB/internal/gameboy"
 	"github.com/davidyorr/LuccaGB/internal/joypad"
 	"github.com/davidyorr/LuccaGB/internal/logger"
@@ -16,6 +17,7 @@ func main() {
 	logger.Info("Hello LuccaGB!")
 
 	js.Global().Set("loadRom", js.FuncOf(loadRom))
+	js.Global().Set("setBootRom", js.FuncOf(setBootRom))
 	js.Global().Set("getCartridgeRam", js.FuncOf(getCartridgeRam))
 	js.Global().Set("setCartridgeRam", js.FuncOf(setCartridgeRam))
 	js.Global().Set("processEmulatorCycles", js.FuncOf(processEmulatorCycles))
@@ -23,6 +25,8 @@ func main() {
 	js.Global().Set("pollAudioBuffer", js.FuncOf(pollAudioBuffer))
 	js.Global().Set("handleJoypadButtonPressed", js.FuncOf(handleJoypadButtonPressed))
 	js.Global().Set("handleJoypadButtonReleased", js.FuncOf(handleJoypadButtonReleased))
+	js.Global().Set("setTilt", js.FuncOf(setTilt))
+	js.Global().Set("setCameraFrame", js.FuncOf(setCameraFrame))
 	js.Global().Set("enableTraceLogging", js.FuncOf(enableTraceLogging))
 	js.Global().Set("disableTraceLogging", js.FuncOf(disableTraceLogging))
 	js.Global().Set("setAudioChannelEnabled", js.FuncOf(setAudioChannelEnabled))
@@ -31,10 +35,13 @@ func main() {
 	js.Global().Set("getSerializedState", js.FuncOf(getSerializedState))
 	js.Global().Set("loadSerializedState", js.FuncOf(loadSerializedState))
 	js.Global().Set("getDebugInfo", js.FuncOf(getDebugInfo))
+	js.Global().Set("pollRumbleEvents", js.FuncOf(pollRumbleEvents))
 
 	// Rewinds
 	js.Global().Set("setRewindBufferSize", js.FuncOf(setRewindBufferSize))
+	js.Global().Set("setRewindFrameInterval", js.FuncOf(setRewindFrameInterval))
 	js.Global().Set("rewindFrames", js.FuncOf(rewindFrames))
+	js.Global().Set("getRewindBufferUsage", js.FuncOf(getRewindBufferUsage))
 
 	jsImageData = js.Global().Get("Uint8Array").New(len(goImageData))
 
@@ -48,27 +55,81 @@ func loadRom(this js.Value, args []js.Value) interface{} {
 	cartridgeRom := make([]byte, jsRomData.Get("length").Int())
 	js.CopyBytesToGo(cartridgeRom, jsRomData)
 
-	// Preserve rewind capacity if a previous instance existed
-	prevRewindCapacity := 0
+	// Preserve the rewind settings if a previous instance existed
+	prevRewindBufferSize := 0
+	prevRewindFrameInterval := 1
 	if gb != nil {
-		prevRewindCapacity = gb.GetRewindCapacity()
+		prevRewindBufferSize = gb.GetRewindBufferSize()
+		prevRewindFrameInterval = gb.GetRewindFrameInterval()
 	}
 
-	gb = gameboy.New()
+	gb = gameboy.New(gameboy.ModelForRom(cartridgeRom))
+	gb.SetRumbleHandler(queueRumbleEvent)
+	gb.ConnectCamera(cameraFrames)
+	rumbleEvents = rumbleEvents[:0]
 
-	if prevRewindCapacity > 0 {
-		gb.SetRewindBufferSize(prevRewindCapacity)
+	gb.SetRewindBufferSize(prevRewindBufferSize)
+	gb.SetRewindFrameInterval(prevRewindFrameInterval)
+
+	// a boot ROM for another model is skipped, the game still runs
+	if bootRom != nil {
+		if err := gb.LoadBootRom(bootRom); err != nil {
+			logger.Warn("BOOT ROM NOT USED", "ERROR", err.Error())
+		}
 	}
 
-	cartridgeInfo := gb.LoadRom(cartridgeRom)
+	cartridgeInfo, err := gb.LoadRom(cartridgeRom)
+
+	// the header is still reported when the ROM can't be run, so the frontend
+	// can show what it is
+	var loadError interface{}
+	if err != nil {
+		loadError = err.Error()
+	}
+	header := cartridgeInfo.Header
 
 	return map[string]interface{}{
+		"error":      loadError,
 		"title":      cartridgeInfo.Title,
 		"ramSize":    cartridgeInfo.RamSize,
 		"hasBattery": cartridgeInfo.HasBattery,
+		"saveSize":   cartridgeInfo.SaveSize,
+		"header": map[string]interface{}{
+			"title":               header.Title,
+			"manufacturerCode":    header.ManufacturerCode,
+			"cgbFlag":             header.CgbFlag,
+			"sgbFlag":             header.SgbFlag,
+			"licensee":            header.Licensee(),
+			"cartridgeType":       header.CartridgeType,
+			"mapperName":          header.MapperName,
+			"romSize":             header.RomSize,
+			"ramSize":             header.RamSize,
+			"version":             header.Version,
+			"headerChecksumValid": header.HeaderChecksumValid,
+			"globalChecksumValid": header.GlobalChecksumValid,
+			"supported":           header.Supported,
+		},
 	}
 }
 
+var bootRom []byte
+
+// setBootRom sets a boot ROM to run before the games loaded after it, or
+// removes it when passed null.
+func setBootRom(this js.Value, args []js.Value) interface{} {
+	jsBootRomData := args[0]
+
+	if jsBootRomData.IsUndefined() || jsBootRomData.IsNull() {
+		bootRom = nil
+		return nil
+	}
+
+	bootRom = make([]byte, jsBootRomData.Get("length").Int())
+	js.CopyBytesToGo(bootRom, jsBootRomData)
+
+	return nil
+}
+
 func getCartridgeRam(this js.Value, args []js.Value) interface{} {
 	cartridgeRam := gb.CartridgeRam()
 	jsBuffer := js.Global().Get("Uint8Array").New(len(cartridgeRam))
@@ -77,18 +138,21 @@ func getCartridgeRam(this js.Value, args []js.Value) interface{} {
 	return jsBuffer
 }
 
+// setCartridgeRam loads a battery save file. Returns an error message if the
+// save could not be loaded, or null on success.
 func setCartridgeRam(this js.Value, args []js.Value) interface{} {
 	jsRamData := args[0]
-	var cartridgeRam []byte
 
 	if jsRamData.IsUndefined() || jsRamData.IsNull() {
-		cartridgeRam = nil
-	} else {
-		cartridgeRam = make([]byte, jsRamData.Get("length").Int())
-		js.CopyBytesToGo(cartridgeRam, jsRamData)
+		return nil
 	}
 
-	gb.SetCartridgeRam(cartridgeRam)
+	cartridgeRam := make([]byte, jsRamData.Get("length").Int())
+	js.CopyBytesToGo(cartridgeRam, jsRamData)
+
+	if err := gb.SetCartridgeRam(cartridgeRam); err != nil {
+		return err.Error()
+	}
 
 	return nil
 }
@@ -98,17 +162,25 @@ func processEmulatorCycles(this js.Value, args []js.Value) interface{} {
 	var tCyclesUsed float64
 
 	for tCyclesToRun >= 4 {
-		tCycles, frameReady, _ := gb.Step()
+		tCycles, frameReady, err := gb.Step()
 		tCyclesUsed += float64(tCycles)
 		tCyclesToRun -= float64(tCycles)
 
 		if frameReady {
 			presentFrame()
 		}
+
+		if err != nil {
+			return js.ValueOf(map[string]interface{}{
+				"tCyclesUsed": tCyclesUsed,
+				"error":       err.Error(),
+			})
+		}
 	}
 
 	return js.ValueOf(map[string]interface{}{
 		"tCyclesUsed": tCyclesUsed,
+		"error":       nil,
 	})
 }
 
@@ -153,6 +225,34 @@ func handleJoypadButtonReleased(this js.Value, args []js.Value) interface{} {
 	return nil
 }
 
+// setTilt sets the accelerometer input for tilt sensing carts, in g.
+func setTilt(this js.Value, args []js.Value) interface{} {
+	if gb == nil {
+		return nil
+	}
+
+	gb.SetTilt(args[0].Float(), args[1].Float())
+	return nil
+}
+
+// frames pushed by the frontend for the Pocket Camera
+var cameraFrames = camera.NewBuffer()
+
+// setCameraFrame provides the image seen by the Pocket Camera, as 128x112
+// greyscale bytes. Returns an error message if the frame has the wrong size,
+// or null on success.
+func setCameraFrame(this js.Value, args []js.Value) interface{} {
+	jsFrameData := args[0]
+	frame := make([]byte, jsFrameData.Get("length").Int())
+	js.CopyBytesToGo(frame, jsFrameData)
+
+	if err := cameraFrames.SetFrame(frame); err != nil {
+		return err.Error()
+	}
+
+	return nil
+}
+
 const (
 	displayWidth  = 160
 	displayHeight = 144
@@ -163,6 +263,11 @@ var jsImageData js.Value
 var frameReady bool = false
 
 func presentFrame() {
+	if gb.IsCgb() {
+		presentColorFrame()
+		return
+	}
+
 	frameBuffer := gb.FrameBuffer()
 	i := 0
 	for screenY := 0; screenY < displayHeight; screenY++ {
@@ -185,6 +290,27 @@ func presentFrame() {
 	frameReady = true
 }
 
+func presentColorFrame() {
+	frameBuffer := gb.ColorFrameBuffer()
+	i := 0
+	for screenY := 0; screenY < displayHeight; screenY++ {
+		for screenX := 0; screenX < displayWidth; screenX++ {
+			color := frameBuffer[screenY][screenX]
+			// scale each 5 bit channel to 8 bits
+			r := uint8(color & 0b1_1111)
+			g := uint8((color >> 5) & 0b1_1111)
+			b := uint8((color >> 10) & 0b1_1111)
+			goImageData[i] = r<<3 | r>>2
+			goImageData[i+1] = g<<3 | g>>2
+			goImageData[i+2] = b<<3 | b>>2
+			goImageData[i+3] = 255
+			i += 4
+		}
+	}
+
+	frameReady = true
+}
+
 // pollFrame returns a newly completed frame, if one is available.
 // The frame is consumed exactly once.
 func pollFrame(this js.Value, args []js.Value) interface{} {
@@ -222,6 +348,31 @@ func pollAudioBuffer(this js.Value, args []js.Value) interface{} {
 	return jsInt16Array
 }
 
+var rumbleEvents []gameboy.RumbleEvent
+
+func queueRumbleEvent(event gameboy.RumbleEvent) {
+	rumbleEvents = append(rumbleEvents, event)
+}
+
+// pollRumbleEvents returns the rumble motor changes since the last poll as an
+// array of { on, tCycle }, or null if the motor wasn't switched.
+func pollRumbleEvents(this js.Value, args []js.Value) interface{} {
+	if len(rumbleEvents) == 0 {
+		return nil
+	}
+
+	events := make([]interface{}, len(rumbleEvents))
+	for i, event := range rumbleEvents {
+		events[i] = map[string]interface{}{
+			"on":     event.On,
+			"tCycle": float64(event.TCycle),
+		}
+	}
+	rumbleEvents = rumbleEvents[:0]
+
+	return events
+}
+
 func enableTraceLogging(this js.Value, args []js.Value) interface{} {
 	logger.GlobalTraceLogger.Enable()
 	return nil
@@ -270,16 +421,7 @@ func getAudioChannelEnabled(this js.Value, args []js.Value) interface{} {
 }
 
 func getSerializedState(this js.Value, args []js.Value) interface{} {
-	// Fast-forward to a safe boundary.
-	safetyLimit := 20
-	for !gb.IsSafeToSerialize() && safetyLimit > 0 {
-		// fmt.Println("waiting for safe state... safetyLimit=", safetyLimit, gb.IsSafeToSave())
-		gb.Step()
-		safetyLimit--
-	}
-
-	const BufferSize = 256 * 1024 // 256KB
-	buf := make([]byte, BufferSize)
+	buf := make([]byte, gameboy.StateBufferSize)
 
 	stateData := gb.SerializeState(buf)
 
@@ -289,6 +431,8 @@ func getSerializedState(this js.Value, args []js.Value) interface{} {
 	return jsArray
 }
 
+// loadSerializedState loads a save state. Returns an error message if the state
+// could not be loaded, leaving the game as it was, or null on success.
 func loadSerializedState(this js.Value, args []js.Value) interface{} {
 	jsData := args[0]
 	dataLength := jsData.Get("length").Int()
@@ -296,7 +440,9 @@ func loadSerializedState(this js.Value, args []js.Value) interface{} {
 	stateData := make([]byte, dataLength)
 	js.CopyBytesToGo(stateData, jsData)
 
-	gb.DeserializeState(stateData)
+	if err := gb.DeserializeState(stateData); err != nil {
+		return err.Error()
+	}
 
 	// Reset the rewind buffer after loading any manual save state
 	gb.ResetRewindBuffer()
@@ -304,7 +450,7 @@ func loadSerializedState(this js.Value, args []js.Value) interface{} {
 	return nil
 }
 
-// setRewindBufferSize allocates the internal circular buffer.
+// setRewindBufferSize sets the memory budget of the rewind buffer in bytes.
 func setRewindBufferSize(this js.Value, args []js.Value) interface{} {
 	if gb == nil {
 		return false
@@ -314,7 +460,31 @@ func setRewindBufferSize(this js.Value, args []js.Value) interface{} {
 	return true
 }
 
-// rewindFrames rewinds the emulator state by N frames.
+// setRewindFrameInterval sets the number of frames between rewind snapshots.
+func setRewindFrameInterval(this js.Value, args []js.Value) interface{} {
+	if gb == nil {
+		return false
+	}
+	frames := args[0].Int()
+	gb.SetRewindFrameInterval(frames)
+	return true
+}
+
+// getRewindBufferUsage returns the bytes used by the rewind snapshots and how
+// many there are.
+func getRewindBufferUsage(this js.Value, args []js.Value) interface{} {
+	if gb == nil {
+		return nil
+	}
+
+	return map[string]interface{}{
+		"bytes":     gb.GetRewindBufferUsage(),
+		"snapshots": gb.GetRewindSnapshotCount(),
+	}
+}
+
+// rewindFrames rewinds the emulator state by N frames, rounded up to the
+// rewind frame interval.
 // Returns the actual number of frames rewound (stops early if buffer empties).
 func rewindFrames(this js.Value, args []js.Value) interface{} {
 	if gb == nil {
@@ -322,13 +492,14 @@ func rewindFrames(this js.Value, args []js.Value) interface{} {
 	}
 
 	framesToRewind := args[0].Int()
+	interval := gb.GetRewindFrameInterval()
 	rewoundCount := 0
 
-	for range framesToRewind {
+	for rewoundCount < framesToRewind {
 		if !gb.Rewind() {
 			break // Reached the oldest available state
 		}
-		rewoundCount++
+		rewoundCount += interval
 	}
 
 	// If we successfully rewound, force an update to the frontend visually
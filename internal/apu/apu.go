@@ -3,6 +3,8 @@ package apu
 import (
 	"encoding/binary"
 	"math"
+
+	"github.com/davidyorr/LuccaGB/internal/model"
 )
 
 type APU struct {
@@ -127,6 +129,10 @@ type APU struct {
 
 	// state controlled by the UI. 1-indexed to match the channel name.
 	channelsEnabled [5]bool
+
+	// the DMG family and the CGB family differ in how wave RAM and the length
+	// timers behave
+	model model.Model
 }
 
 type channel struct {
@@ -227,6 +233,42 @@ func (apu *APU) Reset() {
 	apu.nr50 = 0x77
 	apu.nr51 = 0xF3
 	apu.nr52 = 0xF1
+	// the SGB boot ROM doesn't play the boot sound, so channel 1 is off
+	if apu.model.IsSgbFamily() {
+		apu.nr52 = 0xF0
+	}
+}
+
+// ColdReset puts the APU in its power on state, powered off, for a boot ROM to
+// run.
+func (apu *APU) ColdReset() {
+	apu.nr10 = 0x00
+	apu.nr11 = 0x00
+	apu.nr12 = 0x00
+	apu.nr13 = 0x00
+	apu.nr14 = 0x00
+	apu.nr21 = 0x00
+	apu.nr22 = 0x00
+	apu.nr23 = 0x00
+	apu.nr24 = 0x00
+	apu.nr30 = 0x00
+	apu.nr31 = 0x00
+	apu.nr32 = 0x00
+	apu.nr33 = 0x00
+	apu.nr34 = 0x00
+	apu.nr41 = 0x00
+	apu.nr42 = 0x00
+	apu.nr43 = 0x00
+	apu.nr44 = 0x00
+	apu.nr50 = 0x00
+	apu.nr51 = 0x00
+	apu.nr52 = 0x00
+}
+
+// SetModel selects the model whose quirks the APU has, and resets it.
+func (apu *APU) SetModel(model model.Model) {
+	apu.model = model
+	apu.Reset()
 }
 
 // See: https://gbdev.gg8.se/wiki/articles/Gameboy_sound_hardware#Square_Wave
@@ -583,7 +625,7 @@ func (apu *APU) Read(address uint16) uint8 {
 		// effect.
 		// See: https://gbdev.gg8.se/wiki/articles/Gameboy_sound_hardware#Obscure_Behavior
 		if apu.ch3.enabled {
-			if apu.cyclesSinceWaveRamFetch < 2 {
+			if apu.cyclesSinceWaveRamFetch < 2 || apu.model.IsCgbFamily() {
 				return apu.waveRam[apu.ch3.sampleIndex>>1]
 			}
 			return 0xFF
@@ -601,6 +643,9 @@ func (apu *APU) Write(address uint16, value uint8) {
 	// On DMG the length timer bits can still be written to while powered off
 	// See: https://gbdev.gg8.se/wiki/articles/Gameboy_sound_hardware#Differences
 	isLengthTimerRegister := address == 0xFF11 || address == 0xFF16 || address == 0xFF1B || address == 0xFF20
+	if apu.model.IsCgbFamily() {
+		isLengthTimerRegister = false
+	}
 
 	// Only Wave RAM, NR52, and length timer bits are writable when APU is powered off.
 	if !isPoweredOn && !isNR52 && !isWaveRam && !isLengthTimerRegister {
@@ -727,7 +772,7 @@ func (apu *APU) Write(address uint16, value uint8) {
 			// Each wave RAM fetch takes 4 cycles, so when cyclesSinceWaveRamFetch == 2
 			// we are in the middle of a fetch. 2 cycles must be when the
 			// actual data is being latched.
-			if wasEnabled && apu.cyclesSinceWaveRamFetch == 2 {
+			if wasEnabled && apu.cyclesSinceWaveRamFetch == 2 && apu.model.IsDmgFamily() {
 				// + 1 to use the sample index that is about to be read, because
 				// we haven't actually read the nibble yet
 				byteIndex := ((apu.ch3.sampleIndex + 1) >> 1) & 0xF
@@ -807,6 +852,14 @@ func (apu *APU) Write(address uint16, value uint8) {
 			apu.nr44 = 0x00
 			apu.nr50 = 0x00
 			apu.nr51 = 0x00
+
+			// the CGB also clears the length timers, the DMG keeps them
+			if apu.model.IsCgbFamily() {
+				apu.ch1.lengthTimer = 0
+				apu.ch2.lengthTimer = 0
+				apu.ch3.lengthTimer = 0
+				apu.ch4.lengthTimer = 0
+			}
 		}
 		// APU OFF -> APU ON
 		if !wasPoweredOn && isPoweredOn {
@@ -823,7 +876,7 @@ func (apu *APU) Write(address uint16, value uint8) {
 		// effect.
 		// See: https://gbdev.gg8.se/wiki/articles/Gameboy_sound_hardware#Obscure_Behavior
 		if apu.ch3.enabled {
-			if apu.cyclesSinceWaveRamFetch < 2 {
+			if apu.cyclesSinceWaveRamFetch < 2 || apu.model.IsCgbFamily() {
 				apu.waveRam[apu.ch3.sampleIndex>>1] = value
 			}
 			return
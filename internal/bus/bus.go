@@ -54,12 +54,20 @@ func (bus *Bus) Read(address uint16) (value uint8) {
 			// OAM
 			logger.Info("DMA ACTIVE, 0xFF")
 			return 0xFF
+		} else if getPhysicalBus(address) != getPhysicalBus(bus.dma.CurrentSourceAddress()) {
+			// the DMA only holds the bus it's reading from, so the CPU can
+			// still read from the other one
+			return bus.DirectRead(address)
 		} else {
 			logger.Info(fmt.Sprintf("DMA ACTIVE, RETURNING CURRENT TRANSFER BYTE: 0x%0X2", bus.dma.CurrentTransferByte()))
 			return bus.dma.CurrentTransferByte()
 		}
 	}
 
+	if address >= 0xFE00 && address <= 0xFEFF {
+		bus.ppu.OamBugRead()
+	}
+
 	// handle unusable area when OAM is blocked
 	if bus.ppu.OamIsBlocked() && address >= 0xFEA0 && address <= 0xFEFF {
 		return 0xFF
@@ -80,9 +88,15 @@ func (bus *Bus) DirectRead(address uint16) (value uint8) {
 	// DMA
 	case address == 0xFF46:
 		return bus.dma.DmaRegister()
+	// CGB VRAM DMA
+	case address >= 0xFF51 && address <= 0xFF55:
+		return bus.dma.ReadVramDma(address)
 	// PPU LCD
 	case address >= 0xFF40 && address <= 0xFF4B:
 		return bus.ppu.Read(address)
+	// PPU CGB VRAM bank, palettes and object priority
+	case address == 0xFF4F || (address >= 0xFF68 && address <= 0xFF6C):
+		return bus.ppu.Read(address)
 	// PPU VRAM
 	case address >= 0x8000 && address <= 0x9FFF:
 		return bus.ppu.Read(address)
@@ -123,6 +137,10 @@ func (bus *Bus) Write(address uint16, value uint8) {
 		}
 	}
 
+	if address >= 0xFE00 && address <= 0xFEFF {
+		bus.ppu.OamBugWrite()
+	}
+
 	switch {
 	// APU
 	case address >= 0xFF10 && address <= 0xFF3F:
@@ -130,6 +148,12 @@ func (bus *Bus) Write(address uint16, value uint8) {
 	// PPU LCD
 	case address >= 0xFF40 && address <= 0xFF4B:
 		bus.ppu.Write(address, value)
+	// PPU CGB VRAM bank, palettes and object priority
+	case address == 0xFF4F || (address >= 0xFF68 && address <= 0xFF6C):
+		bus.ppu.Write(address, value)
+	// CGB VRAM DMA
+	case address >= 0xFF51 && address <= 0xFF55:
+		bus.dma.WriteVramDma(address, value)
 	// PPU VRAM
 	case address >= 0x8000 && address <= 0x9FFF:
 		bus.ppu.Write(address, value)
@@ -157,6 +181,33 @@ func (bus *Bus) DmaActive() bool {
 	return bus.dma.Active()
 }
 
+// SpeedSwitchArmed reports whether KEY1 has armed a CGB speed switch for the
+// next STOP.
+func (bus *Bus) SpeedSwitchArmed() bool {
+	return bus.mmu.SpeedSwitchArmed()
+}
+
+func (bus *Bus) SwitchSpeed() {
+	bus.mmu.SwitchSpeed()
+}
+
+// OamBugWrite triggers the OAM corruption bug for the CPU putting an address
+// on the bus to increment or decrement a 16-bit register.
+func (bus *Bus) OamBugWrite(address uint16) {
+	if address >= 0xFE00 && address <= 0xFEFF {
+		bus.ppu.OamBugWrite()
+	}
+}
+
+// OamBugReadIncrease triggers the OAM corruption bug for the CPU incrementing
+// or decrementing a 16-bit register in the same M-cycle as reading from it.
+// The read itself corrupts OAM again.
+func (bus *Bus) OamBugReadIncrease(address uint16) {
+	if address >= 0xFE00 && address <= 0xFEFF {
+		bus.ppu.OamBugReadIncrease()
+	}
+}
+
 const (
 	PhysicalBusMain = iota
 	PhysicalBusVram
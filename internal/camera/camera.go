@@ -0,0 +1,147 @@
+package camera
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"sync"
+)
+
+// The M64282FP sensor's visible area, as used by the Pocket Camera
+const (
+	Width  = 128
+	Height = 112
+)
+
+// Frame is a greyscale image, 0 is black and 255 is white.
+type Frame [Height][Width]uint8
+
+// ImageSource supplies what the Pocket Camera's sensor sees. It's asked for a
+// frame each time the game takes a capture, which can be several times a
+// second while the viewfinder is open.
+type ImageSource interface {
+	Frame() *Frame
+}
+
+// =======================
+// ==== Static image =====
+// =======================
+
+// StaticImage shows the same image for every capture.
+type StaticImage struct {
+	frame Frame
+}
+
+// NewStaticImage converts an image to greyscale, scaling it to cover the
+// sensor and cropping whatever overflows, centered. Image files are decoded by
+// the imagefile package, which keeps the decoders out of the wasm build.
+func NewStaticImage(img image.Image) *StaticImage {
+	staticImage := &StaticImage{}
+
+	bounds := img.Bounds()
+	if bounds.Empty() {
+		return staticImage
+	}
+
+	// scale so the image covers the whole sensor
+	scale := max(
+		float64(Width)/float64(bounds.Dx()),
+		float64(Height)/float64(bounds.Dy()),
+	)
+	offsetX := (float64(bounds.Dx())*scale - Width) / 2
+	offsetY := (float64(bounds.Dy())*scale - Height) / 2
+
+	for y := range Height {
+		for x := range Width {
+			sourceX := bounds.Min.X + int((float64(x)+offsetX)/scale)
+			sourceY := bounds.Min.Y + int((float64(y)+offsetY)/scale)
+			grey := color.GrayModel.Convert(img.At(sourceX, sourceY)).(color.Gray)
+			staticImage.frame[y][x] = grey.Y
+		}
+	}
+
+	return staticImage
+}
+
+func (staticImage *StaticImage) Frame() *Frame {
+	return &staticImage.frame
+}
+
+// =======================
+// ===== Test pattern ====
+// =======================
+
+// TestPattern generates greyscale bars with a diagonal gradient that scrolls
+// with each capture, so it's easy to see the viewfinder updating.
+type TestPattern struct {
+	frame    Frame
+	captures int
+}
+
+func NewTestPattern() *TestPattern {
+	return &TestPattern{}
+}
+
+func (pattern *TestPattern) Frame() *Frame {
+	for y := range Height {
+		for x := range Width {
+			if y < Height/2 {
+				// 8 bars, from white to black
+				pattern.frame[y][x] = uint8(255 - (x/(Width/8))*255/7)
+			} else {
+				pattern.frame[y][x] = uint8((x + y + pattern.captures) * 2)
+			}
+		}
+	}
+	pattern.captures++
+
+	return &pattern.frame
+}
+
+// =======================
+// ======= Buffer ========
+// =======================
+
+// Buffer holds the latest frame pushed by the host, such as a webcam feed.
+// Frames can be pushed from a different goroutine than the emulator's.
+type Buffer struct {
+	mutex   sync.Mutex
+	latest  Frame
+	capture Frame
+}
+
+func NewBuffer() *Buffer {
+	buffer := &Buffer{}
+	// white until the host provides a frame
+	for y := range Height {
+		for x := range Width {
+			buffer.latest[y][x] = 0xFF
+		}
+	}
+
+	return buffer
+}
+
+// SetFrame stores a frame of Width*Height greyscale bytes, row by row.
+func (buffer *Buffer) SetFrame(pixels []uint8) error {
+	if len(pixels) != Width*Height {
+		return fmt.Errorf("camera frame is %d bytes, expected %d", len(pixels), Width*Height)
+	}
+
+	buffer.mutex.Lock()
+	defer buffer.mutex.Unlock()
+
+	for y := range Height {
+		copy(buffer.latest[y][:], pixels[y*Width:(y+1)*Width])
+	}
+
+	return nil
+}
+
+func (buffer *Buffer) Frame() *Frame {
+	buffer.mutex.Lock()
+	defer buffer.mutex.Unlock()
+
+	buffer.capture = buffer.latest
+	return &buffer.capture
+}
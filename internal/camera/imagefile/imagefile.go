@@ -0,0 +1,31 @@
+// Package imagefile loads image files as Pocket Camera sources. It's separate
+// from the camera package so the image decoders are only linked into builds
+// which read files.
+package imagefile
+
+import (
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"os"
+
+	"github.com/davidyorr/LuccaGB/internal/camera"
+)
+
+// Load reads a PNG, JPEG or GIF file into a StaticImage.
+func Load(path string) (*camera.StaticImage, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	img, _, err := image.Decode(file)
+	if err != nil {
+		return nil, fmt.Errorf("decoding camera image %s: %w", path, err)
+	}
+
+	return camera.NewStaticImage(img), nil
+}
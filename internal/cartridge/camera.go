@@ -0,0 +1,375 @@
+package cartridge
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+
+	"github.com/davidyorr/LuccaGB/internal/camera"
+	"github.com/davidyorr/LuccaGB/internal/logger"
+)
+
+// sensor registers mapped to 0xA000-0xA035 when bit 4 of the RAM bank is set
+const (
+	cameraRegisterCount = 0x36
+	// Bit 0: start capture, reads 1 while capturing
+	// Bits 1-2: capture mode
+	cameraRegisterShoot = 0x00
+	// Bit 7: N, disables the negative image used for edge extraction
+	// Bits 5-6: VH, edge extraction direction
+	// Bits 0-4: gain
+	cameraRegisterGain = 0x01
+	// 0x02-0x03: exposure time, big-endian, in units of 16 M-cycles
+	cameraRegisterExposureHigh = 0x02
+	cameraRegisterExposureLow  = 0x03
+	// Bit 7: E, enables edge enhancement
+	// Bits 4-6: edge enhancement ratio
+	// Bit 3: I, inverts the output
+	// Bits 0-2: output reference voltage
+	cameraRegisterEdge = 0x04
+	// Bits 6-7: zero point calibration
+	// Bits 0-5: output voltage offset
+	cameraRegisterZero = 0x05
+	// 0x06-0x35: 4x4 dither matrix, 3 thresholds per pixel
+	cameraRegisterDither = 0x06
+)
+
+// processed captures are written to RAM bank 0 as 16x14 tiles
+const cameraImageAddress = 0x0100
+
+// edge enhancement ratios selected by bits 4-6 of register 0x04
+var cameraEdgeRatios = [8]float64{0.5, 0.75, 1.0, 1.25, 2.0, 3.0, 4.0, 5.0}
+
+// PocketCamera is the mapper of the Game Boy Camera, which wires a Mitsubishi
+// M64282FP image sensor to the cartridge bus. The game starts a capture, the
+// sensor exposes, and the mapper processes the image and dithers it down to
+// 2bpp tiles in RAM, ready to be copied to VRAM.
+type PocketCamera struct {
+	cartridge *Cartridge
+	// bitmask to wrap addresses to the physical ROM capacity,
+	// derived from the ROM size code
+	romAddressMask uint32
+	// bitmask to wrap addresses to the physical RAM capacity,
+	// derived from the RAM size code
+	ramAddressMask uint32
+
+	// T-cycles until the capture in progress completes, 0 when idle
+	captureCountdown uint32
+
+	// =======================
+	// ====== Registers ======
+	// =======================
+
+	// 0000–1FFF — RAM Write Enable (Write Only)
+	ramg uint8
+
+	// 2000–3FFF — ROM Bank Number (Write Only)
+	romb uint8
+
+	// 4000–5FFF — RAM Bank Number (Write Only)
+	// Bit 4 maps the sensor registers instead of RAM
+	ramb uint8
+
+	// A000-A035 — sensor registers
+	registers [cameraRegisterCount]uint8
+}
+
+func newPocketCamera(cartridge *Cartridge) *PocketCamera {
+	pocketCamera := &PocketCamera{}
+
+	pocketCamera.cartridge = cartridge
+	pocketCamera.romAddressMask = addressMaskSizes[cartridge.romSizeCode]
+	pocketCamera.ramAddressMask = ramAddressMaskSizes[cartridge.ramSizeCode]
+	cartridge.ram = make([]uint8, ramSizes[cartridge.ramSizeCode])
+	pocketCamera.Reset()
+
+	return pocketCamera
+}
+
+func (mbc *PocketCamera) Reset() {
+	mbc.ramg = 0x00
+	mbc.romb = 0x01
+	mbc.ramb = 0x00
+	mbc.registers = [cameraRegisterCount]uint8{}
+	mbc.captureCountdown = 0
+}
+
+func (mbc *PocketCamera) registersMapped() bool {
+	return mbc.ramb&0b0001_0000 != 0
+}
+
+func (mbc *PocketCamera) Read(address uint16) uint8 {
+	switch {
+
+	// ROM Bank 00
+	case address <= 0x3FFF:
+		return mbc.cartridge.rom[address]
+
+	// ROM Bank 00-3F
+	case address >= 0x4000 && address <= 0x7FFF:
+		bank := uint32(mbc.romb)
+		// map 0x4000-0x7FFF down to 0x0000-0x3FFF
+		offset := uint32(address) & 0b11_1111_1111_1111
+		actualAddress := ((bank << 14) | offset) & mbc.romAddressMask
+		return mbc.cartridge.rom[actualAddress]
+
+	// External RAM or sensor registers
+	case address >= 0xA000 && address <= 0xBFFF:
+		if mbc.registersMapped() {
+			// only the shoot register can be read back, mirrored every 0x80 bytes
+			if (address-0xA000)&0x7F == cameraRegisterShoot {
+				return mbc.registers[cameraRegisterShoot]
+			}
+			return 0x00
+		}
+
+		// no RAM hardware
+		if len(mbc.cartridge.ram) == 0 {
+			return 0xFF
+		}
+
+		// RAM can be read without being enabled
+		bank := uint32(mbc.ramb & 0b1111)
+		offset := uint32(address - 0xA000)
+
+		actualAddress := ((bank << 13) | offset) & mbc.ramAddressMask
+		return mbc.cartridge.ram[actualAddress]
+	}
+
+	logger.Error(
+		"Pocket Camera returning 0xFF",
+		"ADDRESS", fmt.Sprintf("0x%04X", address),
+		"ROMB", fmt.Sprintf("0x%08b", mbc.romb),
+		"RAMB", fmt.Sprintf("0x%08b", mbc.ramb),
+	)
+	return 0xFF
+}
+
+func (mbc *PocketCamera) Write(address uint16, value uint8) {
+	switch {
+
+	// RAM Write Enable
+	case address <= 0x1FFF:
+		mbc.ramg = value
+
+	// ROM Bank Number
+	case address >= 0x2000 && address <= 0x3FFF:
+		mbc.romb = value & 0b0011_1111
+
+	// RAM Bank Number
+	case address >= 0x4000 && address <= 0x5FFF:
+		mbc.ramb = value & 0b0001_1111
+
+	// External RAM or sensor registers
+	case address >= 0xA000 && address <= 0xBFFF:
+		if mbc.registersMapped() {
+			mbc.writeRegister(uint8((address-0xA000)&0x7F), value)
+			return
+		}
+
+		// RAM writes disabled
+		if (mbc.ramg & 0b1111) != 0b1010 {
+			return
+		}
+
+		// no RAM hardware
+		if len(mbc.cartridge.ram) == 0 {
+			return
+		}
+
+		bank := uint32(mbc.ramb & 0b1111)
+		offset := uint32(address - 0xA000)
+
+		actualAddress := ((bank << 13) | offset) & mbc.ramAddressMask
+		mbc.cartridge.ram[actualAddress] = value
+	}
+}
+
+func (mbc *PocketCamera) writeRegister(register uint8, value uint8) {
+	if register >= cameraRegisterCount {
+		return
+	}
+
+	if register != cameraRegisterShoot {
+		mbc.registers[register] = value
+		return
+	}
+
+	mbc.registers[cameraRegisterShoot] = value & 0b0000_0111
+
+	switch {
+	// start a capture
+	case value&0b1 != 0 && mbc.captureCountdown == 0:
+		mbc.captureCountdown = mbc.captureTCycles()
+	// clearing the bit aborts the capture in progress
+	case value&0b1 == 0:
+		mbc.captureCountdown = 0
+	}
+}
+
+// captureTCycles returns how long the sensor takes to expose and read out
+func (mbc *PocketCamera) captureTCycles() uint32 {
+	tCycles := uint32(129792)
+	// the negative image for edge extraction needs an extra pass
+	if mbc.registers[cameraRegisterGain]&0b1000_0000 == 0 {
+		tCycles += 2048
+	}
+
+	return tCycles + uint32(mbc.exposure())*64
+}
+
+func (mbc *PocketCamera) exposure() uint16 {
+	return uint16(mbc.registers[cameraRegisterExposureHigh])<<8 | uint16(mbc.registers[cameraRegisterExposureLow])
+}
+
+func (mbc *PocketCamera) step(tCycles uint8) {
+	if mbc.captureCountdown == 0 {
+		return
+	}
+
+	if mbc.captureCountdown > uint32(tCycles) {
+		mbc.captureCountdown -= uint32(tCycles)
+		return
+	}
+
+	mbc.captureCountdown = 0
+	mbc.capture()
+	mbc.registers[cameraRegisterShoot] &^= 0b1
+}
+
+// capture runs the sensor and the mapper's processing on the current frame
+// of the image source. It's an approximation of the analog pipeline:
+// exposure and gain scale the brightness, edge extraction sharpens against
+// the neighbouring pixels, then the 4x4 threshold matrix dithers the result
+// into the four shades.
+func (mbc *PocketCamera) capture() {
+	if len(mbc.cartridge.ram) < cameraImageAddress+camera.Width*camera.Height/4 {
+		return
+	}
+
+	frame := mbc.cartridge.camera.Frame()
+
+	// sensor response, where 1.0 is a typical exposure of a white pixel
+	gainRegister := mbc.registers[cameraRegisterGain]
+	scale := float64(mbc.exposure()) / 0x0800 * cameraGain(gainRegister&0b1_1111)
+
+	var sensor [camera.Height][camera.Width]float64
+	for y := range camera.Height {
+		for x := range camera.Width {
+			sensor[y][x] = float64(frame[y][x]) / 255 * scale
+		}
+	}
+
+	edgeRegister := mbc.registers[cameraRegisterEdge]
+	edgeEnabled := edgeRegister&0b1000_0000 != 0
+	edgeRatio := cameraEdgeRatios[(edgeRegister>>4)&0b111]
+	negative := gainRegister&0b1000_0000 == 0
+	direction := (gainRegister >> 5) & 0b11
+	invert := edgeRegister&0b0000_1000 != 0
+
+	sample := func(x, y int) float64 {
+		x = max(0, min(camera.Width-1, x))
+		y = max(0, min(camera.Height-1, y))
+		return sensor[y][x]
+	}
+
+	for y := range camera.Height {
+		for x := range camera.Width {
+			value := sensor[y][x]
+
+			if edgeEnabled && negative {
+				switch direction {
+				// horizontal
+				case 0b01:
+					value += edgeRatio * (2*value - sample(x-1, y) - sample(x+1, y))
+				// vertical
+				case 0b10:
+					value += edgeRatio * (2*value - sample(x, y-1) - sample(x, y+1))
+				// 2D
+				case 0b11:
+					value += edgeRatio * (4*value - sample(x-1, y) - sample(x+1, y) - sample(x, y-1) - sample(x, y+1))
+				}
+			}
+
+			if invert {
+				value = 1 - value
+			}
+
+			level := uint8(max(0, min(255, math.Round(value*255))))
+			mbc.writePixel(x, y, mbc.dither(x, y, level))
+		}
+	}
+}
+
+// cameraGain approximates the sensor's amplifier, roughly 1.5dB per step
+// with bit 4 adding a further 6dB, relative to the lowest setting
+func cameraGain(gain uint8) float64 {
+	decibels := 1.5 * float64(gain&0b1111)
+	if gain&0b1_0000 != 0 {
+		decibels += 6
+	}
+
+	return math.Pow(10, decibels/20)
+}
+
+// dither maps a level to one of the four shades, 0 being the lightest, using
+// the thresholds the game wrote for this pixel's position in the 4x4 matrix
+func (mbc *PocketCamera) dither(x, y int, level uint8) uint8 {
+	base := cameraRegisterDither + ((y%4)*4+(x%4))*3
+	thresholds := mbc.registers[base : base+3]
+
+	switch {
+	case level < thresholds[0]:
+		return 3
+	case level < thresholds[1]:
+		return 2
+	case level < thresholds[2]:
+		return 1
+	}
+
+	return 0
+}
+
+// writePixel stores a shade in the 2bpp tile layout used by VRAM
+func (mbc *PocketCamera) writePixel(x, y int, shade uint8) {
+	tile := (y/8)*(camera.Width/8) + x/8
+	address := cameraImageAddress + tile*16 + (y%8)*2
+	bit := uint8(7 - x%8)
+
+	low := &mbc.cartridge.ram[address]
+	high := &mbc.cartridge.ram[address+1]
+	*low = (*low &^ (1 << bit)) | ((shade & 0b01) << bit)
+	*high = (*high &^ (1 << bit)) | (((shade & 0b10) >> 1) << bit)
+}
+
+func (mbc *PocketCamera) Serialize(buf []byte) int {
+	offset := 0
+
+	buf[offset] = mbc.ramg
+	offset++
+	buf[offset] = mbc.romb
+	offset++
+	buf[offset] = mbc.ramb
+	offset++
+	offset += copy(buf[offset:], mbc.registers[:])
+	binary.LittleEndian.PutUint32(buf[offset:], mbc.captureCountdown)
+	offset += 4
+
+	return offset
+}
+
+func (mbc *PocketCamera) Deserialize(buf []byte) int {
+	offset := 0
+
+	mbc.ramg = buf[offset]
+	offset++
+	mbc.romb = buf[offset]
+	offset++
+	mbc.ramb = buf[offset]
+	offset++
+	offset += copy(mbc.registers[:], buf[offset:offset+cameraRegisterCount])
+	mbc.captureCountdown = binary.LittleEndian.Uint32(buf[offset:])
+	offset += 4
+
+	return offset
+}
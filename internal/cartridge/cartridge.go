@@ -1,10 +1,12 @@
 package cartridge
 
 import (
-	"bytes"
 	"encoding/binary"
 	"fmt"
 
+	"github.com/davidyorr/LuccaGB/internal/camera"
+	"github.com/davidyorr/LuccaGB/internal/clock"
+	"github.com/davidyorr/LuccaGB/internal/infrared"
 	"github.com/davidyorr/LuccaGB/internal/logger"
 )
 
@@ -16,8 +18,21 @@ type Cartridge struct {
 	ram []uint8
 	// memory bank controller
 	mbc MBC
+	// the mapper, if it needs to be stepped with the system clock
+	steppedMbc steppedMbc
 	// for persisting RAM
 	hasBattery bool
+	// wall time source for mappers with a real-time clock
+	clock clock.Clock
+	// called when a rumble cart switches its motor on or off
+	onRumble func(on bool)
+	// the other end of the IR port on HuC1 and HuC3 carts, if any
+	infrared infrared.Transceiver
+	// what the Pocket Camera's sensor sees
+	camera camera.ImageSource
+	// accelerometer input for tilt sensing carts, in g
+	tiltX float64
+	tiltY float64
 
 	// 0x0134 - 0x0143 Title of the ROM in uppercase ASCII
 	title []uint8
@@ -40,50 +55,221 @@ var batteryBackedTypes = map[uint8]bool{
 	0x1B: true, // MBC5+RAM+BATTERY
 	0x1E: true, // MBC5+RUMBLE+RAM+BATTERY
 	0x22: true, // MBC7+SENSOR+RUMBLE+RAM+BATTERY
+	0xFC: true, // POCKET CAMERA
+	0xFE: true, // HuC3+RTC+RAM+BATTERY
 	0xFF: true, // HuC1+RAM+BATTERY
 }
 
 func New() *Cartridge {
 	cartridge := &Cartridge{}
+	cartridge.clock = clock.NewRealTime()
+	cartridge.camera = camera.NewTestPattern()
 
 	return cartridge
 }
 
+// ConnectClock replaces the wall time source. A running real-time clock is
+// rebased onto the new source without advancing.
+func (cartridge *Cartridge) ConnectClock(clock clock.Clock) {
+	cartridge.clock = clock
+
+	if mbc, ok := cartridge.mbc.(clockedMbc); ok {
+		mbc.rebaseClock(clock.Now())
+	}
+}
+
+// ConnectRumble registers the handler called whenever the rumble motor is
+// switched on or off.
+func (cartridge *Cartridge) ConnectRumble(onRumble func(on bool)) {
+	cartridge.onRumble = onRumble
+}
+
+// Rumble reports whether the rumble motor is currently on.
+func (cartridge *Cartridge) Rumble() bool {
+	if mbc, ok := cartridge.mbc.(rumbleMbc); ok {
+		return mbc.rumbleOn()
+	}
+
+	return false
+}
+
+func (cartridge *Cartridge) notifyRumble(on bool) {
+	if cartridge.onRumble != nil {
+		cartridge.onRumble(on)
+	}
+}
+
+// ConnectInfrared connects the IR port of HuC1 and HuC3 carts. With nothing
+// connected, the receiver never sees any light.
+func (cartridge *Cartridge) ConnectInfrared(transceiver infrared.Transceiver) {
+	cartridge.infrared = transceiver
+}
+
+// infraredInput returns the IR receiver as read from 0xA000-0xBFFF,
+// 0xC1 if light is seen and 0xC0 if not.
+func (cartridge *Cartridge) infraredInput() uint8 {
+	if cartridge.infrared != nil && cartridge.infrared.Light() {
+		return 0xC1
+	}
+
+	return 0xC0
+}
+
+func (cartridge *Cartridge) setInfraredLed(on bool) {
+	if cartridge.infrared != nil {
+		cartridge.infrared.SetLed(on)
+	}
+}
+
+// ConnectCamera sets the image source seen by the Pocket Camera. A nil source
+// restores the built-in test pattern.
+func (cartridge *Cartridge) ConnectCamera(source camera.ImageSource) {
+	if source == nil {
+		source = camera.NewTestPattern()
+	}
+
+	cartridge.camera = source
+}
+
+// SetTilt sets the acceleration in g seen by tilt sensing carts, oriented like
+// a gamepad stick: positive x when tilted right, positive y when tilted down.
+func (cartridge *Cartridge) SetTilt(x, y float64) {
+	cartridge.tiltX = x
+	cartridge.tiltY = y
+}
+
+// MulticartDetection controls how MBC1 cartridges are checked for MBC1M
+// multicart wiring.
+type MulticartDetection uint8
+
+const (
+	// MulticartAuto detects multicarts from repeated logos in the ROM
+	MulticartAuto MulticartDetection = iota
+	// MulticartForceOn always wires MBC1 cartridges as MBC1M
+	MulticartForceOn
+	// MulticartForceOff always wires MBC1 cartridges as a regular MBC1
+	MulticartForceOff
+)
+
+type LoadOptions struct {
+	Mbc1Multicart MulticartDetection
+}
+
 type CartridgeInfo struct {
 	Title      string
 	RamSize    int
 	HasBattery bool
+	// size of the battery save file, SRAM plus any RTC footer
+	SaveSize int
+	Header   Header
 }
 
-func (cartridge *Cartridge) LoadRom(rom []uint8) CartridgeInfo {
-	cartridge.rom = rom
+func (cartridge *Cartridge) LoadRom(rom []uint8) (CartridgeInfo, error) {
+	return cartridge.LoadRomWithOptions(rom, LoadOptions{})
+}
+
+// LoadRomWithOptions validates the ROM's header and sets up its mapper. A
+// TruncatedRomError or UnsupportedCartridgeError is returned, along with what
+// could be read of the header, if the ROM can't be run. The previously loaded
+// ROM is kept in that case.
+func (cartridge *Cartridge) LoadRomWithOptions(rom []uint8, options LoadOptions) (CartridgeInfo, error) {
+	if len(rom) < headerEnd {
+		return CartridgeInfo{}, &TruncatedRomError{Size: len(rom), Expected: headerEnd}
+	}
+
+	// MMM01 carts are described by the menu's header at the end of the ROM
+	isMmm01, menuAtStart := detectMmm01(rom)
+	if menuAtStart {
+		rotated := make([]uint8, 0, len(rom))
+		rotated = append(rotated, rom[mmm01MenuSize:]...)
+		rom = append(rotated, rom[:mmm01MenuSize]...)
+	}
 
-	cartridge.title = bytes.Trim(cartridge.rom[0x0134:0x0143], "\x00")
-	cartridge.romSizeCode = cartridge.rom[0x148]
-	cartridge.ramSizeCode = cartridge.rom[0x149]
-	cartridge.cartridgeType = cartridge.rom[0x147]
+	headerRom := rom
+	if isMmm01 {
+		headerRom = rom[len(rom)-mmm01MenuSize:]
+	}
+
+	header, err := ParseHeader(headerRom)
+	if err != nil {
+		return CartridgeInfo{}, err
+	}
+
+	info := CartridgeInfo{
+		Title:  header.Title,
+		Header: header,
+	}
+
+	if !header.Supported {
+		return info, &UnsupportedCartridgeError{
+			CartridgeType: header.CartridgeType,
+			MapperName:    header.MapperName,
+		}
+	}
 
-	if batteryBackedTypes[cartridge.cartridgeType] {
-		cartridge.hasBattery = true
+	// a ROM only cartridge reads past the end of a short ROM as 0xFF, mappers
+	// rely on the declared size
+	if header.CartridgeType != 0x00 && len(rom) < header.RomSize {
+		return info, &TruncatedRomError{Size: len(rom), Expected: header.RomSize}
 	}
 
+	cartridge.rom = rom
+	cartridge.title = []uint8(header.Title)
+	cartridge.romSizeCode = headerRom[0x148]
+	cartridge.ramSizeCode = headerRom[0x149]
+	cartridge.cartridgeType = header.CartridgeType
+	cartridge.ram = nil
+	cartridge.hasBattery = batteryBackedTypes[cartridge.cartridgeType]
+
+	// the supported types must agree with isSupportedType
 	switch cartridge.cartridgeType {
-	// ROM only
-	case 0x00:
+	// ROM only, ROM+RAM and ROM+RAM+BATTERY. No licensed game used RAM
+	// without a mapper, they run as ROM only.
+	case 0x00, 0x08, 0x09:
 		cartridge.mbc = nil
 	// MBC1
 	case 0x01, 0x02, 0x03:
-		cartridge.mbc = newMbc1(cartridge)
+		isMulticart := false
+		switch options.Mbc1Multicart {
+		case MulticartAuto:
+			isMulticart = isMbc1Multicart(rom)
+		case MulticartForceOn:
+			isMulticart = true
+		}
+		cartridge.mbc = newMbc1(cartridge, isMulticart)
 	// MBC2
 	case 0x05, 0x06:
 		cartridge.mbc = newMbc2(cartridge)
+	// MMM01
+	case 0x0B, 0x0C, 0x0D:
+		cartridge.mbc = newMmm01(cartridge)
+	// MBC3
+	case 0x0F, 0x10, 0x11, 0x12, 0x13:
+		cartridge.mbc = newMbc3(cartridge)
 	// MBC5
 	case 0x19, 0x1A, 0x1B, 0x1C, 0x1D, 0x1E:
 		cartridge.mbc = newMbc5(cartridge)
+	// MBC7
+	case 0x22:
+		cartridge.mbc = newMbc7(cartridge)
+	// Pocket Camera
+	case 0xFC:
+		cartridge.mbc = newPocketCamera(cartridge)
+	// HuC3
+	case 0xFE:
+		cartridge.mbc = newHuc3(cartridge)
+	// HuC1
+	case 0xFF:
+		cartridge.mbc = newHuc1(cartridge)
 	default:
 		cartridge.mbc = nil
 	}
 
+	cartridge.steppedMbc = nil
+	if mbc, ok := cartridge.mbc.(steppedMbc); ok {
+		cartridge.steppedMbc = mbc
+	}
+
 	logger.Info(
 		"CARTRIDGE LOAD ROM",
 		"TITLE", string(cartridge.title),
@@ -92,26 +278,30 @@ func (cartridge *Cartridge) LoadRom(rom []uint8) CartridgeInfo {
 		"RAM_SIZE_CODE", fmt.Sprintf("0x%02X", cartridge.ramSizeCode),
 	)
 
-	return CartridgeInfo{
-		Title:      string(cartridge.title),
-		RamSize:    len(cartridge.ram),
-		HasBattery: cartridge.hasBattery,
-	}
+	info.RamSize = len(cartridge.ram)
+	info.HasBattery = cartridge.hasBattery
+	info.SaveSize = cartridge.SaveFileSize()
+
+	return info, nil
 }
 
-func (cartridge *Cartridge) SetRam(ram []uint8) {
-	if cartridge.hasBattery && len(cartridge.ram) > 0 && len(ram) > 0 {
-		n := min(len(cartridge.ram), len(ram))
-		copy(cartridge.ram[:n], ram[:n])
+// SetRam restores the raw SRAM contents. Use LoadSaveFile for .sav files which
+// may also carry the RTC state.
+func (cartridge *Cartridge) SetRam(ram []uint8) error {
+	if !cartridge.hasBattery || len(cartridge.ram) == 0 {
+		return ErrNoBattery
 	}
 
 	if len(cartridge.ram) != len(ram) {
-		logger.Warn(
-			"SetRam() RAM size mismatch: cart=%d persisted=%d",
-			len(cartridge.ram),
-			len(ram),
-		)
+		return &SaveSizeError{
+			Actual:   len(ram),
+			Expected: []int{len(cartridge.ram)},
+		}
 	}
+
+	copy(cartridge.ram, ram)
+
+	return nil
 }
 
 func (cartridge *Cartridge) Ram() []uint8 {
@@ -122,6 +312,14 @@ func (cartridge *Cartridge) Ram() []uint8 {
 	return nil
 }
 
+// Step advances mapper hardware that runs off the system clock, such as the
+// Pocket Camera's sensor.
+func (cartridge *Cartridge) Step(tCycles uint8) {
+	if cartridge.steppedMbc != nil {
+		cartridge.steppedMbc.step(tCycles)
+	}
+}
+
 func (cartridge *Cartridge) Read(address uint16) uint8 {
 	if cartridge.mbc == nil {
 		if int(address) >= len(cartridge.rom) {
@@ -141,6 +339,11 @@ func (cartridge *Cartridge) Write(address uint16, value uint8) {
 	cartridge.mbc.Write(address, value)
 }
 
+// CartridgeType returns the cartridge type byte of the loaded ROM's header.
+func (cartridge *Cartridge) CartridgeType() uint8 {
+	return cartridge.cartridgeType
+}
+
 // Debug gathers the current state of the Cartridge into a structured map.
 func (cartridge *Cartridge) Debug() map[string]interface{} {
 	return map[string]interface{}{
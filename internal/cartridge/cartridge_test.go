@@ -0,0 +1,91 @@
+package cartridge
+
+import (
+	"testing"
+	"time"
+
+	"github.com/davidyorr/LuccaGB/internal/clock"
+)
+
+// newTestRom returns a ROM of the given size with a bootable header at the
+// start.
+func newTestRom(size int, cartridgeType uint8, romSizeCode uint8, ramSizeCode uint8) []uint8 {
+	rom := make([]uint8, size)
+	writeTestHeader(rom, cartridgeType, romSizeCode, ramSizeCode)
+
+	return rom
+}
+
+// writeTestHeader writes a header with the Nintendo logo and a valid checksum
+// to the start of rom.
+func writeTestHeader(rom []uint8, cartridgeType uint8, romSizeCode uint8, ramSizeCode uint8) {
+	copy(rom[0x0104:], nintendoLogo[:])
+	copy(rom[0x0134:], "TEST")
+	rom[0x0147] = cartridgeType
+	rom[0x0148] = romSizeCode
+	rom[0x0149] = ramSizeCode
+	rom[0x014D] = headerChecksum(rom)
+}
+
+var testClockStart = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// newClockedTestCartridge loads rom with a clock that only moves when the test
+// moves it.
+func newClockedTestCartridge(t *testing.T, rom []uint8) (*Cartridge, *clock.Fixed) {
+	t.Helper()
+
+	fixed := clock.NewFixed(testClockStart)
+	cartridge := New()
+	cartridge.ConnectClock(fixed)
+	if _, err := cartridge.LoadRom(rom); err != nil {
+		t.Fatal(err)
+	}
+
+	return cartridge, fixed
+}
+
+func TestParseHeaderChecksum(t *testing.T) {
+	rom := newTestRom(0x8000, 0x00, 0x00, 0x00)
+	if header, _ := ParseHeader(rom); !header.HeaderChecksumValid {
+		t.Error("expected a valid header checksum")
+	}
+
+	rom[0x014D]++
+	if header, _ := ParseHeader(rom); header.HeaderChecksumValid {
+		t.Error("expected an invalid header checksum")
+	}
+}
+
+func TestRomSizesHaveAddressMasks(t *testing.T) {
+	for code := range romSizes {
+		if _, ok := addressMaskSizes[code]; !ok {
+			t.Errorf("expected an address mask for ROM size code 0x%02X", code)
+		}
+	}
+
+	rom := newTestRom(0x8000, 0x01, 0x52, 0x00)
+	if header, _ := ParseHeader(rom); header.RomSize != 0 {
+		t.Errorf("expected the unofficial size code 0x52 to be unknown, got %d bytes", header.RomSize)
+	}
+}
+
+func TestLoadRomOnlyTypes(t *testing.T) {
+	// ROM only, ROM+RAM and ROM+RAM+BATTERY
+	for _, cartridgeType := range []uint8{0x00, 0x08, 0x09} {
+		rom := newTestRom(0x8000, cartridgeType, 0x00, 0x00)
+		rom[0x4000] = 0x42
+
+		cartridge := New()
+		info, err := cartridge.LoadRom(rom)
+		if err != nil {
+			t.Errorf("type 0x%02X: %v", cartridgeType, err)
+			continue
+		}
+		if !info.Header.Supported {
+			t.Errorf("type 0x%02X: expected the header to report it supported", cartridgeType)
+		}
+		if value := cartridge.Read(0x4000); value != 0x42 {
+			t.Errorf("type 0x%02X: expected 0x42 at 0x4000, got 0x%02X", cartridgeType, value)
+		}
+	}
+}
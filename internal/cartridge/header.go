@@ -0,0 +1,255 @@
+package cartridge
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+)
+
+// the header occupies 0x0100-0x014F, so a ROM must be at least this long to
+// describe itself
+const headerEnd = 0x0150
+
+// Header is the cartridge header at 0x0100-0x014F, describing the game and
+// the hardware on the cartridge.
+type Header struct {
+	// 0x0104-0x0133 Logo, checked by the boot ROM, which the DMG's also shows
+	Logo [48]uint8
+	// 0x0134-0x0143 Title in uppercase ASCII, shortened to 0x0134-0x013E on
+	// newer cartridges which use the remaining bytes for other fields
+	Title string
+	// 0x013F-0x0142 Manufacturer code, only on newer cartridges
+	ManufacturerCode string
+	// 0x0143 CGB flag, 0x80 for CGB enhanced and 0xC0 for CGB only
+	CgbFlag uint8
+	// 0x0146 SGB flag, 0x03 if the game supports SGB functions
+	SgbFlag uint8
+	// 0x014B Old licensee code, 0x33 means the new licensee code is used
+	OldLicenseeCode uint8
+	// 0x0144-0x0145 New licensee code, two ASCII characters
+	NewLicenseeCode string
+	// 0x0147 Cartridge type
+	CartridgeType uint8
+	// name of the cartridge type, e.g. "MBC1+RAM+BATTERY"
+	MapperName string
+	// 0x0148 ROM size, in bytes. 0 if the size code is unknown.
+	RomSize int
+	// 0x0149 RAM size, in bytes
+	RamSize int
+	// 0x014C Mask ROM version number
+	Version uint8
+	// 0x014D Header checksum, checked by the boot ROM
+	HeaderChecksum      uint8
+	HeaderChecksumValid bool
+	// 0x014E-0x014F Global checksum, not checked by any hardware
+	GlobalChecksum      uint16
+	GlobalChecksumValid bool
+	// whether the cartridge type has a mapper implemented
+	Supported bool
+}
+
+// IsCgbEnhanced reports whether the game uses CGB features.
+func (header Header) IsCgbEnhanced() bool {
+	return header.CgbFlag&0x80 != 0
+}
+
+// IsCgbOnly reports whether the game refuses to run on a DMG.
+func (header Header) IsCgbOnly() bool {
+	return header.CgbFlag == 0xC0
+}
+
+// SupportsSgb reports whether the game uses SGB functions. The SGB ignores
+// the flag unless the old licensee code is 0x33.
+func (header Header) SupportsSgb() bool {
+	return header.SgbFlag == 0x03 && header.OldLicenseeCode == 0x33
+}
+
+// Licensee returns the licensee code, the new code if the old one defers to
+// it, otherwise the old code as two hex digits.
+func (header Header) Licensee() string {
+	if header.OldLicenseeCode == 0x33 {
+		return header.NewLicenseeCode
+	}
+
+	return fmt.Sprintf("%02X", header.OldLicenseeCode)
+}
+
+// TruncatedRomError is returned when the ROM is shorter than its header, or
+// than the size its header declares.
+type TruncatedRomError struct {
+	Size     int
+	Expected int
+}
+
+func (err *TruncatedRomError) Error() string {
+	return fmt.Sprintf("ROM is truncated: %d bytes, expected at least %d bytes", err.Size, err.Expected)
+}
+
+// UnsupportedCartridgeError is returned when the cartridge type has no mapper
+// implemented.
+type UnsupportedCartridgeError struct {
+	CartridgeType uint8
+	MapperName    string
+}
+
+func (err *UnsupportedCartridgeError) Error() string {
+	return fmt.Sprintf("unsupported cartridge type 0x%02X (%s)", err.CartridgeType, err.MapperName)
+}
+
+var mapperNames = map[uint8]string{
+	0x00: "ROM ONLY",
+	0x01: "MBC1",
+	0x02: "MBC1+RAM",
+	0x03: "MBC1+RAM+BATTERY",
+	0x05: "MBC2",
+	0x06: "MBC2+BATTERY",
+	0x08: "ROM+RAM",
+	0x09: "ROM+RAM+BATTERY",
+	0x0B: "MMM01",
+	0x0C: "MMM01+RAM",
+	0x0D: "MMM01+RAM+BATTERY",
+	0x0F: "MBC3+TIMER+BATTERY",
+	0x10: "MBC3+TIMER+RAM+BATTERY",
+	0x11: "MBC3",
+	0x12: "MBC3+RAM",
+	0x13: "MBC3+RAM+BATTERY",
+	0x19: "MBC5",
+	0x1A: "MBC5+RAM",
+	0x1B: "MBC5+RAM+BATTERY",
+	0x1C: "MBC5+RUMBLE",
+	0x1D: "MBC5+RUMBLE+RAM",
+	0x1E: "MBC5+RUMBLE+RAM+BATTERY",
+	0x20: "MBC6",
+	0x22: "MBC7+SENSOR+RUMBLE+RAM+BATTERY",
+	0xFC: "POCKET CAMERA",
+	0xFD: "BANDAI TAMA5",
+	0xFE: "HuC3",
+	0xFF: "HuC1+RAM+BATTERY",
+}
+
+// romSizes are the ROM sizes in bytes for each ROM size code. The unofficial
+// codes 0x52-0x54 (72, 80 and 96 banks) have no mapper address mask, so they
+// are reported as unknown.
+var romSizes = map[uint8]int{
+	0x00: 32 * 1024,
+	0x01: 64 * 1024,
+	0x02: 128 * 1024,
+	0x03: 256 * 1024,
+	0x04: 512 * 1024,
+	0x05: 1024 * 1024,
+	0x06: 2 * 1024 * 1024,
+	0x07: 4 * 1024 * 1024,
+	0x08: 8 * 1024 * 1024,
+}
+
+// nintendoLogo is the logo at 0x0104-0x0133 which the boot ROM checks
+var nintendoLogo = [48]uint8{
+	0xCE, 0xED, 0x66, 0x66, 0xCC, 0x0D, 0x00, 0x0B, 0x03, 0x73, 0x00, 0x83,
+	0x00, 0x0C, 0x00, 0x0D, 0x00, 0x08, 0x11, 0x1F, 0x88, 0x89, 0x00, 0x0E,
+	0xDC, 0xCC, 0x6E, 0xE6, 0xDD, 0xDD, 0xD9, 0x99, 0xBB, 0xBB, 0x67, 0x63,
+	0x6E, 0x0E, 0xEC, 0xCC, 0xDD, 0xDC, 0x99, 0x9F, 0xBB, 0xB9, 0x33, 0x3E,
+}
+
+// headerChecksum computes the checksum of 0x0134-0x014C which the boot ROM
+// compares to 0x014D.
+func headerChecksum(rom []uint8) uint8 {
+	var checksum uint8
+	for _, value := range rom[0x0134:0x014D] {
+		checksum = checksum - value - 1
+	}
+
+	return checksum
+}
+
+// isBootableHeader reports whether the header would pass the boot ROM's
+// checks: the Nintendo logo and the header checksum.
+func isBootableHeader(rom []uint8) bool {
+	return bytes.Equal(rom[0x0104:0x0134], nintendoLogo[:]) && headerChecksum(rom) == rom[0x014D]
+}
+
+// ParseHeader reads the cartridge header without validating it. The only
+// error is a ROM too short to contain a header.
+func ParseHeader(rom []uint8) (Header, error) {
+	if len(rom) < headerEnd {
+		return Header{}, &TruncatedRomError{Size: len(rom), Expected: headerEnd}
+	}
+
+	header := Header{
+		CgbFlag:        rom[0x0143],
+		SgbFlag:        rom[0x0146],
+		CartridgeType:  rom[0x0147],
+		RomSize:        romSizes[rom[0x0148]],
+		RamSize:        ramSizes[rom[0x0149]],
+		Version:        rom[0x014C],
+		HeaderChecksum: rom[0x014D],
+		GlobalChecksum: binary.BigEndian.Uint16(rom[0x014E:]),
+		Supported:      isSupportedType(rom[0x0147]),
+	}
+
+	copy(header.Logo[:], rom[0x0104:0x0134])
+
+	// the title shrank as the CGB flag and manufacturer code were introduced
+	title := rom[0x0134:0x0144]
+	if header.IsCgbEnhanced() {
+		title = rom[0x0134:0x0143]
+		if isManufacturerCode(rom[0x013F:0x0143]) {
+			title = rom[0x0134:0x013F]
+			header.ManufacturerCode = string(rom[0x013F:0x0143])
+		}
+	}
+	header.Title = string(bytes.TrimRight(title, "\x00 "))
+
+	header.OldLicenseeCode = rom[0x014B]
+	header.NewLicenseeCode = string(rom[0x0144:0x0146])
+
+	var ok bool
+	header.MapperName, ok = mapperNames[header.CartridgeType]
+	if !ok {
+		header.MapperName = "UNKNOWN"
+	}
+
+	header.HeaderChecksumValid = headerChecksum(rom) == header.HeaderChecksum
+
+	var globalChecksum uint16
+	for i, value := range rom {
+		if i == 0x014E || i == 0x014F {
+			continue
+		}
+		globalChecksum += uint16(value)
+	}
+	header.GlobalChecksumValid = globalChecksum == header.GlobalChecksum
+
+	return header, nil
+}
+
+// isManufacturerCode reports whether the bytes look like a 4 character
+// manufacturer code rather than the end of the title
+func isManufacturerCode(code []uint8) bool {
+	for _, char := range code {
+		if (char < 'A' || char > 'Z') && (char < '0' || char > '9') {
+			return false
+		}
+	}
+
+	return true
+}
+
+// isSupportedType must agree with the mappers created by LoadRom
+func isSupportedType(cartridgeType uint8) bool {
+	switch cartridgeType {
+	case 0x00,
+		0x01, 0x02, 0x03,
+		0x05, 0x06,
+		0x08, 0x09,
+		0x0B, 0x0C, 0x0D,
+		0x0F, 0x10, 0x11, 0x12, 0x13,
+		0x19, 0x1A, 0x1B, 0x1C, 0x1D, 0x1E,
+		0x22,
+		0xFC,
+		0xFE,
+		0xFF:
+		return true
+	}
+
+	return false
+}
@@ -0,0 +1,156 @@
+package cartridge
+
+import (
+	"fmt"
+
+	"github.com/davidyorr/LuccaGB/internal/logger"
+)
+
+// writing this to the mode select register of a HuC1 or HuC3 maps the IR port
+// to 0xA000-0xBFFF
+const hucModeInfrared = 0x0E
+
+type Huc1 struct {
+	cartridge *Cartridge
+	// bitmask to wrap addresses to the physical ROM capacity,
+	// derived from the ROM size code
+	romAddressMask uint32
+	// bitmask to wrap addresses to the physical RAM capacity,
+	// derived from the RAM size code
+	ramAddressMask uint32
+
+	// =======================
+	// ====== Registers ======
+	// =======================
+
+	// 0000–1FFF — RAM/IR Select (Write Only)
+	// 0x0E maps the IR port, anything else maps RAM
+	mode uint8
+
+	// 2000–3FFF — ROM Bank Number (Write Only)
+	romb uint8
+
+	// 4000–5FFF — RAM Bank Number (Write Only)
+	ramb uint8
+}
+
+func newHuc1(cartridge *Cartridge) *Huc1 {
+	huc1 := &Huc1{}
+
+	huc1.cartridge = cartridge
+	huc1.romAddressMask = addressMaskSizes[cartridge.romSizeCode]
+	huc1.ramAddressMask = ramAddressMaskSizes[cartridge.ramSizeCode]
+	cartridge.ram = make([]uint8, ramSizes[cartridge.ramSizeCode])
+	huc1.Reset()
+
+	return huc1
+}
+
+func (mbc *Huc1) Reset() {
+	mbc.mode = 0x00
+	mbc.romb = 0x01
+	mbc.ramb = 0x00
+}
+
+func (mbc *Huc1) Read(address uint16) uint8 {
+	switch {
+
+	// ROM Bank 00
+	case address <= 0x3FFF:
+		return mbc.cartridge.rom[address]
+
+	// ROM Bank 00-3F
+	case address >= 0x4000 && address <= 0x7FFF:
+		bank := uint32(mbc.romb)
+		// map 0x4000-0x7FFF down to 0x0000-0x3FFF
+		offset := uint32(address) & 0b11_1111_1111_1111
+		actualAddress := ((bank << 14) | offset) & mbc.romAddressMask
+		return mbc.cartridge.rom[actualAddress]
+
+	// External RAM or IR
+	case address >= 0xA000 && address <= 0xBFFF:
+		if mbc.mode == hucModeInfrared {
+			return mbc.cartridge.infraredInput()
+		}
+
+		// no RAM hardware
+		if len(mbc.cartridge.ram) == 0 {
+			return 0xFF
+		}
+
+		bank := uint32(mbc.ramb)
+		offset := uint32(address - 0xA000)
+
+		actualAddress := ((bank << 13) | offset) & mbc.ramAddressMask
+		return mbc.cartridge.ram[actualAddress]
+	}
+
+	logger.Error(
+		"HuC1 returning 0xFF",
+		"ADDRESS", fmt.Sprintf("0x%04X", address),
+		"ROMB", fmt.Sprintf("0x%08b", mbc.romb),
+		"RAMB", fmt.Sprintf("0x%08b", mbc.ramb),
+	)
+	return 0xFF
+}
+
+func (mbc *Huc1) Write(address uint16, value uint8) {
+	switch {
+
+	// RAM/IR Select
+	case address <= 0x1FFF:
+		mbc.mode = value
+
+	// ROM Bank Number
+	case address >= 0x2000 && address <= 0x3FFF:
+		mbc.romb = value & 0b0011_1111
+
+	// RAM Bank Number
+	case address >= 0x4000 && address <= 0x5FFF:
+		mbc.ramb = value & 0b0000_0011
+
+	// External RAM or IR
+	case address >= 0xA000 && address <= 0xBFFF:
+		if mbc.mode == hucModeInfrared {
+			mbc.cartridge.setInfraredLed(value&0b1 != 0)
+			return
+		}
+
+		// no RAM hardware
+		if len(mbc.cartridge.ram) == 0 {
+			return
+		}
+
+		bank := uint32(mbc.ramb)
+		offset := uint32(address - 0xA000)
+
+		actualAddress := ((bank << 13) | offset) & mbc.ramAddressMask
+		mbc.cartridge.ram[actualAddress] = value
+	}
+}
+
+func (mbc *Huc1) Serialize(buf []byte) int {
+	offset := 0
+
+	buf[offset] = mbc.mode
+	offset++
+	buf[offset] = mbc.romb
+	offset++
+	buf[offset] = mbc.ramb
+	offset++
+
+	return offset
+}
+
+func (mbc *Huc1) Deserialize(buf []byte) int {
+	offset := 0
+
+	mbc.mode = buf[offset]
+	offset++
+	mbc.romb = buf[offset]
+	offset++
+	mbc.ramb = buf[offset]
+	offset++
+
+	return offset
+}
@@ -0,0 +1,98 @@
+package cartridge
+
+import (
+	"testing"
+
+	"github.com/davidyorr/LuccaGB/internal/infrared"
+)
+
+// newHuc1TestCartridge returns a 128KiB HuC1 cart with 32KiB of RAM, each ROM
+// bank holding its number at 0x2000.
+func newHuc1TestCartridge(t *testing.T) *Cartridge {
+	t.Helper()
+
+	rom := newTestRom(0x20000, 0xFF, 0x02, 0x03)
+	for bank := 1; bank < 8; bank++ {
+		rom[bank*0x4000+0x2000] = uint8(bank)
+	}
+
+	cartridge := New()
+	if _, err := cartridge.LoadRom(rom); err != nil {
+		t.Fatal(err)
+	}
+
+	return cartridge
+}
+
+func TestHuc1RomBanks(t *testing.T) {
+	cartridge := newHuc1TestCartridge(t)
+	if bank := cartridge.Read(0x6000); bank != 1 {
+		t.Errorf("expected bank 1 at power on, got %d", bank)
+	}
+
+	cartridge.Write(0x2000, 0x05)
+	if bank := cartridge.Read(0x6000); bank != 5 {
+		t.Errorf("expected bank 5, got %d", bank)
+	}
+
+	// bank 0 isn't remapped to 1
+	cartridge.Write(0x2000, 0x00)
+	if value := cartridge.Read(0x4147); value != 0xFF {
+		t.Errorf("expected bank 0 at 0x4000, got type 0x%02X", value)
+	}
+
+	// wraps to the size of the ROM
+	cartridge.Write(0x2000, 0x0B)
+	if bank := cartridge.Read(0x6000); bank != 3 {
+		t.Errorf("expected bank 0x0B to wrap to 3, got %d", bank)
+	}
+}
+
+func TestHuc1RamBanks(t *testing.T) {
+	cartridge := newHuc1TestCartridge(t)
+	for bank := range uint8(4) {
+		cartridge.Write(0x4000, bank)
+		cartridge.Write(0xA000, 0x10+bank)
+	}
+
+	for bank := range uint8(4) {
+		cartridge.Write(0x4000, bank)
+		if value := cartridge.Read(0xA000); value != 0x10+bank {
+			t.Errorf("expected 0x%02X in RAM bank %d, got 0x%02X", 0x10+bank, bank, value)
+		}
+	}
+}
+
+func TestHuc1Infrared(t *testing.T) {
+	cartridge := newHuc1TestCartridge(t)
+	cartridge.Write(0xA000, 0x42)
+
+	// no IR port connected
+	cartridge.Write(0x0000, hucModeInfrared)
+	if value := cartridge.Read(0xA000); value != 0xC0 {
+		t.Errorf("expected 0xC0 with nothing connected, got 0x%02X", value)
+	}
+	cartridge.Write(0xA000, 0x01)
+
+	local, remote := infrared.NewLink()
+	cartridge.ConnectInfrared(local)
+	remote.SetLed(true)
+	if value := cartridge.Read(0xA000); value != 0xC1 {
+		t.Errorf("expected 0xC1 with the other LED on, got 0x%02X", value)
+	}
+
+	cartridge.Write(0xA000, 0x01)
+	if !remote.Light() {
+		t.Error("expected the LED on")
+	}
+	cartridge.Write(0xA000, 0x00)
+	if remote.Light() {
+		t.Error("expected the LED off")
+	}
+
+	// IR writes don't reach RAM
+	cartridge.Write(0x0000, 0x00)
+	if value := cartridge.Read(0xA000); value != 0x42 {
+		t.Errorf("expected RAM to be untouched by IR writes, got 0x%02X", value)
+	}
+}
@@ -0,0 +1,453 @@
+package cartridge
+
+import (
+	"encoding/binary"
+	"fmt"
+	"time"
+
+	"github.com/davidyorr/LuccaGB/internal/logger"
+)
+
+// HuC3 modes selected through 0000-1FFF, controlling what 0xA000-0xBFFF maps
+const (
+	huc3ModeRamReadOnly = 0x00
+	huc3ModeRam         = 0x0A
+	huc3ModeCommand     = 0x0B
+	huc3ModeResponse    = 0x0C
+	huc3ModeSemaphore   = 0x0D
+)
+
+const (
+	minutesPerDay        = 24 * 60
+	nanosecondsPerMinute = 60 * nanosecondsPerSecond
+)
+
+type Huc3 struct {
+	cartridge *Cartridge
+	// bitmask to wrap addresses to the physical ROM capacity,
+	// derived from the ROM size code
+	romAddressMask uint32
+	// bitmask to wrap addresses to the physical RAM capacity,
+	// derived from the RAM size code
+	ramAddressMask uint32
+
+	// =======================
+	// ====== Registers ======
+	// =======================
+
+	// 0000–1FFF — Mode Select (Write Only)
+	mode uint8
+
+	// 2000–3FFF — ROM Bank Number (Write Only)
+	romb uint8
+
+	// 4000–5FFF — RAM Bank Number (Write Only)
+	ramb uint8
+
+	// A000-BFFF in mode 0x0C — result of the last command
+	response uint8
+
+	rtc Huc3Rtc
+}
+
+func newHuc3(cartridge *Cartridge) *Huc3 {
+	huc3 := &Huc3{}
+
+	huc3.cartridge = cartridge
+	huc3.romAddressMask = addressMaskSizes[cartridge.romSizeCode]
+	huc3.ramAddressMask = ramAddressMaskSizes[cartridge.ramSizeCode]
+	cartridge.ram = make([]uint8, ramSizes[cartridge.ramSizeCode])
+	huc3.Reset()
+	huc3.rtc.Reset()
+	huc3.rtc.Rebase(cartridge.clock.Now())
+
+	return huc3
+}
+
+func (mbc *Huc3) Reset() {
+	mbc.mode = 0x00
+	mbc.romb = 0x01
+	mbc.ramb = 0x00
+	mbc.response = 0x00
+}
+
+func (mbc *Huc3) Read(address uint16) uint8 {
+	switch {
+
+	// ROM Bank 00
+	case address <= 0x3FFF:
+		return mbc.cartridge.rom[address]
+
+	// ROM Bank 00-7F
+	case address >= 0x4000 && address <= 0x7FFF:
+		bank := uint32(mbc.romb)
+		// map 0x4000-0x7FFF down to 0x0000-0x3FFF
+		offset := uint32(address) & 0b11_1111_1111_1111
+		actualAddress := ((bank << 14) | offset) & mbc.romAddressMask
+		return mbc.cartridge.rom[actualAddress]
+
+	// External RAM, RTC or IR depending on the mode
+	case address >= 0xA000 && address <= 0xBFFF:
+		switch mbc.mode {
+		case huc3ModeRamReadOnly, huc3ModeRam:
+			// no RAM hardware
+			if len(mbc.cartridge.ram) == 0 {
+				return 0xFF
+			}
+
+			bank := uint32(mbc.ramb)
+			offset := uint32(address - 0xA000)
+
+			actualAddress := ((bank << 13) | offset) & mbc.ramAddressMask
+			return mbc.cartridge.ram[actualAddress]
+		case huc3ModeResponse:
+			return mbc.response
+		case huc3ModeSemaphore:
+			// commands complete immediately, so the RTC is always ready
+			return 0x01
+		case hucModeInfrared:
+			return mbc.cartridge.infraredInput()
+		}
+
+		return 0xFF
+	}
+
+	logger.Error(
+		"HuC3 returning 0xFF",
+		"ADDRESS", fmt.Sprintf("0x%04X", address),
+		"ROMB", fmt.Sprintf("0x%08b", mbc.romb),
+		"RAMB", fmt.Sprintf("0x%08b", mbc.ramb),
+		"MODE", fmt.Sprintf("0x%02X", mbc.mode),
+	)
+	return 0xFF
+}
+
+func (mbc *Huc3) Write(address uint16, value uint8) {
+	switch {
+
+	// Mode Select
+	case address <= 0x1FFF:
+		mbc.mode = value & 0x0F
+
+	// ROM Bank Number
+	case address >= 0x2000 && address <= 0x3FFF:
+		mbc.romb = value & 0b0111_1111
+
+	// RAM Bank Number
+	case address >= 0x4000 && address <= 0x5FFF:
+		mbc.ramb = value & 0b0000_0011
+
+	// External RAM, RTC or IR depending on the mode
+	case address >= 0xA000 && address <= 0xBFFF:
+		switch mbc.mode {
+		case huc3ModeRam:
+			// no RAM hardware
+			if len(mbc.cartridge.ram) == 0 {
+				return
+			}
+
+			bank := uint32(mbc.ramb)
+			offset := uint32(address - 0xA000)
+
+			actualAddress := ((bank << 13) | offset) & mbc.ramAddressMask
+			mbc.cartridge.ram[actualAddress] = value
+		case huc3ModeCommand:
+			mbc.response = mbc.rtc.Command(value, mbc.cartridge.clock.Now())
+		case hucModeInfrared:
+			mbc.cartridge.setInfraredLed(value&0b1 != 0)
+		}
+	}
+}
+
+func (mbc *Huc3) rebaseClock(now time.Time) {
+	mbc.rtc.Rebase(now)
+}
+
+func (mbc *Huc3) huc3Clock() *Huc3Rtc {
+	return &mbc.rtc
+}
+
+func (mbc *Huc3) Serialize(buf []byte) int {
+	offset := 0
+
+	buf[offset] = mbc.mode
+	offset++
+	buf[offset] = mbc.romb
+	offset++
+	buf[offset] = mbc.ramb
+	offset++
+	buf[offset] = mbc.response
+	offset++
+
+	offset += mbc.rtc.Serialize(buf[offset:])
+
+	return offset
+}
+
+func (mbc *Huc3) Deserialize(buf []byte) int {
+	offset := 0
+
+	mbc.mode = buf[offset]
+	offset++
+	mbc.romb = buf[offset]
+	offset++
+	mbc.ramb = buf[offset]
+	offset++
+	mbc.response = buf[offset]
+	offset++
+
+	offset += mbc.rtc.Deserialize(buf[offset:])
+
+	return offset
+}
+
+// =======================
+// ====== HuC3 RTC =======
+// =======================
+
+// Huc3Rtc is the HuC3's clock. It counts minutes of the day and days, and is
+// driven by a nibble-wide command protocol written to 0xA000 in mode 0x0B:
+//
+//	1x - read the nibble at the access index into the response, then increment
+//	2x - write x to the nibble at the access index
+//	3x - write x to the nibble at the access index, then increment
+//	4x - set the low nibble of the access index
+//	5x - set the high nibble of the access index
+//	6x - extended command, x = 2 reports the clock as ready
+//
+// The response read back in mode 0x0C holds the command in the high nibble
+// and the result in the low nibble.
+//
+// The access index addresses the clock as nibbles:
+//
+//	00-02 - minute of the day (0-1439)
+//	03-06 - day counter
+//	58-5A - alarm minute of the day
+//	5B-5E - alarm day
+//	5F    - alarm enabled
+type Huc3Rtc struct {
+	minutes uint16
+	days    uint16
+
+	alarmMinutes uint16
+	alarmDays    uint16
+	alarmEnabled bool
+
+	accessIndex uint8
+
+	// unix nanoseconds of the last sync
+	lastSync int64
+	// nanoseconds accumulated towards the next minute
+	subMinute int64
+}
+
+func (rtc *Huc3Rtc) Reset() {
+	rtc.minutes = 0
+	rtc.days = 0
+	rtc.alarmMinutes = 0
+	rtc.alarmDays = 0
+	rtc.alarmEnabled = false
+	rtc.accessIndex = 0
+	rtc.subMinute = 0
+}
+
+// Rebase continues counting from now without advancing.
+func (rtc *Huc3Rtc) Rebase(now time.Time) {
+	rtc.lastSync = now.UnixNano()
+}
+
+// Sync advances the clock by the time elapsed since the last sync.
+func (rtc *Huc3Rtc) Sync(now time.Time) {
+	nowNano := now.UnixNano()
+	elapsed := nowNano - rtc.lastSync
+	rtc.lastSync = nowNano
+
+	// the wall clock went backwards, hold the current time
+	if elapsed <= 0 {
+		return
+	}
+
+	rtc.subMinute += elapsed
+	rtc.advance(rtc.subMinute / nanosecondsPerMinute)
+	rtc.subMinute %= nanosecondsPerMinute
+}
+
+func (rtc *Huc3Rtc) advance(minutes int64) {
+	total := int64(rtc.minutes) + minutes
+	// the day counter wraps at its 16-bit width
+	rtc.days += uint16(total / minutesPerDay)
+	rtc.minutes = uint16(total % minutesPerDay)
+}
+
+// Command executes a write in mode 0x0B and returns the response.
+func (rtc *Huc3Rtc) Command(value uint8, now time.Time) uint8 {
+	rtc.Sync(now)
+
+	command := value >> 4
+	argument := value & 0x0F
+	result := uint8(0)
+
+	switch command {
+	case 0x1:
+		result = rtc.nibble(rtc.accessIndex)
+		rtc.accessIndex++
+	case 0x2, 0x3:
+		rtc.setNibble(rtc.accessIndex, argument)
+		if command == 0x3 {
+			rtc.accessIndex++
+		}
+	case 0x4:
+		rtc.accessIndex = (rtc.accessIndex & 0xF0) | argument
+	case 0x5:
+		rtc.accessIndex = (rtc.accessIndex & 0x0F) | (argument << 4)
+	case 0x6:
+		if argument == 0x2 {
+			result = 0x01
+		}
+	default:
+		logger.Info(
+			"HuC3 unsupported RTC command",
+			"VALUE", fmt.Sprintf("0x%02X", value),
+		)
+	}
+
+	return (command << 4) | result
+}
+
+func (rtc *Huc3Rtc) nibble(index uint8) uint8 {
+	switch {
+	case index <= 0x02:
+		return uint8(rtc.minutes>>(index*4)) & 0x0F
+	case index <= 0x06:
+		return uint8(rtc.days>>((index-0x03)*4)) & 0x0F
+	case index >= 0x58 && index <= 0x5A:
+		return uint8(rtc.alarmMinutes>>((index-0x58)*4)) & 0x0F
+	case index >= 0x5B && index <= 0x5E:
+		return uint8(rtc.alarmDays>>((index-0x5B)*4)) & 0x0F
+	case index == 0x5F:
+		if rtc.alarmEnabled {
+			return 0x01
+		}
+	}
+
+	return 0x00
+}
+
+func (rtc *Huc3Rtc) setNibble(index uint8, value uint8) {
+	switch {
+	case index <= 0x02:
+		rtc.minutes = replaceNibble(rtc.minutes, index, value)
+	case index <= 0x06:
+		rtc.days = replaceNibble(rtc.days, index-0x03, value)
+	case index >= 0x58 && index <= 0x5A:
+		rtc.alarmMinutes = replaceNibble(rtc.alarmMinutes, index-0x58, value)
+	case index >= 0x5B && index <= 0x5E:
+		rtc.alarmDays = replaceNibble(rtc.alarmDays, index-0x5B, value)
+	case index == 0x5F:
+		rtc.alarmEnabled = value&0b1 != 0
+	}
+}
+
+func replaceNibble(word uint16, index uint8, value uint8) uint16 {
+	shift := index * 4
+	word &^= 0x0F << shift
+	word |= uint16(value&0x0F) << shift
+
+	return word
+}
+
+// EncodeFooter writes the .sav HuC3 clock footer.
+func (rtc *Huc3Rtc) EncodeFooter(buf []byte, now time.Time) {
+	rtc.Sync(now)
+
+	offset := 0
+
+	binary.LittleEndian.PutUint64(buf[offset:], uint64(now.Unix()))
+	offset += 8
+	binary.LittleEndian.PutUint16(buf[offset:], rtc.minutes)
+	offset += 2
+	binary.LittleEndian.PutUint16(buf[offset:], rtc.days)
+	offset += 2
+	binary.LittleEndian.PutUint16(buf[offset:], rtc.alarmMinutes)
+	offset += 2
+	binary.LittleEndian.PutUint16(buf[offset:], rtc.alarmDays)
+	offset += 2
+	if rtc.alarmEnabled {
+		buf[offset] = 1
+	} else {
+		buf[offset] = 0
+	}
+}
+
+// DecodeFooter restores the clock from a .sav HuC3 clock footer, then catches
+// up on the time that passed since the file was written.
+func (rtc *Huc3Rtc) DecodeFooter(buf []byte, now time.Time) {
+	offset := 0
+
+	timestamp := int64(binary.LittleEndian.Uint64(buf[offset:]))
+	offset += 8
+	rtc.minutes = binary.LittleEndian.Uint16(buf[offset:]) % minutesPerDay
+	offset += 2
+	rtc.days = binary.LittleEndian.Uint16(buf[offset:])
+	offset += 2
+	rtc.alarmMinutes = binary.LittleEndian.Uint16(buf[offset:])
+	offset += 2
+	rtc.alarmDays = binary.LittleEndian.Uint16(buf[offset:])
+	offset += 2
+	rtc.alarmEnabled = buf[offset] == 1
+
+	rtc.lastSync = timestamp * nanosecondsPerSecond
+	rtc.subMinute = 0
+
+	rtc.Sync(now)
+}
+
+func (rtc *Huc3Rtc) Serialize(buf []byte) int {
+	offset := 0
+
+	binary.LittleEndian.PutUint16(buf[offset:], rtc.minutes)
+	offset += 2
+	binary.LittleEndian.PutUint16(buf[offset:], rtc.days)
+	offset += 2
+	binary.LittleEndian.PutUint16(buf[offset:], rtc.alarmMinutes)
+	offset += 2
+	binary.LittleEndian.PutUint16(buf[offset:], rtc.alarmDays)
+	offset += 2
+	if rtc.alarmEnabled {
+		buf[offset] = 1
+	} else {
+		buf[offset] = 0
+	}
+	offset++
+	buf[offset] = rtc.accessIndex
+	offset++
+	binary.LittleEndian.PutUint64(buf[offset:], uint64(rtc.lastSync))
+	offset += 8
+	binary.LittleEndian.PutUint64(buf[offset:], uint64(rtc.subMinute))
+	offset += 8
+
+	return offset
+}
+
+func (rtc *Huc3Rtc) Deserialize(buf []byte) int {
+	offset := 0
+
+	rtc.minutes = binary.LittleEndian.Uint16(buf[offset:])
+	offset += 2
+	rtc.days = binary.LittleEndian.Uint16(buf[offset:])
+	offset += 2
+	rtc.alarmMinutes = binary.LittleEndian.Uint16(buf[offset:])
+	offset += 2
+	rtc.alarmDays = binary.LittleEndian.Uint16(buf[offset:])
+	offset += 2
+	rtc.alarmEnabled = buf[offset] == 1
+	offset++
+	rtc.accessIndex = buf[offset]
+	offset++
+	rtc.lastSync = int64(binary.LittleEndian.Uint64(buf[offset:]))
+	offset += 8
+	rtc.subMinute = int64(binary.LittleEndian.Uint64(buf[offset:]))
+	offset += 8
+
+	return offset
+}
@@ -0,0 +1,220 @@
+package cartridge
+
+import (
+	"encoding/binary"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/davidyorr/LuccaGB/internal/clock"
+	"github.com/davidyorr/LuccaGB/internal/infrared"
+)
+
+func newHuc3TestCartridge(t *testing.T) (*Cartridge, *clock.Fixed) {
+	t.Helper()
+
+	return newClockedTestCartridge(t, newTestRom(0x20000, 0xFE, 0x02, 0x02))
+}
+
+// huc3Command sends an RTC command and returns the response.
+func huc3Command(cartridge *Cartridge, command uint8) uint8 {
+	cartridge.Write(0x0000, huc3ModeCommand)
+	cartridge.Write(0xA000, command)
+	cartridge.Write(0x0000, huc3ModeResponse)
+
+	return cartridge.Read(0xA000)
+}
+
+// huc3ReadNibbles reads count nibbles from index, least significant first.
+func huc3ReadNibbles(cartridge *Cartridge, index uint8, count int) uint16 {
+	huc3Command(cartridge, 0x40|index&0x0F)
+	huc3Command(cartridge, 0x50|index>>4)
+
+	var value uint16
+	for i := range count {
+		value |= uint16(huc3Command(cartridge, 0x10)&0x0F) << (i * 4)
+	}
+
+	return value
+}
+
+// huc3WriteNibbles writes count nibbles of value from index, least
+// significant first.
+func huc3WriteNibbles(cartridge *Cartridge, index uint8, count int, value uint16) {
+	huc3Command(cartridge, 0x40|index&0x0F)
+	huc3Command(cartridge, 0x50|index>>4)
+
+	for i := range count {
+		huc3Command(cartridge, 0x30|uint8(value>>(i*4))&0x0F)
+	}
+}
+
+func TestHuc3RamModes(t *testing.T) {
+	cartridge, _ := newHuc3TestCartridge(t)
+
+	cartridge.Write(0x0000, huc3ModeRam)
+	cartridge.Write(0xA000, 0x42)
+	if value := cartridge.Read(0xA000); value != 0x42 {
+		t.Errorf("expected 0x42 in RAM, got 0x%02X", value)
+	}
+
+	// mode 0x00 maps RAM read only
+	cartridge.Write(0x0000, huc3ModeRamReadOnly)
+	cartridge.Write(0xA000, 0x24)
+	if value := cartridge.Read(0xA000); value != 0x42 {
+		t.Errorf("expected RAM to be read only, got 0x%02X", value)
+	}
+
+	cartridge.Write(0x0000, huc3ModeSemaphore)
+	if value := cartridge.Read(0xA000); value != 0x01 {
+		t.Errorf("expected the RTC to always be ready, got 0x%02X", value)
+	}
+}
+
+func TestHuc3Commands(t *testing.T) {
+	cartridge, _ := newHuc3TestCartridge(t)
+
+	if response := huc3Command(cartridge, 0x62); response != 0x61 {
+		t.Errorf("expected 0x61 for the extended command 2, got 0x%02X", response)
+	}
+	if response := huc3Command(cartridge, 0x45); response != 0x40 {
+		t.Errorf("expected 0x40 for setting the access index, got 0x%02X", response)
+	}
+
+	// 2x writes without moving the access index
+	huc3Command(cartridge, 0x40)
+	huc3Command(cartridge, 0x27)
+	huc3Command(cartridge, 0x29)
+	if response := huc3Command(cartridge, 0x10); response != 0x19 {
+		t.Errorf("expected 0x19 reading back the written nibble, got 0x%02X", response)
+	}
+}
+
+func TestHuc3NibbleMap(t *testing.T) {
+	cartridge, _ := newHuc3TestCartridge(t)
+
+	huc3WriteNibbles(cartridge, 0x00, 3, 1234)
+	huc3WriteNibbles(cartridge, 0x03, 4, 0xBEEF)
+	huc3WriteNibbles(cartridge, 0x58, 3, 600)
+	huc3WriteNibbles(cartridge, 0x5B, 4, 0x1234)
+	huc3WriteNibbles(cartridge, 0x5F, 1, 1)
+
+	tests := []struct {
+		name     string
+		index    uint8
+		count    int
+		expected uint16
+	}{
+		{"minutes", 0x00, 3, 1234},
+		{"days", 0x03, 4, 0xBEEF},
+		{"alarm minutes", 0x58, 3, 600},
+		{"alarm days", 0x5B, 4, 0x1234},
+		{"alarm enabled", 0x5F, 1, 1},
+		{"unmapped", 0x07, 4, 0},
+	}
+	for _, test := range tests {
+		if value := huc3ReadNibbles(cartridge, test.index, test.count); value != test.expected {
+			t.Errorf("%s: expected 0x%X, got 0x%X", test.name, test.expected, value)
+		}
+	}
+}
+
+func TestHuc3RtcAdvance(t *testing.T) {
+	cartridge, fixed := newHuc3TestCartridge(t)
+	huc3WriteNibbles(cartridge, 0x00, 3, minutesPerDay-1)
+
+	fixed.Add(59 * time.Second)
+	if minutes := huc3ReadNibbles(cartridge, 0x00, 3); minutes != minutesPerDay-1 {
+		t.Errorf("expected no minute to pass yet, got %d", minutes)
+	}
+
+	fixed.Add(time.Second + 2*time.Minute)
+	if minutes := huc3ReadNibbles(cartridge, 0x00, 3); minutes != 2 {
+		t.Errorf("expected minute 2 of the next day, got %d", minutes)
+	}
+	if days := huc3ReadNibbles(cartridge, 0x03, 4); days != 1 {
+		t.Errorf("expected day 1, got %d", days)
+	}
+}
+
+func TestHuc3Infrared(t *testing.T) {
+	cartridge, _ := newHuc3TestCartridge(t)
+	local, remote := infrared.NewLink()
+	cartridge.ConnectInfrared(local)
+
+	cartridge.Write(0x0000, hucModeInfrared)
+	if value := cartridge.Read(0xA000); value != 0xC0 {
+		t.Errorf("expected 0xC0 with the other LED off, got 0x%02X", value)
+	}
+	cartridge.Write(0xA000, 0x01)
+	if !remote.Light() {
+		t.Error("expected the LED on")
+	}
+}
+
+func TestHuc3SaveFile(t *testing.T) {
+	cartridge, _ := newHuc3TestCartridge(t)
+	cartridge.Write(0x0000, huc3ModeRam)
+	cartridge.Write(0xA000, 0x42)
+	huc3WriteNibbles(cartridge, 0x00, 3, 100)
+	huc3WriteNibbles(cartridge, 0x03, 4, 7)
+	huc3WriteNibbles(cartridge, 0x58, 3, 200)
+	huc3WriteNibbles(cartridge, 0x5B, 4, 8)
+	huc3WriteNibbles(cartridge, 0x5F, 1, 1)
+
+	save := cartridge.SaveFile()
+	if len(save) != 0x2000+huc3FooterSize {
+		t.Fatalf("expected %d bytes, got %d", 0x2000+huc3FooterSize, len(save))
+	}
+	footer := save[0x2000:]
+	if timestamp := binary.LittleEndian.Uint64(footer); timestamp != uint64(testClockStart.Unix()) {
+		t.Errorf("expected the timestamp %d, got %d", testClockStart.Unix(), timestamp)
+	}
+	expected := []uint16{100, 7, 200, 8}
+	for i, value := range expected {
+		if actual := binary.LittleEndian.Uint16(footer[8+i*2:]); actual != value {
+			t.Errorf("expected 0x%02X: %d, got %d", 8+i*2, value, actual)
+		}
+	}
+	if footer[0x10] != 1 {
+		t.Errorf("expected the alarm enabled, got %d", footer[0x10])
+	}
+
+	// loaded a day and an hour later
+	loaded, loadedClock := newHuc3TestCartridge(t)
+	loadedClock.Set(testClockStart.Add(25 * time.Hour))
+	if err := loaded.LoadSaveFile(save); err != nil {
+		t.Fatal(err)
+	}
+	loaded.Write(0x0000, huc3ModeRam)
+	if value := loaded.Read(0xA000); value != 0x42 {
+		t.Errorf("expected 0x42 in RAM, got 0x%02X", value)
+	}
+	if minutes := huc3ReadNibbles(loaded, 0x00, 3); minutes != 160 {
+		t.Errorf("expected minute 160, got %d", minutes)
+	}
+	if days := huc3ReadNibbles(loaded, 0x03, 4); days != 8 {
+		t.Errorf("expected day 8, got %d", days)
+	}
+	if alarm := huc3ReadNibbles(loaded, 0x58, 3); alarm != 200 {
+		t.Errorf("expected the alarm at minute 200, got %d", alarm)
+	}
+}
+
+func TestHuc3SaveFileSize(t *testing.T) {
+	cartridge, _ := newHuc3TestCartridge(t)
+
+	// the RAM alone is accepted
+	if err := cartridge.LoadSaveFile(make([]byte, 0x2000)); err != nil {
+		t.Errorf("expected a save without the footer to load, got %v", err)
+	}
+
+	var sizeError *SaveSizeError
+	err := cartridge.LoadSaveFile(make([]byte, 0x2000+rtcFooterSize))
+	if !errors.As(err, &sizeError) {
+		t.Fatalf("expected a *SaveSizeError, got %v", err)
+	}
+	if len(sizeError.Expected) != 2 || sizeError.Expected[1] != 0x2000+huc3FooterSize {
+		t.Errorf("expected the sizes %d and %d, got %v", 0x2000, 0x2000+huc3FooterSize, sizeError.Expected)
+	}
+}
@@ -1,5 +1,7 @@
 package cartridge
 
+import "time"
+
 type MBC interface {
 	Read(address uint16) uint8
 	Write(address uint16, value uint8)
@@ -7,6 +9,22 @@ type MBC interface {
 	Deserialize(buf []byte) int
 }
 
+// rumbleMbc is implemented by mappers that can drive a rumble motor.
+type rumbleMbc interface {
+	rumbleOn() bool
+}
+
+// steppedMbc is implemented by mappers with hardware that runs off the
+// system clock
+type steppedMbc interface {
+	step(tCycles uint8)
+}
+
+// clockedMbc is implemented by mappers that keep time from the cartridge clock
+type clockedMbc interface {
+	rebaseClock(now time.Time)
+}
+
 var addressMaskSizes = map[uint8]uint32{
 	0x00: 0x7FFF,   // 15 bits - 32KiB (2 banks, no banking)
 	0x01: 0xFFFF,   // 16 bits - 64KiB (4 banks)
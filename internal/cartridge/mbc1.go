@@ -1,6 +1,7 @@
 package cartridge
 
 import (
+	"bytes"
 	"fmt"
 
 	"github.com/davidyorr/LuccaGB/internal/logger"
@@ -14,6 +15,10 @@ type Mbc1 struct {
 	// bitmask to wrap addresses to the physical RAM capacity,
 	// derived from the RAM size code
 	ramAddressMask uint32
+	// MBC1M multicarts don't connect bit 4 of the BANK1 register to the ROM,
+	// and BANK2 is shifted into bits 4-5 of the bank number instead of 5-6
+	bank1Mask  uint8
+	bank2Shift uint8
 
 	// =======================
 	// ====== Registers ======
@@ -32,10 +37,17 @@ type Mbc1 struct {
 	mode uint8
 }
 
-func newMbc1(cartridge *Cartridge) *Mbc1 {
+func newMbc1(cartridge *Cartridge, isMulticart bool) *Mbc1 {
 	mbc1 := &Mbc1{}
 
 	mbc1.cartridge = cartridge
+	if isMulticart {
+		mbc1.bank2Shift = 4
+		mbc1.bank1Mask = 0b0_1111
+	} else {
+		mbc1.bank2Shift = 5
+		mbc1.bank1Mask = 0b1_1111
+	}
 	mbc1.romAddressMask = addressMaskSizes[cartridge.romSizeCode]
 	mbc1.ramAddressMask = ramAddressMaskSizes[cartridge.ramSizeCode]
 	cartridge.ram = make([]uint8, ramSizes[cartridge.ramSizeCode])
@@ -62,7 +74,7 @@ func (mbc *Mbc1) Read(address uint16) uint8 {
 			return mbc.cartridge.rom[actualAddress]
 		} else if mbc.mode == 0b01 {
 			// Mode 1: Advanced banking mode
-			bank := uint32(mbc.bank2) << 5
+			bank := uint32(mbc.bank2) << mbc.bank2Shift
 			actualAddress := ((bank << 14) | uint32(address)) & mbc.romAddressMask
 			return mbc.cartridge.rom[actualAddress]
 		}
@@ -70,7 +82,8 @@ func (mbc *Mbc1) Read(address uint16) uint8 {
 	// ROM BANK 01-7F
 	case address >= 0x4000 && address <= 0x7FFF:
 		// lower 5 bits from Bank 1, upper 2 bits from Bank 2
-		bank := (uint32(mbc.bank2) << 5) | uint32(mbc.bank1)
+		// (lower 4 bits and bits 4-5 on multicarts)
+		bank := (uint32(mbc.bank2) << mbc.bank2Shift) | uint32(mbc.bank1&mbc.bank1Mask)
 		// map 0x4000-0x7FFF down to 0x0000-0x3FFF
 		offset := uint32(address) & 0b11_1111_1111_1111
 		actualAddress := ((bank << 14) | offset) & mbc.romAddressMask
@@ -160,6 +173,29 @@ func (mbc *Mbc1) Write(address uint16, value uint8) {
 	}
 }
 
+// multicartLogoOffsets are where the header logo of each game would be found
+// on an MBC1M multicart, whose games are laid out at 256KiB boundaries.
+var multicartLogoOffsets = [4]int{0x00104, 0x40104, 0x80104, 0xC0104}
+
+// isMbc1Multicart detects MBC1M wiring heuristically. Multicarts are 8Mbit
+// (1MiB) and contain a menu plus up to three games, each starting with its
+// own copy of the Nintendo logo. A regular 1MiB cart only has the one logo.
+func isMbc1Multicart(rom []uint8) bool {
+	if len(rom) != 1024*1024 {
+		return false
+	}
+
+	logo := rom[0x0104:0x0134]
+	logoCount := 0
+	for _, offset := range multicartLogoOffsets {
+		if bytes.Equal(rom[offset:offset+len(logo)], logo) {
+			logoCount++
+		}
+	}
+
+	return logoCount > 1
+}
+
 func (mbc *Mbc1) Serialize(buf []byte) int {
 	offset := 0
 
@@ -0,0 +1,443 @@
+package cartridge
+
+import (
+	"encoding/binary"
+	"fmt"
+	"time"
+
+	"github.com/davidyorr/LuccaGB/internal/logger"
+)
+
+type Mbc3 struct {
+	cartridge *Cartridge
+	// bitmask to wrap addresses to the physical ROM capacity,
+	// derived from the ROM size code
+	romAddressMask uint32
+	// bitmask to wrap addresses to the physical RAM capacity,
+	// derived from the RAM size code
+	ramAddressMask uint32
+	// MBC30 (Pocket Monsters Crystal JP) uses 8 ROM bank bits and 3 RAM bank bits
+	isMbc30 bool
+	// only the TIMER variants have the real-time clock wired up
+	hasRtc bool
+
+	// =======================
+	// ====== Registers ======
+	// =======================
+
+	// 0000–1FFF — RAM and Timer Enable (Write Only)
+	ramg uint8
+
+	// 2000–3FFF — ROM Bank Number (Write Only)
+	romb uint8
+
+	// 4000–5FFF — RAM Bank Number — or — RTC Register Select (Write Only)
+	//	0x00-0x07 selects a RAM bank, 0x08-0x0C selects an RTC register
+	ramb uint8
+
+	// 6000–7FFF — Latch Clock Data (Write Only)
+	//	the last value written, a 0x00 -> 0x01 sequence latches the clock
+	latch uint8
+
+	rtc Rtc
+}
+
+func newMbc3(cartridge *Cartridge) *Mbc3 {
+	mbc3 := &Mbc3{}
+
+	mbc3.cartridge = cartridge
+	mbc3.romAddressMask = addressMaskSizes[cartridge.romSizeCode]
+	mbc3.ramAddressMask = ramAddressMaskSizes[cartridge.ramSizeCode]
+	mbc3.isMbc30 = cartridge.romSizeCode == 0x07 || cartridge.ramSizeCode == 0x05
+	mbc3.hasRtc = cartridge.cartridgeType == 0x0F || cartridge.cartridgeType == 0x10
+	cartridge.ram = make([]uint8, ramSizes[cartridge.ramSizeCode])
+	mbc3.Reset()
+
+	return mbc3
+}
+
+func (mbc *Mbc3) Reset() {
+	mbc.ramg = 0x00
+	mbc.romb = 0x01
+	mbc.ramb = 0x00
+	mbc.latch = 0xFF
+	mbc.rtc.Reset(mbc.cartridge.clock.Now())
+}
+
+func (mbc *Mbc3) Read(address uint16) uint8 {
+	switch {
+
+	// ROM Bank 00
+	case address >= 0x000 && address <= 0x3FFF:
+		actualAddress := uint32(address) & mbc.romAddressMask
+		return mbc.cartridge.rom[actualAddress]
+
+	// ROM BANK 01-7F (01-FF on MBC30)
+	case address >= 0x4000 && address <= 0x7FFF:
+		bank := uint32(mbc.romb)
+		// map 0x4000-0x7FFF down to 0x0000-0x3FFF
+		offset := uint32(address) & 0b11_1111_1111_1111
+		actualAddress := ((bank << 14) | offset) & mbc.romAddressMask
+		return mbc.cartridge.rom[actualAddress]
+
+	// External RAM or RTC register
+	case address >= 0xA000 && address <= 0xBFFF:
+		// RAM and RTC disabled
+		if (mbc.ramg & 0b1111) != 0b1010 {
+			return 0xFF
+		}
+
+		if mbc.ramb >= 0x08 {
+			if !mbc.hasRtc || mbc.ramb > 0x0C {
+				return 0xFF
+			}
+			return mbc.rtc.ReadLatched(mbc.ramb)
+		}
+
+		// no RAM hardware
+		if len(mbc.cartridge.ram) == 0 {
+			return 0xFF
+		}
+
+		bank := uint32(mbc.ramb)
+		offset := uint32(address - 0xA000)
+
+		actualAddress := ((bank << 13) | offset) & mbc.ramAddressMask
+		return mbc.cartridge.ram[actualAddress]
+	}
+
+	logger.Error(
+		"MBC3 returning 0xFF",
+		"ADDRESS", fmt.Sprintf("0x%04X", address),
+		"ROMB", fmt.Sprintf("0x%08b", mbc.romb),
+		"RAMB", fmt.Sprintf("0x%08b", mbc.ramb),
+	)
+	return 0xFF
+}
+
+func (mbc *Mbc3) Write(address uint16, value uint8) {
+	switch {
+
+	// RAM and Timer Enable
+	case address >= 0x0000 && address <= 0x1FFF:
+		mbc.ramg = value & 0b0000_1111
+
+	// ROM Bank Number
+	case address >= 0x2000 && address <= 0x3FFF:
+		if mbc.isMbc30 {
+			mbc.romb = value
+		} else {
+			mbc.romb = value & 0b0111_1111
+		}
+		if mbc.romb == 0x00 {
+			mbc.romb = 0x01
+		}
+
+	// RAM Bank Number or RTC Register Select
+	case address >= 0x4000 && address <= 0x5FFF:
+		if value >= 0x08 {
+			mbc.ramb = value & 0b0000_1111
+		} else if mbc.isMbc30 {
+			mbc.ramb = value & 0b0111
+		} else {
+			mbc.ramb = value & 0b0011
+		}
+
+	// Latch Clock Data
+	case address >= 0x6000 && address <= 0x7FFF:
+		if mbc.hasRtc && mbc.latch == 0x00 && value == 0x01 {
+			mbc.rtc.Latch(mbc.cartridge.clock.Now())
+		}
+		mbc.latch = value
+
+	// Write to RAM or RTC register
+	case address >= 0xA000 && address <= 0xBFFF:
+		// RAM and RTC disabled
+		if (mbc.ramg & 0b1111) != 0b1010 {
+			return
+		}
+
+		if mbc.ramb >= 0x08 {
+			if mbc.hasRtc && mbc.ramb <= 0x0C {
+				mbc.rtc.Write(mbc.ramb, value, mbc.cartridge.clock.Now())
+			}
+			return
+		}
+
+		// no RAM hardware
+		if len(mbc.cartridge.ram) == 0 {
+			return
+		}
+
+		bank := uint32(mbc.ramb)
+		offset := uint32(address - 0xA000)
+
+		actualAddress := ((bank << 13) | offset) & mbc.ramAddressMask
+		mbc.cartridge.ram[actualAddress] = value
+	}
+}
+
+func (mbc *Mbc3) rebaseClock(now time.Time) {
+	mbc.rtc.Rebase(now)
+}
+
+func (mbc *Mbc3) realTimeClock() *Rtc {
+	if !mbc.hasRtc {
+		return nil
+	}
+
+	return &mbc.rtc
+}
+
+func (mbc *Mbc3) Serialize(buf []byte) int {
+	offset := 0
+
+	buf[offset] = mbc.ramg
+	offset++
+	buf[offset] = mbc.romb
+	offset++
+	buf[offset] = mbc.ramb
+	offset++
+	buf[offset] = mbc.latch
+	offset++
+
+	offset += mbc.rtc.Serialize(buf[offset:])
+
+	return offset
+}
+
+func (mbc *Mbc3) Deserialize(buf []byte) int {
+	offset := 0
+
+	mbc.ramg = buf[offset]
+	offset++
+	mbc.romb = buf[offset]
+	offset++
+	mbc.ramb = buf[offset]
+	offset++
+	mbc.latch = buf[offset]
+	offset++
+
+	offset += mbc.rtc.Deserialize(buf[offset:])
+
+	return offset
+}
+
+// Rtc is the MBC3 real-time clock. The counters only advance when they are
+// observed (latched or written), by catching up on the time that elapsed on
+// the cartridge clock since the last sync.
+type Rtc struct {
+	// live counters
+	//	08 - RTC S: Seconds 0-59 (0-3Bh)
+	//	09 - RTC M: Minutes 0-59 (0-3Bh)
+	//	0A - RTC H: Hours 0-23 (0-17h)
+	//	0B - RTC DL: Lower 8 bits of Day Counter (0-FFh)
+	//	0C - RTC DH: Upper 1 bit of Day Counter, Carry Bit, Halt Flag
+	//		Bit 0 - Most significant bit of Day Counter (Bit 8)
+	//		Bit 6 - Halt (0=Active, 1=Stop Timer)
+	//		Bit 7 - Day Counter Carry Bit (1=Counter Overflow)
+	seconds uint8
+	minutes uint8
+	hours   uint8
+	days    uint16
+	halt    bool
+	carry   bool
+
+	// the values visible to the CPU, copied from the live counters on latch
+	latched [5]uint8
+
+	// wall time (unix nanoseconds) that the live counters are synced up to
+	lastSync int64
+	// nanoseconds accumulated towards the next whole second
+	subSecond int64
+}
+
+const nanosecondsPerSecond = int64(time.Second)
+
+// read masks for the RTC registers 0x08-0x0C
+var rtcRegisterMasks = [5]uint8{0x3F, 0x3F, 0x1F, 0xFF, 0xC1}
+
+func (rtc *Rtc) Reset(now time.Time) {
+	rtc.seconds = 0
+	rtc.minutes = 0
+	rtc.hours = 0
+	rtc.days = 0
+	rtc.halt = false
+	rtc.carry = false
+	rtc.latched = [5]uint8{}
+	rtc.lastSync = now.UnixNano()
+	rtc.subSecond = 0
+}
+
+// Sync advances the live counters by the wall time elapsed since the last sync.
+func (rtc *Rtc) Sync(now time.Time) {
+	nowNano := now.UnixNano()
+	elapsed := nowNano - rtc.lastSync
+	rtc.lastSync = nowNano
+
+	// time went backwards (clock adjusted), don't rewind the counters
+	if elapsed <= 0 || rtc.halt {
+		return
+	}
+
+	elapsed += rtc.subSecond
+	rtc.subSecond = elapsed % nanosecondsPerSecond
+	rtc.advance(elapsed / nanosecondsPerSecond)
+}
+
+// Rebase moves the sync point to now without advancing the counters, for
+// when the clock source is swapped out.
+func (rtc *Rtc) Rebase(now time.Time) {
+	rtc.lastSync = now.UnixNano()
+}
+
+func (rtc *Rtc) Latch(now time.Time) {
+	rtc.Sync(now)
+	rtc.latched = rtc.registers()
+}
+
+func (rtc *Rtc) ReadLatched(register uint8) uint8 {
+	index := register - 0x08
+	return rtc.latched[index] & rtcRegisterMasks[index]
+}
+
+func (rtc *Rtc) Write(register uint8, value uint8, now time.Time) {
+	rtc.Sync(now)
+
+	index := register - 0x08
+	value &= rtcRegisterMasks[index]
+
+	switch register {
+	case 0x08:
+		rtc.seconds = value
+		// writing the seconds register resets the sub-second divider
+		rtc.subSecond = 0
+	case 0x09:
+		rtc.minutes = value
+	case 0x0A:
+		rtc.hours = value
+	case 0x0B:
+		rtc.days = (rtc.days & 0x100) | uint16(value)
+	case 0x0C:
+		rtc.days = (rtc.days & 0x0FF) | (uint16(value&0b1) << 8)
+		rtc.halt = (value & 0b0100_0000) != 0
+		rtc.carry = (value & 0b1000_0000) != 0
+	}
+
+	rtc.latched[index] = value
+}
+
+func (rtc *Rtc) registers() [5]uint8 {
+	dh := uint8(rtc.days>>8) & 0b1
+	if rtc.halt {
+		dh |= 0b0100_0000
+	}
+	if rtc.carry {
+		dh |= 0b1000_0000
+	}
+
+	return [5]uint8{rtc.seconds, rtc.minutes, rtc.hours, uint8(rtc.days), dh}
+}
+
+// advance moves the live counters forward by a number of seconds.
+func (rtc *Rtc) advance(seconds int64) {
+	// Out of range values (e.g. seconds=62) are possible via direct writes, and
+	// wrap at their bit width without carrying into the next counter. Tick one
+	// second at a time until everything is back in range.
+	for seconds > 0 && (rtc.seconds >= 60 || rtc.minutes >= 60 || rtc.hours >= 24) {
+		rtc.tick()
+		seconds--
+	}
+	if seconds == 0 {
+		return
+	}
+
+	total := int64(rtc.seconds) +
+		int64(rtc.minutes)*60 +
+		int64(rtc.hours)*3600 +
+		int64(rtc.days)*86400 +
+		seconds
+
+	rtc.seconds = uint8(total % 60)
+	rtc.minutes = uint8((total / 60) % 60)
+	rtc.hours = uint8((total / 3600) % 24)
+	days := total / 86400
+	if days >= 512 {
+		rtc.carry = true
+	}
+	rtc.days = uint16(days % 512)
+}
+
+// tick advances the live counters by exactly one second.
+func (rtc *Rtc) tick() {
+	// each counter wraps at its bit width (e.g. 63 -> 0) without carrying
+	rtc.seconds = (rtc.seconds + 1) & 0x3F
+	if rtc.seconds != 60 {
+		return
+	}
+	rtc.seconds = 0
+
+	rtc.minutes = (rtc.minutes + 1) & 0x3F
+	if rtc.minutes != 60 {
+		return
+	}
+	rtc.minutes = 0
+
+	rtc.hours = (rtc.hours + 1) & 0x1F
+	if rtc.hours != 24 {
+		return
+	}
+	rtc.hours = 0
+
+	rtc.days++
+	if rtc.days == 512 {
+		rtc.days = 0
+		rtc.carry = true
+	}
+}
+
+func (rtc *Rtc) Serialize(buf []byte) int {
+	offset := 0
+
+	registers := rtc.registers()
+	n := copy(buf[offset:], registers[:])
+	offset += n
+
+	n = copy(buf[offset:], rtc.latched[:])
+	offset += n
+
+	binary.LittleEndian.PutUint64(buf[offset:], uint64(rtc.lastSync))
+	offset += 8
+	binary.LittleEndian.PutUint64(buf[offset:], uint64(rtc.subSecond))
+	offset += 8
+
+	return offset
+}
+
+func (rtc *Rtc) Deserialize(buf []byte) int {
+	offset := 0
+
+	rtc.seconds = buf[offset]
+	offset++
+	rtc.minutes = buf[offset]
+	offset++
+	rtc.hours = buf[offset]
+	offset++
+	dl := buf[offset]
+	offset++
+	dh := buf[offset]
+	offset++
+	rtc.days = (uint16(dh&0b1) << 8) | uint16(dl)
+	rtc.halt = (dh & 0b0100_0000) != 0
+	rtc.carry = (dh & 0b1000_0000) != 0
+
+	n := copy(rtc.latched[:], buf[offset:offset+5])
+	offset += n
+
+	rtc.lastSync = int64(binary.LittleEndian.Uint64(buf[offset:]))
+	offset += 8
+	rtc.subSecond = int64(binary.LittleEndian.Uint64(buf[offset:]))
+	offset += 8
+
+	return offset
+}
@@ -0,0 +1,145 @@
+package cartridge
+
+import (
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"github.com/davidyorr/LuccaGB/internal/clock"
+)
+
+// newMbc3RtcTestCartridge returns an MBC3+TIMER+RAM+BATTERY cart with 8KiB of
+// RAM and the RTC enabled.
+func newMbc3RtcTestCartridge(t *testing.T) (*Cartridge, *clock.Fixed) {
+	t.Helper()
+
+	cartridge, fixed := newClockedTestCartridge(t, newTestRom(0x20000, 0x10, 0x02, 0x02))
+	cartridge.Write(0x0000, 0x0A)
+
+	return cartridge, fixed
+}
+
+func latchRtc(cartridge *Cartridge) {
+	cartridge.Write(0x6000, 0x00)
+	cartridge.Write(0x6000, 0x01)
+}
+
+// readRtc returns the latched S, M, H, DL and DH registers.
+func readRtc(cartridge *Cartridge) [5]uint8 {
+	var registers [5]uint8
+	for i := range registers {
+		cartridge.Write(0x4000, 0x08+uint8(i))
+		registers[i] = cartridge.Read(0xA000)
+	}
+
+	return registers
+}
+
+func writeRtc(cartridge *Cartridge, register uint8, value uint8) {
+	cartridge.Write(0x4000, register)
+	cartridge.Write(0xA000, value)
+}
+
+func TestMbc3RtcLatch(t *testing.T) {
+	cartridge, fixed := newMbc3RtcTestCartridge(t)
+
+	fixed.Add(1*time.Hour + 2*time.Minute + 3*time.Second)
+	if registers := readRtc(cartridge); registers != [5]uint8{} {
+		t.Errorf("expected the latched registers unchanged before latching, got %v", registers)
+	}
+
+	latchRtc(cartridge)
+	if registers := readRtc(cartridge); registers != [5]uint8{3, 2, 1, 0, 0} {
+		t.Errorf("expected 1:02:03 latched, got %v", registers)
+	}
+
+	// only a 0x00 then 0x01 write latches
+	fixed.Add(time.Minute)
+	cartridge.Write(0x6000, 0x01)
+	if registers := readRtc(cartridge); registers[1] != 2 {
+		t.Errorf("expected the minutes to stay latched, got %d", registers[1])
+	}
+}
+
+func TestMbc3RtcHalt(t *testing.T) {
+	cartridge, fixed := newMbc3RtcTestCartridge(t)
+
+	writeRtc(cartridge, 0x0C, 0x40)
+	fixed.Add(time.Hour)
+	latchRtc(cartridge)
+	if registers := readRtc(cartridge); registers != [5]uint8{0, 0, 0, 0, 0x40} {
+		t.Errorf("expected the halted clock to hold, got %v", registers)
+	}
+
+	writeRtc(cartridge, 0x0C, 0x00)
+	fixed.Add(5 * time.Second)
+	latchRtc(cartridge)
+	if registers := readRtc(cartridge); registers[0] != 5 {
+		t.Errorf("expected 5 seconds once resumed, got %d", registers[0])
+	}
+}
+
+func TestMbc3RtcDayCarry(t *testing.T) {
+	cartridge, fixed := newMbc3RtcTestCartridge(t)
+
+	// day 511, 23:59:59
+	writeRtc(cartridge, 0x08, 59)
+	writeRtc(cartridge, 0x09, 59)
+	writeRtc(cartridge, 0x0A, 23)
+	writeRtc(cartridge, 0x0B, 0xFF)
+	writeRtc(cartridge, 0x0C, 0x01)
+
+	fixed.Add(time.Second)
+	latchRtc(cartridge)
+	if registers := readRtc(cartridge); registers != [5]uint8{0, 0, 0, 0, 0x80} {
+		t.Errorf("expected day 0 with the carry set, got %v", registers)
+	}
+
+	// the carry stays until cleared
+	writeRtc(cartridge, 0x0C, 0x00)
+	latchRtc(cartridge)
+	if registers := readRtc(cartridge); registers[4] != 0x00 {
+		t.Errorf("expected the carry cleared, got DH 0x%02X", registers[4])
+	}
+}
+
+func TestMbc3RtcSaveFile(t *testing.T) {
+	cartridge, _ := newMbc3RtcTestCartridge(t)
+	writeRtc(cartridge, 0x09, 10)
+	writeRtc(cartridge, 0x0B, 3)
+	latchRtc(cartridge)
+
+	save := cartridge.SaveFile()
+	if len(save) != 0x2000+rtcFooterSize {
+		t.Fatalf("expected %d bytes, got %d", 0x2000+rtcFooterSize, len(save))
+	}
+
+	tests := []struct {
+		name string
+		save []byte
+	}{
+		{"48 byte footer", save},
+		// a 32-bit timestamp
+		{"44 byte footer", save[:0x2000+rtcFooterLegacySize]},
+	}
+	for _, test := range tests {
+		loaded, fixed := newMbc3RtcTestCartridge(t)
+		fixed.Set(testClockStart.Add(2 * time.Hour))
+		if err := loaded.LoadSaveFile(test.save); err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+
+		if registers := readRtc(loaded); registers != [5]uint8{0, 10, 0, 3, 0} {
+			t.Errorf("%s: expected the latched registers restored, got %v", test.name, registers)
+		}
+		latchRtc(loaded)
+		if registers := readRtc(loaded); registers != [5]uint8{0, 10, 2, 3, 0} {
+			t.Errorf("%s: expected the clock to catch up 2 hours, got %v", test.name, registers)
+		}
+	}
+
+	if timestamp := binary.LittleEndian.Uint64(save[0x2000+0x28:]); timestamp != uint64(testClockStart.Unix()) {
+		t.Errorf("expected the timestamp %d, got %d", testClockStart.Unix(), timestamp)
+	}
+}
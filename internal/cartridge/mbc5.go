@@ -14,6 +14,8 @@ type Mbc5 struct {
 	// bitmask to wrap addresses to the physical RAM capacity,
 	// derived from the RAM size code
 	ramAddressMask uint32
+	// rumble carts wire bit 3 of the RAM bank register to the motor
+	hasRumble bool
 
 	// =======================
 	// ====== Registers ======
@@ -30,12 +32,16 @@ type Mbc5 struct {
 
 	// 4000-5FFF - RAM bank number (Write Only)
 	ramb uint8
+
+	// 4000-5FFF - bit 3, rumble motor on/off (Write Only, rumble carts only)
+	rumble bool
 }
 
 func newMbc5(cartridge *Cartridge) *Mbc5 {
 	mbc5 := &Mbc5{}
 
 	mbc5.cartridge = cartridge
+	mbc5.hasRumble = cartridge.cartridgeType >= 0x1C && cartridge.cartridgeType <= 0x1E
 	mbc5.romAddressMask = addressMaskSizes[cartridge.romSizeCode]
 	mbc5.ramAddressMask = ramAddressMaskSizes[cartridge.ramSizeCode]
 	cartridge.ram = make([]uint8, ramSizes[cartridge.ramSizeCode])
@@ -49,6 +55,7 @@ func (mbc *Mbc5) Reset() {
 	mbc.romb0 = 0x01
 	mbc.romb1 = 0x00
 	mbc.ramb = 0x00
+	mbc.setRumble(false)
 }
 
 func (mbc *Mbc5) Read(address uint16) uint8 {
@@ -113,7 +120,13 @@ func (mbc *Mbc5) Write(address uint16, value uint8) {
 
 	// RAM Bank Select
 	case address >= 0x4000 && address <= 0x5FFF:
-		mbc.ramb = value & 0b0000_1111
+		if mbc.hasRumble {
+			// only 3 bits select the RAM bank, bit 3 drives the motor
+			mbc.ramb = value & 0b0000_0111
+			mbc.setRumble(value&0b0000_1000 != 0)
+		} else {
+			mbc.ramb = value & 0b0000_1111
+		}
 
 	// Write to RAM
 	case address >= 0xA000 && address <= 0xBFFF:
@@ -135,6 +148,19 @@ func (mbc *Mbc5) Write(address uint16, value uint8) {
 	}
 }
 
+func (mbc *Mbc5) setRumble(on bool) {
+	if mbc.rumble == on {
+		return
+	}
+
+	mbc.rumble = on
+	mbc.cartridge.notifyRumble(on)
+}
+
+func (mbc *Mbc5) rumbleOn() bool {
+	return mbc.rumble
+}
+
 func (mbc *Mbc5) Serialize(buf []byte) int {
 	offset := 0
 
@@ -146,6 +172,12 @@ func (mbc *Mbc5) Serialize(buf []byte) int {
 	offset++
 	buf[offset] = mbc.ramb
 	offset++
+	if mbc.rumble {
+		buf[offset] = 1
+	} else {
+		buf[offset] = 0
+	}
+	offset++
 
 	return offset
 }
@@ -161,6 +193,9 @@ func (mbc *Mbc5) Deserialize(buf []byte) int {
 	offset++
 	mbc.ramb = buf[offset]
 	offset++
+	// restored without notifying, loading a state doesn't switch the motor
+	mbc.rumble = buf[offset] == 1
+	offset++
 
 	return offset
 }
@@ -0,0 +1,505 @@
+package cartridge
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/davidyorr/LuccaGB/internal/logger"
+)
+
+// Accelerometer readings are centered on 0x81D0 and move by roughly 0x70 per g
+const (
+	accelerometerCenter = 0x81D0
+	accelerometerPerG   = 0x70
+	// a latch that has been erased but not yet latched reads back as 0x8000
+	accelerometerErased = 0x8000
+	// readings are clamped so extreme input can't wrap the 16-bit registers
+	maxTiltG = 4.0
+)
+
+// the 93LC56 is 2Kbit, organised as 128 16-bit words
+const eepromSize = 256
+
+type Mbc7 struct {
+	cartridge *Cartridge
+	// bitmask to wrap addresses to the physical ROM capacity,
+	// derived from the ROM size code
+	romAddressMask uint32
+
+	// =======================
+	// ====== Registers ======
+	// =======================
+
+	// 0000–1FFF — RAM Enable 1 (Write Only)
+	ramg1 uint8
+
+	// 2000–3FFF — ROM Bank Number (Write Only)
+	romb uint8
+
+	// 4000–5FFF — RAM Enable 2 (Write Only)
+	ramg2 uint8
+
+	// Ax0x/Ax1x — accelerometer erase/latch
+	latchReady bool
+	// Ax2x/Ax3x — latched X, Ax4x/Ax5x — latched Y
+	xLatch uint16
+	yLatch uint16
+
+	// Ax8x — EEPROM pins
+	eeprom Eeprom
+}
+
+func newMbc7(cartridge *Cartridge) *Mbc7 {
+	mbc7 := &Mbc7{}
+
+	mbc7.cartridge = cartridge
+	mbc7.romAddressMask = addressMaskSizes[cartridge.romSizeCode]
+	// the header reports no RAM, the EEPROM is what gets persisted
+	cartridge.ram = make([]uint8, eepromSize)
+	for i := range cartridge.ram {
+		cartridge.ram[i] = 0xFF
+	}
+	mbc7.eeprom.data = cartridge.ram
+	mbc7.Reset()
+
+	return mbc7
+}
+
+func (mbc *Mbc7) Reset() {
+	mbc.ramg1 = 0x00
+	mbc.romb = 0x01
+	mbc.ramg2 = 0x00
+	mbc.latchReady = false
+	mbc.xLatch = accelerometerErased
+	mbc.yLatch = accelerometerErased
+	mbc.eeprom.Reset()
+}
+
+func (mbc *Mbc7) ramEnabled() bool {
+	return mbc.ramg1 == 0x0A && mbc.ramg2 == 0x40
+}
+
+func (mbc *Mbc7) Read(address uint16) uint8 {
+	switch {
+
+	// ROM Bank 00
+	case address <= 0x3FFF:
+		return mbc.cartridge.rom[address]
+
+	// ROM Bank 00-7F
+	case address >= 0x4000 && address <= 0x7FFF:
+		bank := uint32(mbc.romb)
+		// map 0x4000-0x7FFF down to 0x0000-0x3FFF
+		offset := uint32(address) & 0b11_1111_1111_1111
+		actualAddress := ((bank << 14) | offset) & mbc.romAddressMask
+		return mbc.cartridge.rom[actualAddress]
+
+	// Registers, mirrored every 0x100 bytes
+	case address >= 0xA000 && address <= 0xAFFF:
+		if !mbc.ramEnabled() {
+			return 0xFF
+		}
+
+		switch (address >> 4) & 0x0F {
+		case 0x2:
+			return uint8(mbc.xLatch)
+		case 0x3:
+			return uint8(mbc.xLatch >> 8)
+		case 0x4:
+			return uint8(mbc.yLatch)
+		case 0x5:
+			return uint8(mbc.yLatch >> 8)
+		case 0x6:
+			// would be the Z axis, which isn't fitted
+			return 0x00
+		case 0x8:
+			return mbc.eeprom.Pins()
+		}
+
+		return 0xFF
+
+	// Unmapped
+	case address >= 0xB000 && address <= 0xBFFF:
+		return 0xFF
+	}
+
+	logger.Error(
+		"MBC7 returning 0xFF",
+		"ADDRESS", fmt.Sprintf("0x%04X", address),
+		"ROMB", fmt.Sprintf("0x%08b", mbc.romb),
+	)
+	return 0xFF
+}
+
+func (mbc *Mbc7) Write(address uint16, value uint8) {
+	switch {
+
+	// RAM Enable 1
+	case address <= 0x1FFF:
+		mbc.ramg1 = value
+
+	// ROM Bank Number
+	case address >= 0x2000 && address <= 0x3FFF:
+		mbc.romb = value & 0b0111_1111
+
+	// RAM Enable 2
+	case address >= 0x4000 && address <= 0x5FFF:
+		mbc.ramg2 = value
+
+	// Registers, mirrored every 0x100 bytes
+	case address >= 0xA000 && address <= 0xAFFF:
+		if !mbc.ramEnabled() {
+			return
+		}
+
+		switch (address >> 4) & 0x0F {
+		// erase the latched values, arming the next latch
+		case 0x0:
+			if value == 0x55 {
+				mbc.latchReady = true
+				mbc.xLatch = accelerometerErased
+				mbc.yLatch = accelerometerErased
+			}
+		// latch the current accelerometer reading
+		case 0x1:
+			if value == 0xAA && mbc.latchReady {
+				mbc.latchReady = false
+				mbc.xLatch = accelerometerReading(-mbc.cartridge.tiltX)
+				mbc.yLatch = accelerometerReading(mbc.cartridge.tiltY)
+			}
+		case 0x8:
+			mbc.eeprom.SetPins(value)
+		}
+	}
+}
+
+// accelerometerReading converts an acceleration in g to the sensor's value
+func accelerometerReading(g float64) uint16 {
+	g = max(-maxTiltG, min(maxTiltG, g))
+
+	return uint16(int32(accelerometerCenter) + int32(g*accelerometerPerG))
+}
+
+func (mbc *Mbc7) Serialize(buf []byte) int {
+	offset := 0
+
+	buf[offset] = mbc.ramg1
+	offset++
+	buf[offset] = mbc.romb
+	offset++
+	buf[offset] = mbc.ramg2
+	offset++
+	if mbc.latchReady {
+		buf[offset] = 1
+	} else {
+		buf[offset] = 0
+	}
+	offset++
+	binary.LittleEndian.PutUint16(buf[offset:], mbc.xLatch)
+	offset += 2
+	binary.LittleEndian.PutUint16(buf[offset:], mbc.yLatch)
+	offset += 2
+
+	offset += mbc.eeprom.Serialize(buf[offset:])
+
+	return offset
+}
+
+func (mbc *Mbc7) Deserialize(buf []byte) int {
+	offset := 0
+
+	mbc.ramg1 = buf[offset]
+	offset++
+	mbc.romb = buf[offset]
+	offset++
+	mbc.ramg2 = buf[offset]
+	offset++
+	mbc.latchReady = buf[offset] == 1
+	offset++
+	mbc.xLatch = binary.LittleEndian.Uint16(buf[offset:])
+	offset += 2
+	mbc.yLatch = binary.LittleEndian.Uint16(buf[offset:])
+	offset += 2
+
+	// the cartridge may have reallocated its RAM while deserializing
+	mbc.eeprom.data = mbc.cartridge.ram
+	offset += mbc.eeprom.Deserialize(buf[offset:])
+
+	return offset
+}
+
+// =======================
+// ======= EEPROM ========
+// =======================
+
+type eepromState uint8
+
+const (
+	// waiting for a start bit
+	eepromIdle eepromState = iota
+	// shifting in the opcode and address
+	eepromCommand
+	// shifting out a word
+	eepromRead
+	// shifting in a word for WRITE
+	eepromWrite
+	// shifting in a word for WRAL
+	eepromWriteAll
+)
+
+// the start bit isn't counted, commands are 2 opcode bits and 8 address bits
+const eepromCommandBits = 10
+
+// Eeprom is a 93LC56 serial EEPROM in 16-bit mode. The game bit-bangs its
+// pins through the Ax8x register:
+//
+//	Bit 7 - CS (chip select)
+//	Bit 6 - CLK (clock), inputs are sampled on the rising edge
+//	Bit 1 - DI (data in)
+//	Bit 0 - DO (data out)
+//
+// Each command starts with a 1 bit, followed by the opcode and address:
+//
+//	READ  10 xAAAAAAA
+//	WRITE 01 xAAAAAAA DDDDDDDDDDDDDDDD
+//	ERASE 11 xAAAAAAA
+//	EWEN  00 11xxxxxx
+//	EWDS  00 00xxxxxx
+//	ERAL  00 10xxxxxx
+//	WRAL  00 01xxxxxx DDDDDDDDDDDDDDDD
+type Eeprom struct {
+	// 128 16-bit words, stored little-endian. Backed by the cartridge RAM so it
+	// persists through the battery save.
+	data []uint8
+
+	cs  bool
+	clk bool
+	di  bool
+	do  bool
+
+	state eepromState
+	// bits shifted in or out of the current command
+	shift     uint16
+	bitCount  uint8
+	address   uint8
+	writeable bool
+}
+
+func (eeprom *Eeprom) Reset() {
+	eeprom.cs = false
+	eeprom.clk = false
+	eeprom.di = false
+	// DO reads high when the chip is ready
+	eeprom.do = true
+	eeprom.state = eepromIdle
+	eeprom.shift = 0
+	eeprom.bitCount = 0
+	eeprom.address = 0
+	eeprom.writeable = false
+}
+
+func (eeprom *Eeprom) Pins() uint8 {
+	var value uint8
+	if eeprom.cs {
+		value |= 0b1000_0000
+	}
+	if eeprom.clk {
+		value |= 0b0100_0000
+	}
+	if eeprom.di {
+		value |= 0b0000_0010
+	}
+	if eeprom.do {
+		value |= 0b0000_0001
+	}
+
+	return value
+}
+
+func (eeprom *Eeprom) SetPins(value uint8) {
+	cs := value&0b1000_0000 != 0
+	clk := value&0b0100_0000 != 0
+	eeprom.di = value&0b0000_0010 != 0
+
+	// dropping CS aborts whatever command was in progress
+	if !cs {
+		eeprom.cs = false
+		eeprom.clk = clk
+		eeprom.state = eepromIdle
+		eeprom.do = true
+		return
+	}
+
+	risingEdge := eeprom.cs && !eeprom.clk && clk
+	eeprom.cs = true
+	eeprom.clk = clk
+
+	if risingEdge {
+		eeprom.clock()
+	}
+}
+
+func (eeprom *Eeprom) clock() {
+	switch eeprom.state {
+
+	case eepromIdle:
+		if eeprom.di {
+			eeprom.state = eepromCommand
+			eeprom.shift = 0
+			eeprom.bitCount = 0
+		}
+
+	case eepromCommand:
+		eeprom.shiftIn()
+		if eeprom.bitCount == eepromCommandBits {
+			eeprom.execute()
+		}
+
+	case eepromRead:
+		eeprom.do = eeprom.shift&0x8000 != 0
+		eeprom.shift <<= 1
+		eeprom.bitCount++
+		// reads continue sequentially until CS is dropped
+		if eeprom.bitCount == 16 {
+			eeprom.address = (eeprom.address + 1) & 0x7F
+			eeprom.shift = eeprom.word(eeprom.address)
+			eeprom.bitCount = 0
+		}
+
+	case eepromWrite, eepromWriteAll:
+		eeprom.shiftIn()
+		if eeprom.bitCount == 16 {
+			if eeprom.writeable {
+				if eeprom.state == eepromWriteAll {
+					for address := range uint8(eepromSize / 2) {
+						eeprom.setWord(address, eeprom.shift)
+					}
+				} else {
+					eeprom.setWord(eeprom.address, eeprom.shift)
+				}
+			}
+			eeprom.state = eepromIdle
+			eeprom.do = true
+		}
+	}
+}
+
+func (eeprom *Eeprom) shiftIn() {
+	eeprom.shift <<= 1
+	if eeprom.di {
+		eeprom.shift |= 1
+	}
+	eeprom.bitCount++
+}
+
+func (eeprom *Eeprom) execute() {
+	opcode := (eeprom.shift >> 8) & 0b11
+	operand := uint8(eeprom.shift)
+	eeprom.address = operand & 0x7F
+	eeprom.shift = 0
+	eeprom.bitCount = 0
+	eeprom.state = eepromIdle
+
+	switch opcode {
+	// READ
+	case 0b10:
+		eeprom.state = eepromRead
+		eeprom.shift = eeprom.word(eeprom.address)
+		// a dummy 0 bit precedes the data
+		eeprom.do = false
+	// WRITE
+	case 0b01:
+		eeprom.state = eepromWrite
+	// ERASE
+	case 0b11:
+		if eeprom.writeable {
+			eeprom.setWord(eeprom.address, 0xFFFF)
+		}
+		eeprom.do = true
+	case 0b00:
+		switch operand >> 6 {
+		// EWDS
+		case 0b00:
+			eeprom.writeable = false
+		// WRAL
+		case 0b01:
+			eeprom.state = eepromWriteAll
+		// ERAL
+		case 0b10:
+			if eeprom.writeable {
+				for address := range uint8(eepromSize / 2) {
+					eeprom.setWord(address, 0xFFFF)
+				}
+			}
+			eeprom.do = true
+		// EWEN
+		case 0b11:
+			eeprom.writeable = true
+		}
+	}
+}
+
+func (eeprom *Eeprom) word(address uint8) uint16 {
+	return binary.LittleEndian.Uint16(eeprom.data[uint16(address)*2:])
+}
+
+func (eeprom *Eeprom) setWord(address uint8, value uint16) {
+	binary.LittleEndian.PutUint16(eeprom.data[uint16(address)*2:], value)
+}
+
+// Serialize writes the pin and command state. The contents are part of the
+// cartridge RAM, which is serialized by the cartridge.
+func (eeprom *Eeprom) Serialize(buf []byte) int {
+	offset := 0
+
+	var flags uint8
+	if eeprom.cs {
+		flags |= 0b0000_0001
+	}
+	if eeprom.clk {
+		flags |= 0b0000_0010
+	}
+	if eeprom.di {
+		flags |= 0b0000_0100
+	}
+	if eeprom.do {
+		flags |= 0b0000_1000
+	}
+	if eeprom.writeable {
+		flags |= 0b0001_0000
+	}
+	buf[offset] = flags
+	offset++
+	buf[offset] = uint8(eeprom.state)
+	offset++
+	binary.LittleEndian.PutUint16(buf[offset:], eeprom.shift)
+	offset += 2
+	buf[offset] = eeprom.bitCount
+	offset++
+	buf[offset] = eeprom.address
+	offset++
+
+	return offset
+}
+
+func (eeprom *Eeprom) Deserialize(buf []byte) int {
+	offset := 0
+
+	flags := buf[offset]
+	offset++
+	eeprom.cs = flags&0b0000_0001 != 0
+	eeprom.clk = flags&0b0000_0010 != 0
+	eeprom.di = flags&0b0000_0100 != 0
+	eeprom.do = flags&0b0000_1000 != 0
+	eeprom.writeable = flags&0b0001_0000 != 0
+	eeprom.state = eepromState(buf[offset])
+	offset++
+	eeprom.shift = binary.LittleEndian.Uint16(buf[offset:])
+	offset += 2
+	eeprom.bitCount = buf[offset]
+	offset++
+	eeprom.address = buf[offset]
+	offset++
+
+	return offset
+}
@@ -0,0 +1,215 @@
+package cartridge
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+// newMbc7TestCartridge returns an MBC7 cart with RAM enabled.
+func newMbc7TestCartridge(t *testing.T) *Cartridge {
+	t.Helper()
+
+	cartridge := New()
+	if _, err := cartridge.LoadRom(newTestRom(0x20000, 0x22, 0x02, 0x00)); err != nil {
+		t.Fatal(err)
+	}
+	cartridge.Write(0x0000, 0x0A)
+	cartridge.Write(0x4000, 0x40)
+
+	return cartridge
+}
+
+// readAccelerometer returns the latched x and y readings.
+func readAccelerometer(cartridge *Cartridge) (uint16, uint16) {
+	x := uint16(cartridge.Read(0xA020)) | uint16(cartridge.Read(0xA030))<<8
+	y := uint16(cartridge.Read(0xA040)) | uint16(cartridge.Read(0xA050))<<8
+
+	return x, y
+}
+
+func latchAccelerometer(cartridge *Cartridge) {
+	cartridge.Write(0xA000, 0x55)
+	cartridge.Write(0xA010, 0xAA)
+}
+
+func TestMbc7Accelerometer(t *testing.T) {
+	cartridge := newMbc7TestCartridge(t)
+	if x, y := readAccelerometer(cartridge); x != 0x8000 || y != 0x8000 {
+		t.Errorf("expected 0x8000, 0x8000 before latching, got 0x%04X, 0x%04X", x, y)
+	}
+
+	tests := []struct {
+		name  string
+		tiltX float64
+		tiltY float64
+		x     uint16
+		y     uint16
+	}{
+		{"flat", 0, 0, 0x81D0, 0x81D0},
+		// x reads lower when tilted right
+		{"tilted", 1, -0.5, 0x8160, 0x8198},
+		{"clamped", 10, -10, 0x8010, 0x8010},
+	}
+	for _, test := range tests {
+		cartridge.SetTilt(test.tiltX, test.tiltY)
+		latchAccelerometer(cartridge)
+		if x, y := readAccelerometer(cartridge); x != test.x || y != test.y {
+			t.Errorf("%s: expected 0x%04X, 0x%04X, got 0x%04X, 0x%04X", test.name, test.x, test.y, x, y)
+		}
+	}
+
+	// latching again needs an erase first
+	cartridge.SetTilt(0, 0)
+	cartridge.Write(0xA010, 0xAA)
+	if x, _ := readAccelerometer(cartridge); x != 0x8010 {
+		t.Errorf("expected the reading to stay without an erase, got 0x%04X", x)
+	}
+	cartridge.Write(0xA000, 0x55)
+	if x, y := readAccelerometer(cartridge); x != 0x8000 || y != 0x8000 {
+		t.Errorf("expected the erase to reset the readings, got 0x%04X, 0x%04X", x, y)
+	}
+
+	if value := cartridge.Read(0xA060); value != 0x00 {
+		t.Errorf("expected Ax6x to read 0x00, got 0x%02X", value)
+	}
+}
+
+func TestMbc7RamDisabled(t *testing.T) {
+	cartridge := newMbc7TestCartridge(t)
+	cartridge.Write(0x4000, 0x00)
+
+	latchAccelerometer(cartridge)
+	if value := cartridge.Read(0xA020); value != 0xFF {
+		t.Errorf("expected 0xFF with RAM disabled, got 0x%02X", value)
+	}
+
+	cartridge.Write(0x4000, 0x40)
+	if x, _ := readAccelerometer(cartridge); x != 0x8000 {
+		t.Errorf("expected the latch writes to be ignored with RAM disabled, got 0x%04X", x)
+	}
+}
+
+// EEPROM pins written to Ax8x
+const (
+	eepromCs  = 0b1000_0000
+	eepromClk = 0b0100_0000
+	eepromDi  = 0b0000_0010
+	eepromDo  = 0b0000_0001
+)
+
+// sendEeprom selects the EEPROM and clocks in a start bit followed by the
+// bits of value, most significant first.
+func sendEeprom(cartridge *Cartridge, value uint32, bits int) {
+	cartridge.Write(0xA080, 0x00)
+	cartridge.Write(0xA080, eepromCs)
+	clockEepromBit(cartridge, 1)
+	for i := bits - 1; i >= 0; i-- {
+		clockEepromBit(cartridge, uint8(value>>i)&1)
+	}
+}
+
+// clockEepromBit clocks bit into DI and returns DO after the rising edge.
+func clockEepromBit(cartridge *Cartridge, bit uint8) uint8 {
+	pins := uint8(eepromCs)
+	if bit == 1 {
+		pins |= eepromDi
+	}
+	cartridge.Write(0xA080, pins)
+	cartridge.Write(0xA080, pins|eepromClk)
+
+	return cartridge.Read(0xA080) & eepromDo
+}
+
+func eepromEnableWrites(cartridge *Cartridge) {
+	// EWEN: 00 11xxxxxx
+	sendEeprom(cartridge, 0b00_1100_0000, 10)
+	cartridge.Write(0xA080, 0x00)
+}
+
+func eepromWriteWord(cartridge *Cartridge, address uint8, value uint16) {
+	// WRITE: 01 and the address, then the word
+	sendEeprom(cartridge, 0b01<<24|uint32(address)<<16|uint32(value), 26)
+	cartridge.Write(0xA080, 0x00)
+}
+
+// eepromReadWords reads count words from address.
+func eepromReadWords(cartridge *Cartridge, address uint8, count int) []uint16 {
+	// READ: 10 and the address, then a dummy 0
+	sendEeprom(cartridge, 0b10<<8|uint32(address), 10)
+
+	words := make([]uint16, count)
+	for i := range words {
+		for range 16 {
+			words[i] = words[i]<<1 | uint16(clockEepromBit(cartridge, 0))
+		}
+	}
+	cartridge.Write(0xA080, 0x00)
+
+	return words
+}
+
+func TestMbc7EepromWrite(t *testing.T) {
+	cartridge := newMbc7TestCartridge(t)
+
+	// writes are disabled at power on
+	eepromWriteWord(cartridge, 0x05, 0x1234)
+	if words := eepromReadWords(cartridge, 0x05, 1); words[0] != 0xFFFF {
+		t.Errorf("expected the write to be ignored before EWEN, got 0x%04X", words[0])
+	}
+
+	eepromEnableWrites(cartridge)
+	eepromWriteWord(cartridge, 0x05, 0x1234)
+	eepromWriteWord(cartridge, 0x06, 0xABCD)
+
+	// reads continue with the next word
+	words := eepromReadWords(cartridge, 0x05, 2)
+	if words[0] != 0x1234 || words[1] != 0xABCD {
+		t.Errorf("expected 0x1234, 0xABCD, got 0x%04X, 0x%04X", words[0], words[1])
+	}
+	// words are stored little-endian in the save RAM
+	if word := binary.LittleEndian.Uint16(cartridge.Ram()[0x0A:]); word != 0x1234 {
+		t.Errorf("expected 0x1234 at 0x0A in RAM, got 0x%04X", word)
+	}
+}
+
+func TestMbc7EepromErase(t *testing.T) {
+	cartridge := newMbc7TestCartridge(t)
+	eepromEnableWrites(cartridge)
+	eepromWriteWord(cartridge, 0x00, 0x0000)
+	eepromWriteWord(cartridge, 0x01, 0x0000)
+
+	// ERASE: 11 and the address
+	sendEeprom(cartridge, 0b11<<8|0x01, 10)
+	cartridge.Write(0xA080, 0x00)
+	words := eepromReadWords(cartridge, 0x00, 2)
+	if words[0] != 0x0000 || words[1] != 0xFFFF {
+		t.Errorf("expected only word 1 erased, got 0x%04X, 0x%04X", words[0], words[1])
+	}
+
+	// ERAL: 00 10xxxxxx
+	sendEeprom(cartridge, 0b00_1000_0000, 10)
+	cartridge.Write(0xA080, 0x00)
+	if words := eepromReadWords(cartridge, 0x00, 1); words[0] != 0xFFFF {
+		t.Errorf("expected every word erased, got 0x%04X", words[0])
+	}
+}
+
+func TestMbc7EepromWriteAll(t *testing.T) {
+	cartridge := newMbc7TestCartridge(t)
+	eepromEnableWrites(cartridge)
+
+	// WRAL: 00 01xxxxxx, then the word
+	sendEeprom(cartridge, 0b00_0100_0000<<16|0x5AA5, 26)
+	cartridge.Write(0xA080, 0x00)
+	if words := eepromReadWords(cartridge, 0x7F, 2); words[0] != 0x5AA5 || words[1] != 0x5AA5 {
+		t.Errorf("expected every word written, got 0x%04X, 0x%04X", words[0], words[1])
+	}
+
+	// EWDS: 00 00xxxxxx
+	sendEeprom(cartridge, 0b00_0000_0000, 10)
+	cartridge.Write(0xA080, 0x00)
+	eepromWriteWord(cartridge, 0x00, 0x0000)
+	if words := eepromReadWords(cartridge, 0x00, 1); words[0] != 0x5AA5 {
+		t.Errorf("expected the write to be ignored after EWDS, got 0x%04X", words[0])
+	}
+}
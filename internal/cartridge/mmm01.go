@@ -0,0 +1,320 @@
+package cartridge
+
+import (
+	"fmt"
+
+	"github.com/davidyorr/LuccaGB/internal/logger"
+)
+
+// the menu occupies the last two banks of the ROM
+const mmm01MenuSize = 0x8000
+
+// Mmm01 is the mapper of multi-game compilation carts. It powers up unmapped,
+// showing the menu stored in the last 32KiB of the ROM. The menu then picks a
+// game by writing the outer bank bits and masks, and locks them in by
+// enabling the mapping. From then on the game sees an MBC1-like mapper
+// confined to its own slice of the ROM and RAM.
+type Mmm01 struct {
+	cartridge *Cartridge
+	// bitmask to wrap addresses to the physical ROM capacity,
+	// derived from the ROM size code
+	romAddressMask uint32
+	// bitmask to wrap addresses to the physical RAM capacity,
+	// derived from the RAM size code
+	ramAddressMask uint32
+
+	// set once the menu enables the mapping, locking the outer bank bits
+	mapped bool
+
+	// =======================
+	// ====== Registers ======
+	// =======================
+
+	// 0000–1FFF — RAM Enable (Write Only)
+	// Bits 0-3: 0xA enables RAM
+	// Bits 4-5: RAM bank mask (unmapped only)
+	// Bit 6:    map enable (unmapped only)
+	ramg uint8
+
+	// 2000–3FFF — ROM Bank Number (Write Only)
+	// Bits 0-4: ROM bank low, bits 1-4 can be locked by the ROM bank mask
+	// Bits 5-6: ROM bank mid (unmapped only)
+	romb uint8
+
+	// 4000–5FFF — RAM Bank Number (Write Only)
+	// Bits 0-1: RAM bank low, can be locked by the RAM bank mask
+	// Bits 2-3: RAM bank high (unmapped only)
+	// Bits 4-5: ROM bank high (unmapped only)
+	// Bit 6:    MBC1 mode write disable (unmapped only)
+	ramb uint8
+
+	// 6000–7FFF — Mode (Write Only)
+	// Bit 0:    MBC1 banking mode
+	// Bits 2-5: ROM bank mask (unmapped only)
+	// Bit 6:    multiplex enable (unmapped only)
+	mode uint8
+}
+
+func newMmm01(cartridge *Cartridge) *Mmm01 {
+	mmm01 := &Mmm01{}
+
+	mmm01.cartridge = cartridge
+	mmm01.romAddressMask = addressMaskSizes[cartridge.romSizeCode]
+	mmm01.ramAddressMask = ramAddressMaskSizes[cartridge.ramSizeCode]
+	cartridge.ram = make([]uint8, ramSizes[cartridge.ramSizeCode])
+	mmm01.Reset()
+
+	return mmm01
+}
+
+func (mbc *Mmm01) Reset() {
+	mbc.mapped = false
+	mbc.ramg = 0x00
+	mbc.romb = 0x00
+	mbc.ramb = 0x00
+	mbc.mode = 0x00
+}
+
+// romLowWritableBits returns the bits of the ROM bank low register that
+// aren't locked by the ROM bank mask
+func (mbc *Mmm01) romLowWritableBits() uint8 {
+	romMask := (mbc.mode >> 2) & 0b1111
+	return 0b1_1111 &^ (romMask << 1)
+}
+
+// ramLowWritableBits returns the bits of the RAM bank low register that
+// aren't locked by the RAM bank mask
+func (mbc *Mmm01) ramLowWritableBits() uint8 {
+	ramMask := (mbc.ramg >> 4) & 0b11
+	return 0b11 &^ ramMask
+}
+
+func (mbc *Mmm01) multiplexed() bool {
+	return mbc.mode&0b0100_0000 != 0
+}
+
+func (mbc *Mmm01) mbc1Mode() uint8 {
+	return mbc.mode & 0b1
+}
+
+// romBanks returns the banks mapped to 0x0000-0x3FFF and 0x4000-0x7FFF
+func (mbc *Mmm01) romBanks() (bank0 uint32, bank1 uint32) {
+	// the menu lives in the last two banks
+	if !mbc.mapped {
+		return 0x1FE, 0x1FF
+	}
+
+	writable := mbc.romLowWritableBits()
+	romLow := mbc.romb & 0b1_1111
+	romMid := (mbc.romb >> 5) & 0b11
+	romHigh := (mbc.ramb >> 4) & 0b11
+
+	// with multiplexing, the game's BANK2 writes land in the RAM bank low bits
+	// and select the ROM bank mid bits, like on an MBC1
+	bank0Mid := romMid
+	if mbc.multiplexed() {
+		romMid = mbc.ramb & 0b11
+		bank0Mid = romMid
+		if mbc.mbc1Mode() == 0 {
+			bank0Mid = 0
+		}
+	}
+
+	// like MBC1, bank 0 of the game can't be mapped to 0x4000-0x7FFF
+	if romLow&writable == 0 {
+		romLow |= 0b1
+	}
+
+	outer := uint32(romHigh)<<7 | uint32(bank0Mid)<<5
+	bank0 = outer | uint32(mbc.romb&0b1_1111&^writable)
+	bank1 = uint32(romHigh)<<7 | uint32(romMid)<<5 | uint32(romLow)
+
+	return bank0, bank1
+}
+
+func (mbc *Mmm01) ramBank() uint32 {
+	ramLow := mbc.ramb & 0b11
+	ramHigh := (mbc.ramb >> 2) & 0b11
+
+	if mbc.multiplexed() {
+		ramLow = (mbc.romb >> 5) & 0b11
+		if mbc.mbc1Mode() == 0 {
+			ramLow = 0
+		}
+	}
+
+	return uint32(ramHigh)<<2 | uint32(ramLow)
+}
+
+func (mbc *Mmm01) Read(address uint16) uint8 {
+	switch {
+
+	// ROM Bank 00, or the game's first bank once mapped
+	case address <= 0x3FFF:
+		bank, _ := mbc.romBanks()
+		actualAddress := ((bank << 14) | uint32(address)) & mbc.romAddressMask
+		return mbc.cartridge.rom[actualAddress]
+
+	// ROM Bank 01-1FF
+	case address >= 0x4000 && address <= 0x7FFF:
+		_, bank := mbc.romBanks()
+		// map 0x4000-0x7FFF down to 0x0000-0x3FFF
+		offset := uint32(address) & 0b11_1111_1111_1111
+		actualAddress := ((bank << 14) | offset) & mbc.romAddressMask
+		return mbc.cartridge.rom[actualAddress]
+
+	// External RAM
+	case address >= 0xA000 && address <= 0xBFFF:
+		// RAM disabled
+		if (mbc.ramg & 0b1111) != 0b1010 {
+			return 0xFF
+		}
+
+		// no RAM hardware
+		if len(mbc.cartridge.ram) == 0 {
+			return 0xFF
+		}
+
+		offset := uint32(address - 0xA000)
+		actualAddress := ((mbc.ramBank() << 13) | offset) & mbc.ramAddressMask
+		return mbc.cartridge.ram[actualAddress]
+	}
+
+	logger.Error(
+		"MMM01 returning 0xFF",
+		"ADDRESS", fmt.Sprintf("0x%04X", address),
+		"ROMB", fmt.Sprintf("0x%08b", mbc.romb),
+		"RAMB", fmt.Sprintf("0x%08b", mbc.ramb),
+	)
+	return 0xFF
+}
+
+func (mbc *Mmm01) Write(address uint16, value uint8) {
+	switch {
+
+	// RAM Enable
+	case address <= 0x1FFF:
+		if mbc.mapped {
+			mbc.ramg = (mbc.ramg &^ 0b1111) | (value & 0b1111)
+			return
+		}
+
+		mbc.ramg = value & 0b0111_1111
+		if value&0b0100_0000 != 0 {
+			mbc.mapped = true
+		}
+
+	// ROM Bank Number
+	case address >= 0x2000 && address <= 0x3FFF:
+		if mbc.mapped {
+			writable := mbc.romLowWritableBits()
+			mbc.romb = (mbc.romb &^ writable) | (value & writable)
+			return
+		}
+
+		mbc.romb = value & 0b0111_1111
+
+	// RAM Bank Number
+	case address >= 0x4000 && address <= 0x5FFF:
+		if mbc.mapped {
+			writable := mbc.ramLowWritableBits()
+			mbc.ramb = (mbc.ramb &^ writable) | (value & writable)
+			return
+		}
+
+		mbc.ramb = value & 0b0111_1111
+
+	// Mode
+	case address >= 0x6000 && address <= 0x7FFF:
+		if mbc.mapped {
+			// MBC1 mode write disable
+			if mbc.ramb&0b0100_0000 == 0 {
+				mbc.mode = (mbc.mode &^ 0b1) | (value & 0b1)
+			}
+			return
+		}
+
+		mbc.mode = value & 0b0111_1101
+
+	// External RAM
+	case address >= 0xA000 && address <= 0xBFFF:
+		// RAM disabled
+		if (mbc.ramg & 0b1111) != 0b1010 {
+			return
+		}
+
+		// no RAM hardware
+		if len(mbc.cartridge.ram) == 0 {
+			return
+		}
+
+		offset := uint32(address - 0xA000)
+		actualAddress := ((mbc.ramBank() << 13) | offset) & mbc.ramAddressMask
+		mbc.cartridge.ram[actualAddress] = value
+	}
+}
+
+// detectMmm01 detects MMM01 carts from the menu's header. The hardware boots
+// into the last 32KiB of the ROM, so that is where the menu and its header
+// live, while the header at the start of the image belongs to the first game.
+// Some dumps have the menu moved to the start instead. A menu's header must
+// pass the boot ROM's checks, so a stray cartridge type byte isn't mistaken
+// for one. It reports whether the ROM is an MMM01 cart and whether the menu is
+// at the start.
+func detectMmm01(rom []uint8) (isMmm01 bool, menuAtStart bool) {
+	if len(rom) <= mmm01MenuSize {
+		return false, false
+	}
+
+	isMenuHeader := func(menu []uint8) bool {
+		cartridgeType := menu[0x0147]
+		return cartridgeType >= 0x0B && cartridgeType <= 0x0D && isBootableHeader(menu)
+	}
+
+	if isMenuHeader(rom[len(rom)-mmm01MenuSize:]) {
+		return true, false
+	}
+	if isMenuHeader(rom) {
+		return true, true
+	}
+
+	return false, false
+}
+
+func (mbc *Mmm01) Serialize(buf []byte) int {
+	offset := 0
+
+	if mbc.mapped {
+		buf[offset] = 1
+	} else {
+		buf[offset] = 0
+	}
+	offset++
+	buf[offset] = mbc.ramg
+	offset++
+	buf[offset] = mbc.romb
+	offset++
+	buf[offset] = mbc.ramb
+	offset++
+	buf[offset] = mbc.mode
+	offset++
+
+	return offset
+}
+
+func (mbc *Mmm01) Deserialize(buf []byte) int {
+	offset := 0
+
+	mbc.mapped = buf[offset] == 1
+	offset++
+	mbc.ramg = buf[offset]
+	offset++
+	mbc.romb = buf[offset]
+	offset++
+	mbc.ramb = buf[offset]
+	offset++
+	mbc.mode = buf[offset]
+	offset++
+
+	return offset
+}
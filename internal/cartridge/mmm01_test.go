@@ -0,0 +1,63 @@
+package cartridge
+
+import "testing"
+
+// newMmm01TestRom returns a 64KiB MMM01 cart of an MBC1 game followed by the
+// menu.
+func newMmm01TestRom() []uint8 {
+	rom := newTestRom(0x10000, 0x01, 0x01, 0x00)
+	writeTestHeader(rom[len(rom)-mmm01MenuSize:], 0x0B, 0x01, 0x00)
+
+	return rom
+}
+
+func TestDetectMmm01(t *testing.T) {
+	tests := []struct {
+		name        string
+		rom         func() []uint8
+		isMmm01     bool
+		menuAtStart bool
+	}{
+		{"menu at the end", newMmm01TestRom, true, false},
+		{"menu at the start", func() []uint8 {
+			rom := newMmm01TestRom()
+			return append(rom[len(rom)-mmm01MenuSize:], rom[:len(rom)-mmm01MenuSize]...)
+		}, true, true},
+		{"menu without the logo", func() []uint8 {
+			rom := newMmm01TestRom()
+			rom[len(rom)-mmm01MenuSize+0x0104] = 0x00
+			return rom
+		}, false, false},
+		{"menu with a bad checksum", func() []uint8 {
+			rom := newMmm01TestRom()
+			rom[len(rom)-mmm01MenuSize+0x014D]++
+			return rom
+		}, false, false},
+		// game data in the last bank which happens to look like a type byte
+		{"MBC1 with a stray type byte", func() []uint8 {
+			rom := newTestRom(0x10000, 0x01, 0x01, 0x00)
+			rom[len(rom)-mmm01MenuSize+0x0147] = 0x0B
+			return rom
+		}, false, false},
+		{"32KiB ROM", func() []uint8 {
+			return newTestRom(0x8000, 0x0B, 0x00, 0x00)
+		}, false, false},
+	}
+
+	for _, test := range tests {
+		isMmm01, menuAtStart := detectMmm01(test.rom())
+		if isMmm01 != test.isMmm01 || menuAtStart != test.menuAtStart {
+			t.Errorf("%s: expected %t, %t, got %t, %t", test.name, test.isMmm01, test.menuAtStart, isMmm01, menuAtStart)
+		}
+	}
+}
+
+func TestLoadMmm01(t *testing.T) {
+	info, err := New().LoadRom(newMmm01TestRom())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Header.CartridgeType != 0x0B {
+		t.Errorf("expected the menu's header to describe the cart, got type 0x%02X", info.Header.CartridgeType)
+	}
+}
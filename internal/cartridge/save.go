@@ -0,0 +1,214 @@
+package cartridge
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Battery save files use the common .sav layout shared by most emulators and
+// flash cart tools: the raw SRAM, followed by an RTC footer for cartridges that
+// have a real-time clock.
+//
+// The RTC footer is 48 bytes (or 44 bytes in older files with a 32-bit
+// timestamp), all values little-endian:
+//
+//	0x00-0x13 - live S, M, H, DL, DH, one uint32 each
+//	0x14-0x27 - latched S, M, H, DL, DH, one uint32 each
+//	0x28-0x2F - unix timestamp in seconds of when the file was written
+//
+// HuC3 cartridges have their own 17 byte clock footer instead:
+//
+//	0x00-0x07 - unix timestamp in seconds of when the file was written
+//	0x08-0x09 - minute of the day
+//	0x0A-0x0B - day counter
+//	0x0C-0x0D - alarm minute of the day
+//	0x0E-0x0F - alarm day
+//	0x10      - alarm enabled
+const (
+	rtcFooterSize       = 48
+	rtcFooterLegacySize = 44
+	huc3FooterSize      = 17
+)
+
+var ErrNoBattery = errors.New("cartridge has no battery-backed RAM")
+
+// SaveSizeError is returned when a save file's size doesn't match any layout
+// that is valid for the loaded cartridge.
+type SaveSizeError struct {
+	Actual   int
+	Expected []int
+}
+
+func (err *SaveSizeError) Error() string {
+	expected := make([]string, len(err.Expected))
+	for i, size := range err.Expected {
+		expected[i] = fmt.Sprintf("%d", size)
+	}
+
+	return fmt.Sprintf(
+		"save file is %d bytes, expected %s bytes for this cartridge",
+		err.Actual,
+		strings.Join(expected, " or "),
+	)
+}
+
+// rtcMbc is implemented by mappers that may carry an MBC3 style real-time clock
+type rtcMbc interface {
+	// realTimeClock returns nil if the cartridge has no clock wired up
+	realTimeClock() *Rtc
+}
+
+func (cartridge *Cartridge) rtc() *Rtc {
+	if mbc, ok := cartridge.mbc.(rtcMbc); ok {
+		return mbc.realTimeClock()
+	}
+
+	return nil
+}
+
+// huc3RtcMbc is implemented by the HuC3, whose clock has its own footer
+type huc3RtcMbc interface {
+	huc3Clock() *Huc3Rtc
+}
+
+func (cartridge *Cartridge) huc3Rtc() *Huc3Rtc {
+	if mbc, ok := cartridge.mbc.(huc3RtcMbc); ok {
+		return mbc.huc3Clock()
+	}
+
+	return nil
+}
+
+// SaveFileSize returns the size in bytes of the battery save file, or 0 if
+// the cartridge has nothing to persist.
+func (cartridge *Cartridge) SaveFileSize() int {
+	if !cartridge.hasBattery {
+		return 0
+	}
+
+	size := len(cartridge.ram)
+	if cartridge.rtc() != nil {
+		size += rtcFooterSize
+	}
+	if cartridge.huc3Rtc() != nil {
+		size += huc3FooterSize
+	}
+
+	return size
+}
+
+// SaveFile encodes the battery-backed state in the .sav layout. It returns nil
+// if the cartridge has no battery.
+func (cartridge *Cartridge) SaveFile() []byte {
+	size := cartridge.SaveFileSize()
+	if size == 0 {
+		return nil
+	}
+
+	buf := make([]byte, size)
+	n := copy(buf, cartridge.ram)
+
+	if rtc := cartridge.rtc(); rtc != nil {
+		rtc.EncodeFooter(buf[n:], cartridge.clock.Now())
+	}
+	if rtc := cartridge.huc3Rtc(); rtc != nil {
+		rtc.EncodeFooter(buf[n:], cartridge.clock.Now())
+	}
+
+	return buf
+}
+
+// LoadSaveFile restores the battery-backed state from a file in the .sav
+// layout. Nothing is modified if an error is returned.
+func (cartridge *Cartridge) LoadSaveFile(data []byte) error {
+	if !cartridge.hasBattery {
+		return ErrNoBattery
+	}
+
+	ramSize := len(cartridge.ram)
+	rtc := cartridge.rtc()
+	huc3Rtc := cartridge.huc3Rtc()
+
+	switch {
+	case len(data) == ramSize:
+		copy(cartridge.ram, data)
+	case rtc != nil && len(data) == ramSize+rtcFooterSize:
+		copy(cartridge.ram, data[:ramSize])
+		rtc.DecodeFooter(data[ramSize:], cartridge.clock.Now())
+	case rtc != nil && len(data) == ramSize+rtcFooterLegacySize:
+		copy(cartridge.ram, data[:ramSize])
+		rtc.DecodeFooter(data[ramSize:], cartridge.clock.Now())
+	case huc3Rtc != nil && len(data) == ramSize+huc3FooterSize:
+		copy(cartridge.ram, data[:ramSize])
+		huc3Rtc.DecodeFooter(data[ramSize:], cartridge.clock.Now())
+	default:
+		expected := []int{ramSize}
+		if rtc != nil {
+			expected = append(expected, ramSize+rtcFooterSize, ramSize+rtcFooterLegacySize)
+		}
+		if huc3Rtc != nil {
+			expected = append(expected, ramSize+huc3FooterSize)
+		}
+		return &SaveSizeError{
+			Actual:   len(data),
+			Expected: expected,
+		}
+	}
+
+	return nil
+}
+
+// EncodeFooter writes the 48 byte .sav RTC footer.
+func (rtc *Rtc) EncodeFooter(buf []byte, now time.Time) {
+	rtc.Sync(now)
+
+	offset := 0
+
+	for _, value := range rtc.registers() {
+		binary.LittleEndian.PutUint32(buf[offset:], uint32(value))
+		offset += 4
+	}
+	for _, value := range rtc.latched {
+		binary.LittleEndian.PutUint32(buf[offset:], uint32(value))
+		offset += 4
+	}
+
+	binary.LittleEndian.PutUint64(buf[offset:], uint64(now.Unix()))
+}
+
+// DecodeFooter restores the clock from a 44 or 48 byte .sav RTC footer, then
+// catches up on the time that passed since the file was written.
+func (rtc *Rtc) DecodeFooter(buf []byte, now time.Time) {
+	offset := 0
+
+	var registers [5]uint8
+	for i := range registers {
+		registers[i] = uint8(binary.LittleEndian.Uint32(buf[offset:])) & rtcRegisterMasks[i]
+		offset += 4
+	}
+	for i := range rtc.latched {
+		rtc.latched[i] = uint8(binary.LittleEndian.Uint32(buf[offset:])) & rtcRegisterMasks[i]
+		offset += 4
+	}
+
+	var timestamp int64
+	if len(buf)-offset >= 8 {
+		timestamp = int64(binary.LittleEndian.Uint64(buf[offset:]))
+	} else {
+		timestamp = int64(binary.LittleEndian.Uint32(buf[offset:]))
+	}
+
+	rtc.seconds = registers[0]
+	rtc.minutes = registers[1]
+	rtc.hours = registers[2]
+	rtc.days = (uint16(registers[4]&0b1) << 8) | uint16(registers[3])
+	rtc.halt = (registers[4] & 0b0100_0000) != 0
+	rtc.carry = (registers[4] & 0b1000_0000) != 0
+	rtc.lastSync = timestamp * nanosecondsPerSecond
+	rtc.subSecond = 0
+
+	rtc.Sync(now)
+}
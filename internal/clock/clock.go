@@ -0,0 +1,182 @@
+package clock
+
+import (
+	"encoding/binary"
+	"time"
+)
+
+// Clock is the source of wall time for cartridge hardware that keeps time,
+// such as the MBC3 real-time clock. It is owned by the Gameboy, which informs
+// it of every T-cycle that is emulated.
+type Clock interface {
+	// Now returns the current wall time as seen by the cartridge.
+	Now() time.Time
+	// Advance is called by the Gameboy with the number of T-cycles emulated.
+	Advance(tCycles uint64)
+	// Kind identifies the implementation so that serialized state can be
+	// restored into a clock of the same kind.
+	Kind() Kind
+	Serialize(buf []byte) int
+	Deserialize(buf []byte) int
+}
+
+type Kind uint8
+
+const (
+	// KindCustom is for user supplied implementations outside this package.
+	KindCustom Kind = iota
+	KindRealTime
+	KindEmulated
+	KindFixed
+)
+
+// 4,194,304 T-cycles per second
+const TCyclesPerSecond = 4194304
+
+// New creates a clock of the given kind starting at start. Real-time clocks
+// ignore start. It returns nil for KindCustom.
+func New(kind Kind, start time.Time) Clock {
+	switch kind {
+	case KindRealTime:
+		return NewRealTime()
+	case KindEmulated:
+		return NewEmulated(start)
+	case KindFixed:
+		return NewFixed(start)
+	}
+
+	return nil
+}
+
+// =======================
+// ====== Real time ======
+// =======================
+
+// RealTime follows the host's wall clock, so time keeps passing while the
+// emulator is paused or closed.
+type RealTime struct{}
+
+func NewRealTime() *RealTime {
+	return &RealTime{}
+}
+
+func (clock *RealTime) Now() time.Time {
+	return time.Now()
+}
+
+func (clock *RealTime) Advance(tCycles uint64) {}
+
+func (clock *RealTime) Kind() Kind {
+	return KindRealTime
+}
+
+func (clock *RealTime) Serialize(buf []byte) int {
+	return 0
+}
+
+func (clock *RealTime) Deserialize(buf []byte) int {
+	return 0
+}
+
+// ======================
+// ====== Emulated ======
+// ======================
+
+// Emulated derives the time from the number of T-cycles stepped, so it only
+// moves while the emulator runs and is fully deterministic.
+type Emulated struct {
+	// unix nanoseconds at T-cycle 0
+	start   int64
+	tCycles uint64
+}
+
+func NewEmulated(start time.Time) *Emulated {
+	return &Emulated{
+		start: start.UnixNano(),
+	}
+}
+
+func (clock *Emulated) Now() time.Time {
+	seconds := clock.tCycles / TCyclesPerSecond
+	remainder := clock.tCycles % TCyclesPerSecond
+	elapsed := time.Duration(seconds)*time.Second +
+		time.Duration(remainder*uint64(time.Second)/TCyclesPerSecond)
+
+	return time.Unix(0, clock.start).Add(elapsed)
+}
+
+func (clock *Emulated) Advance(tCycles uint64) {
+	clock.tCycles += tCycles
+}
+
+func (clock *Emulated) Kind() Kind {
+	return KindEmulated
+}
+
+func (clock *Emulated) Serialize(buf []byte) int {
+	offset := 0
+
+	binary.LittleEndian.PutUint64(buf[offset:], uint64(clock.start))
+	offset += 8
+	binary.LittleEndian.PutUint64(buf[offset:], clock.tCycles)
+	offset += 8
+
+	return offset
+}
+
+func (clock *Emulated) Deserialize(buf []byte) int {
+	offset := 0
+
+	clock.start = int64(binary.LittleEndian.Uint64(buf[offset:]))
+	offset += 8
+	clock.tCycles = binary.LittleEndian.Uint64(buf[offset:])
+	offset += 8
+
+	return offset
+}
+
+// ===================
+// ====== Fixed ======
+// ===================
+
+// Fixed never moves on its own. Tests can script it with Set and Add.
+type Fixed struct {
+	// unix nanoseconds
+	now int64
+}
+
+func NewFixed(now time.Time) *Fixed {
+	return &Fixed{
+		now: now.UnixNano(),
+	}
+}
+
+func (clock *Fixed) Now() time.Time {
+	return time.Unix(0, clock.now)
+}
+
+// Set moves the clock to the given time.
+func (clock *Fixed) Set(now time.Time) {
+	clock.now = now.UnixNano()
+}
+
+// Add moves the clock by the given duration.
+func (clock *Fixed) Add(duration time.Duration) {
+	clock.now += int64(duration)
+}
+
+func (clock *Fixed) Advance(tCycles uint64) {}
+
+func (clock *Fixed) Kind() Kind {
+	return KindFixed
+}
+
+func (clock *Fixed) Serialize(buf []byte) int {
+	binary.LittleEndian.PutUint64(buf, uint64(clock.now))
+	return 8
+}
+
+func (clock *Fixed) Deserialize(buf []byte) int {
+	clock.now = int64(binary.LittleEndian.Uint64(buf))
+	return 8
+}
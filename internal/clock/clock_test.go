@@ -0,0 +1,78 @@
+package clock
+
+import (
+	"testing"
+	"time"
+)
+
+var start = time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+func TestEmulated(t *testing.T) {
+	clock := NewEmulated(start)
+	if !clock.Now().Equal(start) {
+		t.Errorf("expected %v, got %v", start, clock.Now())
+	}
+
+	clock.Advance(TCyclesPerSecond)
+	clock.Advance(TCyclesPerSecond / 2)
+	if expected := start.Add(1500 * time.Millisecond); !clock.Now().Equal(expected) {
+		t.Errorf("expected %v, got %v", expected, clock.Now())
+	}
+
+	// a year of T-cycles must not overflow
+	clock.Advance(365 * 24 * 60 * 60 * TCyclesPerSecond)
+	if expected := start.Add(365*24*time.Hour + 1500*time.Millisecond); !clock.Now().Equal(expected) {
+		t.Errorf("expected %v, got %v", expected, clock.Now())
+	}
+}
+
+func TestFixed(t *testing.T) {
+	clock := NewFixed(start)
+	clock.Advance(10 * TCyclesPerSecond)
+	if !clock.Now().Equal(start) {
+		t.Errorf("expected the fixed clock to ignore T-cycles, got %v", clock.Now())
+	}
+
+	clock.Add(time.Hour)
+	if expected := start.Add(time.Hour); !clock.Now().Equal(expected) {
+		t.Errorf("expected %v, got %v", expected, clock.Now())
+	}
+
+	later := start.AddDate(1, 0, 0)
+	clock.Set(later)
+	if !clock.Now().Equal(later) {
+		t.Errorf("expected %v, got %v", later, clock.Now())
+	}
+}
+
+func TestNew(t *testing.T) {
+	for _, kind := range []Kind{KindRealTime, KindEmulated, KindFixed} {
+		if clock := New(kind, start); clock == nil || clock.Kind() != kind {
+			t.Errorf("expected a clock of kind %d, got %v", kind, clock)
+		}
+	}
+
+	if clock := New(KindCustom, start); clock != nil {
+		t.Errorf("expected no clock for a custom kind, got %v", clock)
+	}
+}
+
+func TestSerialize(t *testing.T) {
+	emulated := NewEmulated(start)
+	emulated.Advance(3 * TCyclesPerSecond)
+	fixed := NewFixed(start.Add(time.Minute))
+
+	for _, clock := range []Clock{emulated, fixed, NewRealTime()} {
+		buf := make([]byte, 32)
+		length := clock.Serialize(buf)
+
+		// restored into a clock of the same kind at another time
+		restored := New(clock.Kind(), time.Unix(0, 0))
+		if restoredLength := restored.Deserialize(buf); restoredLength != length {
+			t.Errorf("kind %d: expected %d bytes read, got %d", clock.Kind(), length, restoredLength)
+		}
+		if clock.Kind() != KindRealTime && !restored.Now().Equal(clock.Now()) {
+			t.Errorf("kind %d: expected %v, got %v", clock.Kind(), clock.Now(), restored.Now())
+		}
+	}
+}
@@ -8,6 +8,7 @@ import (
 	"github.com/davidyorr/LuccaGB/internal/debug"
 	"github.com/davidyorr/LuccaGB/internal/interrupt"
 	"github.com/davidyorr/LuccaGB/internal/logger"
+	"github.com/davidyorr/LuccaGB/internal/model"
 )
 
 type CPU struct {
@@ -28,19 +29,14 @@ type CPU struct {
 	// interrupt master enable flag
 	ime          bool
 	imeScheduled bool
-	// the current instruction
-	instruction *instruction
-	// the current opcode
+	// the current opcode, its instruction is instructions[opcode]
 	opcode uint8
-	// the current CB opcode
-	cbOpcode *uint8
-	// Backing storage for the cbOpcode pointer.
-	// This avoids a heap allocation caused by taking the address of a
-	// function-local variable (escape analysis).
-	cbOpcodeValue uint8
+	// the current CB opcode, fetched in M-cycle 2 of a 0xCB instruction
+	cbOpcode uint8
 	// the current immediate value
 	immediateValue uint16
-	// the current M-cycle, 1 indexed
+	// the current M-cycle of the instruction, 1 indexed, 0 between
+	// instructions
 	mCycle uint8
 	// Memory Data Register
 	//	for storing temporary values used across different M-cycles
@@ -54,6 +50,17 @@ type CPU struct {
 	interruptTypeToClear        interrupt.Interrupt
 	tCycleCounter               uint8
 	bus                         *bus.Bus
+	// whether to start with the CGB's post-boot registers
+	cgb bool
+	// the model and header checksum select the post-boot registers
+	model          model.Model
+	headerChecksum uint8
+	// STOP mode, until a joypad line goes low
+	stopped bool
+	// the M-cycles left of the pause after a CGB speed switch
+	speedSwitchCycles uint16
+	// hung by an illegal opcode, until reset
+	locked bool
 }
 
 func New() *CPU {
@@ -65,18 +72,93 @@ func New() *CPU {
 }
 
 func (cpu *CPU) Reset() {
-	cpu.a = 0x01
-	cpu.f = 0x80
-	cpu.b = 0x00
-	cpu.c = 0x13
-	cpu.d = 0x00
-	cpu.e = 0xD8
-	cpu.h = 0x01
-	cpu.l = 0x4D
+	registers := bootRegisters(cpu.model, cpu.cgb, cpu.headerChecksum)
+	cpu.a = registers[0]
+	cpu.f = registers[1]
+	cpu.b = registers[2]
+	cpu.c = registers[3]
+	cpu.d = registers[4]
+	cpu.e = registers[5]
+	cpu.h = registers[6]
+	cpu.l = registers[7]
 	cpu.pc = 0x0100
 	cpu.sp = 0xFFFE
 	cpu.halted = false
 	cpu.haltBugActive = false
+	cpu.stopped = false
+	cpu.speedSwitchCycles = 0
+	cpu.locked = false
+}
+
+// ColdReset puts the CPU in its power on state, for a boot ROM to run from
+// 0x0000.
+func (cpu *CPU) ColdReset() {
+	cpu.Reset()
+	cpu.a = 0x00
+	cpu.f = 0x00
+	cpu.b = 0x00
+	cpu.c = 0x00
+	cpu.d = 0x00
+	cpu.e = 0x00
+	cpu.h = 0x00
+	cpu.l = 0x00
+	cpu.pc = 0x0000
+	cpu.sp = 0x0000
+}
+
+// bootRegisters returns the A, F, B, C, D, E, H and L registers each model's
+// boot ROM leaves behind. Games check A to detect the model: 0x01 for the
+// DMG and SGB, 0xFF for the MGB and SGB2 and 0x11 for the CGB and AGB.
+// See: https://gbdev.io/pandocs/Power_Up_Sequence.html#cpu-registers
+func bootRegisters(gbModel model.Model, cgb bool, headerChecksum uint8) [8]uint8 {
+	// the DMG and MGB boot ROMs leave H and C set, unless the header checksum
+	// is 0
+	var dmgFlags uint8 = 0xB0
+	if headerChecksum == 0 {
+		dmgFlags = 0x80
+	}
+
+	switch gbModel {
+	case model.Dmg0:
+		return [8]uint8{0x01, 0x00, 0xFF, 0x13, 0x00, 0xC1, 0x84, 0x03}
+	case model.Mgb:
+		return [8]uint8{0xFF, dmgFlags, 0x00, 0x13, 0x00, 0xD8, 0x01, 0x4D}
+	case model.Sgb:
+		return [8]uint8{0x01, 0x00, 0x00, 0x14, 0x00, 0x00, 0xC0, 0x60}
+	case model.Sgb2:
+		return [8]uint8{0xFF, 0x00, 0x00, 0x14, 0x00, 0x00, 0xC0, 0x60}
+	case model.Cgb:
+		if cgb {
+			return [8]uint8{0x11, 0x80, 0x00, 0x00, 0xFF, 0x56, 0x00, 0x0D}
+		}
+		return [8]uint8{0x11, 0x80, 0x00, 0x00, 0x00, 0x08, 0x00, 0x7C}
+	case model.Agb:
+		// the AGB boot ROM increments B, which also clears the Z flag
+		if cgb {
+			return [8]uint8{0x11, 0x00, 0x01, 0x00, 0xFF, 0x56, 0x00, 0x0D}
+		}
+		return [8]uint8{0x11, 0x00, 0x01, 0x00, 0x00, 0x08, 0x00, 0x7C}
+	}
+
+	return [8]uint8{0x01, dmgFlags, 0x00, 0x13, 0x00, 0xD8, 0x01, 0x4D}
+}
+
+// SetModel selects the model whose post-boot registers the CPU starts with. It
+// applies from the next Reset.
+func (cpu *CPU) SetModel(model model.Model) {
+	cpu.model = model
+}
+
+// SetHeaderChecksum sets the header checksum of the loaded ROM, which the DMG
+// boot ROM leaves a trace of in the flags. It applies from the next Reset.
+func (cpu *CPU) SetHeaderChecksum(checksum uint8) {
+	cpu.headerChecksum = checksum
+}
+
+// SetCgbMode switches between DMG and CGB mode. It applies from the next
+// Reset.
+func (cpu *CPU) SetCgbMode(cgb bool) {
+	cpu.cgb = cgb
 }
 
 func (cpu *CPU) ConnectBus(bus *bus.Bus) {
@@ -95,6 +177,23 @@ func (cpu *CPU) Step() {
 
 // Perform 1 M-cycle of work
 func (cpu *CPU) executeMachineCycle() {
+	if cpu.locked {
+		return
+	}
+
+	if cpu.stopped {
+		if cpu.joypadLineLow() {
+			cpu.stopped = false
+		} else {
+			return
+		}
+	}
+
+	if cpu.speedSwitchCycles > 0 {
+		cpu.speedSwitchCycles--
+		return
+	}
+
 	if cpu.halted {
 		if cpu.interruptsPending() {
 			cpu.halted = false
@@ -103,7 +202,7 @@ func (cpu *CPU) executeMachineCycle() {
 		}
 	}
 
-	if cpu.instruction != nil {
+	if cpu.mCycle > 0 {
 		cpu.executeInstructionStep()
 		return
 	}
@@ -129,15 +228,20 @@ func (cpu *CPU) executeMachineCycle() {
 		return
 	}
 
-	if cpu.imeScheduled {
+	imeEnabledByEi := cpu.imeScheduled
+	if imeEnabledByEi {
 		cpu.ime = true
-		cpu.imeScheduled = false
 	}
 
 	// start new instruction
-	cpu.mCycle = 0
 	cpu.immediateValue = 0
 	cpu.executeInstructionStep()
+
+	// imeScheduled stays set for the first M-cycle of the instruction after
+	// EI, so a HALT there knows no interrupt could be serviced before it
+	if imeEnabledByEi {
+		cpu.imeScheduled = false
+	}
 }
 
 // Perform 1 M-cycle of work for the current instruction
@@ -150,7 +254,6 @@ func (cpu *CPU) executeInstructionStep() {
 	// fetch the opcode
 	if cpu.mCycle == 1 {
 		cpu.opcode = cpu.fetchByte()
-		cpu.instruction = &instructions[cpu.opcode]
 
 		// the first byte of the bugged instruction is read twice, so decrement
 		// the PC back to where it was prior to fetching the opcode
@@ -165,18 +268,12 @@ func (cpu *CPU) executeInstructionStep() {
 
 	// fetch the CB opcode
 	if cpu.mCycle == 2 && cpu.opcode == 0xCB {
-		cpu.cbOpcodeValue = cpu.fetchByte()
-		cpu.cbOpcode = &cpu.cbOpcodeValue
+		cpu.cbOpcode = cpu.fetchByte()
 	}
 
-	done := cpu.instruction.step(cpu)
+	done := instructions[cpu.opcode].step(cpu)
 
 	if debug.Enabled {
-		var cbo uint8 = 0
-		if cpu.cbOpcode != nil {
-			cbo = *cpu.cbOpcode
-		}
-
 		logger.Info(
 			"INSTRUCTION STEP",
 			"M-CYCLE", mCycleForLog,
@@ -187,19 +284,14 @@ func (cpu *CPU) executeInstructionStep() {
 			"DE", fmt.Sprintf("0x%04X", cpu.getDE()),
 			"HL", fmt.Sprintf("0x%04X", cpu.getHL()),
 			"op", fmt.Sprintf("(op:0x%02X, imm:0x%04X)", cpu.opcode, cpu.immediateValue),
-			"cb", fmt.Sprintf("0x%02X", cbo),
-			"instruction", cpu.instruction.mnemonic,
+			"cb", fmt.Sprintf("0x%02X", cpu.cbOpcode),
+			"instruction", cpu.mnemonic(),
 		)
 	}
 	logger.GlobalTraceLogger.LogInstruction(cpu.pc, cpu.opcode)
 
 	if done {
-		// reset state
-		if cpu.opcode == 0xCB {
-			cpu.instruction.mnemonic = ""
-		}
-		cpu.instruction = nil
-		cpu.cbOpcode = nil
+		cpu.mCycle = 0
 	}
 }
 
@@ -207,6 +299,12 @@ func (cpu *CPU) executeInterruptServiceRoutineStep() {
 	switch cpu.interruptServiceRoutineStep {
 	case 1:
 		cpu.ime = false
+		// the dispatch undoes the PC increment of the opcode fetch it replaces,
+		// which the halt bug skipped, so the handler returns to the HALT
+		if cpu.haltBugActive {
+			cpu.haltBugActive = false
+			cpu.pc--
+		}
 		cpu.interruptServiceRoutineStep++
 	case 2:
 		cpu.interruptServiceRoutineStep++
@@ -243,11 +341,18 @@ func (cpu *CPU) executeInterruptServiceRoutineStep() {
 	}
 }
 
+// joypadLineLow reports whether a button is held on a line selected in P1.
+func (cpu *CPU) joypadLineLow() bool {
+	return cpu.bus.DirectRead(0xFF00)&0b0000_1111 != 0b0000_1111
+}
+
 func (cpu *CPU) interruptsPending() bool {
 	interruptEnable := cpu.bus.Read(0xFFFF)
 	interruptFlag := cpu.bus.Read(0xFF0F)
 	// logger.Info("interruptsPending()", "IE", fmt.Sprintf("%08b", interruptEnable), "IF", fmt.Sprintf("%08b", interruptFlag))
-	return (interruptEnable & interruptFlag) != 0
+	// only the lower 5 bits are interrupts, IF always reads the upper 3 bits
+	// as 1 and IE stores whatever is written to them
+	return (interruptEnable & interruptFlag & 0b0001_1111) != 0
 }
 
 // getPendingInterrupt determines the highest priority interrupt to be serviced.
@@ -366,6 +471,9 @@ func (cpu *CPU) set_r8(r8 uint8, value uint8) {
 	}
 }
 
+// the CPU pauses for 2050 M-cycles while the CGB switches speed
+const speedSwitchMachineCycles = 2050
+
 type Flag uint8
 
 const (
@@ -434,6 +542,27 @@ func (cpu *CPU) PC() uint16 {
 	return cpu.pc
 }
 
+func (cpu *CPU) Halted() bool {
+	return cpu.halted
+}
+
+// Locked reports whether an illegal opcode has hung the CPU. The PC is left on
+// the illegal opcode.
+func (cpu *CPU) Locked() bool {
+	return cpu.locked
+}
+
+// Opcode returns the opcode of the current or last executed instruction.
+func (cpu *CPU) Opcode() uint8 {
+	return cpu.opcode
+}
+
+// Stopped reports whether the CPU is in STOP mode, with the system clock
+// stopped.
+func (cpu *CPU) Stopped() bool {
+	return cpu.stopped
+}
+
 // Debug gathers the current state of the CPU into a structured map.
 func (cpu *CPU) Debug() map[string]interface{} {
 	af := (uint16(cpu.a) << 8) | uint16(cpu.f)
@@ -461,19 +590,6 @@ func (cpu *CPU) Debug() map[string]interface{} {
 	}
 }
 
-// IsSafeToSerialize returns true if the CPU is in a state where it is safe
-// to create a snapshot (not mid-instruction, not mid-ISR).
-// I don't think we should have to do this, but I was running into issues
-// when loading a state where the CPU was mid-instruction, so there must be
-// some bugs causing that.
-func (cpu *CPU) IsSafeToSerialize() bool {
-	if cpu.instruction != nil || cpu.isServicingInterrupt {
-		return false
-	}
-
-	return true
-}
-
 func (cpu *CPU) Serialize(buf []byte) int {
 	offset := 0
 
@@ -532,16 +648,13 @@ func (cpu *CPU) Serialize(buf []byte) int {
 	buf[offset] = cpu.interruptServiceRoutineStep
 	offset++
 
+	// the instruction in progress, if any, continues from its opcode and
+	// M-cycle, so the CPU can be serialized at any T-cycle
 	buf[offset] = cpu.opcode
 	offset++
-	if cpu.instruction == nil {
-		buf[offset] = 0
-	} else {
-		buf[offset] = cpu.mCycle
-	}
+	buf[offset] = cpu.mCycle
 	offset++
-
-	buf[offset] = cpu.cbOpcodeValue
+	buf[offset] = cpu.cbOpcode
 	offset++
 
 	binary.LittleEndian.PutUint16(buf[offset:], cpu.immediateValue)
@@ -556,6 +669,20 @@ func (cpu *CPU) Serialize(buf []byte) int {
 	offset++
 	buf[offset] = cpu.tCycleCounter
 	offset++
+	if cpu.stopped {
+		buf[offset] = 1
+	} else {
+		buf[offset] = 0
+	}
+	offset++
+	binary.LittleEndian.PutUint16(buf[offset:], cpu.speedSwitchCycles)
+	offset += 2
+	if cpu.locked {
+		buf[offset] = 1
+	} else {
+		buf[offset] = 0
+	}
+	offset++
 
 	return offset
 }
@@ -602,21 +729,9 @@ func (cpu *CPU) Deserialize(buf []byte) int {
 	offset++
 	cpu.mCycle = buf[offset]
 	offset++
-	cpu.cbOpcodeValue = buf[offset]
+	cpu.cbOpcode = buf[offset]
 	offset++
 
-	if cpu.mCycle > 0 {
-		cpu.instruction = &instructions[cpu.opcode]
-	} else {
-		cpu.instruction = nil
-	}
-
-	if cpu.opcode == 0xCB && cpu.instruction != nil {
-		cpu.cbOpcode = &cpu.cbOpcodeValue
-	} else {
-		cpu.cbOpcode = nil
-	}
-
 	cpu.immediateValue = binary.LittleEndian.Uint16(buf[offset:])
 	offset += 2
 
@@ -629,6 +744,12 @@ func (cpu *CPU) Deserialize(buf []byte) int {
 	offset++
 	cpu.tCycleCounter = buf[offset]
 	offset++
+	cpu.stopped = buf[offset] == 1
+	offset++
+	cpu.speedSwitchCycles = binary.LittleEndian.Uint16(buf[offset:])
+	offset += 2
+	cpu.locked = buf[offset] == 1
+	offset++
 
 	return offset
 }
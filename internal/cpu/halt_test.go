@@ -0,0 +1,247 @@
+package cpu
+
+import (
+	"testing"
+
+	"github.com/davidyorr/LuccaGB/internal/apu"
+	"github.com/davidyorr/LuccaGB/internal/bus"
+	"github.com/davidyorr/LuccaGB/internal/cartridge"
+	"github.com/davidyorr/LuccaGB/internal/dma"
+	"github.com/davidyorr/LuccaGB/internal/mmu"
+	"github.com/davidyorr/LuccaGB/internal/ppu"
+	"github.com/davidyorr/LuccaGB/internal/serial"
+	"github.com/davidyorr/LuccaGB/internal/timer"
+)
+
+const programStart uint16 = 0xC000
+
+// machineCycle is the state expected after 1 M-cycle.
+type machineCycle struct {
+	pc     uint16
+	halted bool
+}
+
+type haltTestCase struct {
+	program []byte
+	ime     bool
+	ie      uint8
+	// IF before the program runs
+	interruptFlag uint8
+	// IF is set to this after the given M-cycle, if not 0
+	lateInterruptFlag   uint8
+	lateInterruptMCycle int
+	cycles              []machineCycle
+}
+
+func newTestCpu(t *testing.T, program []byte) *CPU {
+	t.Helper()
+
+	mmu := mmu.New(cartridge.New())
+	dma := dma.New()
+	ppu := ppu.New(mmu.RequestInterrupt)
+	bus := bus.New()
+	bus.Connect(mmu, timer.New(), serial.New(), ppu, apu.New(), dma)
+	dma.ConnectBus(bus)
+	dma.ConnectPpu(ppu)
+
+	cpu := New()
+	cpu.ConnectBus(bus)
+	for i, b := range program {
+		bus.Write(programStart+uint16(i), b)
+	}
+	cpu.pc = programStart
+	cpu.sp = 0xDFFE
+
+	return cpu
+}
+
+func runHaltTest(t *testing.T, testCase haltTestCase) *CPU {
+	t.Helper()
+
+	cpu := newTestCpu(t, testCase.program)
+	cpu.ime = testCase.ime
+	cpu.bus.Write(0xFFFF, testCase.ie)
+	cpu.bus.Write(0xFF0F, testCase.interruptFlag)
+
+	for i, expected := range testCase.cycles {
+		mCycle := i + 1
+		cpu.executeMachineCycle()
+
+		if cpu.pc != expected.pc {
+			t.Fatalf("M-cycle %d: expected PC 0x%04X, got 0x%04X", mCycle, expected.pc, cpu.pc)
+		}
+		if cpu.halted != expected.halted {
+			t.Fatalf("M-cycle %d: expected halted %t, got %t", mCycle, expected.halted, cpu.halted)
+		}
+
+		if testCase.lateInterruptFlag != 0 && mCycle == testCase.lateInterruptMCycle {
+			cpu.bus.Write(0xFF0F, testCase.lateInterruptFlag)
+		}
+	}
+
+	return cpu
+}
+
+// the return address the interrupt dispatch pushed
+func pushedPc(cpu *CPU) uint16 {
+	return uint16(cpu.bus.Read(cpu.sp+1))<<8 | uint16(cpu.bus.Read(cpu.sp))
+}
+
+func TestHalt__ime_0_waits_for_interrupt(t *testing.T) {
+	cpu := runHaltTest(t, haltTestCase{
+		// HALT, INC A
+		program:             []byte{0x76, 0x3C},
+		ie:                  0x04,
+		lateInterruptFlag:   0x04,
+		lateInterruptMCycle: 3,
+		cycles: []machineCycle{
+			{pc: 0xC001, halted: true},
+			{pc: 0xC001, halted: true},
+			{pc: 0xC001, halted: true},
+			// woken up, the interrupt isn't serviced
+			{pc: 0xC002},
+		},
+	})
+
+	if cpu.a != 0x02 {
+		t.Errorf("expected A 0x02, got 0x%02X", cpu.a)
+	}
+}
+
+func TestHalt__ime_0_pending_reads_next_byte_twice(t *testing.T) {
+	cpu := runHaltTest(t, haltTestCase{
+		// HALT, INC A
+		program:       []byte{0x76, 0x3C},
+		ie:            0x01,
+		interruptFlag: 0x01,
+		cycles: []machineCycle{
+			{pc: 0xC001},
+			// INC A, the PC isn't incremented
+			{pc: 0xC001},
+			// INC A again
+			{pc: 0xC002},
+		},
+	})
+
+	if cpu.a != 0x03 {
+		t.Errorf("expected A 0x03, got 0x%02X", cpu.a)
+	}
+}
+
+func TestHalt__ime_0_pending_multi_byte_instruction(t *testing.T) {
+	cpu := runHaltTest(t, haltTestCase{
+		// HALT, LD DE,0x0C04
+		program:       []byte{0x76, 0x11, 0x04, 0x0C},
+		ie:            0x01,
+		interruptFlag: 0x01,
+		cycles: []machineCycle{
+			{pc: 0xC001},
+			// the opcode 0x11 is read, the PC stays
+			{pc: 0xC001},
+			// the opcode is read again as the low byte
+			{pc: 0xC002},
+			{pc: 0xC003},
+			// the high byte of the intended instruction runs as INC C
+			{pc: 0xC004},
+		},
+	})
+
+	if cpu.getDE() != 0x0411 {
+		t.Errorf("expected DE 0x0411, got 0x%04X", cpu.getDE())
+	}
+	if cpu.c != 0x14 {
+		t.Errorf("expected C 0x14, got 0x%02X", cpu.c)
+	}
+}
+
+func TestHalt__ime_0_upper_bits_are_not_interrupts(t *testing.T) {
+	runHaltTest(t, haltTestCase{
+		// HALT, NOP
+		program:       []byte{0x76, 0x00},
+		ie:            0xE1,
+		interruptFlag: 0xE0,
+		cycles: []machineCycle{
+			{pc: 0xC001, halted: true},
+			{pc: 0xC001, halted: true},
+		},
+	})
+}
+
+func TestHalt__ime_1_services_interrupt_after_halt(t *testing.T) {
+	cpu := runHaltTest(t, haltTestCase{
+		// HALT, NOP
+		program:             []byte{0x76, 0x00},
+		ime:                 true,
+		ie:                  0x04,
+		lateInterruptFlag:   0x04,
+		lateInterruptMCycle: 2,
+		cycles: []machineCycle{
+			{pc: 0xC001, halted: true},
+			{pc: 0xC001, halted: true},
+			// woken up, the interrupt is dispatched
+			{pc: 0xC001},
+			{pc: 0xC001},
+			{pc: 0xC001},
+			{pc: 0xC001},
+			{pc: 0x0050},
+		},
+	})
+
+	if pushedPc(cpu) != 0xC001 {
+		t.Errorf("expected return address 0xC001, got 0x%04X", pushedPc(cpu))
+	}
+}
+
+func TestHalt__ei_before_halt_returns_to_halt(t *testing.T) {
+	cpu := runHaltTest(t, haltTestCase{
+		// EI, HALT, NOP
+		program:       []byte{0xFB, 0x76, 0x00},
+		ie:            0x01,
+		interruptFlag: 0x01,
+		cycles: []machineCycle{
+			{pc: 0xC001},
+			// the interrupt can't be serviced before HALT, so the halt bug
+			// triggers
+			{pc: 0xC002},
+			// the interrupt is dispatched instead of the bugged fetch
+			{pc: 0xC001},
+			{pc: 0xC001},
+			{pc: 0xC001},
+			{pc: 0xC001},
+			{pc: 0x0040},
+		},
+	})
+
+	if pushedPc(cpu) != 0xC001 {
+		t.Errorf("expected return address 0xC001, got 0x%04X", pushedPc(cpu))
+	}
+	if cpu.haltBugActive {
+		t.Error("expected the halt bug to be cleared by the interrupt dispatch")
+	}
+}
+
+func TestHalt__ime_0_pending_ei_read_twice(t *testing.T) {
+	cpu := runHaltTest(t, haltTestCase{
+		// HALT, EI, NOP
+		program:       []byte{0x76, 0xFB, 0x00},
+		ie:            0x01,
+		interruptFlag: 0x01,
+		cycles: []machineCycle{
+			{pc: 0xC001},
+			// EI, the PC isn't incremented
+			{pc: 0xC001},
+			// EI again
+			{pc: 0xC002},
+			// the interrupt is dispatched before the NOP
+			{pc: 0xC002},
+			{pc: 0xC002},
+			{pc: 0xC002},
+			{pc: 0xC002},
+			{pc: 0x0040},
+		},
+	})
+
+	if pushedPc(cpu) != 0xC002 {
+		t.Errorf("expected return address 0xC002, got 0x%04X", pushedPc(cpu))
+	}
+}
@@ -2,8 +2,6 @@ package cpu
 
 import (
 	"fmt"
-
-	"github.com/davidyorr/LuccaGB/internal/debug"
 )
 
 type instruction struct {
@@ -313,19 +311,19 @@ var instructions = [256]instruction{
 	0x10: {"STOP", stop},
 
 	// undefined opcodes
-	0xD3: {"NOP", nop},
-	0xDB: {"NOP", nop},
-	0xDD: {"NOP", nop},
-	0xE3: {"NOP", nop},
-	0xE4: {"NOP", nop},
-	0xEB: {"NOP", nop},
-	0xEC: {"NOP", nop},
-	0xED: {"NOP", nop},
-	0xF4: {"NOP", nop},
-	0xFC: {"NOP", nop},
-	0xFD: {"NOP", nop},
-
-	// the mnemonic is generated in the handler function
+	0xD3: {"ILLEGAL", illegal},
+	0xDB: {"ILLEGAL", illegal},
+	0xDD: {"ILLEGAL", illegal},
+	0xE3: {"ILLEGAL", illegal},
+	0xE4: {"ILLEGAL", illegal},
+	0xEB: {"ILLEGAL", illegal},
+	0xEC: {"ILLEGAL", illegal},
+	0xED: {"ILLEGAL", illegal},
+	0xF4: {"ILLEGAL", illegal},
+	0xFC: {"ILLEGAL", illegal},
+	0xFD: {"ILLEGAL", illegal},
+
+	// the mnemonic depends on the CB opcode, see mnemonic
 	0xCB: {"", executeCbInstructionStep},
 }
 
@@ -341,32 +339,43 @@ func executeCbInstructionStep(cpu *CPU) bool {
 		return false
 	}
 
-	operation := *cpu.cbOpcode >> 6
-	u3 := (*cpu.cbOpcode & 0b0011_1000) >> 3
-	r8 := (*cpu.cbOpcode & 0b0000_0111)
+	operation := cpu.cbOpcode >> 6
+	u3 := (cpu.cbOpcode & 0b0011_1000) >> 3
+	r8 := (cpu.cbOpcode & 0b0000_0111)
 
 	switch operation {
 	case 0b00:
-		if debug.Enabled {
-			cpu.instruction.mnemonic = fmt.Sprintf("%s %s", cbShiftRotates[u3], cbRegisters[r8])
-		}
 		return cpu.shift_rotate_u3_r8(u3, r8)
 	case 0b01:
-		if debug.Enabled {
-			cpu.instruction.mnemonic = fmt.Sprintf("BIT %d, %s", u3, cbRegisters[r8])
-		}
 		return cpu.bit_u3_r8(u3, r8)
 	case 0b10:
-		if debug.Enabled {
-			cpu.instruction.mnemonic = fmt.Sprintf("RES %d, %s", u3, cbRegisters[r8])
-		}
 		return cpu.res_u3_r8(u3, r8)
 	case 0b11:
-		if debug.Enabled {
-			cpu.instruction.mnemonic = fmt.Sprintf("SET %d, %s", u3, cbRegisters[r8])
-		}
 		return cpu.set_u3_r8(u3, r8)
 	}
 
 	return false
 }
+
+// mnemonic returns the mnemonic of the current instruction, for logging.
+func (cpu *CPU) mnemonic() string {
+	if cpu.opcode != 0xCB {
+		return instructions[cpu.opcode].mnemonic
+	}
+	if cpu.mCycle < 2 {
+		return "PREFIX CB"
+	}
+
+	u3 := (cpu.cbOpcode & 0b0011_1000) >> 3
+	r8 := (cpu.cbOpcode & 0b0000_0111)
+	switch cpu.cbOpcode >> 6 {
+	case 0b00:
+		return fmt.Sprintf("%s %s", cbShiftRotates[u3], cbRegisters[r8])
+	case 0b01:
+		return fmt.Sprintf("BIT %d, %s", u3, cbRegisters[r8])
+	case 0b10:
+		return fmt.Sprintf("RES %d, %s", u3, cbRegisters[r8])
+	}
+
+	return fmt.Sprintf("SET %d, %s", u3, cbRegisters[r8])
+}
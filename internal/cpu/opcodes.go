@@ -816,6 +816,7 @@ func ld_a_hli(cpu *CPU) bool {
 		return false
 	case 2:
 		value := cpu.getHL()
+		cpu.bus.OamBugReadIncrease(value)
 		cpu.a = cpu.bus.Read(value)
 		cpu.setHL(value + 1)
 		return true
@@ -830,6 +831,7 @@ func ld_a_hld(cpu *CPU) bool {
 		return false
 	case 2:
 		value := cpu.getHL()
+		cpu.bus.OamBugReadIncrease(value)
 		cpu.a = cpu.bus.Read(value)
 		cpu.setHL(value - 1)
 		return true
@@ -1438,6 +1440,7 @@ func dec_bc(cpu *CPU) bool {
 	case 1:
 		return false
 	case 2:
+		cpu.bus.OamBugWrite(cpu.getBC())
 		cpu.setBC(cpu.getBC() - 1)
 		return true
 	}
@@ -1450,6 +1453,7 @@ func dec_de(cpu *CPU) bool {
 	case 1:
 		return false
 	case 2:
+		cpu.bus.OamBugWrite(cpu.getDE())
 		cpu.setDE(cpu.getDE() - 1)
 		return true
 	}
@@ -1462,6 +1466,7 @@ func dec_hl(cpu *CPU) bool {
 	case 1:
 		return false
 	case 2:
+		cpu.bus.OamBugWrite(cpu.getHL())
 		cpu.setHL(cpu.getHL() - 1)
 		return true
 	}
@@ -1474,6 +1479,7 @@ func inc_bc(cpu *CPU) bool {
 	case 1:
 		return false
 	case 2:
+		cpu.bus.OamBugWrite(cpu.getBC())
 		cpu.setBC(cpu.getBC() + 1)
 		return true
 	}
@@ -1486,6 +1492,7 @@ func inc_de(cpu *CPU) bool {
 	case 1:
 		return false
 	case 2:
+		cpu.bus.OamBugWrite(cpu.getDE())
 		cpu.setDE(cpu.getDE() + 1)
 		return true
 	}
@@ -1498,6 +1505,7 @@ func inc_hl(cpu *CPU) bool {
 	case 1:
 		return false
 	case 2:
+		cpu.bus.OamBugWrite(cpu.getHL())
 		cpu.setHL(cpu.getHL() + 1)
 		return true
 	}
@@ -2300,6 +2308,7 @@ func ret(cpu *CPU) bool {
 	case 1:
 		return false
 	case 2:
+		cpu.bus.OamBugReadIncrease(cpu.sp)
 		lowByte := cpu.bus.Read(cpu.sp)
 		cpu.setImmLowByte(lowByte)
 		cpu.sp++
@@ -2323,6 +2332,7 @@ func reti(cpu *CPU) bool {
 	case 1:
 		return false
 	case 2:
+		cpu.bus.OamBugReadIncrease(cpu.sp)
 		lowByte := cpu.bus.Read(cpu.sp)
 		cpu.setImmLowByte(lowByte)
 		cpu.sp++
@@ -2594,6 +2604,7 @@ func dec_sp(cpu *CPU) bool {
 	case 1:
 		return false
 	case 2:
+		cpu.bus.OamBugWrite(cpu.sp)
 		cpu.sp--
 		return true
 	}
@@ -2606,6 +2617,7 @@ func inc_sp(cpu *CPU) bool {
 	case 1:
 		return false
 	case 2:
+		cpu.bus.OamBugWrite(cpu.sp)
 		cpu.sp++
 		return true
 	}
@@ -2674,6 +2686,7 @@ func pop_bc(cpu *CPU) bool {
 	case 1:
 		return false
 	case 2:
+		cpu.bus.OamBugReadIncrease(cpu.sp)
 		lowByte := cpu.bus.Read(cpu.sp)
 		cpu.setImmLowByte(lowByte)
 		cpu.sp++
@@ -2695,6 +2708,7 @@ func pop_de(cpu *CPU) bool {
 	case 1:
 		return false
 	case 2:
+		cpu.bus.OamBugReadIncrease(cpu.sp)
 		lowByte := cpu.bus.Read(cpu.sp)
 		cpu.setImmLowByte(lowByte)
 		cpu.sp++
@@ -2716,6 +2730,7 @@ func pop_hl(cpu *CPU) bool {
 	case 1:
 		return false
 	case 2:
+		cpu.bus.OamBugReadIncrease(cpu.sp)
 		lowByte := cpu.bus.Read(cpu.sp)
 		cpu.setImmLowByte(lowByte)
 		cpu.sp++
@@ -2736,6 +2751,7 @@ func pop_af(cpu *CPU) bool {
 	case 1:
 		return false
 	case 2:
+		cpu.bus.OamBugReadIncrease(cpu.sp)
 		lowByte := cpu.bus.Read(cpu.sp)
 		cpu.setImmLowByte(lowByte)
 		cpu.sp++
@@ -2756,6 +2772,7 @@ func push_bc(cpu *CPU) bool {
 	case 1:
 		return false
 	case 2:
+		cpu.bus.OamBugWrite(cpu.sp)
 		cpu.sp--
 		return false
 	case 3:
@@ -2777,6 +2794,7 @@ func push_de(cpu *CPU) bool {
 	case 1:
 		return false
 	case 2:
+		cpu.bus.OamBugWrite(cpu.sp)
 		cpu.sp--
 		return false
 	case 3:
@@ -2798,6 +2816,7 @@ func push_hl(cpu *CPU) bool {
 	case 1:
 		return false
 	case 2:
+		cpu.bus.OamBugWrite(cpu.sp)
 		cpu.sp--
 		return false
 	case 3:
@@ -2819,6 +2838,7 @@ func push_af(cpu *CPU) bool {
 	case 1:
 		return false
 	case 2:
+		cpu.bus.OamBugWrite(cpu.sp)
 		cpu.sp--
 		return false
 	case 3:
@@ -2847,8 +2867,14 @@ func ei(cpu *CPU) bool {
 }
 
 // 0x76 Enter CPU low-power consumption mode until an interrupt occurs
+//
+// With an interrupt already pending HALT doesn't halt. If the interrupt can't
+// be serviced right away, because IME is clear or was only just set by EI, the
+// halt bug makes the CPU fail to increment the PC after reading the next
+// opcode.
+// See: https://gbdev.io/pandocs/halt.html#halt-bug
 func halt(cpu *CPU) bool {
-	if !cpu.ime && cpu.interruptsPending() {
+	if (!cpu.ime || cpu.imeScheduled) && cpu.interruptsPending() {
 		cpu.haltBugActive = true
 		logger.Info("halt()", "halt bug active", cpu.haltBugActive)
 	} else {
@@ -2902,10 +2928,55 @@ func daa(cpu *CPU) bool {
 
 // 0x10 Enter CPU very low power mode
 //
-// Not implementing this for now because:
+// What STOP does depends on the joypad, pending interrupts and a CGB speed
+// switch armed through KEY1:
+//
+//	button held, interrupt pending:    1 byte, nothing happens
+//	button held, no interrupt pending: 2 bytes, HALT mode, DIV not reset
+//	speed switch armed:                DIV reset, speed switched, and with no
+//	                                   interrupt pending 2 bytes and a pause
+//	otherwise:                         STOP mode, DIV reset, 2 bytes unless an
+//	                                   interrupt is pending
 //
-//	No licensed rom makes use of STOP outside of CGB speed switching.
+// With a speed switch armed, IME set and an interrupt pending the CPU glitches
+// unpredictably, that case is treated like IME clear.
+// See: https://gbdev.io/pandocs/Reducing_Power_Consumption.html#the-bizarre-case-of-the-game-boy-stop-instruction-before-even-considering-timing
 func stop(cpu *CPU) bool {
+	interruptPending := cpu.interruptsPending()
+
+	switch {
+	case cpu.joypadLineLow():
+		if !interruptPending {
+			cpu.pc++
+			cpu.halted = true
+		}
+	case cpu.bus.SpeedSwitchArmed():
+		cpu.bus.Write(0xFF04, 0x00)
+		cpu.bus.SwitchSpeed()
+		if !interruptPending {
+			cpu.pc++
+			cpu.speedSwitchCycles = speedSwitchMachineCycles
+		}
+	default:
+		cpu.bus.Write(0xFF04, 0x00)
+		if !interruptPending {
+			cpu.pc++
+		}
+		cpu.stopped = true
+	}
+
+	return true
+}
+
+// 0xD3, 0xDB, 0xDD, 0xE3, 0xE4, 0xEB, 0xEC, 0xED, 0xF4, 0xFC, 0xFD Undefined
+//
+// Hangs the CPU until the Game Boy is reset: nothing more is fetched and
+// interrupts are ignored. The PC is left on the illegal opcode.
+func illegal(cpu *CPU) bool {
+	cpu.pc--
+	cpu.locked = true
+	logger.Info("illegal()", "locked", cpu.locked)
+
 	return true
 }
 
@@ -23,6 +23,8 @@ type DMA struct {
 	wasRestarted bool
 	bus          MemoryBus
 	ppu          *ppu.PPU
+	// CGB VRAM DMA, driven through HDMA1-HDMA5
+	vramDma VramDma
 }
 
 type TransferState uint8
@@ -57,6 +59,7 @@ func (dma *DMA) Reset() {
 	dma.state = StateIdle
 	dma.sourceAddress = 0
 	dma.progress = 0
+	dma.vramDma.Reset()
 }
 
 func (dma *DMA) ConnectBus(bus MemoryBus) {
@@ -215,6 +218,8 @@ func (dma *DMA) Serialize(buf []byte) int {
 	}
 	offset++
 
+	offset += dma.vramDma.Serialize(buf[offset:])
+
 	return offset
 }
 
@@ -243,5 +248,7 @@ func (dma *DMA) Deserialize(buf []byte) int {
 	dma.wasRestarted = buf[offset] == 1
 	offset++
 
+	offset += dma.vramDma.Deserialize(buf[offset:])
+
 	return offset
 }
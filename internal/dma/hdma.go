@@ -0,0 +1,288 @@
+package dma
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/davidyorr/LuccaGB/internal/debug"
+	"github.com/davidyorr/LuccaGB/internal/logger"
+	"github.com/davidyorr/LuccaGB/internal/ppu"
+)
+
+// VRAM DMA (CGB only) copies blocks of 16 bytes from ROM or RAM to VRAM. A
+// general purpose DMA copies every block at once, an HBlank DMA copies one
+// block at the start of each HBlank. The CPU is stalled while a block is
+// being copied.
+// See: https://gbdev.io/pandocs/CGB_Registers.html#lcd-vram-dma-transfers
+
+type VramDmaMode uint8
+
+const (
+	VramDmaIdle VramDmaMode = iota
+	VramDmaGeneral
+	VramDmaHblank
+)
+
+const (
+	vramDmaBlockSize = 16
+	// a byte is copied every 2 dots, so a block takes 8 M-cycles in normal
+	// speed and 16 M-cycles in double speed
+	dotsPerVramDmaByte = 2
+)
+
+type VramDma struct {
+	// 0xFF51 - HDMA1: Source high
+	// 0xFF52 - HDMA2: Source low, bits 0-3 are ignored
+	source uint16
+	// 0xFF53 - HDMA3: Destination high, bits 5-7 are ignored
+	// 0xFF54 - HDMA4: Destination low, bits 0-3 are ignored
+	destination uint16
+	// 0xFF55 - HDMA5: Length/Mode/Start
+	//	7 - Mode when written: 0 = general purpose; 1 = HBlank
+	//	    Status when read: 0 = active; 1 = inactive
+	//	6-0 - Remaining blocks minus 1
+	length uint8
+	mode   VramDmaMode
+	// true while a block is being copied, stalling the CPU
+	copying bool
+	// bytes of the current block copied so far
+	blockProgress uint8
+	dotCounter    uint8
+	// set when an HBlank starts, the block is copied once the CPU leaves HALT
+	blockPending    bool
+	previousPpuMode ppu.Mode
+	// whether the VRAM DMA registers are available
+	cgb bool
+}
+
+func (vramDma *VramDma) Reset() {
+	vramDma.source = 0x0000
+	vramDma.destination = 0x8000
+	vramDma.length = 0x7F
+	vramDma.mode = VramDmaIdle
+	vramDma.copying = false
+	vramDma.blockProgress = 0
+	vramDma.dotCounter = 0
+	vramDma.blockPending = false
+}
+
+// SetCgbMode enables or disables the VRAM DMA.
+func (dma *DMA) SetCgbMode(cgb bool) {
+	dma.vramDma.cgb = cgb
+}
+
+// VramDmaCopying reports whether the VRAM DMA is copying a block, during which
+// the CPU is stalled.
+func (dma *DMA) VramDmaCopying() bool {
+	return dma.vramDma.copying
+}
+
+// StepVramDma performs 1 dot of VRAM DMA work. It runs at the same speed in
+// normal and double speed mode.
+func (dma *DMA) StepVramDma(cpuHalted bool) {
+	vramDma := &dma.vramDma
+	if !vramDma.cgb {
+		return
+	}
+
+	mode := dma.ppu.Mode()
+	hblankStarted := mode == ppu.HorizontalBlank && vramDma.previousPpuMode != ppu.HorizontalBlank && dma.ppu.LcdEnabled()
+	vramDma.previousPpuMode = mode
+	if vramDma.mode == VramDmaHblank && hblankStarted {
+		vramDma.blockPending = true
+	}
+
+	// HBlank blocks aren't copied while the CPU is halted
+	if vramDma.blockPending && !vramDma.copying && !cpuHalted {
+		vramDma.blockPending = false
+		vramDma.copying = true
+		vramDma.blockProgress = 0
+		vramDma.dotCounter = 0
+	}
+
+	if !vramDma.copying {
+		return
+	}
+
+	vramDma.dotCounter++
+	if vramDma.dotCounter < dotsPerVramDmaByte {
+		return
+	}
+	vramDma.dotCounter = 0
+
+	dma.copyVramDmaByte()
+	vramDma.blockProgress++
+	if vramDma.blockProgress < vramDmaBlockSize {
+		return
+	}
+
+	// block finished
+	vramDma.blockProgress = 0
+	vramDma.length = (vramDma.length - 1) & 0b0111_1111
+
+	// the length wraps from 0 to 0x7F after the last block
+	if vramDma.length == 0x7F {
+		if debug.Enabled {
+			logger.Info("FINISHED VRAM DMA TRANSFER")
+		}
+		vramDma.mode = VramDmaIdle
+		vramDma.copying = false
+		return
+	}
+
+	// general purpose DMA copies every block in one go, HBlank DMA waits for
+	// the next HBlank, unless it was cancelled during this block
+	if vramDma.mode != VramDmaGeneral {
+		vramDma.copying = false
+	}
+}
+
+func (dma *DMA) copyVramDmaByte() {
+	vramDma := &dma.vramDma
+
+	var value uint8 = 0xFF
+	// VRAM can't be a source
+	if vramDma.source < 0x8000 || vramDma.source >= 0xA000 {
+		value = dma.bus.DirectRead(vramDma.source)
+	}
+	dma.ppu.WriteVram(vramDma.destination, value)
+
+	if debug.Enabled {
+		logger.Info(
+			"VRAM DMA WRITE",
+			"SOURCE", fmt.Sprintf("0x%04X", vramDma.source),
+			"DESTINATION", fmt.Sprintf("0x%04X", vramDma.destination),
+			"VALUE", fmt.Sprintf("0x%02X", value),
+		)
+	}
+
+	vramDma.source++
+	// the destination wraps within VRAM
+	vramDma.destination = 0x8000 | ((vramDma.destination + 1) & 0x1FFF)
+}
+
+func (dma *DMA) ReadVramDma(address uint16) uint8 {
+	vramDma := &dma.vramDma
+	// only HDMA5 can be read
+	if !vramDma.cgb || address != 0xFF55 {
+		return 0xFF
+	}
+
+	if vramDma.mode == VramDmaIdle {
+		return 0b1000_0000 | vramDma.length
+	}
+
+	return vramDma.length
+}
+
+func (dma *DMA) WriteVramDma(address uint16, value uint8) {
+	vramDma := &dma.vramDma
+	if !vramDma.cgb {
+		return
+	}
+
+	switch address {
+	case 0xFF51:
+		vramDma.source = (vramDma.source & 0x00FF) | uint16(value)<<8
+	case 0xFF52:
+		vramDma.source = (vramDma.source & 0xFF00) | uint16(value&0b1111_0000)
+	case 0xFF53:
+		vramDma.destination = 0x8000 | (vramDma.destination & 0x00FF) | uint16(value&0b0001_1111)<<8
+	case 0xFF54:
+		vramDma.destination = (vramDma.destination & 0xFF00) | uint16(value&0b1111_0000)
+	case 0xFF55:
+		// writing with bit 7 clear during an HBlank DMA cancels it
+		if vramDma.mode == VramDmaHblank && value&0b1000_0000 == 0 {
+			if debug.Enabled {
+				logger.Info("VRAM DMA CANCELLED")
+			}
+			vramDma.mode = VramDmaIdle
+			vramDma.blockPending = false
+			return
+		}
+
+		vramDma.length = value & 0b0111_1111
+		if value&0b1000_0000 == 0 {
+			vramDma.mode = VramDmaGeneral
+			vramDma.copying = true
+			vramDma.blockProgress = 0
+			vramDma.dotCounter = 0
+			return
+		}
+
+		vramDma.mode = VramDmaHblank
+		// with the LCD off there's no HBlank, and an HBlank already under way
+		// counts, so a block is copied right away
+		if !dma.ppu.LcdEnabled() || dma.ppu.Mode() == ppu.HorizontalBlank {
+			vramDma.blockPending = true
+		}
+	}
+}
+
+func (vramDma *VramDma) Serialize(buf []byte) int {
+	offset := 0
+
+	binary.LittleEndian.PutUint16(buf[offset:], vramDma.source)
+	offset += 2
+	binary.LittleEndian.PutUint16(buf[offset:], vramDma.destination)
+	offset += 2
+
+	buf[offset] = vramDma.length
+	offset++
+	buf[offset] = uint8(vramDma.mode)
+	offset++
+	if vramDma.copying {
+		buf[offset] = 1
+	} else {
+		buf[offset] = 0
+	}
+	offset++
+	buf[offset] = vramDma.blockProgress
+	offset++
+	buf[offset] = vramDma.dotCounter
+	offset++
+	if vramDma.blockPending {
+		buf[offset] = 1
+	} else {
+		buf[offset] = 0
+	}
+	offset++
+	buf[offset] = uint8(vramDma.previousPpuMode)
+	offset++
+	if vramDma.cgb {
+		buf[offset] = 1
+	} else {
+		buf[offset] = 0
+	}
+	offset++
+
+	return offset
+}
+
+func (vramDma *VramDma) Deserialize(buf []byte) int {
+	offset := 0
+
+	vramDma.source = binary.LittleEndian.Uint16(buf[offset:])
+	offset += 2
+	vramDma.destination = binary.LittleEndian.Uint16(buf[offset:])
+	offset += 2
+
+	vramDma.length = buf[offset]
+	offset++
+	vramDma.mode = VramDmaMode(buf[offset])
+	offset++
+	vramDma.copying = buf[offset] == 1
+	offset++
+	vramDma.blockProgress = buf[offset]
+	offset++
+	vramDma.dotCounter = buf[offset]
+	offset++
+	vramDma.blockPending = buf[offset] == 1
+	offset++
+	vramDma.previousPpuMode = ppu.Mode(buf[offset])
+	offset++
+	vramDma.cgb = buf[offset] == 1
+	offset++
+
+	return offset
+}
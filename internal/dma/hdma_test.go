@@ -0,0 +1,173 @@
+package dma
+
+import (
+	"testing"
+
+	"github.com/davidyorr/LuccaGB/internal/interrupt"
+	"github.com/davidyorr/LuccaGB/internal/ppu"
+)
+
+type testBus struct {
+	memory [0x10000]uint8
+}
+
+func (bus *testBus) DirectRead(address uint16) uint8 {
+	return bus.memory[address]
+}
+
+func (bus *testBus) Write(address uint16, value uint8) {
+	bus.memory[address] = value
+}
+
+// newTestVramDma returns a VRAM DMA in CGB mode set up to copy from 0xC000,
+// which holds 0x01, 0x02, ..., to 0x8000.
+func newTestVramDma() (*DMA, *ppu.PPU) {
+	bus := &testBus{}
+	for i := range 0x100 {
+		bus.memory[0xC000+i] = uint8(i + 1)
+	}
+
+	ppu := ppu.New(func(interrupt.Interrupt) {})
+	ppu.SetCgbMode(true)
+	dma := New()
+	dma.ConnectBus(bus)
+	dma.ConnectPpu(ppu)
+	dma.SetCgbMode(true)
+
+	dma.WriteVramDma(0xFF51, 0xC0)
+	dma.WriteVramDma(0xFF52, 0x00)
+	dma.WriteVramDma(0xFF53, 0x00)
+	dma.WriteVramDma(0xFF54, 0x00)
+
+	return dma, ppu
+}
+
+func stepDots(dma *DMA, ppu *ppu.PPU, dots int) {
+	for range dots {
+		ppu.Step()
+		dma.StepVramDma(false)
+	}
+}
+
+// copiedBlocks counts the blocks copied to VRAM from the start of 0x8000.
+func copiedBlocks(ppu *ppu.PPU) int {
+	blocks := 0
+	for ; blocks < 0x10; blocks++ {
+		for i := range vramDmaBlockSize {
+			address := blocks*vramDmaBlockSize + i
+			if ppu.ReadVram(0x8000+uint16(address)) != uint8(address+1) {
+				return blocks
+			}
+		}
+	}
+
+	return blocks
+}
+
+const (
+	dotsPerBlock = vramDmaBlockSize * dotsPerVramDmaByte
+	hblank       = ppu.HorizontalBlank
+)
+
+func TestGeneralDma(t *testing.T) {
+	dma, ppu := newTestVramDma()
+	// 3 blocks
+	dma.WriteVramDma(0xFF55, 0x02)
+	if !dma.VramDmaCopying() {
+		t.Fatal("expected the general purpose DMA to start right away")
+	}
+
+	stepDots(dma, ppu, 3*dotsPerBlock)
+	if blocks := copiedBlocks(ppu); blocks != 3 {
+		t.Errorf("expected 3 blocks copied, got %d", blocks)
+	}
+	if dma.VramDmaCopying() {
+		t.Error("expected the DMA to be done")
+	}
+	if value := dma.ReadVramDma(0xFF55); value != 0xFF {
+		t.Errorf("expected HDMA5 to read 0xFF once done, got 0x%02X", value)
+	}
+}
+
+func TestHblankDma(t *testing.T) {
+	dma, ppu := newTestVramDma()
+	// 2 blocks, started in mode 2
+	dma.WriteVramDma(0xFF55, 0x81)
+	stepDots(dma, ppu, 80)
+	if blocks := copiedBlocks(ppu); blocks != 0 {
+		t.Fatalf("expected no blocks copied before the HBlank, got %d", blocks)
+	}
+
+	for ppu.Mode() != hblank {
+		stepDots(dma, ppu, 1)
+	}
+	stepDots(dma, ppu, dotsPerBlock)
+	if blocks := copiedBlocks(ppu); blocks != 1 {
+		t.Errorf("expected 1 block copied in the first HBlank, got %d", blocks)
+	}
+	if value := dma.ReadVramDma(0xFF55); value != 0x00 {
+		t.Errorf("expected HDMA5 to read 0x00 with 1 block left, got 0x%02X", value)
+	}
+
+	// the next line's HBlank
+	stepDots(dma, ppu, 456)
+	if blocks := copiedBlocks(ppu); blocks != 2 {
+		t.Errorf("expected 2 blocks copied in the second HBlank, got %d", blocks)
+	}
+	if value := dma.ReadVramDma(0xFF55); value != 0xFF {
+		t.Errorf("expected HDMA5 to read 0xFF once done, got 0x%02X", value)
+	}
+}
+
+func TestHblankDmaStartedInHblank(t *testing.T) {
+	dma, ppu := newTestVramDma()
+	for ppu.Mode() != hblank {
+		stepDots(dma, ppu, 1)
+	}
+	stepDots(dma, ppu, 10)
+
+	dma.WriteVramDma(0xFF55, 0x81)
+	stepDots(dma, ppu, dotsPerBlock)
+	if blocks := copiedBlocks(ppu); blocks != 1 {
+		t.Errorf("expected the first block copied in the current HBlank, got %d blocks", blocks)
+	}
+}
+
+func TestHblankDmaLcdOff(t *testing.T) {
+	dma, ppu := newTestVramDma()
+	ppu.Write(0xFF40, 0x11)
+
+	dma.WriteVramDma(0xFF55, 0x81)
+	stepDots(dma, ppu, 2*dotsPerBlock)
+	if blocks := copiedBlocks(ppu); blocks != 1 {
+		t.Errorf("expected 1 block copied with the LCD off, got %d", blocks)
+	}
+}
+
+func TestHblankDmaCancel(t *testing.T) {
+	dma, ppu := newTestVramDma()
+	dma.WriteVramDma(0xFF55, 0x83)
+	dma.WriteVramDma(0xFF55, 0x00)
+	if value := dma.ReadVramDma(0xFF55); value != 0x83 {
+		t.Errorf("expected HDMA5 to read 0x83 once cancelled, got 0x%02X", value)
+	}
+
+	stepDots(dma, ppu, 456)
+	if blocks := copiedBlocks(ppu); blocks != 0 {
+		t.Errorf("expected no blocks copied once cancelled, got %d", blocks)
+	}
+}
+
+func TestVramDmaDmgMode(t *testing.T) {
+	dma, ppu := newTestVramDma()
+	dma.SetCgbMode(false)
+
+	dma.WriteVramDma(0xFF55, 0x00)
+	stepDots(dma, ppu, dotsPerBlock)
+	if blocks := copiedBlocks(ppu); blocks != 0 {
+		t.Errorf("expected no VRAM DMA in DMG mode, got %d blocks", blocks)
+	}
+	if value := dma.ReadVramDma(0xFF55); value != 0xFF {
+		t.Errorf("expected HDMA5 to read 0xFF in DMG mode, got 0x%02X", value)
+	}
+}
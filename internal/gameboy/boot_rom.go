@@ -0,0 +1,85 @@
+package gameboy
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+
+	"github.com/davidyorr/LuccaGB/internal/model"
+)
+
+// the DMG family's boot ROMs are mapped to 0x0000-0x00FF, the CGB family's to
+// 0x0000-0x00FF and 0x0200-0x08FF
+const (
+	dmgBootRomSize = 0x0100
+	cgbBootRomSize = 0x0900
+)
+
+// SHA-1 hashes of the official boot ROMs, used to catch a boot ROM loaded for
+// the wrong model. Other boot ROMs, like open source replacements, are allowed.
+var bootRomHashes = map[string]Model{
+	"8bd501e31921e9601788316dbd3ce9833a97bcbc": model.Dmg0,
+	"4ed31ec6b0b175bb109c0eb5fd3d193da823339f": model.Dmg,
+	"4e68f9da03c310e84c523654b9026e51f26ce7f0": model.Mgb,
+	"aa2f50a77dfb4823da96ba99309085a3c6278515": model.Sgb,
+	"93407ea10d2f30ab96a314d8eca44fe160aea734": model.Sgb2,
+	"1293d68bf9643bc4f36954c1e80e38f39864528d": model.Cgb,
+	"fa5287e24b0fa533b3b5ef2b28a81245346c1a0f": model.Agb,
+}
+
+// BootRomSizeError is returned when a boot ROM isn't the size of the model's
+// boot ROMs.
+type BootRomSizeError struct {
+	Model    Model
+	Size     int
+	Expected int
+}
+
+func (err *BootRomSizeError) Error() string {
+	return fmt.Sprintf("%s boot ROM is %d bytes, expected %d bytes", err.Model, err.Size, err.Expected)
+}
+
+// BootRomModelError is returned when a boot ROM is the official boot ROM of
+// another model.
+type BootRomModelError struct {
+	Model        Model
+	BootRomModel Model
+}
+
+func (err *BootRomModelError) Error() string {
+	return fmt.Sprintf("boot ROM is for the %s, expected one for the %s", err.BootRomModel, err.Model)
+}
+
+func validateBootRom(gbModel Model, bootRom []uint8) error {
+	expectedSize := dmgBootRomSize
+	if gbModel.IsCgbFamily() {
+		expectedSize = cgbBootRomSize
+	}
+	if len(bootRom) != expectedSize {
+		return &BootRomSizeError{Model: gbModel, Size: len(bootRom), Expected: expectedSize}
+	}
+
+	hash := sha1.Sum(bootRom)
+	bootRomModel, known := bootRomHashes[hex.EncodeToString(hash[:])]
+	if known && bootRomModel != gbModel {
+		return &BootRomModelError{Model: gbModel, BootRomModel: bootRomModel}
+	}
+
+	return nil
+}
+
+// LoadBootRom sets a boot ROM to run when a ROM is loaded, instead of starting
+// the game with the state the boot ROM leaves behind. It applies from the next
+// LoadRom, which starts the CPU at 0x0000 from a cold reset, with the boot ROM
+// mapped until 0xFF50 is written. The error is a *BootRomSizeError or
+// *BootRomModelError if the boot ROM doesn't match the model.
+func (gameboy *Gameboy) LoadBootRom(bootRom []uint8) error {
+	if err := validateBootRom(gameboy.model, bootRom); err != nil {
+		return err
+	}
+
+	gameboy.bootRom = make([]uint8, len(bootRom))
+	copy(gameboy.bootRom, bootRom)
+
+	return nil
+}
@@ -0,0 +1,183 @@
+//go:build !screenshots
+
+package gameboy
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"testing"
+)
+
+// newTestBootRom returns a boot ROM for the model which runs code from 0x0000,
+// filling the rest with 0xBB.
+func newTestBootRom(gbModel Model, code ...uint8) []uint8 {
+	size := dmgBootRomSize
+	if gbModel.IsCgbFamily() {
+		size = cgbBootRomSize
+	}
+	bootRom := make([]uint8, size)
+	for i := range bootRom {
+		bootRom[i] = 0xBB
+	}
+	copy(bootRom, code)
+
+	return bootRom
+}
+
+// newTestBootGameboy loads rom on a Game Boy running bootRom.
+func newTestBootGameboy(t *testing.T, gbModel Model, bootRom []uint8, rom []uint8) *Gameboy {
+	t.Helper()
+
+	gb := New(gbModel)
+	if err := gb.LoadBootRom(bootRom); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := gb.LoadRom(rom); err != nil {
+		t.Fatal(err)
+	}
+
+	return gb
+}
+
+// stepUntilUnmapped runs the boot ROM until it unmaps itself.
+func stepUntilUnmapped(t *testing.T, gb *Gameboy) {
+	t.Helper()
+
+	for range 100 {
+		if !gb.mmu.BootRomMapped() {
+			return
+		}
+		gb.Step()
+	}
+	t.Fatal("expected the boot ROM to unmap itself")
+}
+
+func TestLoadBootRomErrors(t *testing.T) {
+	var sizeError *BootRomSizeError
+	if err := New(ModelDmg).LoadBootRom(make([]uint8, cgbBootRomSize)); !errors.As(err, &sizeError) || sizeError.Expected != dmgBootRomSize {
+		t.Errorf("expected a *BootRomSizeError for a CGB sized boot ROM on the DMG, got %v", err)
+	}
+	if err := New(ModelCgb).LoadBootRom(make([]uint8, dmgBootRomSize)); !errors.As(err, &sizeError) || sizeError.Expected != cgbBootRomSize {
+		t.Errorf("expected a *BootRomSizeError for a DMG sized boot ROM on the CGB, got %v", err)
+	}
+
+	// an official boot ROM of another model
+	bootRom := newTestBootRom(ModelDmg)
+	hash := sha1.Sum(bootRom)
+	bootRomHashes[hex.EncodeToString(hash[:])] = ModelMgb
+	defer delete(bootRomHashes, hex.EncodeToString(hash[:]))
+
+	var modelError *BootRomModelError
+	if err := New(ModelDmg).LoadBootRom(bootRom); !errors.As(err, &modelError) || modelError.BootRomModel != ModelMgb {
+		t.Errorf("expected a *BootRomModelError, got %v", err)
+	}
+	if err := New(ModelMgb).LoadBootRom(bootRom); err != nil {
+		t.Errorf("expected the boot ROM to load on its own model, got %v", err)
+	}
+}
+
+func TestBootRomUnmap(t *testing.T) {
+	rom := make([]uint8, 0x8000)
+	rom[0x0000] = 0x12
+	// LD A, 0x01; LDH (0x50), A
+	bootRom := newTestBootRom(ModelDmg, 0x3E, 0x01, 0xE0, 0x50)
+	gb := newTestBootGameboy(t, ModelDmg, bootRom, rom)
+
+	if value := gb.mmu.Read(0x0000); value != 0x3E {
+		t.Errorf("expected the boot ROM at 0x0000, got 0x%02X", value)
+	}
+	if value := gb.mmu.Read(0x0100); value != rom[0x0100] {
+		t.Errorf("expected the cartridge at 0x0100, got 0x%02X", value)
+	}
+
+	stepUntilUnmapped(t, gb)
+	if value := gb.mmu.Read(0x0000); value != 0x12 {
+		t.Errorf("expected the cartridge at 0x0000 once unmapped, got 0x%02X", value)
+	}
+
+	// the boot ROM can't be mapped again
+	gb.mmu.Write(0xFF50, 0x00)
+	if value := gb.mmu.Read(0x0000); value != 0x12 {
+		t.Errorf("expected the cartridge at 0x0000 after another write, got 0x%02X", value)
+	}
+}
+
+func TestBootRomHeaderGap(t *testing.T) {
+	rom := make([]uint8, 0x8000)
+	rom[0x0134] = 'T'
+	gb := newTestBootGameboy(t, ModelCgb, newTestBootRom(ModelCgb), rom)
+
+	for _, address := range []uint16{0x0000, 0x00FF, 0x0200, 0x08FF} {
+		if value := gb.mmu.Read(address); value != 0xBB {
+			t.Errorf("expected the boot ROM at 0x%04X, got 0x%02X", address, value)
+		}
+	}
+	for _, address := range []uint16{0x0100, 0x0134, 0x01FF, 0x0900} {
+		if value := gb.mmu.Read(address); value != rom[address] {
+			t.Errorf("expected the cartridge at 0x%04X, got 0x%02X", address, value)
+		}
+	}
+}
+
+// a CGB boot ROM handing over in the mode selected by KEY0
+func newTestHandoffBootRom(key0 uint8) []uint8 {
+	// LD A, key0; LDH (0x4C), A; LD A, 0x11; NOP; LDH (0x50), A
+	return newTestBootRom(ModelCgb, 0x3E, key0, 0xE0, 0x4C, 0x3E, 0x11, 0x00, 0xE0, 0x50)
+}
+
+func TestBootRomHandoff(t *testing.T) {
+	dmgRom := make([]uint8, 0x8000)
+	cgbRom := make([]uint8, 0x8000)
+	cgbRom[0x0143] = 0x80
+
+	tests := []struct {
+		name string
+		rom  []uint8
+		key0 uint8
+		cgb  bool
+	}{
+		{"DMG game", dmgRom, 0x04, false},
+		{"CGB game", cgbRom, 0x00, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			gb := newTestBootGameboy(t, ModelCgb, newTestHandoffBootRom(test.key0), test.rom)
+			if !gb.IsCgb() {
+				t.Fatal("expected the boot ROM to run in CGB mode")
+			}
+
+			stepUntilUnmapped(t, gb)
+			if gb.IsCgb() != test.cgb || gb.mmu.CgbMode() != test.cgb {
+				t.Errorf("expected CGB mode %v after the handoff, got %v", test.cgb, gb.IsCgb())
+			}
+
+			// KEY0 is locked after the handoff
+			gb.mmu.Write(0xFF4C, 0x04)
+			if gb.mmu.DmgCompatibility() {
+				t.Error("expected KEY0 to be locked after the handoff")
+			}
+		})
+	}
+}
+
+func TestBootRomHandoffAfterState(t *testing.T) {
+	rom := make([]uint8, 0x8000)
+	bootRom := newTestHandoffBootRom(0x04)
+	gb := newTestBootGameboy(t, ModelCgb, bootRom, rom)
+	// KEY0 is written, 0xFF50 isn't yet
+	for !gb.mmu.DmgCompatibility() {
+		gb.Step()
+	}
+	state := append([]byte(nil), gb.SerializeState(make([]byte, StateBufferSize))...)
+
+	gb = newTestBootGameboy(t, ModelCgb, bootRom, rom)
+	if err := gb.DeserializeState(state); err != nil {
+		t.Fatal(err)
+	}
+	stepUntilUnmapped(t, gb)
+	if gb.IsCgb() {
+		t.Error("expected the KEY0 of the state to select DMG mode")
+	}
+}
@@ -1,17 +1,25 @@
 package gameboy
 
 import (
+	"crypto/sha1"
+	"fmt"
+
 	"github.com/davidyorr/LuccaGB/internal/apu"
 	"github.com/davidyorr/LuccaGB/internal/bus"
+	"github.com/davidyorr/LuccaGB/internal/camera"
 	"github.com/davidyorr/LuccaGB/internal/cartridge"
+	"github.com/davidyorr/LuccaGB/internal/clock"
 	"github.com/davidyorr/LuccaGB/internal/cpu"
 	"github.com/davidyorr/LuccaGB/internal/dma"
+	"github.com/davidyorr/LuccaGB/internal/infrared"
 	"github.com/davidyorr/LuccaGB/internal/interrupt"
 	"github.com/davidyorr/LuccaGB/internal/joypad"
 	"github.com/davidyorr/LuccaGB/internal/logger"
 	"github.com/davidyorr/LuccaGB/internal/mmu"
+	"github.com/davidyorr/LuccaGB/internal/model"
 	"github.com/davidyorr/LuccaGB/internal/ppu"
 	"github.com/davidyorr/LuccaGB/internal/serial"
+	"github.com/davidyorr/LuccaGB/internal/sgb"
 	"github.com/davidyorr/LuccaGB/internal/timer"
 )
 
@@ -26,17 +34,68 @@ type Gameboy struct {
 	bus       *bus.Bus
 	cartridge *cartridge.Cartridge
 	joypad    *joypad.Joypad
+	sgb       *sgb.SGB
+	// wall time source for cartridge real-time clocks
+	clock clock.Clock
+
+	// the hardware revision being emulated
+	model Model
+	// whether the loaded ROM uses the SGB's functions
+	sgbEnabled bool
+	// run when a ROM is loaded, if set
+	bootRom []uint8
+	// identify the loaded ROM in save states
+	romSha1  [20]byte
+	romTitle string
+	// non-hardware: T-cycles elapsed since power on, used to timestamp events
+	tCycles uint64
+	// non-hardware: receives rumble motor changes
+	rumbleHandler func(RumbleEvent)
+
+	// non-hardware: snapshots to rewind to
+	rewind       rewindBuffer
+	serializeBuf []byte // Reusable buffer
+
+	// the components' chunks of a save state
+	stateChunks []stateChunk
+	// holds the state to restore if loading a save state fails, allocated on
+	// first use
+	stateBackupBuf []byte
+}
+
+// Model is a Game Boy hardware revision. It decides the state the boot ROM
+// leaves behind and the quirks of the hardware.
+type Model = model.Model
+
+const (
+	ModelDmg  = model.Dmg
+	ModelDmg0 = model.Dmg0
+	ModelMgb  = model.Mgb
+	ModelSgb  = model.Sgb
+	ModelSgb2 = model.Sgb2
+	ModelCgb  = model.Cgb
+	ModelAgb  = model.Agb
+)
+
+// ModelForRom returns the model a ROM was made for: the CGB for CGB enhanced
+// games, the SGB for games that use SGB functions and the DMG otherwise.
+func ModelForRom(rom []uint8) Model {
+	header, err := cartridge.ParseHeader(rom)
+	if err != nil {
+		return ModelDmg
+	}
+
+	switch {
+	case header.IsCgbEnhanced():
+		return ModelCgb
+	case header.SupportsSgb():
+		return ModelSgb
+	}
 
-	// non-hardware: circular rewind buffer
-	rewindBuffer      [][]byte // Circular buffer of serialized states
-	rewindCapacity    int      // Maximum number of states to hold
-	rewindHead        int      // Index where the next state will be written
-	rewindCount       int      // Current number of stored states
-	pendingRewindSave bool     // Flag to save on the next safe cycle
-	serializeBuf      []byte   // Reusable buffer
+	return ModelDmg
 }
 
-func New() *Gameboy {
+func New(model Model) *Gameboy {
 	cartridge := cartridge.New()
 	cpu := cpu.New()
 	apu := apu.New()
@@ -47,14 +106,21 @@ func New() *Gameboy {
 	dma := dma.New()
 	ppu := ppu.New(mmu.RequestInterrupt)
 	joypad := joypad.New(mmu.RequestInterrupt)
-
+	sgb := sgb.New(joypad, ppu)
+	clock := clock.NewRealTime()
+
+	cpu.SetModel(model)
+	timer.SetModel(model)
+	apu.SetModel(model)
+	ppu.SetModel(model)
+	cartridge.ConnectClock(clock)
 	mmu.ConnectJoypad(joypad)
 	bus.Connect(mmu, timer, serial, ppu, apu, dma)
 	cpu.ConnectBus(bus)
 	dma.ConnectBus(bus)
 	dma.ConnectPpu(ppu)
 
-	return &Gameboy{
+	gameboy := &Gameboy{
 		cpu:          cpu,
 		ppu:          ppu,
 		apu:          apu,
@@ -65,68 +131,246 @@ func New() *Gameboy {
 		bus:          bus,
 		cartridge:    cartridge,
 		joypad:       joypad,
-		serializeBuf: make([]byte, 1024*512), // 512KB
+		sgb:          sgb,
+		clock:        clock,
+		model:        model,
+		rewind:       rewindBuffer{frameInterval: 1},
+		serializeBuf: make([]byte, StateBufferSize),
 	}
+	gameboy.stateChunks = gameboy.newStateChunks()
+	cartridge.ConnectRumble(gameboy.handleRumble)
+	mmu.ConnectBootRomHandoff(gameboy.handleBootRomHandoff)
+
+	return gameboy
+}
+
+func (gameboy *Gameboy) Model() Model {
+	return gameboy.model
+}
+
+// IsCgb reports whether the loaded ROM runs in CGB mode.
+func (gameboy *Gameboy) IsCgb() bool {
+	return gameboy.ppu.CgbMode()
+}
+
+// IsSgb reports whether the loaded ROM uses the Super Game Boy's functions.
+func (gameboy *Gameboy) IsSgb() bool {
+	return gameboy.sgbEnabled
 }
 
-func (gameboy *Gameboy) LoadRom(rom []uint8) cartridge.CartridgeInfo {
+// LoadRom loads a ROM, returning its header. The error is a
+// *cartridge.TruncatedRomError or *cartridge.UnsupportedCartridgeError if
+// the ROM can't be run.
+func (gameboy *Gameboy) LoadRom(rom []uint8) (cartridge.CartridgeInfo, error) {
+	return gameboy.LoadRomWithOptions(rom, cartridge.LoadOptions{})
+}
+
+func (gameboy *Gameboy) LoadRomWithOptions(rom []uint8, options cartridge.LoadOptions) (cartridge.CartridgeInfo, error) {
 	logger.Info("GAMEBOY LOAD ROM", "SIZE", len(rom))
 
-	// Reset rewind buffer so stale states from a previous ROM can't be loaded
+	info, err := gameboy.cartridge.LoadRomWithOptions(rom, options)
+	if err != nil {
+		return info, err
+	}
+
+	// Reset rewind buffer so stale states from a previous ROM can't be loaded.
+	// A rejected ROM leaves the running game, and its history, as they were.
 	gameboy.ResetRewindBuffer()
+	gameboy.romSha1 = sha1.Sum(rom)
+	gameboy.romTitle = info.Title
+
+	// the CGB and AGB run games that aren't CGB enhanced in DMG mode. Their
+	// boot ROM runs in CGB mode and selects the game's mode itself, see
+	// handleBootRomHandoff.
+	cgb := gameboy.model.IsCgbFamily() && (info.Header.IsCgbEnhanced() || gameboy.bootRom != nil)
+	gameboy.cpu.SetHeaderChecksum(info.Header.HeaderChecksum)
+	gameboy.ppu.SetLogo(info.Header.Logo)
+	gameboy.setCgbMode(cgb)
+	gameboy.cpu.Reset()
+	gameboy.ppu.Reset()
+	gameboy.mmu.Reset()
+	gameboy.dma.Reset()
+	gameboy.timer.Reset()
+	gameboy.serial.Reset()
+
+	// the SGB ignores packets from games that don't declare SGB support
+	gameboy.sgbEnabled = gameboy.model.IsSgbFamily() && info.Header.SupportsSgb()
+	gameboy.sgb.Reset()
+	gameboy.joypad.SetPlayers(1)
+	if gameboy.sgbEnabled {
+		gameboy.joypad.ConnectSgb(gameboy.sgb.WriteP1)
+	} else {
+		gameboy.joypad.ConnectSgb(nil)
+	}
+
+	if gameboy.bootRom != nil {
+		gameboy.cpu.ColdReset()
+		gameboy.timer.ColdReset()
+		gameboy.ppu.ColdReset()
+		gameboy.apu.ColdReset()
+		gameboy.mmu.MapBootRom(gameboy.bootRom)
+	}
+
+	return info, nil
+}
+
+// setCgbMode switches every component between DMG and CGB mode, keeping their
+// state.
+func (gameboy *Gameboy) setCgbMode(cgb bool) {
+	gameboy.cpu.SetCgbMode(cgb)
+	gameboy.ppu.SetCgbMode(cgb)
+	gameboy.mmu.SetCgbMode(cgb)
+	gameboy.dma.SetCgbMode(cgb)
+	gameboy.timer.SetCgbMode(cgb)
+	gameboy.serial.SetCgbMode(cgb)
+}
+
+// handleBootRomHandoff switches to DMG mode when the CGB boot ROM selected it
+// through KEY0, before unmapping itself, for a game that isn't CGB enhanced.
+// The compatibility palettes it set up stay in the palette RAM.
+func (gameboy *Gameboy) handleBootRomHandoff() {
+	if gameboy.mmu.DmgCompatibility() {
+		gameboy.setCgbMode(false)
+	}
+}
+
+// SetClock replaces the wall time source used by cartridge real-time clocks.
+// A running cartridge clock continues from its current value on the new source.
+func (gameboy *Gameboy) SetClock(clock clock.Clock) {
+	gameboy.clock = clock
+	gameboy.cartridge.ConnectClock(clock)
+}
 
-	return gameboy.cartridge.LoadRom(rom)
+func (gameboy *Gameboy) Clock() clock.Clock {
+	return gameboy.clock
 }
 
+// CartridgeRam returns the battery save file in the .sav layout, or nil if the
+// cartridge has no battery.
 func (gameboy *Gameboy) CartridgeRam() []uint8 {
-	return gameboy.cartridge.Ram()
+	return gameboy.cartridge.SaveFile()
+}
+
+// SetCartridgeRam loads a battery save file in the .sav layout.
+func (gameboy *Gameboy) SetCartridgeRam(ram []uint8) error {
+	return gameboy.cartridge.LoadSaveFile(ram)
+}
+
+// CpuLockedError is returned by Step once an illegal opcode has hung the CPU.
+// The rest of the Game Boy keeps running, but only a reset recovers the CPU.
+type CpuLockedError struct {
+	PC     uint16
+	Opcode uint8
 }
 
-func (gameboy *Gameboy) SetCartridgeRam(ram []uint8) {
-	gameboy.cartridge.SetRam(ram)
+func (err *CpuLockedError) Error() string {
+	return fmt.Sprintf("CPU locked up by illegal opcode 0x%02X at 0x%04X", err.Opcode, err.PC)
 }
 
-// Advance the entire system by 1 M-cycle (4 T-cycles)
+// Advance the entire system by 1 M-cycle (4 T-cycles). In CGB double speed
+// mode, the CPU, timer, serial and OAM DMA run 2 M-cycles in that time. The
+// error is a *CpuLockedError once the CPU has hung.
 func (gameboy *Gameboy) Step() (tCycles uint8, frameReady bool, err error) {
+	// STOP mode stops the system clock, only the CPU watches the joypad
+	if gameboy.cpu.Stopped() {
+		for range 4 {
+			gameboy.cpu.Step()
+		}
+		gameboy.cartridge.Step(4)
+		gameboy.clock.Advance(4)
+		gameboy.tCycles += 4
+
+		return 4, false, nil
+	}
+
 	for range 4 {
 		gameboy.dma.Step()
 		if gameboy.ppu.Step() {
 			frameReady = true
-			gameboy.pendingRewindSave = true
+			if gameboy.sgbEnabled {
+				gameboy.sgb.OnFrame()
+			}
 		}
+		gameboy.dma.StepVramDma(gameboy.cpu.Halted())
 		gameboy.apu.Step()
-		gameboy.cpu.Step()
-		requestTimerInterrupt := gameboy.timer.Step()
-		if requestTimerInterrupt {
-			gameboy.mmu.RequestInterrupt(interrupt.TimerInterrupt)
-		}
-		requestSerialInterrupt := gameboy.serial.Step()
-		if requestSerialInterrupt {
-			gameboy.mmu.RequestInterrupt(interrupt.SerialInterrupt)
+		gameboy.stepCpuClockedComponents()
+
+		if gameboy.mmu.DoubleSpeed() {
+			gameboy.dma.Step()
+			gameboy.stepCpuClockedComponents()
 		}
 	}
+	gameboy.cartridge.Step(4)
+	gameboy.clock.Advance(4)
+	gameboy.tCycles += 4
+
+	if gameboy.cpu.Stopped() && gameboy.ppu.Stop() {
+		frameReady = true
+	}
 
-	if gameboy.pendingRewindSave && gameboy.IsSafeToSerialize() {
+	if frameReady {
 		gameboy.saveRewindState()
-		gameboy.pendingRewindSave = false
 	}
 
-	return 4, frameReady, nil
+	return 4, frameReady, gameboy.Err()
+}
+
+// Err returns a *CpuLockedError once an illegal opcode has hung the CPU, and
+// nil otherwise.
+func (gameboy *Gameboy) Err() error {
+	if gameboy.cpu.Locked() {
+		return &CpuLockedError{PC: gameboy.cpu.PC(), Opcode: gameboy.cpu.Opcode()}
+	}
+
+	return nil
+}
+
+// stepCpuClockedComponents performs 1 T-cycle of work for the components which
+// run at double speed along with the CPU, except the DMA which must step before
+// the PPU
+func (gameboy *Gameboy) stepCpuClockedComponents() {
+	// the CPU is stalled while the VRAM DMA copies a block
+	if !gameboy.dma.VramDmaCopying() {
+		gameboy.cpu.Step()
+	}
+	requestTimerInterrupt := gameboy.timer.Step()
+	if requestTimerInterrupt {
+		gameboy.mmu.RequestInterrupt(interrupt.TimerInterrupt)
+	}
+	requestSerialInterrupt := gameboy.serial.Step()
+	if requestSerialInterrupt {
+		gameboy.mmu.RequestInterrupt(interrupt.SerialInterrupt)
+	}
 }
 
 // StepFrames runs the emulator until exactly n frames are generated.
 // It ignores real-time syncing and runs as fast as the CPU allows.
-func (gameboy *Gameboy) StepFrames(frames int) {
+// A Game Boy in STOP mode or with the LCD off generates no frames, so meanwhile
+// the length of a frame counts as one. It stops early with a *CpuLockedError
+// once the CPU has hung.
+func (gameboy *Gameboy) StepFrames(frames int) error {
+	// A Game Boy frame is 70224 clock cycles
+	const cyclesPerFrame = 70224
 	framesSeen := 0
-	for {
-		_, frameReady, _ := gameboy.Step()
+	idleCycles := 0
+	for framesSeen < frames {
+		tCycles, frameReady, err := gameboy.Step()
+		if err != nil {
+			return err
+		}
+		if gameboy.cpu.Stopped() || !gameboy.ppu.LcdEnabled() {
+			idleCycles += int(tCycles)
+			if idleCycles >= cyclesPerFrame {
+				idleCycles = 0
+				frameReady = true
+			}
+		}
 		if frameReady {
 			framesSeen++
-			if framesSeen == frames {
-				break
-			}
 		}
 	}
+
+	return nil
 }
 
 // SetJoypadState sets the entire controller state in one go.
@@ -161,143 +405,101 @@ func (gameboy *Gameboy) ReleaseJoypadInput(input joypad.JoypadInput) {
 	gameboy.joypad.Release(input)
 }
 
-func (gameboy *Gameboy) FrameBuffer() [144][160]uint8 {
-	return gameboy.ppu.FrameBuffer()
+// PressPlayerJoypadInput presses a button on one of the controllers of the
+// SGB's multiplayer mode. Player 0 is the same controller as PressJoypadInput,
+// players outside 0-3 are ignored.
+func (gameboy *Gameboy) PressPlayerJoypadInput(player int, input joypad.JoypadInput) {
+	gameboy.joypad.PressPlayer(player, input)
 }
 
-func (gameboy *Gameboy) FrameBufferDownsampled() [72][80]uint8 {
-	return gameboy.ppu.FrameBufferDownsampled()
+func (gameboy *Gameboy) ReleasePlayerJoypadInput(player int, input joypad.JoypadInput) {
+	gameboy.joypad.ReleasePlayer(player, input)
 }
 
-func (gameboy *Gameboy) ReadSamples(dst []int16) int {
-	return gameboy.apu.ReadSamples(dst)
+// ConnectInfrared connects the IR port of HuC1 and HuC3 cartridges to a
+// transceiver. Use infrared.NewLink to connect two Gameboys to each other.
+func (gameboy *Gameboy) ConnectInfrared(transceiver infrared.Transceiver) {
+	gameboy.cartridge.ConnectInfrared(transceiver)
 }
 
-func (gameboy *Gameboy) SetAudioChannelEnabled(channel int, enabled bool) {
-	gameboy.apu.SetChannelEnabled(channel, enabled)
+// ConnectCamera sets the image source seen by the Pocket Camera's sensor. Until
+// one is connected, the camera sees a test pattern.
+func (gameboy *Gameboy) ConnectCamera(source camera.ImageSource) {
+	gameboy.cartridge.ConnectCamera(source)
 }
 
-func (gameboy *Gameboy) GetAudioChannelEnabled(channel int) bool {
-	return gameboy.apu.GetChannelEnabled(channel)
+// SetTilt sets the accelerometer input for tilt sensing cartridges (MBC7), in
+// g. It's oriented like a gamepad stick: positive x when the Game Boy is tilted
+// right, positive y when it's tilted down (towards the player).
+func (gameboy *Gameboy) SetTilt(x, y float64) {
+	gameboy.cartridge.SetTilt(x, y)
 }
 
-func (gameboy *Gameboy) ReadMemory(address uint16) uint8 {
-	return gameboy.bus.DirectRead(address)
+// RumbleEvent reports the rumble motor being switched on or off.
+type RumbleEvent struct {
+	On bool
+	// T-cycles elapsed since power on when the motor was switched
+	TCycle uint64
 }
 
-// SetRewindBufferSize sets the maximum number of frames to store in the rewind buffer.
-// If the new size is smaller than the current count, it preserves the most recent states.
-func (gb *Gameboy) SetRewindBufferSize(size int) {
-	if size < 0 {
-		size = 0
-	}
-	if size == gb.rewindCapacity {
-		return
-	}
-
-	newBuffer := make([][]byte, size)
-
-	// Determine how many of the existing states we can keep
-	keep := gb.rewindCount
-	if keep > size {
-		keep = size
-	}
-
-	if keep > 0 {
-		oldStates := gb.GetRewindBuffer() // Gets them chronologically (oldest to newest)
-
-		// Copy the "keep" newest states to the new buffer
-		startIdx := len(oldStates) - keep
-		for i := 0; i < keep; i++ {
-			newBuffer[i] = oldStates[startIdx+i]
-		}
-	}
-
-	gb.rewindBuffer = newBuffer
-	gb.rewindCapacity = size
-	gb.rewindCount = keep
-
-	if size > 0 {
-		gb.rewindHead = keep % size
-	} else {
-		gb.rewindHead = 0
-	}
+// SetRumbleHandler registers a handler called whenever the cartridge switches
+// its rumble motor. Games vary the motor strength by toggling it rapidly, so
+// the handler may be called many times per frame.
+func (gameboy *Gameboy) SetRumbleHandler(handler func(RumbleEvent)) {
+	gameboy.rumbleHandler = handler
 }
 
-// GetRewindBuffer returns a copy of all currently saved states in chronological
-// order (from oldest to newest). It does not modify or consume the buffer.
-func (gb *Gameboy) GetRewindBuffer() [][]byte {
-	if gb.rewindCount == 0 {
-		return nil
-	}
-
-	states := make([][]byte, gb.rewindCount)
-
-	// Find the index of the oldest element
-	oldest := 0
-	if gb.rewindCount == gb.rewindCapacity {
-		oldest = gb.rewindHead
-	}
+// Rumble reports whether the rumble motor is currently on.
+func (gameboy *Gameboy) Rumble() bool {
+	return gameboy.cartridge.Rumble()
+}
 
-	for i := 0; i < gb.rewindCount; i++ {
-		idx := (oldest + i) % gb.rewindCapacity
-		states[i] = gb.rewindBuffer[idx]
+func (gameboy *Gameboy) handleRumble(on bool) {
+	if gameboy.rumbleHandler == nil {
+		return
 	}
 
-	return states
+	gameboy.rumbleHandler(RumbleEvent{
+		On:     on,
+		TCycle: gameboy.tCycles,
+	})
 }
 
-// GetRewindCapacity gets the size of the rewind buffer.
-func (gb *Gameboy) GetRewindCapacity() int {
-	return gb.rewindCapacity
+func (gameboy *Gameboy) FrameBuffer() [144][160]uint8 {
+	return gameboy.ppu.FrameBuffer()
 }
 
-// Rewind pops the most recent state off the buffer and loads it.
-// Returns true if a state was successfully loaded, false if the buffer is empty.
-func (gb *Gameboy) Rewind() bool {
-	if gb.rewindCount == 0 {
-		return false
-	}
-
-	gb.rewindHead--
-	if gb.rewindHead < 0 {
-		gb.rewindHead = gb.rewindCapacity - 1
-	}
-
-	stateData := gb.rewindBuffer[gb.rewindHead]
-	gb.DeserializeState(stateData)
-	gb.rewindCount--
-
-	return true
+// ColorFrameBuffer returns the frame in RGB555: bits 0-4 red, 5-9 green and
+// 10-14 blue. In DMG mode the 4 shades are shown in the colors of the model's
+// screen.
+func (gameboy *Gameboy) ColorFrameBuffer() [144][160]uint16 {
+	return gameboy.ppu.ColorFrameBuffer()
 }
 
-func (gb *Gameboy) ResetRewindBuffer() {
-	gb.rewindHead = 0
-	gb.rewindCount = 0
-	gb.pendingRewindSave = false
+// SgbFrameBuffer returns the 256x224 output of the Super Game Boy in RGB555,
+// the frame colored by the SGB's palettes inside its border.
+func (gameboy *Gameboy) SgbFrameBuffer() [sgb.Height][sgb.Width]uint16 {
+	return gameboy.sgb.Frame()
 }
 
-// internal helper to save the state
-func (gb *Gameboy) saveRewindState() {
-	if gb.rewindCapacity <= 0 {
-		return
-	}
+func (gameboy *Gameboy) FrameBufferDownsampled() [72][80]uint8 {
+	return gameboy.ppu.FrameBufferDownsampled()
+}
 
-	// Serialize into our pre-allocated, reusable buffer
-	data := gb.SerializeState(gb.serializeBuf)
+func (gameboy *Gameboy) ReadSamples(dst []int16) int {
+	return gameboy.apu.ReadSamples(dst)
+}
 
-	// Reuse existing buffer if possible to prevent allocations every frame
-	snapshot := gb.rewindBuffer[gb.rewindHead]
-	snapshot = append(snapshot[:0], data...)
+func (gameboy *Gameboy) SetAudioChannelEnabled(channel int, enabled bool) {
+	gameboy.apu.SetChannelEnabled(channel, enabled)
+}
 
-	// Store in the ring buffer
-	gb.rewindBuffer[gb.rewindHead] = snapshot
+func (gameboy *Gameboy) GetAudioChannelEnabled(channel int) bool {
+	return gameboy.apu.GetChannelEnabled(channel)
+}
 
-	// Advance head and count
-	gb.rewindHead = (gb.rewindHead + 1) % gb.rewindCapacity
-	if gb.rewindCount < gb.rewindCapacity {
-		gb.rewindCount++
-	}
+func (gameboy *Gameboy) ReadMemory(address uint16) uint8 {
+	return gameboy.bus.DirectRead(address)
 }
 
 // Debug gathers debug information from all components, acting as a single entry
@@ -312,37 +514,3 @@ func (gb *Gameboy) Debug() map[string]interface{} {
 
 	return debugInfo
 }
-
-func (gb *Gameboy) IsSafeToSerialize() bool {
-	return gb.cpu.IsSafeToSerialize()
-}
-
-func (gb *Gameboy) SerializeState(buf []byte) []byte {
-	offset := 0
-
-	offset += gb.cpu.Serialize(buf[offset:])
-	offset += gb.apu.Serialize(buf[offset:])
-	offset += gb.ppu.Serialize(buf[offset:])
-	offset += gb.mmu.Serialize(buf[offset:])
-	offset += gb.dma.Serialize(buf[offset:])
-	offset += gb.timer.Serialize(buf[offset:])
-	offset += gb.serial.Serialize(buf[offset:])
-	offset += gb.cartridge.Serialize(buf[offset:])
-	offset += gb.joypad.Serialize(buf[offset:])
-
-	return buf[:offset]
-}
-
-func (gb *Gameboy) DeserializeState(data []byte) {
-	offset := 0
-
-	offset += gb.cpu.Deserialize(data[offset:])
-	offset += gb.apu.Deserialize(data[offset:])
-	offset += gb.ppu.Deserialize(data[offset:])
-	offset += gb.mmu.Deserialize(data[offset:])
-	offset += gb.dma.Deserialize(data[offset:])
-	offset += gb.timer.Deserialize(data[offset:])
-	offset += gb.serial.Deserialize(data[offset:])
-	offset += gb.cartridge.Deserialize(data[offset:])
-	offset += gb.joypad.Deserialize(data[offset:])
-}
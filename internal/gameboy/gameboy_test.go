@@ -28,6 +28,8 @@ type TestType string
 const (
 	TestTypeBlargg       TestType = "blargg"
 	TestTypeBlarggMemory TestType = "blargg_memory"
+	// for ROMs which only print their result on the screen
+	TestTypeBlarggScreen TestType = "blargg_screen"
 	TestTypeMooneye      TestType = "mooneye"
 )
 
@@ -59,7 +61,7 @@ func TestMain(m *testing.M) {
 }
 
 func TestBlargg__cpu_instrs(t *testing.T) {
-	loadRomAndRunSteps(t, "blargg/cpu_instrs", 56_108_272, TestTypeBlargg)
+	loadRomAndRunSteps(t, "blargg/cpu_instrs", 56_108_273, TestTypeBlargg)
 }
 
 func TestBlargg__dmg_sound(t *testing.T) {
@@ -67,7 +69,7 @@ func TestBlargg__dmg_sound(t *testing.T) {
 }
 
 func TestBlargg__halt_bug(t *testing.T) {
-	loadRomAndRunSteps(t, "blargg/halt_bug", 2_000_000, TestTypeBlargg)
+	loadRomAndRunSteps(t, "blargg/halt_bug", 2_000_000, TestTypeBlarggScreen)
 }
 
 func TestBlargg__instr_timing(t *testing.T) {
@@ -79,7 +81,7 @@ func TestBlargg__mem_timing(t *testing.T) {
 }
 
 func TestBlargg__oam_bug(t *testing.T) {
-	loadRomAndRunSteps(t, "blargg/oam_bug", 2_000_000, TestTypeBlarggMemory)
+	loadRomAndRunSteps(t, "blargg/oam_bug", 20_778_293, TestTypeBlarggMemory)
 }
 
 func TestMooneye__add_sp_e_timing(t *testing.T) {
@@ -109,7 +111,7 @@ func TestMooneye__call_timing2(t *testing.T) {
 // immediately
 // On CGB/GBA DI has a delay and this test fails in round 2!!
 func TestMooneye__di_timing_GS(t *testing.T) {
-	loadRomAndRunSteps(t, "mooneye/di_timing-GS", 269_971, TestTypeMooneye)
+	loadRomAndRunStepsOnModels(t, "mooneye/di_timing-GS", 269_971, TestTypeMooneye, ModelDmg, ModelMgb, ModelSgb, ModelSgb2)
 }
 
 func TestMooneye__div_timing(t *testing.T) {
@@ -139,7 +141,7 @@ func TestMooneye__halt_ime1_timing(t *testing.T) {
 }
 
 func TestMooneye__halt_ime1_timing2_GS(t *testing.T) {
-	loadRomAndRunSteps(t, "mooneye/halt_ime1_timing2-GS", 326_246, TestTypeMooneye)
+	loadRomAndRunStepsOnModels(t, "mooneye/halt_ime1_timing2-GS", 326_246, TestTypeMooneye, ModelDmg, ModelMgb, ModelSgb, ModelSgb2)
 }
 
 // This tests the behaviour of IE and IF flags by forcing a serial
@@ -224,7 +226,7 @@ func TestMooneye__bits__reg_f(t *testing.T) {
 // This test checks all unused bits in working $FFxx IO,
 // and all unused $FFxx IO. Unused bits and unused IO all return 1s.
 func TestMooneye__bits__unused_hwio_GS(t *testing.T) {
-	loadRomAndRunSteps(t, "mooneye/bits/unused_hwio-GS", 182_118, TestTypeMooneye)
+	loadRomAndRunStepsOnModels(t, "mooneye/bits/unused_hwio-GS", 182_118, TestTypeMooneye, ModelDmg, ModelMgb, ModelSgb, ModelSgb2)
 }
 
 // Tests the DAA instruction with all possible input combinations
@@ -365,7 +367,7 @@ func TestMooneye__oam_dma__reg_read(t *testing.T) {
 // This test checks that OAM DMA source memory areas work as expected,
 // including the area past $DFFF.
 func TestMooneye__oam_dma__sources_GS(t *testing.T) {
-	loadRomAndRunSteps(t, "mooneye/oam_dma/sources-GS", 636_933, TestTypeMooneye)
+	loadRomAndRunStepsOnModels(t, "mooneye/oam_dma/sources-GS", 636_933, TestTypeMooneye, ModelDmg, ModelMgb, ModelSgb, ModelSgb2)
 }
 
 // Tests how SCX affects the duration between STAT mode=0 interrupt and LY increment.
@@ -377,13 +379,13 @@ func TestMooneye__oam_dma__sources_GS(t *testing.T) {
 //	(SCX mod 8) = 1-4 => LY increments 50 cycles after STAT interrupt
 //	(SCX mod 8) = 5-7 => LY increments 49 cycles after STAT interrupt
 func TestMooneye__ppu__hblank_ly_scx_timing_GS(t *testing.T) {
-	loadRomAndRunSteps(t, "mooneye/ppu/hblank_ly_scx_timing-GS", 829_917, TestTypeMooneye)
+	loadRomAndRunStepsOnModels(t, "mooneye/ppu/hblank_ly_scx_timing-GS", 829_917, TestTypeMooneye, ModelDmg, ModelMgb, ModelSgb, ModelSgb2)
 }
 
 // Tests how long does it take to get from STAT mode=1 interrupt to STAT mode=2 interrupt
 // No sprites, scroll or window.
 func TestMooneye__ppu__intr_1_2_timing_GS(t *testing.T) {
-	loadRomAndRunSteps(t, "mooneye/ppu/intr_1_2_timing-GS", 238_312, TestTypeMooneye)
+	loadRomAndRunStepsOnModels(t, "mooneye/ppu/intr_1_2_timing-GS", 238_312, TestTypeMooneye, ModelDmg, ModelMgb, ModelSgb, ModelSgb2)
 }
 
 // Tests how long does it take to get from STAT mode=2 interrupt to STAT mode=0 interrupt
@@ -427,7 +429,7 @@ func TestMooneye__ppu__stat_irq_blocking(t *testing.T) {
 // This test measures the cycles between vblank<->vblank and compares that to vblank<->stat_m2_144
 // Expected behaviour: vblank and stat_m2_144 are triggered at the same time
 func TestMooneye__ppu__vblank_stat_intr_GS(t *testing.T) {
-	loadRomAndRunSteps(t, "mooneye/ppu/vblank_stat_intr-GS", 324_462, TestTypeMooneye)
+	loadRomAndRunStepsOnModels(t, "mooneye/ppu/vblank_stat_intr-GS", 324_462, TestTypeMooneye, ModelDmg, ModelMgb, ModelSgb, ModelSgb2)
 }
 
 // This test verifies that the timer is affected by resetting the DIV register
@@ -507,6 +509,22 @@ func TestMooneye__timer__tma_write_reloading(t *testing.T) {
 }
 
 func loadRomAndRunSteps(t *testing.T, romName string, stepCount int, testType TestType) {
+	// the step counts and expected outputs were recorded on a DMG
+	loadRomAndRunStepsOnModel(t, romName, stepCount, testType, ModelDmg)
+}
+
+// loadRomAndRunStepsOnModels runs a test on each model it passes on. Mooneye
+// tests are suffixed with them: G for the DMG, S for the SGB and SGB2, C for
+// the CGB and A for the AGB.
+func loadRomAndRunStepsOnModels(t *testing.T, romName string, stepCount int, testType TestType, models ...Model) {
+	for _, model := range models {
+		t.Run(model.String(), func(t *testing.T) {
+			loadRomAndRunStepsOnModel(t, romName, stepCount, testType, model)
+		})
+	}
+}
+
+func loadRomAndRunStepsOnModel(t *testing.T, romName string, stepCount int, testType TestType, model Model) {
 	atomic.AddInt32(&testsRun, 1)
 	t.Logf("TESTCASE: %s.gb", romName)
 	logBuffer, testLogger := initTestLogger()
@@ -518,8 +536,10 @@ func loadRomAndRunSteps(t *testing.T, romName string, stepCount int, testType Te
 		t.Fatal("Error reading file:", err)
 	}
 
-	gb := New()
-	gb.LoadRom(romBytes)
+	gb := New(model)
+	if _, err := gb.LoadRom(romBytes); err != nil {
+		t.Fatal("Error loading ROM:", err)
+	}
 
 	// track if the test emitted any pass/fail signal
 	testComplete := false
@@ -528,7 +548,7 @@ func loadRomAndRunSteps(t *testing.T, romName string, stepCount int, testType Te
 	var testOutput string
 
 	for i := range stepCount {
-		gb.Step()
+		_, frameReady, _ := gb.Step()
 
 		var passed, failed bool
 
@@ -543,6 +563,13 @@ func loadRomAndRunSteps(t *testing.T, romName string, stepCount int, testType Te
 				testOutput = string(memOutput)
 			}
 			passed, failed = checkResult(testOutput, testType)
+		case TestTypeBlarggScreen:
+			// VRAM can only be read outside of mode 3, so read the screen
+			// when the frame is done
+			if frameReady {
+				testOutput = readScreenText(gb)
+				passed, failed = checkResult(testOutput, testType)
+			}
 		}
 
 		if failed {
@@ -603,9 +630,25 @@ func (r *MemoryReader) TryToReadNextByte() (output byte, success bool) {
 	return output, success
 }
 
+// readScreenText reads the visible part of the background tile map as text.
+// Blargg's font maps tile numbers to ASCII.
+func readScreenText(gb *Gameboy) string {
+	var text strings.Builder
+	for row := range uint16(18) {
+		var line strings.Builder
+		for column := range uint16(20) {
+			line.WriteByte(gb.bus.DirectRead(0x9800 + row*32 + column))
+		}
+		text.WriteString(strings.TrimRight(line.String(), " "))
+		text.WriteString("\n")
+	}
+
+	return text.String()
+}
+
 func checkResult(output string, testType TestType) (passed bool, failed bool) {
 	switch testType {
-	case TestTypeBlargg, TestTypeBlarggMemory:
+	case TestTypeBlargg, TestTypeBlarggMemory, TestTypeBlarggScreen:
 		passed = strings.Contains(output, "Passed\n") || strings.Contains(output, "Passed all tests\n")
 		failed = strings.Contains(output, "Failed")
 	case TestTypeMooneye:
@@ -685,8 +728,10 @@ func BenchmarkRom(b *testing.B) {
 	logger.Init(silentHandler)
 	defer logger.Init(slog.Default().Handler())
 
-	gb := New()
-	gb.LoadRom(romBytes)
+	gb := New(ModelDmg)
+	if _, err := gb.LoadRom(romBytes); err != nil {
+		b.Fatal("Error loading ROM:", err)
+	}
 
 	// Snapshot Memory before loop
 	var m1 runtime.MemStats
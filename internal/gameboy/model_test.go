@@ -0,0 +1,108 @@
+//go:build !screenshots
+
+package gameboy
+
+import "testing"
+
+// newModelTestRom returns a ROM of NOPs with the given CGB flag and header
+// checksum, which also declares SGB support.
+func newModelTestRom(cgbFlag uint8, headerChecksum uint8) []uint8 {
+	rom := make([]uint8, 0x8000)
+	rom[0x0143] = cgbFlag
+	rom[0x0146] = 0x03
+	rom[0x014B] = 0x33
+	rom[0x014D] = headerChecksum
+
+	return rom
+}
+
+// the state each model's boot ROM hands over to the game in, which the -dmg0,
+// -mgb, -sgb and -C variants of mooneye's boot_regs, boot_div and boot_hwio
+// check
+func TestModelHandoffState(t *testing.T) {
+	tests := []struct {
+		name  string
+		model Model
+		rom   []uint8
+		// AF, BC, DE and HL
+		registers [4]uint16
+		div       uint8
+		// M-cycles until DIV next increments
+		divMCycles int
+		sc         uint8
+		sgb        bool
+	}{
+		{"DMG0", ModelDmg0, newModelTestRom(0x00, 0x01), [4]uint16{0x0100, 0xFF13, 0x00C1, 0x8403}, 0x18, 53, 0x7E, false},
+		{"DMG", ModelDmg, newModelTestRom(0x00, 0x01), [4]uint16{0x01B0, 0x0013, 0x00D8, 0x014D}, 0xAB, 13, 0x7E, false},
+		// the H and C flags come from the header checksum
+		{"DMG, header checksum 0", ModelDmg, newModelTestRom(0x00, 0x00), [4]uint16{0x0180, 0x0013, 0x00D8, 0x014D}, 0xAB, 13, 0x7E, false},
+		{"MGB", ModelMgb, newModelTestRom(0x00, 0x01), [4]uint16{0xFFB0, 0x0013, 0x00D8, 0x014D}, 0xAB, 13, 0x7E, false},
+		{"SGB", ModelSgb, newModelTestRom(0x00, 0x01), [4]uint16{0x0100, 0x0014, 0x0000, 0xC060}, 0xD8, 41, 0x7E, true},
+		{"SGB2", ModelSgb2, newModelTestRom(0x00, 0x01), [4]uint16{0xFF00, 0x0014, 0x0000, 0xC060}, 0xD8, 41, 0x7E, true},
+		{"CGB", ModelCgb, newModelTestRom(0x80, 0x01), [4]uint16{0x1180, 0x0000, 0xFF56, 0x000D}, 0x1E, 24, 0x7F, false},
+		{"CGB in DMG mode", ModelCgb, newModelTestRom(0x00, 0x01), [4]uint16{0x1180, 0x0000, 0x0008, 0x007C}, 0x26, 33, 0x7E, false},
+		{"AGB", ModelAgb, newModelTestRom(0x80, 0x01), [4]uint16{0x1100, 0x0100, 0xFF56, 0x000D}, 0x1E, 24, 0x7F, false},
+		{"AGB in DMG mode", ModelAgb, newModelTestRom(0x00, 0x01), [4]uint16{0x1100, 0x0100, 0x0008, 0x007C}, 0x26, 33, 0x7E, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			gb := New(test.model)
+			if _, err := gb.LoadRom(test.rom); err != nil {
+				t.Fatal(err)
+			}
+
+			registers16 := gb.cpu.Debug()["registers16"].(map[string]interface{})
+			registers := [4]uint16{
+				registers16["AF"].(uint16),
+				registers16["BC"].(uint16),
+				registers16["DE"].(uint16),
+				registers16["HL"].(uint16),
+			}
+			if registers != test.registers {
+				t.Errorf("expected AF, BC, DE and HL %04X, got %04X", test.registers, registers)
+			}
+			if sp, pc := registers16["SP"].(uint16), registers16["PC"].(uint16); sp != 0xFFFE || pc != 0x0100 {
+				t.Errorf("expected SP 0xFFFE and PC 0x0100, got 0x%04X and 0x%04X", sp, pc)
+			}
+			if gb.IsSgb() != test.sgb {
+				t.Errorf("expected IsSgb %t, got %t", test.sgb, gb.IsSgb())
+			}
+
+			// the PPU, serial port and timer registers
+			io := []struct {
+				name     string
+				address  uint16
+				expected uint8
+			}{
+				{"LCDC", 0xFF40, 0x91},
+				{"SCY", 0xFF42, 0x00},
+				{"SCX", 0xFF43, 0x00},
+				{"LY", 0xFF44, 0x00},
+				{"BGP", 0xFF47, 0xFC},
+				{"SB", 0xFF01, 0x00},
+				{"SC", 0xFF02, test.sc},
+				{"DIV", 0xFF04, test.div},
+				{"TIMA", 0xFF05, 0x00},
+				{"TAC", 0xFF07, 0xF8},
+			}
+			for _, register := range io {
+				if value := gb.bus.Read(register.address); value != register.expected {
+					t.Errorf("expected %s 0x%02X, got 0x%02X", register.name, register.expected, value)
+				}
+			}
+
+			// the phase of DIV within its 256 T-cycles
+			for range test.divMCycles - 1 {
+				gb.Step()
+			}
+			if div := gb.bus.Read(0xFF04); div != test.div {
+				t.Errorf("expected DIV 0x%02X after %d M-cycles, got 0x%02X", test.div, test.divMCycles-1, div)
+			}
+			gb.Step()
+			if div := gb.bus.Read(0xFF04); div != test.div+1 {
+				t.Errorf("expected DIV 0x%02X after %d M-cycles, got 0x%02X", test.div+1, test.divMCycles, div)
+			}
+		})
+	}
+}
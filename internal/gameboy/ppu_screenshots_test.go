@@ -104,8 +104,7 @@ func TestMealybug_tearoom__m2_win_en_toggle(t *testing.T) {
 }
 
 func TestMealybug_tearoom__m3_bgp_change(t *testing.T) {
-	// adjust the frameToRun after this tests passes
-	runPpuTest(t, "mealybug_tearoom/m3_bgp_change", 100, "ebba92b2babcdc02536c05806efbb492addc4f948d0aacf87fd199752cceeb2c")
+	runPpuTest(t, "mealybug_tearoom/m3_bgp_change", 3, "ebba92b2babcdc02536c05806efbb492addc4f948d0aacf87fd199752cceeb2c")
 }
 
 func TestMealybug_tearoom__m3_bgp_change_sprites(t *testing.T) {
@@ -174,8 +173,7 @@ func TestMealybug_tearoom__m3_obp0_change(t *testing.T) {
 }
 
 func TestMealybug_tearoom__m3_scx_high_5_bits(t *testing.T) {
-	// adjust the frameToRun after this tests passes
-	runPpuTest(t, "mealybug_tearoom/m3_scx_high_5_bits", 100, "6b0c2f51914064fa6f682a352fe205d42a10eabd971df7ef4c116d25b30c6528")
+	runPpuTest(t, "mealybug_tearoom/m3_scx_high_5_bits", 2, "6b0c2f51914064fa6f682a352fe205d42a10eabd971df7ef4c116d25b30c6528")
 }
 
 func TestMealybug_tearoom__m3_scx_low_3_bits(t *testing.T) {
@@ -184,8 +182,7 @@ func TestMealybug_tearoom__m3_scx_low_3_bits(t *testing.T) {
 }
 
 func TestMealybug_tearoom__m3_scy_change(t *testing.T) {
-	// adjust the frameToRun after this tests passes
-	runPpuTest(t, "mealybug_tearoom/m3_scy_change", 100, "b2daaac1a178651c295d1bee0fe3b42bc0ab62fbb2f3b5aecd2c53e927095da0")
+	runPpuTest(t, "mealybug_tearoom/m3_scy_change", 3, "b2daaac1a178651c295d1bee0fe3b42bc0ab62fbb2f3b5aecd2c53e927095da0")
 }
 
 func TestMealybug_tearoom__m3_window_timing(t *testing.T) {
@@ -237,8 +234,10 @@ func runPpuTest(t *testing.T, romName string, framesToRun int, expectedHash stri
 		t.Fatalf("❌ SETUP FAIL: %v", err)
 	}
 
-	gb := New()
-	gb.LoadRom(romBytes)
+	gb := New(ModelDmg)
+	if _, err := gb.LoadRom(romBytes); err != nil {
+		t.Fatalf("❌ SETUP FAIL: %v", err)
+	}
 
 	// 2. Run
 	for i := 0; i < framesToRun; i++ {
@@ -0,0 +1,259 @@
+package gameboy
+
+import "encoding/binary"
+
+// a snapshot is a keyframe at least this often, so restoring one never needs
+// more than a keyframe and a delta and evicting a keyframe drops few snapshots
+const rewindKeyframeInterval = 60
+
+// rewindSnapshot is a save state kept for rewinding. Consecutive states differ
+// in few bytes, so most are kept as the difference to the keyframe before
+// them: the XOR of both, run-length encoded.
+type rewindSnapshot struct {
+	keyframe bool
+	// the length of the state
+	length int
+	// the state of a keyframe, otherwise the encoded difference
+	data []byte
+}
+
+// rewindBuffer holds the snapshots in a memory budget, dropping the oldest ones
+// first.
+type rewindBuffer struct {
+	// oldest first, the first is always a keyframe
+	snapshots []rewindSnapshot
+	// the total size of the snapshots' data
+	size int
+	// in bytes, 0 disables rewinding
+	budget int
+	// the number of frames between snapshots
+	frameInterval int
+	// frames completed since the last snapshot
+	frames int
+	// decoded states are written here
+	stateBuf []byte
+}
+
+// SetRewindBufferSize sets the memory budget of the rewind buffer in bytes, 0
+// disables rewinding. If the snapshots taken don't fit it, the oldest are
+// dropped.
+func (gb *Gameboy) SetRewindBufferSize(size int) {
+	gb.rewind.budget = max(size, 0)
+	gb.rewind.evict()
+}
+
+// GetRewindBufferSize gets the memory budget of the rewind buffer in bytes.
+func (gb *Gameboy) GetRewindBufferSize() int {
+	return gb.rewind.budget
+}
+
+// GetRewindBufferUsage gets the bytes used by the snapshots in the rewind
+// buffer.
+func (gb *Gameboy) GetRewindBufferUsage() int {
+	return gb.rewind.size
+}
+
+// GetRewindSnapshotCount gets the number of snapshots in the rewind buffer.
+func (gb *Gameboy) GetRewindSnapshotCount() int {
+	return len(gb.rewind.snapshots)
+}
+
+// SetRewindFrameInterval sets the number of frames between snapshots, each
+// Rewind goes back that many frames. Longer intervals fit a longer history in
+// the same budget.
+func (gb *Gameboy) SetRewindFrameInterval(frames int) {
+	gb.rewind.frameInterval = max(frames, 1)
+}
+
+// GetRewindFrameInterval gets the number of frames between snapshots.
+func (gb *Gameboy) GetRewindFrameInterval() int {
+	return gb.rewind.frameInterval
+}
+
+// GetRewindBuffer returns a copy of all currently saved states in chronological
+// order (from oldest to newest). It does not modify or consume the buffer.
+func (gb *Gameboy) GetRewindBuffer() [][]byte {
+	states := make([][]byte, len(gb.rewind.snapshots))
+	for i := range gb.rewind.snapshots {
+		states[i] = gb.rewind.decode(i, nil)
+	}
+
+	return states
+}
+
+// Rewind pops the most recent snapshot off the buffer and loads it, going back
+// the frame interval. Returns true if a state was successfully loaded, false
+// if the buffer is empty or the state couldn't be loaded.
+func (gb *Gameboy) Rewind() bool {
+	last := len(gb.rewind.snapshots) - 1
+	if last < 0 {
+		return false
+	}
+
+	gb.rewind.stateBuf = gb.rewind.decode(last, gb.rewind.stateBuf[:0])
+	gb.rewind.drop(last, len(gb.rewind.snapshots))
+	gb.rewind.frames = 0
+
+	return gb.DeserializeState(gb.rewind.stateBuf) == nil
+}
+
+func (gb *Gameboy) ResetRewindBuffer() {
+	gb.rewind.drop(0, len(gb.rewind.snapshots))
+	gb.rewind.frames = 0
+}
+
+// saveRewindState takes a snapshot when a frame is completed, every frame
+// interval.
+func (gb *Gameboy) saveRewindState() {
+	if gb.rewind.budget <= 0 {
+		return
+	}
+
+	gb.rewind.frames++
+	if gb.rewind.frames < gb.rewind.frameInterval {
+		return
+	}
+	gb.rewind.frames = 0
+
+	// Serialize into our pre-allocated, reusable buffer
+	gb.rewind.push(gb.SerializeState(gb.serializeBuf))
+}
+
+func (rewind *rewindBuffer) push(state []byte) {
+	snapshot := rewindSnapshot{length: len(state)}
+
+	keyframe := rewind.keyframeOf(len(rewind.snapshots) - 1)
+	if keyframe < 0 || len(rewind.snapshots)-keyframe >= rewindKeyframeInterval {
+		snapshot.keyframe = true
+		snapshot.data = append([]byte(nil), state...)
+	} else {
+		snapshot.data = appendRewindDelta(nil, state, rewind.snapshots[keyframe].data)
+	}
+
+	rewind.snapshots = append(rewind.snapshots, snapshot)
+	rewind.size += len(snapshot.data)
+	rewind.evict()
+}
+
+// evict drops the oldest keyframes with the snapshots encoded against them
+// until the buffer fits its budget.
+func (rewind *rewindBuffer) evict() {
+	for rewind.size > rewind.budget && len(rewind.snapshots) > 0 {
+		end := 1
+		for end < len(rewind.snapshots) && !rewind.snapshots[end].keyframe {
+			end++
+		}
+		rewind.drop(0, end)
+	}
+}
+
+// drop removes the snapshots from start to end, which must be at the start or
+// end of the buffer.
+func (rewind *rewindBuffer) drop(start int, end int) {
+	for i := start; i < end; i++ {
+		rewind.size -= len(rewind.snapshots[i].data)
+		// release the data, the backing array outlives the slice
+		rewind.snapshots[i] = rewindSnapshot{}
+	}
+
+	if start == 0 {
+		rewind.snapshots = rewind.snapshots[end:]
+	} else {
+		rewind.snapshots = rewind.snapshots[:start]
+	}
+}
+
+// keyframeOf returns the index of the keyframe the snapshot at i is encoded
+// against, or -1 if i is out of range.
+func (rewind *rewindBuffer) keyframeOf(i int) int {
+	for ; i >= 0; i-- {
+		if rewind.snapshots[i].keyframe {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// decode appends the state of the snapshot at i to dst.
+func (rewind *rewindBuffer) decode(i int, dst []byte) []byte {
+	snapshot := rewind.snapshots[i]
+	if snapshot.keyframe {
+		return append(dst, snapshot.data...)
+	}
+
+	return appendRewindState(dst, snapshot.data, rewind.snapshots[rewind.keyframeOf(i)].data, snapshot.length)
+}
+
+// the number of equal bytes which ends a literal run, fewer are cheaper to
+// keep in the literal than to start a new pair for
+const rewindMinZeroRun = 4
+
+// appendRewindDelta appends the difference of state to keyframe to dst, as
+// pairs of uvarints: the number of equal bytes, then the number of differing
+// bytes followed by their XOR with the keyframe. The keyframe is taken as
+// padded with zeros if it's shorter than state.
+func appendRewindDelta(dst []byte, state []byte, keyframe []byte) []byte {
+	xorAt := func(i int) uint8 {
+		if i < len(keyframe) {
+			return state[i] ^ keyframe[i]
+		}
+		return state[i]
+	}
+	zeroRunAt := func(i int) bool {
+		for j := i; j < i+rewindMinZeroRun; j++ {
+			if j < len(state) && xorAt(j) != 0 {
+				return false
+			}
+		}
+		return true
+	}
+
+	i := 0
+	for i < len(state) {
+		start := i
+		for i < len(state) && xorAt(i) == 0 {
+			i++
+		}
+		dst = binary.AppendUvarint(dst, uint64(i-start))
+
+		start = i
+		for i < len(state) && !zeroRunAt(i) {
+			i++
+		}
+		dst = binary.AppendUvarint(dst, uint64(i-start))
+		for j := start; j < i; j++ {
+			dst = append(dst, xorAt(j))
+		}
+	}
+
+	return dst
+}
+
+// appendRewindState appends the state of length bytes a delta was encoded
+// from to dst.
+func appendRewindState(dst []byte, delta []byte, keyframe []byte, length int) []byte {
+	start := len(dst)
+	dst = append(dst, keyframe[:min(length, len(keyframe))]...)
+	for len(dst)-start < length {
+		dst = append(dst, 0)
+	}
+	state := dst[start:]
+
+	i := 0
+	for len(delta) > 0 {
+		zeros, n := binary.Uvarint(delta)
+		delta = delta[n:]
+		i += int(zeros)
+
+		literal, n := binary.Uvarint(delta)
+		delta = delta[n:]
+		for j := range int(literal) {
+			state[i+j] ^= delta[j]
+		}
+		delta = delta[literal:]
+		i += int(literal)
+	}
+
+	return dst
+}
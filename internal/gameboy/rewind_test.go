@@ -0,0 +1,138 @@
+//go:build !screenshots
+
+package gameboy
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestRewindDelta(t *testing.T) {
+	keyframe := []byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}
+	states := [][]byte{
+		{1, 2, 3, 4, 5, 6, 7, 8, 9, 10},
+		{0, 2, 3, 4, 5, 6, 7, 8, 9, 0},
+		{1, 2, 0, 4, 0, 6, 7, 8, 9, 10},
+		// longer and shorter than the keyframe
+		{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 0, 0, 0, 0, 0, 12},
+		{1, 2, 3},
+		{},
+	}
+
+	for _, state := range states {
+		delta := appendRewindDelta(nil, state, keyframe)
+		if decoded := appendRewindState([]byte{0xFF}, delta, keyframe, len(state)); !bytes.Equal(decoded[1:], state) {
+			t.Errorf("expected %v, got %v", state, decoded[1:])
+		}
+	}
+}
+
+func newRewindTestGameboy(t *testing.T) *Gameboy {
+	t.Helper()
+
+	return newStateFixtureGameboy(t, stateFixture{rom: "blargg/cpu_instrs", model: ModelDmg})
+}
+
+// runFrame runs the Game Boy to the end of the next frame and returns the
+// chunks of its state then, as the rewind buffer saw it.
+func runFrame(gb *Gameboy) []byte {
+	for {
+		if _, frameReady, _ := gb.Step(); frameReady {
+			return stateChunksOf(gb)
+		}
+	}
+}
+
+func TestRewind(t *testing.T) {
+	gb := newRewindTestGameboy(t)
+	gb.SetRewindBufferSize(64 * 1024 * 1024)
+
+	// past a keyframe, so both keyframes and deltas are rewound to
+	var states [][]byte
+	for range rewindKeyframeInterval + 10 {
+		states = append(states, runFrame(gb))
+	}
+
+	for i := len(states) - 1; i >= 0; i-- {
+		if !gb.Rewind() {
+			t.Fatalf("expected to rewind to frame %d", i)
+		}
+		if !bytes.Equal(stateChunksOf(gb), states[i]) {
+			t.Fatalf("expected the state of frame %d", i)
+		}
+	}
+	if gb.Rewind() {
+		t.Error("expected the rewind buffer to be empty")
+	}
+}
+
+func TestRewindBudget(t *testing.T) {
+	gb := newRewindTestGameboy(t)
+	stateSize := len(gb.SerializeState(make([]byte, StateBufferSize)))
+	// a few keyframes' worth
+	budget := 4 * stateSize
+	gb.SetRewindBufferSize(budget)
+
+	for range 5 * rewindKeyframeInterval {
+		runFrame(gb)
+		if usage := gb.GetRewindBufferUsage(); usage > budget {
+			t.Fatalf("expected at most %d bytes used, got %d", budget, usage)
+		}
+	}
+
+	// the deltas are small, so far more states than keyframes fit
+	if frames := len(gb.GetRewindBuffer()); frames < rewindKeyframeInterval {
+		t.Errorf("expected at least %d frames in the buffer, got %d", rewindKeyframeInterval, frames)
+	}
+
+	gb.SetRewindBufferSize(0)
+	if gb.Rewind() {
+		t.Error("expected no frames in a buffer without a budget")
+	}
+}
+
+func TestRewindFrameInterval(t *testing.T) {
+	gb := newRewindTestGameboy(t)
+	gb.SetRewindBufferSize(64 * 1024 * 1024)
+	gb.SetRewindFrameInterval(5)
+
+	var states [][]byte
+	for range 50 {
+		states = append(states, runFrame(gb))
+	}
+
+	if snapshots := len(gb.GetRewindBuffer()); snapshots != 10 {
+		t.Fatalf("expected 10 snapshots, got %d", snapshots)
+	}
+	gb.Rewind()
+	if !bytes.Equal(stateChunksOf(gb), states[49]) {
+		t.Error("expected the state of the last frame")
+	}
+	gb.Rewind()
+	if !bytes.Equal(stateChunksOf(gb), states[44]) {
+		t.Error("expected the state 5 frames before")
+	}
+}
+
+func TestRewindKeptOnRejectedRom(t *testing.T) {
+	gb := newRewindTestGameboy(t)
+	gb.SetRewindBufferSize(64 * 1024 * 1024)
+	for range 3 {
+		runFrame(gb)
+	}
+
+	// shorter than a header
+	if _, err := gb.LoadRom(make([]uint8, 0x100)); err == nil {
+		t.Fatal("expected the ROM to be rejected")
+	}
+	if snapshots := gb.GetRewindSnapshotCount(); snapshots != 3 {
+		t.Errorf("expected the 3 snapshots of the running game, got %d", snapshots)
+	}
+
+	if _, err := gb.LoadRom(make([]uint8, 0x8000)); err != nil {
+		t.Fatal(err)
+	}
+	if snapshots := gb.GetRewindSnapshotCount(); snapshots != 0 {
+		t.Errorf("expected no snapshots once another ROM is loaded, got %d", snapshots)
+	}
+}
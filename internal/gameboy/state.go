@@ -0,0 +1,435 @@
+package gameboy
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"time"
+
+	"github.com/davidyorr/LuccaGB/internal/clock"
+)
+
+// A save state is a header identifying the state, followed by a chunk for each
+// component:
+//
+//	"LGBS"                magic
+//	uint16                format version of the container
+//	uint8 + string        emulator version
+//	[20]byte              SHA-1 of the ROM
+//	uint8 + string        title of the ROM
+//	uint8                 model
+//	int64                 unix time the state was saved at
+//
+// and then for each chunk:
+//
+//	[4]byte               tag, e.g. "CPU "
+//	uint16                version of the component's layout
+//	uint32                length of the data
+//	uint32                CRC-32 (IEEE) of the data
+//	[length]byte          data
+//
+// All integers are little endian. Chunks with unknown tags are skipped, so
+// chunks can be added without a new format version.
+
+const stateMagic = "LGBS"
+
+// StateFormatVersion is the version of the save state container layout. The
+// headerless states of older builds are version 0, see readLegacyState.
+const StateFormatVersion = 1
+
+// Version identifies the emulator build in save state headers. Release builds
+// set it with -ldflags "-X github.com/davidyorr/LuccaGB/internal/gameboy.Version=...".
+var Version = "dev"
+
+const stateChunkHeaderSize = 4 + 2 + 4 + 4
+
+// StateBufferSize is the size of the buffer to pass to SerializeState, large
+// enough for the biggest cartridge RAM and the CGB's memory.
+const StateBufferSize = 1024 * 512
+
+// StateHeader describes a save state.
+type StateHeader struct {
+	FormatVersion   uint16
+	EmulatorVersion string
+	RomSha1         [20]byte
+	RomTitle        string
+	Model           Model
+	Timestamp       time.Time
+}
+
+// StateFormatError is returned for data which isn't a well formed save state.
+type StateFormatError struct {
+	Reason string
+}
+
+func (err *StateFormatError) Error() string {
+	return "invalid save state: " + err.Reason
+}
+
+// StateVersionError is returned for a save state, or a chunk of one, in a
+// layout this build can't read. Chunk is empty for the container itself.
+type StateVersionError struct {
+	Chunk    string
+	Version  uint16
+	Expected uint16
+}
+
+func (err *StateVersionError) Error() string {
+	if err.Chunk == "" {
+		return fmt.Sprintf("save state format version %d, expected %d", err.Version, err.Expected)
+	}
+
+	return fmt.Sprintf("save state %q chunk version %d, expected %d", err.Chunk, err.Version, err.Expected)
+}
+
+// StateRomError is returned for a save state of another ROM than the one
+// loaded.
+type StateRomError struct {
+	RomTitle string
+	RomSha1  [20]byte
+}
+
+func (err *StateRomError) Error() string {
+	return fmt.Sprintf("save state is for another ROM: %q (SHA-1 %x)", err.RomTitle, err.RomSha1)
+}
+
+// StateModelError is returned for a save state of another model than the one
+// being emulated.
+type StateModelError struct {
+	Model      Model
+	StateModel Model
+}
+
+func (err *StateModelError) Error() string {
+	return fmt.Sprintf("save state is for the %s, expected one for the %s", err.StateModel, err.Model)
+}
+
+// stateChunk is a component's part of a save state. When the layout of a
+// component's state changes its version is bumped and a migration from the
+// previous version is added to stateMigrations.
+type stateChunk struct {
+	tag string
+	// bumped whenever the component's layout changes
+	version     uint16
+	serialize   func(buf []byte) int
+	deserialize func(buf []byte) int
+}
+
+func (gb *Gameboy) newStateChunks() []stateChunk {
+	return []stateChunk{
+		// before the cartridge, whose real-time clocks are synced to it
+		{"CLCK", 2, gb.serializeClock, gb.deserializeClock},
+		{"CPU ", 2, gb.cpu.Serialize, gb.cpu.Deserialize},
+		{"APU ", 1, gb.apu.Serialize, gb.apu.Deserialize},
+		{"PPU ", 1, gb.ppu.Serialize, gb.ppu.Deserialize},
+		{"MMU ", 2, gb.mmu.Serialize, gb.mmu.Deserialize},
+		{"DMA ", 1, gb.dma.Serialize, gb.dma.Deserialize},
+		{"TIMR", 1, gb.timer.Serialize, gb.timer.Deserialize},
+		{"SERL", 1, gb.serial.Serialize, gb.serial.Deserialize},
+		{"CART", 1, gb.cartridge.Serialize, gb.cartridge.Deserialize},
+		{"JOYP", 1, gb.joypad.Serialize, gb.joypad.Deserialize},
+		{"SGB ", 1, gb.sgb.Serialize, gb.sgb.Deserialize},
+	}
+}
+
+// the T-cycles elapsed, then the clock's kind and state
+func (gb *Gameboy) serializeClock(buf []byte) int {
+	binary.LittleEndian.PutUint64(buf, gb.tCycles)
+	buf[8] = uint8(gb.clock.Kind())
+
+	return 9 + gb.clock.Serialize(buf[9:])
+}
+
+func (gb *Gameboy) deserializeClock(buf []byte) int {
+	gb.tCycles = binary.LittleEndian.Uint64(buf)
+
+	// restore the clock the state was saved with, unless it was a custom clock
+	// which can only be restored into a custom clock of the caller's choosing
+	kind := clock.Kind(buf[8])
+	if kind == gb.clock.Kind() {
+		return 9 + gb.clock.Deserialize(buf[9:])
+	}
+	if kind == clock.KindCustom {
+		return len(buf)
+	}
+
+	// the new clock is restored before the cartridge is connected to it, the
+	// cartridge's chunk then restores its real-time clocks' sync points
+	restored := clock.New(kind, time.Unix(0, 0))
+	length := 9 + restored.Deserialize(buf[9:])
+	gb.clock = restored
+	gb.cartridge.ConnectClock(restored)
+
+	return length
+}
+
+// SerializeState writes a save state of the whole Game Boy into buf, which must
+// hold StateBufferSize bytes, and returns the part of buf used.
+func (gb *Gameboy) SerializeState(buf []byte) []byte {
+	offset := 0
+
+	offset += copy(buf[offset:], stateMagic)
+	binary.LittleEndian.PutUint16(buf[offset:], StateFormatVersion)
+	offset += 2
+	offset += putStateString(buf[offset:], Version)
+	offset += copy(buf[offset:], gb.romSha1[:])
+	offset += putStateString(buf[offset:], gb.romTitle)
+	buf[offset] = uint8(gb.model)
+	offset++
+	binary.LittleEndian.PutUint64(buf[offset:], uint64(time.Now().Unix()))
+	offset += 8
+
+	for _, chunk := range gb.stateChunks {
+		data := buf[offset+stateChunkHeaderSize:]
+		length := chunk.serialize(data)
+
+		copy(buf[offset:], chunk.tag)
+		binary.LittleEndian.PutUint16(buf[offset+4:], chunk.version)
+		binary.LittleEndian.PutUint32(buf[offset+6:], uint32(length))
+		binary.LittleEndian.PutUint32(buf[offset+10:], crc32.ChecksumIEEE(data[:length]))
+		offset += stateChunkHeaderSize + length
+	}
+
+	return buf[:offset]
+}
+
+// strings are truncated to 255 bytes
+func putStateString(buf []byte, s string) int {
+	if len(s) > 0xFF {
+		s = s[:0xFF]
+	}
+	buf[0] = uint8(len(s))
+
+	return 1 + copy(buf[1:], s)
+}
+
+// ReadStateHeader reads the header of a save state. The error is a
+// *StateFormatError or *StateVersionError if the data can't be read as one.
+func ReadStateHeader(data []byte) (StateHeader, error) {
+	header, _, err := readStateHeader(data)
+
+	return header, err
+}
+
+// readStateHeader returns the header and the size of it.
+func readStateHeader(data []byte) (StateHeader, int, error) {
+	var header StateHeader
+	reader := stateReader{data: data}
+
+	if string(reader.bytes(len(stateMagic))) != stateMagic {
+		return header, 0, &StateFormatError{Reason: "not a save state"}
+	}
+	header.FormatVersion = reader.uint16()
+	if reader.err == nil && header.FormatVersion != StateFormatVersion {
+		return header, 0, &StateVersionError{Version: header.FormatVersion, Expected: StateFormatVersion}
+	}
+	header.EmulatorVersion = reader.string()
+	copy(header.RomSha1[:], reader.bytes(len(header.RomSha1)))
+	header.RomTitle = reader.string()
+	header.Model = Model(reader.uint8())
+	header.Timestamp = time.Unix(int64(reader.uint64()), 0)
+
+	if reader.err != nil {
+		return StateHeader{}, 0, reader.err
+	}
+
+	return header, reader.offset, nil
+}
+
+// readStateChunks validates the chunks of a save state and returns their data
+// by tag.
+func (gb *Gameboy) readStateChunks(data []byte) (map[string][]byte, error) {
+	chunks := map[string][]byte{}
+	reader := stateReader{data: data}
+
+	for reader.offset < len(data) && reader.err == nil {
+		tag := string(reader.bytes(4))
+		version := reader.uint16()
+		length := reader.uint32()
+		checksum := reader.uint32()
+		chunkData := reader.bytes(int(length))
+		if reader.err != nil {
+			break
+		}
+
+		if crc32.ChecksumIEEE(chunkData) != checksum {
+			return nil, &StateFormatError{Reason: fmt.Sprintf("%q chunk checksum mismatch", tag)}
+		}
+		chunkData, err := gb.migrateStateChunk(tag, version, chunkData)
+		if err != nil {
+			return nil, err
+		}
+		chunks[tag] = chunkData
+	}
+	if reader.err != nil {
+		return nil, reader.err
+	}
+
+	for _, chunk := range gb.stateChunks {
+		if _, ok := chunks[chunk.tag]; !ok {
+			return nil, &StateFormatError{Reason: fmt.Sprintf("%q chunk is missing", chunk.tag)}
+		}
+	}
+
+	return chunks, nil
+}
+
+// migrateStateChunk upgrades a chunk saved by an older build to the current
+// version of its component.
+func (gb *Gameboy) migrateStateChunk(tag string, version uint16, data []byte) ([]byte, error) {
+	for _, chunk := range gb.stateChunks {
+		if chunk.tag != tag {
+			continue
+		}
+		if version > chunk.version {
+			return nil, &StateVersionError{Chunk: tag, Version: version, Expected: chunk.version}
+		}
+
+		for ; version < chunk.version; version++ {
+			migrate, ok := stateMigrations[stateMigrationKey{tag, version}]
+			if !ok {
+				return nil, &StateVersionError{Chunk: tag, Version: version, Expected: chunk.version}
+			}
+
+			var err error
+			if data, err = migrate(gb, data); err != nil {
+				return nil, &StateFormatError{Reason: fmt.Sprintf("%q chunk can't be migrated from version %d: %s", tag, version, err)}
+			}
+		}
+	}
+
+	return data, nil
+}
+
+// readState validates a save state and returns the data of its chunks by tag,
+// migrated to the current versions.
+func (gb *Gameboy) readState(data []byte) (map[string][]byte, error) {
+	if !bytes.HasPrefix(data, []byte(stateMagic)) {
+		return gb.readLegacyState(data)
+	}
+
+	header, headerSize, err := readStateHeader(data)
+	if err != nil {
+		return nil, err
+	}
+	if header.RomSha1 != gb.romSha1 {
+		return nil, &StateRomError{RomTitle: header.RomTitle, RomSha1: header.RomSha1}
+	}
+	if header.Model != gb.model {
+		return nil, &StateModelError{Model: gb.model, StateModel: header.Model}
+	}
+
+	return gb.readStateChunks(data[headerSize:])
+}
+
+// DeserializeState loads a save state made by SerializeState, or a legacy
+// state made by a build before the versioned container. The error is a
+// *StateFormatError or *StateVersionError if the data can't be read, a
+// *StateRomError or *StateModelError if it's for another ROM or model, and the
+// Game Boy is left as it was.
+func (gb *Gameboy) DeserializeState(data []byte) error {
+	chunks, err := gb.readState(data)
+	if err != nil {
+		return err
+	}
+
+	// the chunks are consistent, but a component can still find its data
+	// malformed halfway through loading it
+	if gb.stateBackupBuf == nil {
+		gb.stateBackupBuf = make([]byte, StateBufferSize)
+	}
+	backup := gb.SerializeState(gb.stateBackupBuf)
+	backupClock := gb.clock
+
+	if err := gb.deserializeChunks(chunks); err != nil {
+		gb.clock = backupClock
+		gb.cartridge.ConnectClock(backupClock)
+		_, backupHeaderSize, _ := readStateHeader(backup)
+		backupChunks, _ := gb.readStateChunks(backup[backupHeaderSize:])
+		gb.deserializeChunks(backupChunks)
+
+		return err
+	}
+
+	return nil
+}
+
+func (gb *Gameboy) deserializeChunks(chunks map[string][]byte) (err error) {
+	tag := ""
+	defer func() {
+		if recover() != nil {
+			err = &StateFormatError{Reason: fmt.Sprintf("%q chunk is truncated", tag)}
+		}
+	}()
+
+	for _, chunk := range gb.stateChunks {
+		tag = chunk.tag
+		data := chunks[tag]
+		if length := chunk.deserialize(data); length != len(data) {
+			return &StateFormatError{Reason: fmt.Sprintf("%q chunk is %d bytes, expected %d bytes", tag, len(data), length)}
+		}
+	}
+	// the CPU, timer and serial port don't save their mode, which the boot ROM
+	// may have switched since the ROM was loaded
+	gb.setCgbMode(gb.mmu.CgbMode())
+
+	return nil
+}
+
+// stateReader reads the fields of a save state, recording the first read past
+// the end of the data.
+type stateReader struct {
+	data   []byte
+	offset int
+	err    error
+}
+
+func (reader *stateReader) bytes(n int) []byte {
+	if reader.err != nil || n < 0 || n > len(reader.data)-reader.offset {
+		if reader.err == nil {
+			reader.err = &StateFormatError{Reason: "truncated"}
+		}
+		return nil
+	}
+	b := reader.data[reader.offset : reader.offset+n : reader.offset+n]
+	reader.offset += n
+
+	return b
+}
+
+func (reader *stateReader) uint8() uint8 {
+	if b := reader.bytes(1); b != nil {
+		return b[0]
+	}
+
+	return 0
+}
+
+func (reader *stateReader) uint16() uint16 {
+	if b := reader.bytes(2); b != nil {
+		return binary.LittleEndian.Uint16(b)
+	}
+
+	return 0
+}
+
+func (reader *stateReader) uint32() uint32 {
+	if b := reader.bytes(4); b != nil {
+		return binary.LittleEndian.Uint32(b)
+	}
+
+	return 0
+}
+
+func (reader *stateReader) uint64() uint64 {
+	if b := reader.bytes(8); b != nil {
+		return binary.LittleEndian.Uint64(b)
+	}
+
+	return 0
+}
+
+func (reader *stateReader) string() string {
+	return string(reader.bytes(int(reader.uint8())))
+}
@@ -0,0 +1,337 @@
+package gameboy
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+
+	"github.com/davidyorr/LuccaGB/internal/dma"
+	"github.com/davidyorr/LuccaGB/internal/joypad"
+	"github.com/davidyorr/LuccaGB/internal/ppu"
+)
+
+type stateMigrationKey struct {
+	tag string
+	// the version migrated from, to version+1
+	version uint16
+}
+
+// stateMigration upgrades the data of a chunk to the next version, filling the
+// fields added in it with the values a reset leaves. It returns new data rather
+// than changing data in place. The Game Boy has the state's ROM loaded, for
+// layouts which depend on the cartridge.
+type stateMigration func(gb *Gameboy, data []byte) ([]byte, error)
+
+// stateMigrations lets states saved by older builds load in this one. Every
+// chunk version bump adds the migration from the previous version here, and a
+// fixture of the old layout to testdata/states, see TestStateFixtures.
+//
+// Version 0 of a chunk is its component's part of a legacy state, see
+// readLegacyState.
+var stateMigrations = map[stateMigrationKey]stateMigration{
+	{"CPU ", 0}: migrateCpuState0,
+	{"APU ", 0}: migrateUnchangedState,
+	{"PPU ", 0}: migratePpuState0,
+	{"MMU ", 0}: migrateMmuState0,
+	{"DMA ", 0}: migrateDmaState0,
+	{"TIMR", 0}: migrateUnchangedState,
+	{"SERL", 0}: migrateUnchangedState,
+	{"CART", 0}: migrateCartridgeState0,
+	{"JOYP", 0}: migrateJoypadState0,
+	{"CPU ", 1}: migrateCpuState1,
+	{"MMU ", 1}: migrateMmuState1,
+	{"CLCK", 1}: migrateClockState1,
+}
+
+func migrateUnchangedState(gb *Gameboy, data []byte) ([]byte, error) {
+	return data, nil
+}
+
+// ==========================
+// ====== Legacy state ======
+// ==========================
+
+// Builds before the versioned container saved the states of the components
+// back to back, without a header or chunks. Only the DMG was emulated then.
+// The components' states are read as version 0 chunks, the components which
+// didn't exist yet keep their state.
+
+const (
+	legacyCpuStateSize   = 28
+	legacyApuStateSize   = 172
+	legacyPpuStateSize   = 0x2000 + 0xA0 + 15 + legacyFetcherStateSize + 18 + 144*160
+	legacyMmuStateSize   = 0x2000 + 127 + 128 + 2
+	legacyDmaStateSize   = 10
+	legacyTimerStateSize = 8
+	// P1, buttons and d-pad
+	legacyJoypadStateSize = 3
+
+	// the fetcher and its 2 pixel FIFOs
+	legacyFetcherStateSize   = 17 + 2*legacyPixelFifoStateSize
+	legacyPixelFifoStateSize = 8*3 + 24
+)
+
+const (
+	// 8 pixels, the head, tail and size
+	pixelFifoStateSize = 8*4 + 24
+	// the pixel between the FIFOs and the LCD, with its background and sprite
+	lcdPixelStateSize = 4 + 4 + 2
+)
+
+// the size of the mapper's state legacy builds saved, by cartridge type. They
+// ran the types missing here as ROM only, which the states of mappers added
+// since can't be made from.
+var legacyMbcStateSizes = map[uint8]int{
+	0x00: 0,
+	0x01: 4, 0x02: 4, 0x03: 4,
+	0x05: 2, 0x06: 2,
+	0x08: 0, 0x09: 0,
+	0x19: 4, 0x1A: 4, 0x1B: 4, 0x1C: 4, 0x1D: 4, 0x1E: 4,
+}
+
+// legacyStateChunks are the chunks of a legacy state in the order they were
+// saved, with the size of their data at the start of buf.
+var legacyStateChunks = []struct {
+	tag  string
+	size func(gb *Gameboy, buf []byte) (int, error)
+}{
+	{"CPU ", legacyFixedSize(legacyCpuStateSize)},
+	{"APU ", legacyFixedSize(legacyApuStateSize)},
+	{"PPU ", legacyFixedSize(legacyPpuStateSize)},
+	{"MMU ", legacyFixedSize(legacyMmuStateSize)},
+	{"DMA ", legacyFixedSize(legacyDmaStateSize)},
+	{"TIMR", legacyFixedSize(legacyTimerStateSize)},
+	{"SERL", legacySerialStateSize},
+	{"CART", legacyCartridgeStateSize},
+	{"JOYP", legacyFixedSize(legacyJoypadStateSize)},
+}
+
+func legacyFixedSize(size int) func(gb *Gameboy, buf []byte) (int, error) {
+	return func(gb *Gameboy, buf []byte) (int, error) {
+		return size, nil
+	}
+}
+
+// SB, SC, the output buffer with its length and the transfer
+func legacySerialStateSize(gb *Gameboy, buf []byte) (int, error) {
+	if len(buf) < 6 {
+		return 0, &StateFormatError{Reason: "truncated"}
+	}
+
+	return 6 + int(binary.LittleEndian.Uint32(buf[2:])) + 3, nil
+}
+
+// the RAM with its length, the battery flag and the mapper
+func legacyCartridgeStateSize(gb *Gameboy, buf []byte) (int, error) {
+	if len(buf) < 4 {
+		return 0, &StateFormatError{Reason: "truncated"}
+	}
+	cartridgeType := gb.cartridge.CartridgeType()
+	mbcSize, ok := legacyMbcStateSizes[cartridgeType]
+	if !ok {
+		return 0, &StateFormatError{Reason: fmt.Sprintf("legacy states of cartridge type 0x%02X can't be loaded", cartridgeType)}
+	}
+
+	return 4 + int(binary.LittleEndian.Uint32(buf)) + 1 + mbcSize, nil
+}
+
+// readLegacyState validates a legacy state and returns the data of its chunks
+// by tag, migrated to the current versions. The ROM can't be checked, the
+// state has no header.
+func (gb *Gameboy) readLegacyState(data []byte) (map[string][]byte, error) {
+	if gb.model != ModelDmg {
+		return nil, &StateModelError{Model: gb.model, StateModel: ModelDmg}
+	}
+
+	chunks := map[string][]byte{}
+	offset := 0
+	for _, chunk := range legacyStateChunks {
+		size, err := chunk.size(gb, data[offset:])
+		if err != nil {
+			return nil, err
+		}
+		if size > len(data)-offset {
+			return nil, &StateFormatError{Reason: "not a save state"}
+		}
+
+		chunkData, err := gb.migrateStateChunk(chunk.tag, 0, data[offset:offset+size:offset+size])
+		if err != nil {
+			return nil, err
+		}
+		chunks[chunk.tag] = chunkData
+		offset += size
+	}
+	if offset != len(data) {
+		return nil, &StateFormatError{Reason: "not a save state"}
+	}
+
+	for _, chunk := range gb.stateChunks {
+		if _, ok := chunks[chunk.tag]; !ok {
+			buf := make([]byte, StateBufferSize)
+			chunks[chunk.tag] = buf[:chunk.serialize(buf)]
+		}
+	}
+
+	return chunks, nil
+}
+
+// STOP, the speed switch and the lockup were added after the legacy layout.
+func migrateCpuState0(gb *Gameboy, data []byte) ([]byte, error) {
+	migrated := append([]byte(nil), data...)
+	// not stopped, no speed switch in progress, not locked
+	migrated = append(migrated, 0, 0, 0, 0)
+
+	return migrated, nil
+}
+
+// The CPU is saved in the middle of instructions, which version 1 states
+// weren't. The CB opcode was kept after its instruction, it's only saved
+// during one now.
+func migrateCpuState1(gb *Gameboy, data []byte) ([]byte, error) {
+	old := stateReader{data: data}
+
+	// PC, SP, A to L, IME and its scheduling, HALT, the HALT bug and the
+	// interrupt dispatch
+	migrated := append([]byte(nil), old.bytes(2+2+8+6)...)
+	opcode, mCycle, cbOpcode := old.uint8(), old.uint8(), old.uint8()
+	if opcode != 0xCB || mCycle == 0 {
+		// the CB opcode of an earlier instruction
+		cbOpcode = 0
+	}
+	migrated = append(migrated, opcode, mCycle, cbOpcode)
+	// the immediate value, MDR, the interrupt and its flag, the T-cycle
+	// counter, STOP, the speed switch and the lockup
+	migrated = append(migrated, old.bytes(2+1+2+1+1+1+2+1)...)
+
+	return migrated, old.err
+}
+
+// The pixel fetcher was rewritten for the dot-accurate mode 3, and the CGB's
+// VRAM bank, registers and palettes were added.
+func migratePpuState0(gb *Gameboy, data []byte) ([]byte, error) {
+	old := stateReader{data: data}
+
+	// bank 0, bank 1 only exists on the CGB
+	migrated := append([]byte(nil), old.bytes(0x2000)...)
+	migrated = append(migrated, make([]byte, 0x2000)...)
+	// OAM and LCDC to OBP1
+	migrated = append(migrated, old.bytes(0xA0+11)...)
+	// not in CGB mode, VBK and BCPS, the BG palettes white, OCPS, the OBJ
+	// palettes white and OPRI
+	migrated = append(migrated, 0, 0, 0)
+	migrated = append(migrated, bytes.Repeat([]byte{0xFF}, 64)...)
+	migrated = append(migrated, 0)
+	migrated = append(migrated, bytes.Repeat([]byte{0xFF}, 64)...)
+	migrated = append(migrated, 0)
+	// the mode, the STAT interrupt line and the dot
+	migrated = append(migrated, old.bytes(1+1+2)...)
+
+	// the fetcher's state, counter, tile, data, x, pixels to discard and
+	// scrolling penalty, whether it's fetching the window and its x
+	old.bytes(1 + 2 + 1 + 2 + 1 + 1 + 1 + 1 + 1)
+	windowLineCounter := old.uint8()
+	// whether it's the scanline's first fetch
+	old.uint8()
+	scanlineHadWindowPixels, wyEqualedLyDuringFrame := old.uint8(), old.uint8()
+	// whether it's fetching a sprite and which, the background and sprite
+	// FIFOs
+	old.bytes(1 + 1 + 2*legacyPixelFifoStateSize)
+
+	// a new fetcher continues the scanline, only the window's progress for the
+	// frame is kept
+	migrated = append(migrated, make([]byte, 1+2+1+1+1+1+1+1)...)
+	// not fetching the window, at x 0, no offscreen pixels left
+	migrated = append(migrated, 0, 0, windowLineCounter, 0)
+	migrated = append(migrated, scanlineHadWindowPixels, wyEqualedLyDuringFrame)
+	// not fetching a sprite, sprite 0
+	migrated = append(migrated, 0, 0)
+	// empty FIFOs, no pixel on its way to the LCD at x 0 and no sprite fetched
+	migrated = append(migrated, make([]byte, 2*pixelFifoStateSize+lcdPixelStateSize+1+4)...)
+
+	// the sprite buffer and DMG frame buffer
+	migrated = append(migrated, old.bytes(18+144*160)...)
+	// a black CGB frame buffer, no palette write pending
+	migrated = append(migrated, make([]byte, 2*144*160)...)
+	migrated = append(migrated, 0, 0, 0)
+
+	return migrated, old.err
+}
+
+// The WRAM was banked for the CGB, and KEY1, SVBK, the CGB's mode flag and
+// the boot ROM mapping were added.
+func migrateMmuState0(gb *Gameboy, data []byte) ([]byte, error) {
+	old := stateReader{data: data}
+
+	// banks 0 and 1, 2-7 only exist on the CGB
+	migrated := append([]byte(nil), old.bytes(0x2000)...)
+	migrated = append(migrated, make([]byte, 6*0x1000)...)
+	// HRAM, the I/O registers, IE and IF
+	migrated = append(migrated, old.bytes(127+128+2)...)
+	// KEY1, SVBK, not in CGB mode, the boot ROM unmapped
+	migrated = append(migrated, 0, 0, 0, 0)
+
+	return migrated, old.err
+}
+
+// KEY0 was added for the CGB boot ROM's handoff.
+func migrateMmuState1(gb *Gameboy, data []byte) ([]byte, error) {
+	migrated := append([]byte(nil), data...)
+	// CGB mode, the boot ROM hasn't selected DMG compatibility
+	migrated = append(migrated, 0)
+
+	return migrated, nil
+}
+
+// The T-cycles elapsed, which rumble events are timed by, were added before
+// the clock.
+func migrateClockState1(gb *Gameboy, data []byte) ([]byte, error) {
+	// keep counting from the current T-cycle, so events aren't timed earlier
+	// than ones already reported
+	migrated := binary.LittleEndian.AppendUint64(nil, gb.tCycles)
+	migrated = append(migrated, data...)
+
+	return migrated, nil
+}
+
+// The CGB's VRAM DMA was added after the OAM DMA.
+func migrateDmaState0(gb *Gameboy, data []byte) ([]byte, error) {
+	old := stateReader{data: data}
+
+	// the OAM DMA
+	migrated := append([]byte(nil), old.bytes(legacyDmaStateSize)...)
+	// the VRAM DMA's source and destination
+	migrated = binary.LittleEndian.AppendUint16(migrated, 0x0000)
+	migrated = binary.LittleEndian.AppendUint16(migrated, 0x8000)
+	// the length, idle, not copying, no block progress or dots counted, no
+	// block pending, HBlank as the previous PPU mode and not in CGB mode
+	migrated = append(migrated, 0x7F, uint8(dma.VramDmaIdle), 0, 0, 0, 0, uint8(ppu.HorizontalBlank), 0)
+
+	return migrated, old.err
+}
+
+// The MBC5's rumble motor was added at the end of its state.
+func migrateCartridgeState0(gb *Gameboy, data []byte) ([]byte, error) {
+	migrated := append([]byte(nil), data...)
+	if cartridgeType := gb.cartridge.CartridgeType(); cartridgeType >= 0x19 && cartridgeType <= 0x1E {
+		// the motor is off
+		migrated = append(migrated, 0)
+	}
+
+	return migrated, nil
+}
+
+// The buttons and d-pad became one per player for the SGB's multiplayer.
+func migrateJoypadState0(gb *Gameboy, data []byte) ([]byte, error) {
+	old := stateReader{data: data}
+	p1, buttons, dpad := old.uint8(), old.uint8(), old.uint8()
+
+	migrated := []byte{p1}
+	migrated = append(migrated, buttons)
+	migrated = append(migrated, make([]byte, joypad.MaxPlayers-1)...)
+	migrated = append(migrated, dpad)
+	migrated = append(migrated, make([]byte, joypad.MaxPlayers-1)...)
+	// 1 player, the first one selected
+	migrated = append(migrated, 1, 0)
+
+	return migrated, old.err
+}
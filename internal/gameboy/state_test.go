@@ -0,0 +1,437 @@
+//go:build !screenshots
+
+package gameboy
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/davidyorr/LuccaGB/internal/clock"
+)
+
+var saveStateFixtures = flag.Bool("save-state-fixtures", false, "Save fixtures of the current save state layout to testdata/states")
+
+const stateFixturesDir = "../../testdata/states"
+
+// stateFixture is a test ROM saved partway through. The fixtures saved by
+// older builds must load and still pass, so they are never replaced. The
+// "-legacy" fixtures were saved by the last build before the versioned
+// container, at the same steps.
+type stateFixture struct {
+	name  string
+	rom   string
+	model Model
+	// steps run before the state is saved
+	saveAfter int
+	// steps the ROM has to pass in once the state is loaded
+	stepCount int
+}
+
+var stateFixtures = []stateFixture{
+	{"instr_timing-dmg", "blargg/instr_timing", ModelDmg, 400_000, 676_091},
+	{"mem_timing-dmg", "blargg/mem_timing", ModelDmg, 1_000_000, 1_597_872},
+	{"instr_timing-cgb", "blargg/instr_timing", ModelCgb, 400_000, 700_000},
+}
+
+// stateLayout names the chunk versions of the states this build saves, e.g.
+// "cpu1-apu1-ppu1-...".
+func stateLayout(gb *Gameboy) string {
+	var versions []string
+	for _, chunk := range gb.stateChunks {
+		versions = append(versions, fmt.Sprintf("%s%d", strings.ToLower(strings.TrimSpace(chunk.tag)), chunk.version))
+	}
+
+	return strings.Join(versions, "-")
+}
+
+func newStateFixtureGameboy(t *testing.T, fixture stateFixture) *Gameboy {
+	t.Helper()
+
+	rom, err := os.ReadFile(fmt.Sprintf("../../roms/test/%s.gb", fixture.rom))
+	if err != nil {
+		t.Fatal("Error reading file:", err)
+	}
+	gb := New(fixture.model)
+	if _, err := gb.LoadRom(rom); err != nil {
+		t.Fatal("Error loading ROM:", err)
+	}
+
+	return gb
+}
+
+func TestStateFixtures(t *testing.T) {
+	for _, fixture := range stateFixtures {
+		t.Run(fixture.name, func(t *testing.T) {
+			if *saveStateFixtures {
+				gb := newStateFixtureGameboy(t, fixture)
+				path := filepath.Join(stateFixturesDir, fixture.name+"-"+stateLayout(gb)+".state")
+				if _, err := os.Stat(path); err != nil {
+					for range fixture.saveAfter {
+						gb.Step()
+					}
+					if err := os.WriteFile(path, gb.SerializeState(make([]byte, StateBufferSize)), 0o644); err != nil {
+						t.Fatal(err)
+					}
+				}
+			}
+
+			paths, _ := filepath.Glob(filepath.Join(stateFixturesDir, fixture.name+"-*.state"))
+			if len(paths) == 0 {
+				t.Fatal("no fixtures, run the test with -save-state-fixtures")
+			}
+
+			for _, path := range paths {
+				state, err := os.ReadFile(path)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				gb := newStateFixtureGameboy(t, fixture)
+				if err := gb.DeserializeState(state); err != nil {
+					t.Fatalf("%s: %v", filepath.Base(path), err)
+				}
+
+				passed := false
+				for range fixture.stepCount {
+					gb.Step()
+					output := string(gb.serial.SerialOutputBuffer())
+					if passed, _ = checkResult(output, TestTypeBlargg); passed {
+						break
+					}
+				}
+				if !passed {
+					t.Errorf("%s: expected the ROM to pass after loading the state, got %q", filepath.Base(path), gb.serial.SerialOutputBuffer())
+				}
+			}
+		})
+	}
+}
+
+func TestStateMigrations(t *testing.T) {
+	fixture := stateFixtures[0]
+	gb := newStateFixtureGameboy(t, fixture)
+	state := append([]byte(nil), gb.SerializeState(make([]byte, StateBufferSize))...)
+
+	// a build with version 2 of the serial chunk loading a version 1 state
+	gb = newStateFixtureGameboy(t, fixture)
+	for i := range gb.stateChunks {
+		if gb.stateChunks[i].tag == "SERL" {
+			gb.stateChunks[i].version = 2
+		}
+	}
+
+	var versionError *StateVersionError
+	if err := gb.DeserializeState(state); !errors.As(err, &versionError) {
+		t.Errorf("expected a *StateVersionError without a migration, got %v", err)
+	}
+
+	migrated := false
+	stateMigrations[stateMigrationKey{"SERL", 1}] = func(gb *Gameboy, data []byte) ([]byte, error) {
+		migrated = true
+		return data, nil
+	}
+	defer delete(stateMigrations, stateMigrationKey{"SERL", 1})
+
+	if err := gb.DeserializeState(state); err != nil {
+		t.Fatal(err)
+	}
+	if !migrated {
+		t.Error("expected the serial chunk to be migrated")
+	}
+
+	// states from newer builds can't be loaded
+	gb = newStateFixtureGameboy(t, fixture)
+	state = gb.SerializeState(make([]byte, StateBufferSize))
+	gb.stateChunks[0].version = 0
+	if err := gb.DeserializeState(state); !errors.As(err, &versionError) {
+		t.Errorf("expected a *StateVersionError for a newer chunk, got %v", err)
+	}
+}
+
+func TestLegacyStateErrors(t *testing.T) {
+	state, err := os.ReadFile(filepath.Join(stateFixturesDir, "instr_timing-dmg-legacy.state"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// legacy builds only emulated the DMG
+	gb := newStateFixtureGameboy(t, stateFixture{rom: "blargg/instr_timing", model: ModelCgb})
+	var modelError *StateModelError
+	if err := gb.DeserializeState(state); !errors.As(err, &modelError) {
+		t.Errorf("expected a *StateModelError, got %v", err)
+	}
+
+	gb = newStateFixtureGameboy(t, stateFixtures[0])
+	var formatError *StateFormatError
+	for _, data := range [][]byte{state[:len(state)-1], append(state, 0), {0x00}} {
+		if err := gb.DeserializeState(data); !errors.As(err, &formatError) {
+			t.Errorf("expected a *StateFormatError for %d bytes, got %v", len(data), err)
+		}
+	}
+}
+
+func TestLegacyStateMigrations(t *testing.T) {
+	state, err := os.ReadFile(filepath.Join(stateFixturesDir, "instr_timing-dmg-legacy.state"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	gb := newStateFixtureGameboy(t, stateFixtures[0])
+	chunks, err := gb.readLegacyState(state)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	legacyPpu := state[legacyCpuStateSize+legacyApuStateSize:]
+	legacyFetcher := legacyPpu[0x2000+0xA0+15:]
+	legacyDma := state[legacyCpuStateSize+legacyApuStateSize+legacyPpuStateSize+legacyMmuStateSize:]
+
+	ppu := chunks["PPU "]
+	if !bytes.Equal(ppu[:0x2000], legacyPpu[:0x2000]) {
+		t.Error("expected VRAM bank 0 to be kept")
+	}
+	if !bytes.Equal(ppu[0x2000:0x4000], make([]byte, 0x2000)) {
+		t.Error("expected VRAM bank 1 to be cleared")
+	}
+	registers := ppu[0x4000+0xA0:]
+	if !bytes.Equal(registers[:11], legacyPpu[0x2000+0xA0:0x2000+0xA0+11]) {
+		t.Errorf("expected LCDC to OBP1 to be kept, got % X", registers[:11])
+	}
+	if registers[11] != 0 {
+		t.Errorf("expected the DMG mode, got %d", registers[11])
+	}
+	// BCPS is followed by the BG palettes, OCPS by the OBJ palettes
+	if !bytes.Equal(registers[14:14+64], bytes.Repeat([]byte{0xFF}, 64)) || !bytes.Equal(registers[79:79+64], bytes.Repeat([]byte{0xFF}, 64)) {
+		t.Error("expected the palettes to be white")
+	}
+	if !bytes.Equal(registers[144:148], legacyPpu[0x2000+0xA0+11:0x2000+0xA0+15]) {
+		t.Errorf("expected the mode, STAT line and dot to be kept, got % X", registers[144:148])
+	}
+	fetcher := registers[148:]
+	if fetcher[11] != legacyFetcher[11] {
+		t.Errorf("expected the window line counter %d, got %d", legacyFetcher[11], fetcher[11])
+	}
+	if fetcher[13] != legacyFetcher[13] || fetcher[14] != legacyFetcher[14] {
+		t.Errorf("expected the window flags %d, %d, got %d, %d", legacyFetcher[13], legacyFetcher[14], fetcher[13], fetcher[14])
+	}
+	spriteBuffer := fetcher[17+2*pixelFifoStateSize+lcdPixelStateSize+1+4:]
+	if !bytes.Equal(spriteBuffer[:18+144*160], legacyFetcher[legacyFetcherStateSize:legacyFetcherStateSize+18+144*160]) {
+		t.Error("expected the sprite buffer and frame buffer to be kept")
+	}
+
+	dma := chunks["DMA "]
+	if !bytes.Equal(dma[:legacyDmaStateSize], legacyDma[:legacyDmaStateSize]) {
+		t.Errorf("expected the OAM DMA to be kept, got % X", dma[:legacyDmaStateSize])
+	}
+	vramDma := dma[legacyDmaStateSize:]
+	if source, destination := binary.LittleEndian.Uint16(vramDma), binary.LittleEndian.Uint16(vramDma[2:]); source != 0x0000 || destination != 0x8000 {
+		t.Errorf("expected the VRAM DMA from 0x0000 to 0x8000, got 0x%04X to 0x%04X", source, destination)
+	}
+	if vramDma[4] != 0x7F {
+		t.Errorf("expected the VRAM DMA length 0x7F, got 0x%02X", vramDma[4])
+	}
+}
+
+func TestCpuStateMigration1(t *testing.T) {
+	gb := newStateFixtureGameboy(t, stateFixtures[0])
+	for range 1000 {
+		gb.Step()
+	}
+	state := make([]byte, StateBufferSize)
+	state = state[:gb.cpu.Serialize(state)]
+
+	tests := []struct {
+		name     string
+		opcode   uint8
+		mCycle   uint8
+		cbOpcode uint8
+	}{
+		// the CB opcode of an earlier instruction
+		{"between instructions", 0xCB, 0, 0},
+		{"in another instruction", 0x01, 2, 0},
+		{"in a CB instruction", 0xCB, 2, 0x37},
+	}
+	for _, test := range tests {
+		data := append([]byte(nil), state...)
+		data[18], data[19], data[20] = test.opcode, test.mCycle, 0x37
+
+		migrated, err := migrateCpuState1(gb, data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if migrated[20] != test.cbOpcode {
+			t.Errorf("%s: expected the CB opcode 0x%02X, got 0x%02X", test.name, test.cbOpcode, migrated[20])
+		}
+		migrated[20] = 0x37
+		if !bytes.Equal(migrated, data) {
+			t.Errorf("%s: expected the other fields to be kept", test.name)
+		}
+	}
+}
+
+// the chunks of a save state, without the header and its timestamp
+func stateChunksOf(gb *Gameboy) []byte {
+	state := gb.SerializeState(make([]byte, StateBufferSize))
+	_, headerSize, _ := readStateHeader(state)
+
+	return state[headerSize:]
+}
+
+func TestStateMidInstruction(t *testing.T) {
+	fixture := stateFixture{rom: "blargg/cpu_instrs", model: ModelCgb}
+	gb := newStateFixtureGameboy(t, fixture)
+
+	// the states are 997 M-cycles apart, so most are saved in the middle of an
+	// instruction or interrupt dispatch. The loaded Game Boy must run in
+	// lockstep with the original.
+	for range 200 {
+		for range 997 {
+			gb.Step()
+		}
+
+		loaded := newStateFixtureGameboy(t, fixture)
+		if err := loaded.DeserializeState(gb.SerializeState(make([]byte, StateBufferSize))); err != nil {
+			t.Fatal(err)
+		}
+		for range 500 {
+			gb.Step()
+			loaded.Step()
+		}
+		if !bytes.Equal(stateChunksOf(gb), stateChunksOf(loaded)) {
+			t.Fatalf("expected the loaded state to run like the original, PC 0x%04X", gb.cpu.PC())
+		}
+	}
+}
+
+// newMbc3TestRom returns a ROM of NOPs on an MBC3 with a real-time clock.
+func newMbc3TestRom() []byte {
+	rom := make([]byte, 0x8000)
+	// MBC3+TIMER+RAM+BATTERY with 32 KiB of RAM
+	rom[0x0147] = 0x10
+	rom[0x0149] = 0x03
+
+	return rom
+}
+
+// latchRtc latches the MBC3's real-time clock and reads its registers.
+func latchRtc(gb *Gameboy) [5]uint8 {
+	gb.cartridge.Write(0x0000, 0x0A)
+	gb.cartridge.Write(0x6000, 0x00)
+	gb.cartridge.Write(0x6000, 0x01)
+
+	var registers [5]uint8
+	for i := range registers {
+		gb.cartridge.Write(0x4000, 0x08+uint8(i))
+		registers[i] = gb.cartridge.Read(0xA000)
+	}
+
+	return registers
+}
+
+func TestStateRestoresClockKind(t *testing.T) {
+	rom := newMbc3TestRom()
+
+	gb := New(ModelDmg)
+	gb.SetClock(clock.NewEmulated(time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)))
+	if _, err := gb.LoadRom(rom); err != nil {
+		t.Fatal(err)
+	}
+	gb.StepFrames(120)
+	registers := latchRtc(gb)
+	state := append([]byte(nil), gb.SerializeState(make([]byte, StateBufferSize))...)
+
+	// the state is loaded into a Game Boy on a real-time clock
+	loaded := New(ModelDmg)
+	if _, err := loaded.LoadRom(rom); err != nil {
+		t.Fatal(err)
+	}
+	if err := loaded.DeserializeState(state); err != nil {
+		t.Fatal(err)
+	}
+
+	if kind := loaded.Clock().Kind(); kind != clock.KindEmulated {
+		t.Errorf("expected the emulated clock to be restored, got kind %d", kind)
+	}
+	if loadedRegisters := latchRtc(loaded); loadedRegisters != registers {
+		t.Errorf("expected the RTC registers %v, got %v", registers, loadedRegisters)
+	}
+}
+
+func TestStateRestoresRumble(t *testing.T) {
+	rom := make([]byte, 0x8000)
+	// MBC5+RUMBLE
+	rom[0x0147] = 0x1C
+
+	gb := New(ModelDmg)
+	if _, err := gb.LoadRom(rom); err != nil {
+		t.Fatal(err)
+	}
+	var events []RumbleEvent
+	gb.SetRumbleHandler(func(event RumbleEvent) {
+		events = append(events, event)
+	})
+
+	gb.StepFrames(10)
+	gb.cartridge.Write(0x4000, 0b0000_1000)
+	state := append([]byte(nil), gb.SerializeState(make([]byte, StateBufferSize))...)
+	savedAt := events[0].TCycle
+
+	gb.StepFrames(10)
+	gb.cartridge.Write(0x4000, 0b0000_0000)
+	events = nil
+	if err := gb.DeserializeState(state); err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 0 {
+		t.Errorf("expected loading a state not to switch the motor, got %v", events)
+	}
+	if !gb.Rumble() {
+		t.Error("expected the motor to be on after loading the state")
+	}
+
+	// events continue from the state's T-cycle
+	gb.cartridge.Write(0x4000, 0b0000_0000)
+	if len(events) != 1 || events[0].TCycle != savedAt {
+		t.Errorf("expected the motor switched off at T-cycle %d, got %v", savedAt, events)
+	}
+}
+
+func TestStateOfLargeCartridges(t *testing.T) {
+	tests := []struct {
+		name          string
+		cartridgeType uint8
+		ramSizeCode   uint8
+	}{
+		{"MBC5 with 128KiB of RAM", 0x1B, 0x04},
+		{"Pocket Camera", 0xFC, 0x04},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rom := make([]byte, 0x8000)
+			rom[0x0143] = 0x80
+			rom[0x0147] = test.cartridgeType
+			rom[0x0149] = test.ramSizeCode
+
+			gb := New(ModelCgb)
+			if _, err := gb.LoadRom(rom); err != nil {
+				t.Fatal(err)
+			}
+			// larger than the 256KiB the frontends used to allocate
+			state := gb.SerializeState(make([]byte, StateBufferSize))
+			if len(state) <= 256*1024 {
+				t.Errorf("expected a state over 256KiB, got %d bytes", len(state))
+			}
+			if err := gb.DeserializeState(state); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
@@ -0,0 +1,52 @@
+package infrared
+
+import "sync"
+
+// Transceiver is the other end of the infrared port on carts with an IR LED
+// and receiver, such as HuC1 and HuC3. The cartridge switches its LED through
+// SetLed and samples its receiver through Light.
+type Transceiver interface {
+	// SetLed is called whenever the Game Boy switches its IR LED on or off.
+	SetLed(on bool)
+	// Light reports whether the receiver currently sees IR light.
+	Light() bool
+}
+
+// =======================
+// ======== Link =========
+// =======================
+
+// Link connects two Game Boys facing each other. Each end sees the light from
+// the other end's LED. The ends can be used from different goroutines, but
+// IR protocols are timing sensitive, so the two emulators should be stepped
+// in lockstep.
+type Link struct {
+	mutex sync.Mutex
+	leds  [2]bool
+}
+
+// NewLink returns the two ends of an IR link.
+func NewLink() (*Endpoint, *Endpoint) {
+	link := &Link{}
+
+	return &Endpoint{link: link, side: 0}, &Endpoint{link: link, side: 1}
+}
+
+type Endpoint struct {
+	link *Link
+	side int
+}
+
+func (endpoint *Endpoint) SetLed(on bool) {
+	endpoint.link.mutex.Lock()
+	defer endpoint.link.mutex.Unlock()
+
+	endpoint.link.leds[endpoint.side] = on
+}
+
+func (endpoint *Endpoint) Light() bool {
+	endpoint.link.mutex.Lock()
+	defer endpoint.link.mutex.Unlock()
+
+	return endpoint.link.leds[1-endpoint.side]
+}
@@ -0,0 +1,50 @@
+package infrared
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestLink(t *testing.T) {
+	a, b := NewLink()
+	if a.Light() || b.Light() {
+		t.Fatal("expected no light before either LED is on")
+	}
+
+	a.SetLed(true)
+	if !b.Light() {
+		t.Error("expected b to see a's LED")
+	}
+	if a.Light() {
+		t.Error("expected a not to see its own LED")
+	}
+
+	b.SetLed(true)
+	a.SetLed(false)
+	if !a.Light() || b.Light() {
+		t.Errorf("expected only a to see light, got a %v and b %v", a.Light(), b.Light())
+	}
+}
+
+// the ends are used from the goroutines of 2 emulators, run with -race
+func TestLinkConcurrent(t *testing.T) {
+	a, b := NewLink()
+
+	var wait sync.WaitGroup
+	for _, endpoint := range []*Endpoint{a, b} {
+		wait.Add(1)
+		go func() {
+			defer wait.Done()
+			for i := range 1000 {
+				endpoint.SetLed(i%2 == 0)
+				endpoint.Light()
+			}
+		}()
+	}
+	wait.Wait()
+
+	// the last write of each end was off
+	if a.Light() || b.Light() {
+		t.Error("expected both LEDs off")
+	}
+}
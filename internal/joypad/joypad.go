@@ -10,12 +10,22 @@ type Joypad struct {
 	p1Register uint8
 
 	// Internal state: 1 = Pressed, 0 = Released
-	buttons uint8
-	dpad    uint8
+	// one entry per player, the SGB supports up to 4 controllers
+	buttons [MaxPlayers]uint8
+	dpad    [MaxPlayers]uint8
+
+	// number of controllers enabled by the SGB's MLT_REQ command, 1, 2 or 4
+	players uint8
+	// the controller read through P1
+	currentPlayer uint8
 
 	interruptRequester func(interruptType interrupt.Interrupt)
+	// receives every write to P1, which the SGB uses to transmit packets
+	sgbReceiver func(value uint8)
 }
 
+const MaxPlayers = 4
+
 type JoypadInput uint8
 
 const (
@@ -49,22 +59,47 @@ func New(interruptRequest func(interrupt.Interrupt)) *Joypad {
 }
 
 func (joypad *Joypad) Reset() {
-	joypad.buttons = 0
-	joypad.dpad = 0
+	joypad.buttons = [MaxPlayers]uint8{}
+	joypad.dpad = [MaxPlayers]uint8{}
 	joypad.p1Register = 0xCF
+	joypad.players = 1
+	joypad.currentPlayer = 0
+}
+
+// ConnectSgb sets the receiver of P1 writes, used to decode SGB packets.
+func (joypad *Joypad) ConnectSgb(receiver func(value uint8)) {
+	joypad.sgbReceiver = receiver
+}
+
+// SetPlayers sets the number of controllers read through P1, for the SGB's
+// multiplayer mode. It must be 1, 2 or 4.
+func (joypad *Joypad) SetPlayers(players uint8) {
+	joypad.players = players
+	joypad.currentPlayer = 0
 }
 
 func (joypad *Joypad) Write(value uint8) {
 	logger.GlobalTraceLogger.LogMemWrite(0xFF00, value)
 
 	oldState := joypad.calculateP1Register()
+	oldP1Register := joypad.p1Register
 
 	// only update the "Select" bits (4-5)
 	joypad.p1Register = value & 0b0011_0000
 
+	// in multiplayer mode, the next controller is selected when P15 goes high
+	p15Rising := oldP1Register&0b0010_0000 == 0 && joypad.p1Register&0b0010_0000 != 0
+	if joypad.players > 1 && p15Rising {
+		joypad.currentPlayer = (joypad.currentPlayer + 1) % joypad.players
+	}
+
 	newState := joypad.calculateP1Register()
 
 	joypad.checkInterrupt(oldState, newState)
+
+	if joypad.sgbReceiver != nil {
+		joypad.sgbReceiver(value)
+	}
 }
 
 func (joypad *Joypad) Read() uint8 {
@@ -75,13 +110,27 @@ func (joypad *Joypad) Read() uint8 {
 }
 
 func (joypad *Joypad) Press(input JoypadInput) {
+	joypad.PressPlayer(0, input)
+}
+
+func (joypad *Joypad) Release(input JoypadInput) {
+	joypad.ReleasePlayer(0, input)
+}
+
+// PressPlayer presses an input on one of the SGB's controllers, player 0 is
+// the Game Boy's own. Players outside 0 to MaxPlayers-1 are ignored.
+func (joypad *Joypad) PressPlayer(player int, input JoypadInput) {
+	if player < 0 || player >= MaxPlayers {
+		return
+	}
+
 	oldState := joypad.calculateP1Register()
 
 	mask := joypadInputMask[input]
 	if input.isDpad() {
-		joypad.dpad |= mask
+		joypad.dpad[player] |= mask
 	} else {
-		joypad.buttons |= mask
+		joypad.buttons[player] |= mask
 	}
 
 	newState := joypad.calculateP1Register()
@@ -89,14 +138,20 @@ func (joypad *Joypad) Press(input JoypadInput) {
 	joypad.checkInterrupt(oldState, newState)
 }
 
-func (joypad *Joypad) Release(input JoypadInput) {
+// ReleasePlayer releases an input on one of the SGB's controllers. Players
+// outside 0 to MaxPlayers-1 are ignored.
+func (joypad *Joypad) ReleasePlayer(player int, input JoypadInput) {
+	if player < 0 || player >= MaxPlayers {
+		return
+	}
+
 	oldState := joypad.calculateP1Register()
 
 	mask := joypadInputMask[input]
 	if input.isDpad() {
-		joypad.dpad &^= mask
+		joypad.dpad[player] &^= mask
 	} else {
-		joypad.buttons &^= mask
+		joypad.buttons[player] &^= mask
 	}
 
 	newState := joypad.calculateP1Register()
@@ -114,14 +169,22 @@ func (joypad *Joypad) calculateP1Register() uint8 {
 	// Start with bits 0-3 as 1 (Released).
 	value |= 0b0000_1111
 
+	// in multiplayer mode, deselecting both groups reads the controller ID
+	if joypad.players > 1 && (joypad.p1Register&0b0011_0000) == 0b0011_0000 {
+		return value - joypad.currentPlayer
+	}
+
+	buttons := joypad.buttons[joypad.currentPlayer]
+	dpad := joypad.dpad[joypad.currentPlayer]
+
 	// Reading Start, Select, B, A
 	if (joypad.p1Register & 0b0010_0000) == 0 {
-		value &= (^joypad.buttons & 0b0000_1111) | 0b1111_0000
+		value &= (^buttons & 0b0000_1111) | 0b1111_0000
 	}
 
 	// Reading Down, Up, Left, Right
 	if (joypad.p1Register & 0b0001_0000) == 0 {
-		dpadState := ^joypad.dpad & 0b0000_1111
+		dpadState := ^dpad & 0b0000_1111
 
 		// == impossible input sanitization ==
 		// prevent Left+Right
@@ -161,9 +224,13 @@ func (joypad *Joypad) Serialize(buf []byte) int {
 
 	buf[offset] = joypad.p1Register
 	offset++
-	buf[offset] = joypad.buttons
+	n := copy(buf[offset:], joypad.buttons[:])
+	offset += n
+	n = copy(buf[offset:], joypad.dpad[:])
+	offset += n
+	buf[offset] = joypad.players
 	offset++
-	buf[offset] = joypad.dpad
+	buf[offset] = joypad.currentPlayer
 	offset++
 
 	return offset
@@ -174,9 +241,13 @@ func (joypad *Joypad) Deserialize(buf []byte) int {
 
 	joypad.p1Register = buf[offset]
 	offset++
-	joypad.buttons = buf[offset]
+	n := copy(joypad.buttons[:], buf[offset:])
+	offset += n
+	n = copy(joypad.dpad[:], buf[offset:])
+	offset += n
+	joypad.players = buf[offset]
 	offset++
-	joypad.dpad = buf[offset]
+	joypad.currentPlayer = buf[offset]
 	offset++
 
 	return offset
@@ -0,0 +1,47 @@
+package joypad
+
+import (
+	"testing"
+
+	"github.com/davidyorr/LuccaGB/internal/interrupt"
+)
+
+// readButtons selects the buttons and returns their bits, 0 for pressed.
+func readButtons(joypad *Joypad) uint8 {
+	joypad.Write(0b0001_0000)
+
+	return joypad.Read() & 0b1111
+}
+
+func TestPressPlayer(t *testing.T) {
+	joypad := New(func(interrupt.Interrupt) {})
+	joypad.SetPlayers(2)
+
+	joypad.PressPlayer(1, JoypadInputA)
+	if buttons := readButtons(joypad); buttons != 0b1111 {
+		t.Errorf("expected player 1's buttons released, got 0b%04b", buttons)
+	}
+
+	// P15 going high selects the next controller
+	joypad.Write(0b0011_0000)
+	if buttons := readButtons(joypad); buttons != 0b1110 {
+		t.Errorf("expected player 2's A pressed, got 0b%04b", buttons)
+	}
+
+	joypad.ReleasePlayer(1, JoypadInputA)
+	if buttons := readButtons(joypad); buttons != 0b1111 {
+		t.Errorf("expected player 2's A released, got 0b%04b", buttons)
+	}
+}
+
+func TestPressPlayerOutOfRange(t *testing.T) {
+	joypad := New(func(interrupt.Interrupt) {})
+
+	for _, player := range []int{-1, MaxPlayers, 255} {
+		joypad.PressPlayer(player, JoypadInputStart)
+		joypad.ReleasePlayer(player, JoypadInputStart)
+	}
+	if joypad.buttons != [MaxPlayers]uint8{} || joypad.dpad != [MaxPlayers]uint8{} {
+		t.Errorf("expected no inputs pressed, got %v %v", joypad.buttons, joypad.dpad)
+	}
+}
@@ -14,7 +14,9 @@ type MMU struct {
 	cartridge *cartridge.Cartridge
 	joypad    *joypad.Joypad
 	// 0xC000 - 0xDFFF
-	workingRam [8192]uint8
+	//	0xC000 - 0xCFFF - bank 0
+	//	0xD000 - 0xDFFF - bank 1, or banks 1-7 on the CGB, selected by SVBK
+	workingRam [8][4096]uint8
 	// 0xFF00 - 0xFF7F
 	ioRegisters [128]uint8
 	// 0xFF80 - 0xFFFE
@@ -23,6 +25,23 @@ type MMU struct {
 	ieRegister uint8
 	// 0xFF0F - Interrupt flag
 	ifRegister uint8
+	// 0xFF4D - KEY1: Prepare speed switch (CGB only)
+	//	7 - Current speed: 0 = normal; 1 = double
+	//	0 - Switch armed, the next STOP switches speed
+	key1 uint8
+	// 0xFF70 - SVBK: WRAM bank (CGB only)
+	svbk uint8
+	// 0xFF4C - KEY0: CPU mode select (CGB only), written by the boot ROM
+	//	2 - DMG compatibility mode, selected when the boot ROM is unmapped
+	key0 uint8
+	// whether CGB registers are available
+	cgb bool
+	// the boot ROM, overlaid on 0x0000-0x00FF, and 0x0200-0x08FF for the CGB
+	bootRom []uint8
+	// 0xFF50 - BOOT: writing any value unmaps the boot ROM until the next reset
+	bootRomMapped bool
+	// called when the boot ROM unmaps itself
+	bootRomHandoff func()
 }
 
 func New(cartridge *cartridge.Cartridge) *MMU {
@@ -35,26 +54,94 @@ func New(cartridge *cartridge.Cartridge) *MMU {
 }
 
 func (mmu *MMU) Reset() {
+	mmu.key0 = 0x00
+	mmu.key1 = 0x00
+	mmu.svbk = 0x00
+	mmu.bootRomMapped = false
+}
+
+// SetCgbMode switches between DMG and CGB mode, keeping the MMU's state.
+func (mmu *MMU) SetCgbMode(cgb bool) {
+	mmu.cgb = cgb
+}
+
+func (mmu *MMU) CgbMode() bool {
+	return mmu.cgb
+}
+
+// DmgCompatibility reports whether the boot ROM selected DMG compatibility
+// mode through KEY0, for a game that isn't CGB enhanced.
+func (mmu *MMU) DmgCompatibility() bool {
+	return mmu.cgb && mmu.key0&0b0100 != 0
+}
+
+// MapBootRom overlays a boot ROM on the cartridge until 0xFF50 is written.
+func (mmu *MMU) MapBootRom(bootRom []uint8) {
+	mmu.bootRom = bootRom
+	mmu.bootRomMapped = true
+}
+
+func (mmu *MMU) BootRomMapped() bool {
+	return mmu.bootRomMapped
+}
+
+// bootRomOverlays reports whether an address reads from the boot ROM. The
+// CGB boot ROM leaves a gap for the cartridge header.
+func (mmu *MMU) bootRomOverlays(address uint16) bool {
+	if !mmu.bootRomMapped || int(address) >= len(mmu.bootRom) {
+		return false
+	}
+
+	return address <= 0x00FF || address >= 0x0200
+}
+
+// workingRamBank returns the bank mapped to 0xD000-0xDFFF
+func (mmu *MMU) workingRamBank() uint8 {
+	bank := mmu.svbk & 0b111
+	// bank 0 can't be selected
+	if bank == 0 {
+		bank = 1
+	}
+
+	return bank
+}
+
+// workingRamAddress maps an address in working RAM, or echo RAM, to its bank
+// and offset
+func (mmu *MMU) workingRamAddress(address uint16) (bank uint8, offset uint16) {
+	offset = (address - 0xC000) & 0x1FFF
+	if offset < 0x1000 {
+		return 0, offset
+	}
+
+	return mmu.workingRamBank(), offset - 0x1000
 }
 
 func (mmu *MMU) ConnectJoypad(joypad *joypad.Joypad) {
 	mmu.joypad = joypad
 }
 
+// ConnectBootRomHandoff sets the function called when the boot ROM unmaps
+// itself and hands over to the game.
+func (mmu *MMU) ConnectBootRomHandoff(handoff func()) {
+	mmu.bootRomHandoff = handoff
+}
+
 func (mmu *MMU) Read(address uint16) (value uint8) {
 	switch {
+	// boot ROM
+	case mmu.bootRomOverlays(address):
+		value = mmu.bootRom[address]
 	// ROM
 	case address <= 0x7FFF:
 		value = mmu.cartridge.Read(address)
 	// External RAM
 	case address >= 0xA000 && address <= 0xBFFF:
 		value = mmu.cartridge.Read(address)
-	// working RAM
-	case address >= 0xC000 && address <= 0xDFFF:
-		value = mmu.workingRam[address-0xC000]
-	// echo RAM
-	case address >= 0xE000 && address <= 0xFDFF:
-		value = mmu.workingRam[address-0xE000]
+	// working RAM and echo RAM
+	case address >= 0xC000 && address <= 0xFDFF:
+		bank, offset := mmu.workingRamAddress(address)
+		value = mmu.workingRam[bank][offset]
 	// IF
 	case address == 0xFF0F:
 		value = mmu.ifRegister | 0b1110_0000
@@ -64,6 +151,18 @@ func (mmu *MMU) Read(address uint16) (value uint8) {
 	// JOYPAD
 	case address == 0xFF00:
 		value = mmu.joypad.Read()
+	// KEY1
+	case address == 0xFF4D:
+		value = 0xFF
+		if mmu.cgb {
+			value = mmu.key1 | 0b0111_1110
+		}
+	// SVBK
+	case address == 0xFF70:
+		value = 0xFF
+		if mmu.cgb {
+			value = mmu.svbk | 0b1111_1000
+		}
 	// IO registers
 	case address >= 0xFF01 && address <= 0xFF7F:
 		value = mmu.ioRegisters[address-0xFF00]
@@ -104,12 +203,10 @@ func (mmu *MMU) Write(address uint16, value uint8) {
 	// External RAM
 	case address >= 0xA000 && address <= 0xBFFF:
 		mmu.cartridge.Write(address, value)
-	// working RAM
-	case address >= 0xC000 && address <= 0xDFFF:
-		mmu.workingRam[address-0xC000] = value
-	// echo RAM
-	case address >= 0xE000 && address <= 0xFDFF:
-		mmu.workingRam[address-0xE000] = value
+	// working RAM and echo RAM
+	case address >= 0xC000 && address <= 0xFDFF:
+		bank, offset := mmu.workingRamAddress(address)
+		mmu.workingRam[bank][offset] = value
 	// IF
 	case address == 0xFF0F:
 		mmu.ifRegister = value & 0b0001_1111
@@ -119,6 +216,33 @@ func (mmu *MMU) Write(address uint16, value uint8) {
 	// JOYPAD
 	case address == 0xFF00:
 		mmu.joypad.Write(value)
+	// KEY0, locked once the boot ROM is unmapped
+	case address == 0xFF4C:
+		if mmu.cgb && mmu.bootRomMapped {
+			mmu.key0 = value & 0b0000_1100
+		}
+	// KEY1
+	case address == 0xFF4D:
+		if mmu.cgb {
+			mmu.key1 = (mmu.key1 & 0b1000_0000) | (value & 0b1)
+		}
+	// SVBK
+	case address == 0xFF70:
+		if mmu.cgb {
+			mmu.svbk = value & 0b111
+		}
+	// BOOT
+	case address == 0xFF50:
+		if !mmu.bootRomMapped {
+			break
+		}
+		if debug.Enabled {
+			logger.Info("BOOT ROM UNMAPPED")
+		}
+		mmu.bootRomMapped = false
+		if mmu.bootRomHandoff != nil {
+			mmu.bootRomHandoff()
+		}
 	// IO registers
 	case address >= 0xFF01 && address <= 0xFF7F:
 		mmu.ioRegisters[address-0xFF00] = value
@@ -146,13 +270,30 @@ func (mmu *MMU) InterruptFlag() uint8 {
 	return mmu.ifRegister | 0b1110_0000
 }
 
+// SpeedSwitchArmed reports whether the next STOP switches the CPU speed.
+func (mmu *MMU) SpeedSwitchArmed() bool {
+	return mmu.cgb && mmu.key1&0b1 != 0
+}
+
+// SwitchSpeed toggles between normal and double speed, disarming the switch.
+func (mmu *MMU) SwitchSpeed() {
+	mmu.key1 = (mmu.key1 ^ 0b1000_0000) & 0b1000_0000
+}
+
+// DoubleSpeed reports whether the CPU runs at double speed.
+func (mmu *MMU) DoubleSpeed() bool {
+	return mmu.key1&0b1000_0000 != 0
+}
+
 func (mmu *MMU) Serialize(buf []byte) int {
 	offset := 0
 
-	n := copy(buf[offset:], mmu.workingRam[:])
-	offset += n
+	for bank := range mmu.workingRam {
+		n := copy(buf[offset:], mmu.workingRam[bank][:])
+		offset += n
+	}
 
-	n = copy(buf[offset:], mmu.highRam[:])
+	n := copy(buf[offset:], mmu.highRam[:])
 	offset += n
 
 	n = copy(buf[offset:], mmu.ioRegisters[:])
@@ -162,6 +303,24 @@ func (mmu *MMU) Serialize(buf []byte) int {
 	offset++
 	buf[offset] = mmu.ifRegister
 	offset++
+	buf[offset] = mmu.key1
+	offset++
+	buf[offset] = mmu.svbk
+	offset++
+	if mmu.cgb {
+		buf[offset] = 1
+	} else {
+		buf[offset] = 0
+	}
+	offset++
+	if mmu.bootRomMapped {
+		buf[offset] = 1
+	} else {
+		buf[offset] = 0
+	}
+	offset++
+	buf[offset] = mmu.key0
+	offset++
 
 	return offset
 }
@@ -169,10 +328,12 @@ func (mmu *MMU) Serialize(buf []byte) int {
 func (mmu *MMU) Deserialize(buf []byte) int {
 	offset := 0
 
-	n := copy(mmu.workingRam[:], buf[offset:])
-	offset += n
+	for bank := range mmu.workingRam {
+		n := copy(mmu.workingRam[bank][:], buf[offset:])
+		offset += n
+	}
 
-	n = copy(mmu.highRam[:], buf[offset:])
+	n := copy(mmu.highRam[:], buf[offset:])
 	offset += n
 
 	n = copy(mmu.ioRegisters[:], buf[offset:])
@@ -182,6 +343,17 @@ func (mmu *MMU) Deserialize(buf []byte) int {
 	offset++
 	mmu.ifRegister = buf[offset]
 	offset++
+	mmu.key1 = buf[offset]
+	offset++
+	mmu.svbk = buf[offset]
+	offset++
+	mmu.cgb = buf[offset] == 1
+	offset++
+	// the boot ROM itself isn't part of the state
+	mmu.bootRomMapped = buf[offset] == 1 && mmu.bootRom != nil
+	offset++
+	mmu.key0 = buf[offset]
+	offset++
 
 	return offset
 }
@@ -0,0 +1,60 @@
+package model
+
+// Model is a Game Boy hardware revision. Each one is left in a different state
+// by its boot ROM, and has its own quirks.
+// See: https://gbdev.io/pandocs/Power_Up_Sequence.html
+type Model uint8
+
+const (
+	// Dmg is the original Game Boy, revisions A, B and C
+	Dmg Model = iota
+	// Dmg0 is the early Japanese revision of the original Game Boy
+	Dmg0
+	// Mgb is the Game Boy Pocket and Game Boy Light
+	Mgb
+	// Sgb is the Super Game Boy
+	Sgb
+	// Sgb2 is the Super Game Boy 2
+	Sgb2
+	// Cgb is the Game Boy Color
+	Cgb
+	// Agb is the Game Boy Advance, running Game Boy games
+	Agb
+)
+
+// IsDmgFamily reports whether the model has the DMG's CPU, APU and PPU. The
+// SGB and SGB2 are a DMG inside a cartridge.
+func (model Model) IsDmgFamily() bool {
+	return !model.IsCgbFamily()
+}
+
+// IsCgbFamily reports whether the model can run games in CGB mode.
+func (model Model) IsCgbFamily() bool {
+	return model == Cgb || model == Agb
+}
+
+// IsSgbFamily reports whether the model receives SGB packets.
+func (model Model) IsSgbFamily() bool {
+	return model == Sgb || model == Sgb2
+}
+
+func (model Model) String() string {
+	switch model {
+	case Dmg:
+		return "DMG"
+	case Dmg0:
+		return "DMG0"
+	case Mgb:
+		return "MGB"
+	case Sgb:
+		return "SGB"
+	case Sgb2:
+		return "SGB2"
+	case Cgb:
+		return "CGB"
+	case Agb:
+		return "AGB"
+	}
+
+	return "UNKNOWN"
+}
@@ -0,0 +1,53 @@
+package ppu
+
+import "github.com/davidyorr/LuccaGB/internal/model"
+
+// registeredMark is the ® tile the DMG boot ROM draws next to the logo, 1 byte
+// per row
+var registeredMark = [8]uint8{0x3C, 0x42, 0xB9, 0xA5, 0xB9, 0xA5, 0x42, 0x3C}
+
+// SetLogo sets the logo in the loaded ROM's header, which the DMG family's
+// boot ROMs leave in VRAM. It applies from the next Reset.
+func (ppu *PPU) SetLogo(logo [48]uint8) {
+	ppu.logo = logo
+}
+
+// drawBootLogo leaves VRAM as the DMG, DMG0 and MGB boot ROMs do: the header's
+// logo in tiles 0x01-0x18 and the ® in tile 0x19, shown in the middle of the
+// background map. The SGB's boot ROM leaves the showing of the logo to the
+// SNES, and the CGB's draws it differently.
+// See: https://gbdev.io/pandocs/Power_Up_Sequence.html#monochrome-models-dmg0-dmg-mgb
+func (ppu *PPU) drawBootLogo() {
+	switch ppu.model {
+	case model.Dmg0, model.Dmg, model.Mgb:
+	default:
+		return
+	}
+
+	// each nibble of the logo is scaled up 2 times into 2 rows of a tile, only
+	// the low bit plane is set
+	address := 0x0010
+	for _, value := range ppu.logo {
+		for _, nibble := range [2]uint8{value >> 4, value & 0x0F} {
+			var row uint8
+			for bit := 3; bit >= 0; bit-- {
+				row = row<<2 | (nibble>>bit&1)*0b11
+			}
+			ppu.videoRam[0][address] = row
+			ppu.videoRam[0][address+2] = row
+			address += 4
+		}
+	}
+	for _, row := range registeredMark {
+		ppu.videoRam[0][address] = row
+		address += 2
+	}
+
+	// the ® at the end of the top row of the logo, and the logo's 2 rows of 12
+	// tiles
+	ppu.videoRam[0][0x1910] = 0x19
+	for i := range uint8(12) {
+		ppu.videoRam[0][0x1904+uint16(i)] = 0x01 + i
+		ppu.videoRam[0][0x1924+uint16(i)] = 0x0D + i
+	}
+}
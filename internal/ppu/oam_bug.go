@@ -0,0 +1,88 @@
+package ppu
+
+// The DMG family's OAM corruption bug: while the PPU is scanning OAM, the CPU
+// touching 0xFE00-0xFEFF, by reading, writing, or just putting the address on
+// the bus to increment or decrement a 16-bit register, corrupts the row of OAM
+// being scanned. OAM is 20 rows of 8 bytes, 4 little endian words per row.
+// See: https://gbdev.io/pandocs/OAM_Corruption_Bug.html
+
+const oamRowSize = 8
+
+// accessedOamRow returns the byte offset of the row the PPU is reading, 2
+// objects every M-cycle, or -1 outside of OAM scan.
+func (ppu *PPU) accessedOamRow() int {
+	if ppu.model.IsCgbFamily() || !ppu.lcdEnabled() || ppu.ly >= 144 || ppu.mode != OamScan {
+		return -1
+	}
+
+	row := (int(ppu.dot) / 4) * oamRowSize
+	if row >= len(ppu.oam) {
+		return -1
+	}
+
+	return row
+}
+
+func (ppu *PPU) oamWord(offset int) uint16 {
+	return uint16(ppu.oam[offset]) | uint16(ppu.oam[offset+1])<<8
+}
+
+func (ppu *PPU) setOamWord(offset int, value uint16) {
+	ppu.oam[offset] = uint8(value)
+	ppu.oam[offset+1] = uint8(value >> 8)
+}
+
+// copyPrecedingOamRow copies the last 3 words of the preceding row into the row.
+func (ppu *PPU) copyPrecedingOamRow(row int) {
+	copy(ppu.oam[row+2:row+oamRowSize], ppu.oam[row-oamRowSize+2:row])
+}
+
+// OamBugWrite corrupts the row being scanned for a write, or an increment or
+// decrement, with an address in 0xFE00-0xFEFF. The first row is never
+// corrupted.
+func (ppu *PPU) OamBugWrite() {
+	row := ppu.accessedOamRow()
+	if row < oamRowSize {
+		return
+	}
+
+	a := ppu.oamWord(row)
+	b := ppu.oamWord(row - oamRowSize)
+	c := ppu.oamWord(row - oamRowSize + 4)
+	ppu.setOamWord(row, ((a^c)&(b^c))^c)
+	ppu.copyPrecedingOamRow(row)
+}
+
+// OamBugRead corrupts the row being scanned for a read from 0xFE00-0xFEFF.
+func (ppu *PPU) OamBugRead() {
+	row := ppu.accessedOamRow()
+	if row < oamRowSize {
+		return
+	}
+
+	a := ppu.oamWord(row)
+	b := ppu.oamWord(row - oamRowSize)
+	c := ppu.oamWord(row - oamRowSize + 4)
+	ppu.setOamWord(row, b|(a&c))
+	ppu.copyPrecedingOamRow(row)
+}
+
+// OamBugReadIncrease corrupts the rows before the row being scanned for a read
+// and increment or decrement in the same M-cycle, like LD A, [HLI] and POP.
+// It's followed by the corruption of the read itself. The first 4 rows and the
+// last row aren't affected.
+func (ppu *PPU) OamBugReadIncrease() {
+	row := ppu.accessedOamRow()
+	if row < 4*oamRowSize || row >= 19*oamRowSize {
+		return
+	}
+
+	preceding := row - oamRowSize
+	a := ppu.oamWord(row - 2*oamRowSize)
+	b := ppu.oamWord(preceding)
+	c := ppu.oamWord(row)
+	d := ppu.oamWord(preceding + 4)
+	ppu.setOamWord(preceding, (b&(a|c|d))|(a&c&d))
+	copy(ppu.oam[row:row+oamRowSize], ppu.oam[preceding:row])
+	copy(ppu.oam[row-2*oamRowSize:preceding], ppu.oam[preceding:row])
+}
@@ -3,29 +3,62 @@ package ppu
 import "encoding/binary"
 
 type PixelFetcher struct {
-	ppu                        *PPU
-	state                      FetcherState
-	counter                    uint16
-	fetchedTileNumber          uint8
-	fetchedTileDataLow         uint8
-	fetchedTileDataHigh        uint8
-	xPositionCounter           uint8
-	pixelsToDiscard            uint8
-	backgroundScrollingPenalty uint8
-	isFetchingWindow           bool
-	currentX                   uint8
-	windowLineCounter          uint8
-	isFirstFetchOfScanline     bool
-	scanlineHadWindowPixels    bool
-	wyEqualedLyDuringFrame     bool
+	ppu                     *PPU
+	state                   FetcherState
+	counter                 uint16
+	fetchedTileNumber       uint8
+	fetchedTileDataLow      uint8
+	fetchedTileDataHigh     uint8
+	xPositionCounter        uint8
+	pixelsToDiscard         uint8
+	isFetchingWindow        bool
+	currentX                uint8
+	windowLineCounter       uint8
+	offscreenPixels         uint8
+	scanlineHadWindowPixels bool
+	wyEqualedLyDuringFrame  bool
 
 	isFetchingSprite bool
 	spriteIndex      uint8
+	// the sprite fetch has its own steps and data, the background fetcher
+	// keeps its tile while it waits
+	spriteCounter      uint8
+	spriteTileNumber   uint8
+	spriteTileDataLow  uint8
+	spriteTileDataHigh uint8
+
+	// BG map attributes of the fetched tile (CGB only)
+	//	7 - BG-to-OAM priority
+	//	6 - Y flip
+	//	5 - X flip
+	//	3 - Tile VRAM bank
+	//	2-0 - Color palette
+	fetchedTileAttributes uint8
 
 	// background and window FIFO
 	backgroundFifo PixelFifo
 	// sprite (object) FIFO
 	spriteFifo PixelFifo
+
+	// pixels which have left the FIFOs but haven't reached the LCD yet, the
+	// oldest first
+	lcdPipeline [lcdLatency]lcdPixel
+	// the X coordinate of the next pixel the LCD draws
+	lcdX uint8
+}
+
+// The LCD draws a pixel 1 dot after it leaves the FIFOs. The palettes are
+// applied then, so writes to them take effect 1 pixel earlier than the fetcher
+// would suggest.
+const lcdLatency = 1
+
+// lcdPixel is a background pixel and the sprite pixel mixed with it, on their
+// way to the LCD.
+type lcdPixel struct {
+	background FIFO
+	sprite     FIFO
+	hasSprite  bool
+	valid      bool
 }
 
 type FetcherState int
@@ -50,72 +83,142 @@ func (fetcher *PixelFetcher) prepareForScanline() {
 	fetcher.state = StateGetTile
 	fetcher.backgroundFifo.Reset()
 	fetcher.spriteFifo.Reset()
-	fetcher.isFirstFetchOfScanline = true
+	fetcher.offscreenPixels = 8
 	fetcher.isFetchingSprite = false
 	fetcher.isFetchingWindow = false
 	fetcher.currentX = 0
 	fetcher.counter = 0
 	fetcher.xPositionCounter = 0
-
-	// SCX
-	fetcher.pixelsToDiscard = fetcher.ppu.scx % 8
-	if fetcher.pixelsToDiscard == 0 {
-		fetcher.backgroundScrollingPenalty = 0
-	} else if fetcher.pixelsToDiscard < 5 {
-		fetcher.backgroundScrollingPenalty = 4
-	} else {
-		fetcher.backgroundScrollingPenalty = 8
-	}
+	fetcher.lcdPipeline = [lcdLatency]lcdPixel{}
+	fetcher.lcdX = 0
+	fetcher.pixelsToDiscard = 0
 }
 
 func (fetcher *PixelFetcher) step() {
+	fetcher.stepLcd()
+	if fetcher.isFetchingSprite && fetcher.spriteCounter == 6 {
+		fetcher.finishSpriteFetch()
+	}
+	// the DMG doesn't fetch sprites while they're disabled
+	if !fetcher.isFetchingSprite && (fetcher.ppu.cgb || (fetcher.ppu.lcdc>>1)&1 == 1) {
+		fetcher.checkForSprite()
+	}
 	if !fetcher.isFetchingSprite {
 		fetcher.attemptToPushPixel()
 	}
 	fetcher.tick()
 }
 
-func (fetcher *PixelFetcher) tick() {
-	// Only check for new sprites if we aren't already fetching a sprite
-	if !fetcher.isFetchingSprite {
-		// If the X-Position of any sprite in the sprite buffer is less than or
-		// equal to the current Pixel-X-Position + 8, a sprite fetch is initiated.
-		// See: https://ashiepaws.github.io/GBEDG/ppu/#sprite-fetching
-		for i := 0; i < fetcher.ppu.spriteBuffer.size; i++ {
-			oamIndex := fetcher.ppu.spriteBuffer.data[i]
-			// each sprite is 4 bytes long
-			baseAddress := oamIndex * 4
-			spriteX := fetcher.ppu.oam[baseAddress+1]
-
-			if spriteX <= fetcher.currentX+8 {
-				fetcher.isFetchingSprite = true
-				fetcher.state = StateGetTile
-				fetcher.spriteIndex = oamIndex
-				fetcher.ppu.spriteBuffer.Remove(i)
-
-				// reset background fetcher
-				fetcher.counter = 0
-				fetcher.fetchedTileNumber = 0
-				fetcher.fetchedTileDataLow = 0
-				fetcher.fetchedTileDataHigh = 0
-				return
-			}
+// checkForSprite starts a sprite fetch if a sprite begins at the next pixel.
+// The pixel is held back until every sprite there has been fetched.
+func (fetcher *PixelFetcher) checkForSprite() {
+	// If the X-Position of any sprite in the sprite buffer is less than or
+	// equal to the current Pixel-X-Position + 8, a sprite fetch is initiated.
+	// See: https://ashiepaws.github.io/GBEDG/ppu/#sprite-fetching
+	for i := 0; i < fetcher.ppu.spriteBuffer.size; i++ {
+		oamIndex := fetcher.ppu.spriteBuffer.data[i]
+		// each sprite is 4 bytes long
+		baseAddress := oamIndex * 4
+		spriteX := fetcher.ppu.oam[baseAddress+1]
+
+		if spriteX <= fetcher.currentX+8-fetcher.offscreenPixels {
+			fetcher.isFetchingSprite = true
+			fetcher.spriteIndex = oamIndex
+			fetcher.spriteCounter = 0
+			fetcher.ppu.spriteBuffer.Remove(i)
+			return
 		}
 	}
+}
 
+// abortSpriteFetch stops a sprite fetch, for the DMG's sprites being turned
+// off in the middle of it. The sprite is dropped, and the pixels go on from
+// the dot of the write.
+func (fetcher *PixelFetcher) abortSpriteFetch() {
+	if !fetcher.isFetchingSprite {
+		return
+	}
+	fetcher.isFetchingSprite = false
+	fetcher.attemptToPushPixel()
+}
+
+func (fetcher *PixelFetcher) tick() {
+	if fetcher.isFetchingSprite {
+		fetcher.tickSprite()
+		return
+	}
+
+	// The window starts when WX matches the position of the next pixel plus 7.
+	// The position counts the offscreen pixels too, so the remaining ones
+	// shift out the window's first columns when WX is below 7. A WX written
+	// past the position misses the window for the rest of the scanline.
+	// See: https://gbdev.io/pandocs/Window.html
 	windowEnabled := (fetcher.ppu.lcdc>>5)&1 == 1
-	if !fetcher.isFetchingWindow && (windowEnabled) && (fetcher.wyEqualedLyDuringFrame) && (fetcher.currentX+7 >= fetcher.ppu.wx) {
+	wxMatches := int(fetcher.currentX)+7-int(fetcher.offscreenPixels) == int(fetcher.ppu.wx)
+	// on the DMG, WX matching again while the window waits for its next tile
+	// slips a pixel of color 0 in
+	if fetcher.isFetchingWindow && windowEnabled && wxMatches && fetcher.xPositionCounter > 0 && fetcher.backgroundFifo.size == 0 && !fetcher.ppu.cgb {
+		fetcher.backgroundFifo.Push(FIFO{})
+	}
+	if !fetcher.isFetchingWindow && (windowEnabled) && (fetcher.wyEqualedLyDuringFrame) && wxMatches {
 		fetcher.state = StateGetTile
 		fetcher.backgroundFifo.Reset()
 		fetcher.isFetchingWindow = true
+		// every time the window starts again on the same scanline it moves on
+		// to its next line
+		if fetcher.scanlineHadWindowPixels {
+			fetcher.windowLineCounter++
+		}
 		fetcher.scanlineHadWindowPixels = true
 		fetcher.xPositionCounter = 0
 		fetcher.counter = 0
-		fetcher.pixelsToDiscard = 0
-		fetcher.backgroundScrollingPenalty = 0
-		return
+		// a window that starts offscreen keeps the fine scroll, and it costs
+		// a dot more
+		if fetcher.offscreenPixels == 0 {
+			fetcher.pixelsToDiscard = 0
+		} else if fetcher.pixelsToDiscard > 0 {
+			fetcher.pixelsToDiscard++
+		}
+	}
+
+	// the window's first fetch starts in the same dot
+	fetcher.tickBackground()
+}
+
+// tickSprite performs 1 dot of a sprite fetch. It waits for the background
+// fetcher to have a tile ready and for the background FIFO to have pixels,
+// then it takes 6 dots, while the background fetcher is paused.
+// See: https://gbdev.io/pandocs/Rendering.html#mode-3-length
+func (fetcher *PixelFetcher) tickSprite() {
+	if fetcher.state != StatePush || fetcher.backgroundFifo.size == 0 {
+		fetcher.tickBackground()
+		// the sprite fetch starts in the same dot the background fetcher is ready
+		if fetcher.state != StatePush || fetcher.backgroundFifo.size == 0 {
+			return
+		}
 	}
 
+	fetcher.spriteCounter++
+
+	switch fetcher.spriteCounter {
+	case 2:
+		// each sprite is 4 bytes, byte 2 is the tile index
+		fetcher.spriteTileNumber = fetcher.ppu.oam[fetcher.spriteIndex*4+2]
+	case 4:
+		fetcher.spriteTileDataLow = fetcher.fetchSpriteTileData(0)
+	}
+}
+
+// finishSpriteFetch reads the high byte of the sprite's tile data and mixes
+// the sprite in, 1 dot after the 6 dots of its fetch. The pixels go on in the
+// same dot.
+func (fetcher *PixelFetcher) finishSpriteFetch() {
+	fetcher.spriteTileDataHigh = fetcher.fetchSpriteTileData(1)
+	fetcher.pushSprite()
+	fetcher.isFetchingSprite = false
+}
+
+func (fetcher *PixelFetcher) tickBackground() {
 	fetcher.counter++
 
 	switch fetcher.state {
@@ -125,33 +228,37 @@ func (fetcher *PixelFetcher) tick() {
 		}
 		fetcher.counter = 0
 
-		if fetcher.isFetchingSprite {
-			oamIndex := fetcher.spriteIndex
-			// each sprite is 4 bytes, byte 2 is the tile index
-			tileIndex := (oamIndex * 4) + 2
-			fetcher.fetchedTileNumber = fetcher.ppu.oam[tileIndex]
+		// the fetcher goes back to the background as soon as the window is
+		// disabled
+		if fetcher.isFetchingWindow && (fetcher.ppu.lcdc>>5)&1 == 0 {
+			fetcher.isFetchingWindow = false
+		}
+
+		var tileMapAreaStart uint16 = 0x9800
+		var yTile uint8 = 0
+		var xTile uint8 = fetcher.xPositionCounter
+
+		// during window fetching we ignore the SCX and SCY values completely
+		if fetcher.isFetchingWindow {
+			if (fetcher.ppu.lcdc>>6)&1 == 1 {
+				tileMapAreaStart = 0x9C00
+			}
+			yTile = (fetcher.windowLineCounter / 8)
 		} else {
-			var tileMapAreaStart uint16 = 0x9800
-			var yTile uint8 = 0
-			var xTile uint8 = fetcher.xPositionCounter
-
-			// during window fetching we ignore the SCX and SCY values completely
-			if fetcher.isFetchingWindow {
-				if (fetcher.ppu.lcdc>>6)&1 == 1 {
-					tileMapAreaStart = 0x9C00
-				}
-				yTile = (fetcher.windowLineCounter / 8)
-			} else {
-				if (fetcher.ppu.lcdc>>3)&1 == 1 {
-					tileMapAreaStart = 0x9C00
-				}
-				yTile = ((fetcher.ppu.ly + fetcher.ppu.scy) & 0xFF) / 8
-				xTile += (fetcher.ppu.scx / 8)
-				xTile &= 0x1f // for wrap-around
+			if (fetcher.ppu.lcdc>>3)&1 == 1 {
+				tileMapAreaStart = 0x9C00
 			}
+			yTile = ((fetcher.ppu.ly + fetcher.ppu.scy) & 0xFF) / 8
+			xTile += (fetcher.ppu.scx / 8)
+			xTile &= 0x1f // for wrap-around
+		}
 
-			address := (tileMapAreaStart - 0x8000) + ((uint16(yTile)*32)+uint16(xTile))&0x3FF
-			fetcher.fetchedTileNumber = fetcher.ppu.videoRam[address]
+		address := (tileMapAreaStart - 0x8000) + ((uint16(yTile)*32)+uint16(xTile))&0x3FF
+		fetcher.fetchedTileNumber = fetcher.ppu.videoRam[0][address]
+		if fetcher.ppu.cgb {
+			fetcher.fetchedTileAttributes = fetcher.ppu.videoRam[1][address]
+		} else {
+			fetcher.fetchedTileAttributes = 0
 		}
 
 		fetcher.state = StateGetTileDataLow
@@ -171,95 +278,41 @@ func (fetcher *PixelFetcher) tick() {
 
 		fetcher.fetchedTileDataHigh = fetcher.fetchTileData(1)
 
-		// Note: The first time the background fetcher completes this step on a scanline the status is fully reset and operation restarts at Step 1.
-		// See: https://ashiepaws.github.io/GBEDG/ppu/#background-pixel-fetching
-		if fetcher.isFirstFetchOfScanline {
-			fetcher.isFirstFetchOfScanline = false
-			fetcher.state = StateGetTile
-		} else {
-			fetcher.state = StatePush
-		}
+		fetcher.state = StatePush
 	case StatePush:
-		if fetcher.counter < 2 {
-			return
-		}
-
 		pushedToFifo := false
 
-		if fetcher.isFetchingSprite {
-			oamIndex := fetcher.spriteIndex
-			spriteX := fetcher.ppu.oam[oamIndex*4+1]
-			spriteFlags := fetcher.ppu.oam[oamIndex*4+3]
-			spriteFlipX := (spriteFlags>>5)&1 == 1
-			var tempBuffer [8]FIFO
-
+		// Note: While fetching background pixels, this step is only executed if the background FIFO is fully empty.
+		// If it is not, this step repeats every cycle until it succeeds.
+		// See: https://ashiepaws.github.io/GBEDG/ppu/#background-pixel-fetching
+		if fetcher.backgroundFifo.size == 0 {
+			attributes := fetcher.fetchedTileAttributes
+			flipX := (attributes>>5)&1 == 1
 			for i := 7; i >= 0; i-- {
 				bit := i
-				if !spriteFlipX {
+				if flipX {
 					bit = 7 - i
 				}
 				lowBit := (fetcher.fetchedTileDataLow >> bit) & 1
 				highBit := (fetcher.fetchedTileDataHigh >> bit) & 1
-				color := (highBit << 1) | lowBit
+				colorId := (highBit << 1) | lowBit
 				pixel := FIFO{
-					colorId:            color,
-					palette:            (spriteFlags >> 4) & 1,
-					backgroundPriority: (spriteFlags >> 7) & 1,
-				}
-				tempBuffer[i] = pixel
-			}
-
-			// only pixels which are actually visible on the screen are loaded into the FIFO
-			// pixels can only be loaded into FIFO slots if there is no pixel in the given slot already
-			// See: https://ashiepaws.github.io/GBEDG/ppu/#sprite-fetching
-			var pixelsToDiscard uint8 = 0
-			if spriteX < 8 {
-				pixelsToDiscard = 8 - spriteX
-			}
-			for i := 0; i <= 7; i++ {
-				if i < int(pixelsToDiscard) {
-					continue
-				}
-
-				fifoIndex := i - int(pixelsToDiscard)
-
-				// if the FIFO is not big enough to hold this pixel we need to expand it
-				if fifoIndex >= fetcher.spriteFifo.size {
-					fetcher.spriteFifo.Push(tempBuffer[i])
-				} else {
-					// if the FIFO already has a pixel in this slot we only overwrite it if
-					// 1. the existing pixel is transparent (color ID 0)
-					// 2. the new pixel is not transparent (color ID not 0)
-					slot := fetcher.spriteFifo.Peek(fifoIndex)
-					if slot.colorId == 0 && tempBuffer[i].colorId != 0 {
-						*slot = tempBuffer[i]
-					}
+					colorId:            colorId,
+					palette:            attributes & 0b111,
+					backgroundPriority: (attributes >> 7) & 1,
 				}
+				fetcher.backgroundFifo.Push(pixel)
 			}
 			fetcher.state = StateGetTile
-			fetcher.isFetchingSprite = false
-			pushedToFifo = true
-		} else {
-			// Note: While fetching background pixels, this step is only executed if the background FIFO is fully empty.
-			// If it is not, this step repeats every cycle until it succeeds.
-			// See: https://ashiepaws.github.io/GBEDG/ppu/#background-pixel-fetching
-			if fetcher.backgroundFifo.size == 0 {
-				for i := 7; i >= 0; i-- {
-					lowBit := (fetcher.fetchedTileDataLow >> i) & 1
-					highBit := (fetcher.fetchedTileDataHigh >> i) & 1
-					colorId := (highBit << 1) | lowBit
-					pixel := FIFO{
-						colorId:            colorId,
-						palette:            0, // only applies to sprites
-						backgroundPriority: 0, // only applies to sprites
-					}
-					fetcher.backgroundFifo.Push(pixel)
-				}
-				fetcher.state = StateGetTile
+			// the first tile of the scanline is shifted out offscreen, the
+			// next one is fetched again. SCX is latched for the fine scroll
+			// as it goes in. The window's tiles are never fetched again.
+			if fetcher.offscreenPixels == 0 || fetcher.isFetchingWindow {
 				fetcher.xPositionCounter++
-				fetcher.isFetchingSprite = false
-				pushedToFifo = true
+			} else {
+				fetcher.pixelsToDiscard = fetcher.ppu.scx % 8
 			}
+			pushedToFifo = true
 		}
 
 		// We only reset the counter if we actually pushed to the FIFO.
@@ -271,118 +324,247 @@ func (fetcher *PixelFetcher) tick() {
 	}
 }
 
-func (fetcher *PixelFetcher) fetchTileData(offset uint16) uint8 {
-	var address uint16
-	if fetcher.isFetchingSprite {
-		// sprites always use 8000 method
-		address = 0x8000
+// pushSprite mixes the fetched sprite's pixels into the sprite FIFO.
+func (fetcher *PixelFetcher) pushSprite() {
+	oamIndex := fetcher.spriteIndex
+	spriteX := fetcher.ppu.oam[oamIndex*4+1]
+	spriteFlags := fetcher.ppu.oam[oamIndex*4+3]
+	spriteFlipX := (spriteFlags>>5)&1 == 1
+	var tempBuffer [8]FIFO
+
+	for i := 7; i >= 0; i-- {
+		bit := i
+		if !spriteFlipX {
+			bit = 7 - i
+		}
+		lowBit := (fetcher.spriteTileDataLow >> bit) & 1
+		highBit := (fetcher.spriteTileDataHigh >> bit) & 1
+		color := (highBit << 1) | lowBit
+		pixel := FIFO{
+			colorId:            color,
+			palette:            (spriteFlags >> 4) & 1,
+			backgroundPriority: (spriteFlags >> 7) & 1,
+			oamIndex:           oamIndex,
+		}
+		if fetcher.ppu.cgb {
+			pixel.palette = spriteFlags & 0b111
+		}
+		tempBuffer[i] = pixel
+	}
 
-		oamIndex := fetcher.spriteIndex
-		spriteY := fetcher.ppu.oam[oamIndex*4]
-		spriteFlags := fetcher.ppu.oam[oamIndex*4+3]
-		spriteTileNumber := fetcher.fetchedTileNumber
-
-		// determine which vertical row of the sprite we are on
-		rowInSprite := (fetcher.ppu.ly + 16) - spriteY
-		isTallSprite := (fetcher.ppu.lcdc>>2)&1 == 1
-		flipY := (spriteFlags>>6)&1 == 1
-
-		// See: https://ashiepaws.github.io/GBEDG/ppu/#lcdc2---sprite-size
-		if isTallSprite {
-			// handle y flipping
-			if flipY {
-				rowInSprite = 15 - rowInSprite
-			}
-			if rowInSprite < 8 {
-				// the top tile, so force LSB to 0
-				spriteTileNumber &= 0b1111_1110
-			} else {
-				// the bottom tile, so force LSB to 1
-				spriteTileNumber |= 0x0000_0001
-				rowInSprite -= 8
-			}
+	// only pixels which are actually visible on the screen are loaded into the FIFO
+	// pixels can only be loaded into FIFO slots if there is no pixel in the given slot already
+	// See: https://ashiepaws.github.io/GBEDG/ppu/#sprite-fetching
+	var pixelsToDiscard uint8 = 0
+	if spriteX < 8 {
+		pixelsToDiscard = 8 - spriteX
+	}
+	for i := 0; i <= 7; i++ {
+		if i < int(pixelsToDiscard) {
+			continue
+		}
+
+		fifoIndex := i - int(pixelsToDiscard)
+
+		// if the FIFO is not big enough to hold this pixel we need to expand it
+		if fifoIndex >= fetcher.spriteFifo.size {
+			fetcher.spriteFifo.Push(tempBuffer[i])
 		} else {
-			// 8x8 Mode
-			// Limit to 3 bits (0-7), so that if a tall sprite was picked during
-			// Mode 2, we wrap correctly within the 8x8 tile.
-			rowInSprite &= 0b0111
-			// handle y flipping
-			if flipY {
-				rowInSprite = 7 - rowInSprite
+			// if the FIFO already has a pixel in this slot we only overwrite it if
+			// 1. the existing pixel is transparent (color ID 0)
+			// 2. the new pixel is not transparent (color ID not 0)
+			// or, when the CGB prioritizes by OAM index, if the new pixel is not
+			// transparent and comes first in OAM
+			slot := fetcher.spriteFifo.Peek(fifoIndex)
+			if tempBuffer[i].colorId == 0 {
+				continue
+			}
+			if slot.colorId == 0 {
+				*slot = tempBuffer[i]
+			} else if fetcher.ppu.cgb && fetcher.ppu.opri == 0 && tempBuffer[i].oamIndex < slot.oamIndex {
+				*slot = tempBuffer[i]
 			}
 		}
+	}
+}
 
-		address += uint16(spriteTileNumber) * 16
-		address += uint16(rowInSprite) * 2
+func (fetcher *PixelFetcher) fetchTileData(offset uint16) uint8 {
+	var address uint16
+	var bank uint8
+	// 8000 method
+	if (fetcher.ppu.lcdc>>4)&1 == 1 {
+		address = 0x8000
+		address += uint16(fetcher.fetchedTileNumber) * 16
+	} else
+	// 8800 method
+	{
+		address = 0x9000
+		address += uint16(int16(int8(fetcher.fetchedTileNumber))) * 16
+	}
+	// get the row offset
+	var row uint8
+	if fetcher.isFetchingWindow {
+		row = fetcher.windowLineCounter % 8
 	} else {
-		// 8000 method
-		if (fetcher.ppu.lcdc>>4)&1 == 1 {
-			address = 0x8000
-			address += uint16(fetcher.fetchedTileNumber) * 16
-		} else
-		// 8800 method
-		{
-			address = 0x9000
-			address += uint16(int16(int8(fetcher.fetchedTileNumber))) * 16
+		row = (fetcher.ppu.ly + fetcher.ppu.scy) % 8
+	}
+	// handle y flipping, CGB only
+	if (fetcher.fetchedTileAttributes>>6)&1 == 1 {
+		row = 7 - row
+	}
+	address += uint16(2 * row)
+
+	bank = (fetcher.fetchedTileAttributes >> 3) & 1
+
+	address += offset
+
+	return fetcher.ppu.videoRam[bank][address-0x8000]
+}
+
+func (fetcher *PixelFetcher) fetchSpriteTileData(offset uint16) uint8 {
+	var address uint16
+	var bank uint8
+	// sprites always use 8000 method
+	address = 0x8000
+
+	oamIndex := fetcher.spriteIndex
+	spriteY := fetcher.ppu.oam[oamIndex*4]
+	spriteFlags := fetcher.ppu.oam[oamIndex*4+3]
+	spriteTileNumber := fetcher.spriteTileNumber
+
+	// determine which vertical row of the sprite we are on
+	rowInSprite := (fetcher.ppu.ly + 16) - spriteY
+	isTallSprite := (fetcher.ppu.lcdc>>2)&1 == 1
+	flipY := (spriteFlags>>6)&1 == 1
+
+	// See: https://ashiepaws.github.io/GBEDG/ppu/#lcdc2---sprite-size
+	if isTallSprite {
+		// handle y flipping
+		if flipY {
+			rowInSprite = 15 - rowInSprite
 		}
-		// get the row offset
-		if fetcher.isFetchingWindow {
-			address += uint16(2 * (fetcher.windowLineCounter % 8))
+		if rowInSprite < 8 {
+			// the top tile, so force LSB to 0
+			spriteTileNumber &= 0b1111_1110
 		} else {
-			address += uint16(2 * ((fetcher.ppu.ly + fetcher.ppu.scy) % 8))
+			// the bottom tile, so force LSB to 1
+			spriteTileNumber |= 0x0000_0001
+			rowInSprite -= 8
+		}
+	} else {
+		// 8x8 Mode
+		// Limit to 3 bits (0-7), so that if a tall sprite was picked during
+		// Mode 2, we wrap correctly within the 8x8 tile.
+		rowInSprite &= 0b0111
+		// handle y flipping
+		if flipY {
+			rowInSprite = 7 - rowInSprite
 		}
 	}
 
+	address += uint16(spriteTileNumber) * 16
+	address += uint16(rowInSprite) * 2
+
+	if fetcher.ppu.cgb {
+		bank = (spriteFlags >> 3) & 1
+	}
+
 	address += offset
 
-	return fetcher.ppu.videoRam[address-0x8000]
+	return fetcher.ppu.videoRam[bank][address-0x8000]
 }
 
 func (fetcher *PixelFetcher) attemptToPushPixel() {
-	if fetcher.currentX == 160 {
-		fetcher.ppu.changeMode(HorizontalBlank)
+	// do nothing if the FIFO is empty
+	if fetcher.backgroundFifo.size == 0 {
 		return
 	}
 
-	// do nothing if the FIFO is empty
-	if fetcher.backgroundFifo.size == 0 {
+	// The first 8 pixels are left of the screen. Then SCX % 8 pixels are
+	// discarded for the fine scroll, 1 every dot.
+	if fetcher.offscreenPixels > 0 {
+		fetcher.backgroundFifo.Pop()
+		fetcher.offscreenPixels--
 		return
 	}
+	if fetcher.pixelsToDiscard > 0 {
+		fetcher.backgroundFifo.Pop()
+		fetcher.pixelsToDiscard--
+		return
+	}
+
+	// otherwise send it to the LCD
+	pixel := lcdPixel{
+		background: fetcher.backgroundFifo.Pop(),
+		valid:      true,
+	}
+	if fetcher.spriteFifo.size > 0 {
+		pixel.sprite = fetcher.spriteFifo.Pop()
+		pixel.hasSprite = true
+	}
+	// the first pixel of the scanline only samples LCDC when it's drawn
+	if fetcher.currentX > 0 {
+		fetcher.applyLayerEnables(&pixel)
+	}
+	fetcher.lcdPipeline[lcdLatency-1] = pixel
+	fetcher.currentX++
 
-	// handle background scrolling penalty: this occurs in chunks of 4 T-cycles (1 M-cycle)
-	if fetcher.backgroundScrollingPenalty > 0 {
-		fetcher.backgroundScrollingPenalty--
+	// mode 3 ends as soon as the last pixel leaves the FIFO
+	if fetcher.currentX == 160 {
+		fetcher.ppu.changeMode(HorizontalBlank)
+	}
+}
 
-		if fetcher.pixelsToDiscard > 0 {
-			fetcher.backgroundFifo.Pop()
-			fetcher.pixelsToDiscard--
+// stepLcd draws the oldest pixel in the LCD pipeline, if any, and moves the
+// others along. It runs every dot of mode 3, and in mode 0 until the last
+// pixels of the scanline are drawn.
+func (fetcher *PixelFetcher) stepLcd() {
+	if fetcher.lcdPipeline[0].valid {
+		if fetcher.lcdX == 0 {
+			fetcher.applyLayerEnables(&fetcher.lcdPipeline[0])
 		}
+		if fetcher.ppu.cgb {
+			fetcher.drawCgbPixel(fetcher.lcdPipeline[0])
+		} else {
+			fetcher.drawPixel(fetcher.lcdPipeline[0])
+		}
+	}
+	copy(fetcher.lcdPipeline[:], fetcher.lcdPipeline[1:])
+	fetcher.lcdPipeline[lcdLatency-1] = lcdPixel{}
+}
+
+// applyLayerEnables applies the DMG's BG and OBJ enable bits of LCDC to a
+// pixel. Unlike the palettes, they're sampled as the pixel leaves the FIFOs,
+// so writes to them take effect 1 pixel later. The CGB applies them when
+// mixing, see drawCgbPixel.
+func (fetcher *PixelFetcher) applyLayerEnables(pixel *lcdPixel) {
+	if fetcher.ppu.cgb {
 		return
 	}
+	// the background is drawn with color ID 0, and sprites always go over it
+	if (fetcher.ppu.lcdc>>0)&1 == 0 {
+		pixel.background.colorId = 0
+	}
+	if (fetcher.ppu.lcdc>>1)&1 == 0 {
+		pixel.hasSprite = false
+	}
+}
 
-	// otherwise add to the framebuffer
-	backgroundPixel := fetcher.backgroundFifo.Pop()
+func (fetcher *PixelFetcher) drawPixel(pixel lcdPixel) {
 	var color uint8
 
 	// use the background pixel's color as the default
-	colorId := backgroundPixel.colorId
-	// if the background is disabled, we always use color ID 0
-	bgEnabled := (fetcher.ppu.lcdc>>0)&1 == 1
-	if !bgEnabled {
-		colorId = 0
-	}
+	colorId := pixel.background.colorId
 	// color IDs are 2 bits, so we shift times 2, then mask 2 bits for the final color/shade
 	color = (fetcher.ppu.bgp >> (colorId * 2)) & 0b11
 
 	// See: https://ashiepaws.github.io/GBEDG/ppu/#pixel-mixing
-	var spritePixel FIFO
-	if fetcher.spriteFifo.size > 0 {
-		spritePixel = fetcher.spriteFifo.Pop()
+	if pixel.hasSprite {
+		spritePixel := pixel.sprite
 		spriteIsTransparent := spritePixel.colorId == 0
-		backgroundHasPriority := spritePixel.backgroundPriority == 1 && backgroundPixel.colorId != 0
-		objEnabled := (fetcher.ppu.lcdc>>1)&1 == 1
+		backgroundHasPriority := spritePixel.backgroundPriority == 1 && pixel.background.colorId != 0
 
-		if !spriteIsTransparent && !backgroundHasPriority && objEnabled {
+		if !spriteIsTransparent && !backgroundHasPriority {
 			colorId := spritePixel.colorId
 			if spritePixel.palette == 0 {
 				color = (fetcher.ppu.obp0 >> (colorId * 2)) & 0b11
@@ -392,8 +574,38 @@ func (fetcher *PixelFetcher) attemptToPushPixel() {
 		}
 	}
 
-	fetcher.ppu.frameBuffer[fetcher.ppu.ly][fetcher.currentX] = color
-	fetcher.currentX++
+	fetcher.ppu.frameBuffer[fetcher.ppu.ly][fetcher.lcdX] = color
+	fetcher.ppu.colorFrameBuffer[fetcher.ppu.ly][fetcher.lcdX] = fetcher.ppu.shadeColor(color)
+	fetcher.lcdX++
+}
+
+// drawCgbPixel mixes the background pixel with the sprite pixel, if any, using
+// the CGB's color palettes and priorities.
+// See: https://gbdev.io/pandocs/Tile_Maps.html#bg-to-obj-priority-in-cgb-mode
+func (fetcher *PixelFetcher) drawCgbPixel(pixel lcdPixel) {
+	ppu := fetcher.ppu
+	backgroundPixel := pixel.background
+	color := paletteColor(&ppu.bgPaletteRam, backgroundPixel.palette, backgroundPixel.colorId)
+
+	if pixel.hasSprite {
+		spritePixel := pixel.sprite
+		spriteIsTransparent := spritePixel.colorId == 0
+		objEnabled := (ppu.lcdc>>1)&1 == 1
+		// on the CGB, LCDC bit 0 doesn't disable the background, instead it
+		// removes the background's priority over sprites
+		bgMasterPriority := (ppu.lcdc>>0)&1 == 1
+		backgroundHasPriority := bgMasterPriority &&
+			backgroundPixel.colorId != 0 &&
+			(backgroundPixel.backgroundPriority == 1 || spritePixel.backgroundPriority == 1)
+
+		if !spriteIsTransparent && !backgroundHasPriority && objEnabled {
+			color = paletteColor(&ppu.objPaletteRam, spritePixel.palette, spritePixel.colorId)
+		}
+	}
+
+	ppu.colorFrameBuffer[ppu.ly][fetcher.lcdX] = ppu.lcdColor(color)
+	ppu.frameBuffer[ppu.ly][fetcher.lcdX] = rgb555ToShade(color)
+	fetcher.lcdX++
 }
 
 func (fetcher *PixelFetcher) Serialize(buf []byte) int {
@@ -406,6 +618,8 @@ func (fetcher *PixelFetcher) Serialize(buf []byte) int {
 
 	buf[offset] = fetcher.fetchedTileNumber
 	offset++
+	buf[offset] = fetcher.fetchedTileAttributes
+	offset++
 	buf[offset] = fetcher.fetchedTileDataLow
 	offset++
 	buf[offset] = fetcher.fetchedTileDataHigh
@@ -414,8 +628,6 @@ func (fetcher *PixelFetcher) Serialize(buf []byte) int {
 	offset++
 	buf[offset] = fetcher.pixelsToDiscard
 	offset++
-	buf[offset] = fetcher.backgroundScrollingPenalty
-	offset++
 
 	if fetcher.isFetchingWindow {
 		buf[offset] = 1
@@ -427,11 +639,7 @@ func (fetcher *PixelFetcher) Serialize(buf []byte) int {
 	offset++
 	buf[offset] = fetcher.windowLineCounter
 	offset++
-	if fetcher.isFirstFetchOfScanline {
-		buf[offset] = 1
-	} else {
-		buf[offset] = 0
-	}
+	buf[offset] = fetcher.offscreenPixels
 	offset++
 	if fetcher.scanlineHadWindowPixels {
 		buf[offset] = 1
@@ -457,6 +665,34 @@ func (fetcher *PixelFetcher) Serialize(buf []byte) int {
 	offset += fetcher.backgroundFifo.Serialize(buf[offset:])
 	offset += fetcher.spriteFifo.Serialize(buf[offset:])
 
+	for _, pixel := range fetcher.lcdPipeline {
+		offset += pixel.background.serialize(buf[offset:])
+		offset += pixel.sprite.serialize(buf[offset:])
+		if pixel.hasSprite {
+			buf[offset] = 1
+		} else {
+			buf[offset] = 0
+		}
+		offset++
+		if pixel.valid {
+			buf[offset] = 1
+		} else {
+			buf[offset] = 0
+		}
+		offset++
+	}
+	buf[offset] = fetcher.lcdX
+	offset++
+
+	buf[offset] = fetcher.spriteCounter
+	offset++
+	buf[offset] = fetcher.spriteTileNumber
+	offset++
+	buf[offset] = fetcher.spriteTileDataLow
+	offset++
+	buf[offset] = fetcher.spriteTileDataHigh
+	offset++
+
 	return offset
 }
 
@@ -470,6 +706,8 @@ func (fetcher *PixelFetcher) Deserialize(buf []byte) int {
 
 	fetcher.fetchedTileNumber = buf[offset]
 	offset++
+	fetcher.fetchedTileAttributes = buf[offset]
+	offset++
 	fetcher.fetchedTileDataLow = buf[offset]
 	offset++
 	fetcher.fetchedTileDataHigh = buf[offset]
@@ -478,8 +716,6 @@ func (fetcher *PixelFetcher) Deserialize(buf []byte) int {
 	offset++
 	fetcher.pixelsToDiscard = buf[offset]
 	offset++
-	fetcher.backgroundScrollingPenalty = buf[offset]
-	offset++
 
 	fetcher.isFetchingWindow = buf[offset] == 1
 	offset++
@@ -487,7 +723,7 @@ func (fetcher *PixelFetcher) Deserialize(buf []byte) int {
 	offset++
 	fetcher.windowLineCounter = buf[offset]
 	offset++
-	fetcher.isFirstFetchOfScanline = buf[offset] == 1
+	fetcher.offscreenPixels = buf[offset]
 	offset++
 	fetcher.scanlineHadWindowPixels = buf[offset] == 1
 	offset++
@@ -501,5 +737,26 @@ func (fetcher *PixelFetcher) Deserialize(buf []byte) int {
 	offset += fetcher.backgroundFifo.Deserialize(buf[offset:])
 	offset += fetcher.spriteFifo.Deserialize(buf[offset:])
 
+	for i := range fetcher.lcdPipeline {
+		pixel := &fetcher.lcdPipeline[i]
+		offset += pixel.background.deserialize(buf[offset:])
+		offset += pixel.sprite.deserialize(buf[offset:])
+		pixel.hasSprite = buf[offset] == 1
+		offset++
+		pixel.valid = buf[offset] == 1
+		offset++
+	}
+	fetcher.lcdX = buf[offset]
+	offset++
+
+	fetcher.spriteCounter = buf[offset]
+	offset++
+	fetcher.spriteTileNumber = buf[offset]
+	offset++
+	fetcher.spriteTileDataLow = buf[offset]
+	offset++
+	fetcher.spriteTileDataHigh = buf[offset]
+	offset++
+
 	return offset
 }
@@ -8,9 +8,30 @@ const bufferMask = fifoSize - 1
 type FIFO struct {
 	// 4 possible colors
 	colorId uint8
-	// only applies to objects (sprites)
+	// objects: OBP0/OBP1 on the DMG, or the color palette on the CGB
+	// background: the color palette from the BG map attributes (CGB only)
 	palette            uint8
 	backgroundPriority uint8
+	// only applies to objects, decides which object is drawn on top on the CGB
+	oamIndex uint8
+}
+
+func (p *FIFO) serialize(buf []byte) int {
+	buf[0] = p.colorId
+	buf[1] = p.palette
+	buf[2] = p.backgroundPriority
+	buf[3] = p.oamIndex
+
+	return 4
+}
+
+func (p *FIFO) deserialize(buf []byte) int {
+	p.colorId = buf[0]
+	p.palette = buf[1]
+	p.backgroundPriority = buf[2]
+	p.oamIndex = buf[3]
+
+	return 4
 }
 
 type PixelFifo struct {
@@ -48,12 +69,7 @@ func (f *PixelFifo) Serialize(buf []byte) int {
 	offset := 0
 
 	for i := range len(f.buffer) {
-		buf[offset] = f.buffer[i].colorId
-		offset++
-		buf[offset] = f.buffer[i].palette
-		offset++
-		buf[offset] = f.buffer[i].backgroundPriority
-		offset++
+		offset += f.buffer[i].serialize(buf[offset:])
 	}
 
 	binary.LittleEndian.PutUint64(buf[offset:], uint64(f.head))
@@ -70,12 +86,7 @@ func (f *PixelFifo) Deserialize(buf []byte) int {
 	offset := 0
 
 	for i := range len(f.buffer) {
-		f.buffer[i].colorId = buf[offset]
-		offset++
-		f.buffer[i].palette = buf[offset]
-		offset++
-		f.buffer[i].backgroundPriority = buf[offset]
-		offset++
+		offset += f.buffer[i].deserialize(buf[offset:])
 	}
 
 	f.head = int(binary.LittleEndian.Uint64(buf[offset:]))
@@ -7,6 +7,7 @@ import (
 	"github.com/davidyorr/LuccaGB/internal/debug"
 	"github.com/davidyorr/LuccaGB/internal/interrupt"
 	"github.com/davidyorr/LuccaGB/internal/logger"
+	"github.com/davidyorr/LuccaGB/internal/model"
 )
 
 type PPU struct {
@@ -17,7 +18,11 @@ type PPU struct {
 	//	Block 1 - 0x8800 - 0x8FFF
 	//	Block 2 - 0x9000 - 0x97FF
 	//	Each block contains 384 tiles, each 16 bytes
-	videoRam [8192]uint8
+	//	The CGB has a second bank, selected by VBK. In bank 1 the tile maps
+	//	hold the BG map attributes of the tiles in bank 0.
+	videoRam [2][8192]uint8
+	// 0xFF4F - VBK: VRAM bank (CGB only)
+	vbk uint8
 	// 0xFE00 - 0xFE9F - Object Attribute Memory
 	//	40 sprites (objects), each 4 bytes long
 	//	Byte 0 — Y Position
@@ -63,7 +68,24 @@ type PPU struct {
 	// 0xFF48 - OBP0: Object palette 0 data
 	obp0 uint8
 	// 0xFF49 - OBP1: Object palette 1 data
-	obp1                           uint8
+	obp1 uint8
+	// 0xFF68 - BCPS: Background color palette specification (CGB only)
+	//	7 - Auto increment after writing BCPD
+	//	5-0 - Address in the background palette RAM
+	bcps uint8
+	// 0xFF69 - BCPD: Background color palette data (CGB only)
+	//	8 palettes of 4 colors, each color is 2 bytes of RGB555, little endian
+	bgPaletteRam [64]uint8
+	// 0xFF6A - OCPS: Object color palette specification (CGB only)
+	ocps uint8
+	// 0xFF6B - OCPD: Object color palette data (CGB only)
+	objPaletteRam [64]uint8
+	// 0xFF6C - OPRI: Object priority mode (CGB only)
+	//	0 - by OAM index, the CGB's own mode
+	//	1 - by X coordinate, as on the DMG
+	opri uint8
+	// whether the PPU runs in CGB mode, with colour palettes and BG attributes
+	cgb                            bool
 	mode                           Mode
 	previousStatInterruptLineState bool
 	statOrModeChanged              bool
@@ -72,6 +94,15 @@ type PPU struct {
 	frameBuffer        [144][160]uint8
 	interruptRequester func(interruptType interrupt.Interrupt)
 	dot                uint16
+	// the same frame in RGB555, in both DMG and CGB mode
+	colorFrameBuffer [144][160]uint16
+	// the model's screen decides how the frame's colors look
+	model model.Model
+	// the logo in the loaded ROM's header
+	logo [48]uint8
+	// a palette write the LCD only sees fully 1 dot later, see writePalette
+	pendingPaletteAddress uint16
+	pendingPaletteValue   uint8
 }
 
 func New(interruptRequest func(interrupt.Interrupt)) *PPU {
@@ -96,6 +127,69 @@ func (ppu *PPU) Reset() {
 	ppu.bgp = 0xFC
 	ppu.mode = OamScan
 	ppu.dot = 0
+
+	ppu.vbk = 0x00
+	ppu.bcps = 0x00
+	ppu.ocps = 0x00
+	ppu.opri = 0x00
+	// the CGB boot ROM leaves every palette white
+	for i := range ppu.bgPaletteRam {
+		ppu.bgPaletteRam[i] = 0xFF
+		ppu.objPaletteRam[i] = 0xFF
+	}
+
+	// the boot ROM clears VRAM before drawing the logo
+	ppu.videoRam = [2][8192]uint8{}
+	ppu.drawBootLogo()
+}
+
+// ColdReset puts the PPU in its power on state, with the LCD off, for a boot
+// ROM to run.
+func (ppu *PPU) ColdReset() {
+	ppu.Reset()
+	ppu.lcdc = 0x00
+	ppu.stat = 0x84
+	ppu.bgp = 0x00
+	ppu.mode = HorizontalBlank
+	ppu.videoRam = [2][8192]uint8{}
+}
+
+// Stop blanks the screen when the CPU enters STOP mode, which stops the PPU's
+// clock. The DMG family's LCD turns white, the CGB family's black. It reports
+// whether there's a blank frame to show.
+func (ppu *PPU) Stop() (frameReady bool) {
+	if !ppu.lcdEnabled() {
+		return false
+	}
+
+	shade := uint8(0)
+	color := ppu.shadeColor(0)
+	if ppu.model.IsCgbFamily() {
+		shade = 3
+		color = 0x0000
+	}
+	for y := range ppu.frameBuffer {
+		for x := range ppu.frameBuffer[y] {
+			ppu.frameBuffer[y][x] = shade
+			ppu.colorFrameBuffer[y][x] = color
+		}
+	}
+
+	return true
+}
+
+// SetCgbMode switches between DMG and CGB mode, keeping the PPU's state.
+func (ppu *PPU) SetCgbMode(cgb bool) {
+	ppu.cgb = cgb
+}
+
+// SetModel selects the model whose screen the colored frame imitates.
+func (ppu *PPU) SetModel(model model.Model) {
+	ppu.model = model
+}
+
+func (ppu *PPU) CgbMode() bool {
+	return ppu.cgb
 }
 
 // 1 dot = T-cycle
@@ -116,8 +210,14 @@ func (ppu *PPU) Step() (frameReady bool) {
 	// turned on. A STAT interrupt will be triggered by a rising edge (transition
 	// from low to high) on the STAT interrupt line.
 	// See: https://gbdev.io/pandocs/Interrupt_Sources.html#int-48--stat-interrupt
+	// the OAM scan interrupt of lines 1-143 fires 1 dot before STAT switches
+	// to mode 2
+	if ppu.earlyOam() {
+		ppu.statOrModeChanged = true
+	}
+
 	if ppu.statOrModeChanged {
-		idx := int(ppu.mode)<<8 | int(ppu.stat)
+		idx := int(ppu.interruptMode())<<8 | int(ppu.stat)
 		current := (statInterruptBitset[idx>>6]>>uint(idx&63))&1 == 1
 
 		// Check for a rising edge
@@ -148,13 +248,12 @@ func (ppu *PPU) Step() (frameReady bool) {
 				if ppu.spriteBuffer.size < MaxSpriteBufferSize {
 					oamIndex := ppu.dot / 2
 					spriteY := ppu.oam[oamIndex*4]
-					spriteX := ppu.oam[oamIndex*4+1]
 					var height uint8 = 8
 					if ((ppu.lcdc & 0b0000_0100) >> 2) == 1 {
 						height = 16
 					}
 					// See: https://ashiepaws.github.io/GBEDG/ppu/#oam-scan-mode-2
-					if ppu.ly+16 >= spriteY && ppu.ly+16 < spriteY+height && spriteX > 0 {
+					if ppu.ly+16 >= spriteY && ppu.ly+16 < spriteY+height {
 						ppu.spriteBuffer.Push(uint8(oamIndex))
 					}
 				}
@@ -164,6 +263,8 @@ func (ppu *PPU) Step() (frameReady bool) {
 			ppu.pixelFetcher.step()
 		// Mode 0
 		case HorizontalBlank:
+			// the LCD draws the scanline's last pixels
+			ppu.pixelFetcher.stepLcd()
 			// "pads" the duration of the scanline to a total of 456
 		}
 	}
@@ -207,6 +308,8 @@ func (ppu *PPU) Step() (frameReady bool) {
 		ppu.updateLycCoincidenceFlag()
 	}
 
+	ppu.applyPendingPaletteWrite()
+
 	return frameReady
 }
 
@@ -234,10 +337,41 @@ func (ppu *PPU) Read(address uint16) uint8 {
 		return ppu.wy
 	case address == 0xFF4B:
 		return ppu.wx
+	// CGB registers
+	case address == 0xFF4F:
+		if !ppu.cgb {
+			return 0xFF
+		}
+		return ppu.vbk | 0b1111_1110
+	case address == 0xFF68:
+		if !ppu.cgb {
+			return 0xFF
+		}
+		return ppu.bcps | 0b0100_0000
+	case address == 0xFF69:
+		if !ppu.cgb || !ppu.vramIsAccessible() {
+			return 0xFF
+		}
+		return ppu.bgPaletteRam[ppu.bcps&0b0011_1111]
+	case address == 0xFF6A:
+		if !ppu.cgb {
+			return 0xFF
+		}
+		return ppu.ocps | 0b0100_0000
+	case address == 0xFF6B:
+		if !ppu.cgb || !ppu.vramIsAccessible() {
+			return 0xFF
+		}
+		return ppu.objPaletteRam[ppu.ocps&0b0011_1111]
+	case address == 0xFF6C:
+		if !ppu.cgb {
+			return 0xFF
+		}
+		return ppu.opri | 0b1111_1110
 	// VRAM
 	case address >= 0x8000 && address <= 0x9FFF:
 		if ppu.vramIsAccessible() {
-			return ppu.videoRam[address-0x8000]
+			return ppu.videoRam[ppu.vbk][address-0x8000]
 		}
 		return 0xFF
 	// OAM
@@ -264,6 +398,10 @@ func (ppu *PPU) Write(address uint16, value uint8) {
 		ppu.lcdc = value
 		lcdIsEnabled := ppu.lcdEnabled()
 
+		if !ppu.cgb && ppu.mode == DrawingPixels && (value>>1)&1 == 0 {
+			ppu.pixelFetcher.abortSpriteFetch()
+		}
+
 		// LCD ON -> LCD OFF
 		if lcdWasEnabled && !lcdIsEnabled {
 			ppu.ly = 0
@@ -277,6 +415,8 @@ func (ppu *PPU) Write(address uint16, value uint8) {
 		if !lcdWasEnabled && lcdIsEnabled {
 			ppu.changeMode(OamScan)
 			ppu.updateLycCoincidenceFlag()
+			// the first line after turning the LCD on is 5 dots shorter
+			ppu.dot = 5
 		}
 	case address == 0xFF41:
 		ppu.stat = (ppu.stat & 0b1000_0111) | (value & 0b0111_1000)
@@ -289,19 +429,44 @@ func (ppu *PPU) Write(address uint16, value uint8) {
 		ppu.lyc = value
 		ppu.updateLycCoincidenceFlag()
 	case address == 0xFF47:
-		ppu.bgp = value
+		ppu.writePalette(address, &ppu.bgp, value)
 	case address == 0xFF48:
-		ppu.obp0 = value
+		ppu.writePalette(address, &ppu.obp0, value)
 	case address == 0xFF49:
-		ppu.obp1 = value
+		ppu.writePalette(address, &ppu.obp1, value)
 	case address == 0xFF4A:
 		ppu.wy = value
 	case address == 0xFF4B:
 		ppu.wx = value
+	// CGB registers
+	case address == 0xFF4F:
+		if ppu.cgb {
+			ppu.vbk = value & 0b1
+		}
+	case address == 0xFF68:
+		if ppu.cgb {
+			ppu.bcps = value & 0b1011_1111
+		}
+	case address == 0xFF69:
+		if ppu.cgb {
+			writePaletteData(&ppu.bgPaletteRam, &ppu.bcps, value, ppu.vramIsAccessible())
+		}
+	case address == 0xFF6A:
+		if ppu.cgb {
+			ppu.ocps = value & 0b1011_1111
+		}
+	case address == 0xFF6B:
+		if ppu.cgb {
+			writePaletteData(&ppu.objPaletteRam, &ppu.ocps, value, ppu.vramIsAccessible())
+		}
+	case address == 0xFF6C:
+		if ppu.cgb {
+			ppu.opri = value & 0b1
+		}
 	// VRAM
 	case address >= 0x8000 && address <= 0x9FFF:
 		if ppu.vramIsAccessible() {
-			ppu.videoRam[address-0x8000] = value
+			ppu.videoRam[ppu.vbk][address-0x8000] = value
 		}
 	// OAM
 	case address >= 0xFE00 && address <= 0xFE9F:
@@ -313,11 +478,109 @@ func (ppu *PPU) Write(address uint16, value uint8) {
 	}
 }
 
+// writePaletteData writes to the palette RAM at the address held by the
+// specification register. Writes are ignored while the PPU is drawing, but
+// the address is still incremented.
+// See: https://gbdev.io/pandocs/Palettes.html#ff68--bcpsbgpi-cgb-mode-only-background-color-palette-specification--background-palette-index
+func writePaletteData(paletteRam *[64]uint8, specification *uint8, value uint8, accessible bool) {
+	address := *specification & 0b0011_1111
+	if accessible {
+		paletteRam[address] = value
+	}
+
+	// auto increment
+	if *specification&0b1000_0000 != 0 {
+		*specification = 0b1000_0000 | ((address + 1) & 0b0011_1111)
+	}
+}
+
+// paletteColor returns a color from a palette RAM in RGB555
+func paletteColor(paletteRam *[64]uint8, palette uint8, colorId uint8) uint16 {
+	address := palette*8 + colorId*2
+	return uint16(paletteRam[address]) | uint16(paletteRam[address+1]&0b0111_1111)<<8
+}
+
+// dmgColors are the RGB555 equivalents of the 4 DMG shades
+var dmgColors = [4]uint16{0x7FFF, 0x56B5, 0x294A, 0x0000}
+
+// the DMG's screen is green, the MGB's is a slightly warm grey. The SGB and
+// the CGB in DMG mode show greys, unless a palette is set.
+var (
+	dmgLcdColors = [4]uint16{rgb555(0xC5, 0xCA, 0xA4), rgb555(0x8C, 0x92, 0x6B), rgb555(0x4A, 0x51, 0x38), rgb555(0x18, 0x18, 0x18)}
+	mgbLcdColors = [4]uint16{rgb555(0xD8, 0xD8, 0xC0), rgb555(0xA0, 0xA0, 0x88), rgb555(0x60, 0x60, 0x50), rgb555(0x20, 0x20, 0x18)}
+)
+
+func rgb555(r, g, b uint8) uint16 {
+	return uint16(r>>3) | uint16(g>>3)<<5 | uint16(b>>3)<<10
+}
+
+// shadeColor returns the color of a DMG shade on the model's screen
+func (ppu *PPU) shadeColor(shade uint8) uint16 {
+	switch ppu.model {
+	case model.Dmg, model.Dmg0:
+		return dmgLcdColors[shade]
+	case model.Mgb:
+		return mgbLcdColors[shade]
+	}
+
+	return dmgColors[shade]
+}
+
+// lcdColor returns how a CGB palette color looks on the model's screen. The
+// CGB's screen bleeds the channels into each other, the AGB's much less so.
+func (ppu *PPU) lcdColor(color uint16) uint16 {
+	r := color & 0b1_1111
+	g := (color >> 5) & 0b1_1111
+	b := (color >> 10) & 0b1_1111
+
+	var red, green, blue uint16
+	if ppu.model == model.Agb {
+		red = (r*14 + g + b) / 16
+		green = (r + g*14 + b) / 16
+		blue = (r + g + b*14) / 16
+	} else {
+		red = (r*13 + g*2 + b) / 16
+		green = (g*3 + b) / 4
+		blue = (r*3 + g*2 + b*11) / 16
+	}
+
+	return red | green<<5 | blue<<10
+}
+
+// rgb555ToShade converts a color to the nearest of the 4 DMG shades, using
+// its luminance
+func rgb555ToShade(color uint16) uint8 {
+	r := uint16(color & 0b1_1111)
+	g := uint16((color >> 5) & 0b1_1111)
+	b := uint16((color >> 10) & 0b1_1111)
+	luminance := (r*3 + g*6 + b) / 10
+
+	return 3 - uint8(luminance*4/32)
+}
+
 // to give direct access to the DMA
 func (ppu *PPU) WriteOam(address uint16, value uint8) {
 	ppu.oam[address-0xFE00] = value
 }
 
+// to give the CGB VRAM DMA direct access to the selected VRAM bank
+func (ppu *PPU) WriteVram(address uint16, value uint8) {
+	ppu.videoRam[ppu.vbk][address-0x8000] = value
+}
+
+// to give the SGB direct access to the tiles of a VRAM transfer
+func (ppu *PPU) ReadVram(address uint16) uint8 {
+	return ppu.videoRam[0][address-0x8000]
+}
+
+func (ppu *PPU) Lcdc() uint8 {
+	return ppu.lcdc
+}
+
+func (ppu *PPU) LcdEnabled() bool {
+	return ppu.lcdEnabled()
+}
+
 func (ppu *PPU) Mode() Mode {
 	return ppu.mode
 }
@@ -354,6 +617,47 @@ func (ppu *PPU) initStatInterruptLookup() {
 	}
 }
 
+// earlyOam reports whether the OAM scan interrupt of the line is already
+// driving the STAT interrupt line while the mode is still 0.
+func (ppu *PPU) earlyOam() bool {
+	return ppu.dot == 0 && ppu.ly > 0 && ppu.ly < 144
+}
+
+// writePalette writes BGP, OBP0 or OBP1. On the DMG family the LCD sees the
+// old and the new value ORed together for 1 dot, before the new value.
+func (ppu *PPU) writePalette(address uint16, palette *uint8, value uint8) {
+	if ppu.model.IsCgbFamily() || !ppu.lcdEnabled() {
+		*palette = value
+		return
+	}
+
+	*palette |= value
+	ppu.pendingPaletteAddress = address
+	ppu.pendingPaletteValue = value
+}
+
+// applyPendingPaletteWrite finishes the palette write of the previous dot.
+func (ppu *PPU) applyPendingPaletteWrite() {
+	switch ppu.pendingPaletteAddress {
+	case 0xFF47:
+		ppu.bgp = ppu.pendingPaletteValue
+	case 0xFF48:
+		ppu.obp0 = ppu.pendingPaletteValue
+	case 0xFF49:
+		ppu.obp1 = ppu.pendingPaletteValue
+	}
+	ppu.pendingPaletteAddress = 0
+}
+
+// interruptMode returns the mode which drives the STAT interrupt line.
+func (ppu *PPU) interruptMode() Mode {
+	if ppu.mode == HorizontalBlank && ppu.earlyOam() {
+		return OamScan
+	}
+
+	return ppu.mode
+}
+
 func (ppu *PPU) changeMode(mode Mode) {
 	ppu.mode = mode
 	ppu.stat = (ppu.stat & 0b1111_1100) | uint8(mode)
@@ -392,6 +696,12 @@ func (ppu *PPU) FrameBuffer() [144][160]uint8 {
 	return ppu.frameBuffer
 }
 
+// ColorFrameBuffer returns the frame in RGB555: bits 0-4 red, 5-9 green and
+// 10-14 blue.
+func (ppu *PPU) ColorFrameBuffer() [144][160]uint16 {
+	return ppu.colorFrameBuffer
+}
+
 // Average Pooling (Box Sampling)
 func (ppu *PPU) FrameBufferDownsampled() [72][80]uint8 {
 	var dst [72][80]uint8
@@ -418,7 +728,9 @@ func (ppu *PPU) FrameBufferDownsampled() [72][80]uint8 {
 func (ppu *PPU) Serialize(buf []byte) int {
 	offset := 0
 
-	n := copy(buf[offset:], ppu.videoRam[:])
+	n := copy(buf[offset:], ppu.videoRam[0][:])
+	offset += n
+	n = copy(buf[offset:], ppu.videoRam[1][:])
 	offset += n
 
 	n = copy(buf[offset:], ppu.oam[:])
@@ -447,6 +759,25 @@ func (ppu *PPU) Serialize(buf []byte) int {
 	buf[offset] = ppu.obp1
 	offset++
 
+	if ppu.cgb {
+		buf[offset] = 1
+	} else {
+		buf[offset] = 0
+	}
+	offset++
+	buf[offset] = ppu.vbk
+	offset++
+	buf[offset] = ppu.bcps
+	offset++
+	n = copy(buf[offset:], ppu.bgPaletteRam[:])
+	offset += n
+	buf[offset] = ppu.ocps
+	offset++
+	n = copy(buf[offset:], ppu.objPaletteRam[:])
+	offset += n
+	buf[offset] = ppu.opri
+	offset++
+
 	buf[offset] = uint8(ppu.mode)
 	offset++
 
@@ -468,6 +799,17 @@ func (ppu *PPU) Serialize(buf []byte) int {
 		n = copy(buf[offset:], ppu.frameBuffer[i][:])
 		offset += n
 	}
+	for y := range 144 {
+		for x := range 160 {
+			binary.LittleEndian.PutUint16(buf[offset:], ppu.colorFrameBuffer[y][x])
+			offset += 2
+		}
+	}
+
+	binary.LittleEndian.PutUint16(buf[offset:], ppu.pendingPaletteAddress)
+	offset += 2
+	buf[offset] = ppu.pendingPaletteValue
+	offset++
 
 	return offset
 }
@@ -475,7 +817,9 @@ func (ppu *PPU) Serialize(buf []byte) int {
 func (ppu *PPU) Deserialize(buf []byte) int {
 	offset := 0
 
-	n := copy(ppu.videoRam[:], buf[offset:])
+	n := copy(ppu.videoRam[0][:], buf[offset:])
+	offset += n
+	n = copy(ppu.videoRam[1][:], buf[offset:])
 	offset += n
 
 	n = copy(ppu.oam[:], buf[offset:])
@@ -504,6 +848,21 @@ func (ppu *PPU) Deserialize(buf []byte) int {
 	ppu.obp1 = buf[offset]
 	offset++
 
+	ppu.cgb = buf[offset] == 1
+	offset++
+	ppu.vbk = buf[offset]
+	offset++
+	ppu.bcps = buf[offset]
+	offset++
+	n = copy(ppu.bgPaletteRam[:], buf[offset:])
+	offset += n
+	ppu.ocps = buf[offset]
+	offset++
+	n = copy(ppu.objPaletteRam[:], buf[offset:])
+	offset += n
+	ppu.opri = buf[offset]
+	offset++
+
 	ppu.mode = Mode(buf[offset])
 	offset++
 	ppu.previousStatInterruptLineState = buf[offset] == 1
@@ -522,6 +881,17 @@ func (ppu *PPU) Deserialize(buf []byte) int {
 		n = copy(ppu.frameBuffer[i][:], buf[offset:])
 		offset += n
 	}
+	for y := range 144 {
+		for x := range 160 {
+			ppu.colorFrameBuffer[y][x] = binary.LittleEndian.Uint16(buf[offset:])
+			offset += 2
+		}
+	}
+
+	ppu.pendingPaletteAddress = binary.LittleEndian.Uint16(buf[offset:])
+	offset += 2
+	ppu.pendingPaletteValue = buf[offset]
+	offset++
 
 	return offset
 }
@@ -0,0 +1,70 @@
+package ppu
+
+import (
+	"testing"
+
+	"github.com/davidyorr/LuccaGB/internal/interrupt"
+	"github.com/davidyorr/LuccaGB/internal/model"
+)
+
+// the mealybug m3_* screenshots only match with the first line after turning
+// the LCD on 5 dots shorter
+func TestLcdOnFirstLineLength(t *testing.T) {
+	ppu := New(func(interrupt.Interrupt) {})
+	ppu.Write(0xFF40, 0x11)
+	ppu.Write(0xFF40, 0x91)
+
+	dots := 0
+	for ppu.Read(0xFF44) == 0 {
+		ppu.Step()
+		dots++
+	}
+	if dots != dotsPerScanline-5 {
+		t.Errorf("expected the first line to take %d dots, got %d", dotsPerScanline-5, dots)
+	}
+
+	dots = 0
+	for ppu.Read(0xFF44) == 1 {
+		ppu.Step()
+		dots++
+	}
+	if dots != dotsPerScanline {
+		t.Errorf("expected the second line to take %d dots, got %d", dotsPerScanline, dots)
+	}
+}
+
+// the DMG boot ROM's VRAM, with the first 2 bytes of the Nintendo logo
+// decoded into tile 0x01
+func TestBootLogo(t *testing.T) {
+	logo := [48]uint8{0xCE, 0xED}
+	expectedTile := []uint8{0xF0, 0x00, 0xF0, 0x00, 0xFC, 0x00, 0xFC, 0x00, 0xFC, 0x00, 0xFC, 0x00, 0xF3, 0x00, 0xF3, 0x00}
+
+	ppu := New(func(interrupt.Interrupt) {})
+	ppu.SetModel(model.Dmg)
+	ppu.SetLogo(logo)
+	ppu.Reset()
+	for i, expected := range expectedTile {
+		if got := ppu.videoRam[0][0x0010+i]; got != expected {
+			t.Errorf("expected 0x%02X at 0x%04X, got 0x%02X", expected, 0x8010+i, got)
+		}
+	}
+	if got := ppu.videoRam[0][0x0190]; got != 0x3C {
+		t.Errorf("expected the ® tile to start with 0x3C, got 0x%02X", got)
+	}
+	if got := ppu.videoRam[0][0x1904]; got != 0x01 {
+		t.Errorf("expected tile 0x01 at 0x9904, got 0x%02X", got)
+	}
+	if got := ppu.videoRam[0][0x1910]; got != 0x19 {
+		t.Errorf("expected tile 0x19 at 0x9910, got 0x%02X", got)
+	}
+	if got := ppu.videoRam[0][0x192F]; got != 0x18 {
+		t.Errorf("expected tile 0x18 at 0x992F, got 0x%02X", got)
+	}
+
+	// the SGB's boot ROM leaves VRAM clear
+	ppu.SetModel(model.Sgb)
+	ppu.Reset()
+	if ppu.videoRam != [2][8192]uint8{} {
+		t.Errorf("expected VRAM to be clear")
+	}
+}
@@ -16,6 +16,8 @@ type Serial struct {
 	serialOutputBuffer      []uint8
 	transferInProgress      bool
 	transferCyclesRemaining uint16
+	// whether the CGB's high speed clock, SC bit 1, is available
+	cgb bool
 }
 
 func New() *Serial {
@@ -29,6 +31,15 @@ func New() *Serial {
 func (serial *Serial) Reset() {
 	serial.sb = 0x00
 	serial.sc = 0x7E
+	if serial.cgb {
+		serial.sc = 0x7F
+	}
+}
+
+// SetCgbMode switches between DMG and CGB mode, keeping the serial port's
+// state.
+func (serial *Serial) SetCgbMode(cgb bool) {
+	serial.cgb = cgb
 }
 
 // Perform 1 T-cycle of work
@@ -66,6 +77,9 @@ func (serial *Serial) Read(address uint16) uint8 {
 	}
 	// SC
 	if address == 0xFF02 {
+		if serial.cgb {
+			return serial.sc | 0b0111_1100
+		}
 		return serial.sc | 0b0111_1110
 	}
 
@@ -84,6 +98,10 @@ func (serial *Serial) Write(address uint16, value uint8) {
 			serial.transferInProgress = true
 			// speed for DMG (4194304 / 8192)
 			serial.transferCyclesRemaining = 512
+			// the CGB's high speed clock is 32 times faster
+			if serial.cgb && (value&0b0000_0010) != 0 {
+				serial.transferCyclesRemaining = 512 / 32
+			}
 			serial.serialOutputBuffer = append(serial.serialOutputBuffer, serial.sb)
 		}
 	}
@@ -146,7 +164,7 @@ func (serial *Serial) Deserialize(buf []byte) int {
 		serial.transferInProgress = buf[offset] == 1
 		offset++
 	}
-	if offset+2 < len(buf) {
+	if offset+2 <= len(buf) {
 		serial.transferCyclesRemaining = binary.LittleEndian.Uint16(buf[offset:])
 		offset += 2
 	}
@@ -0,0 +1,281 @@
+package sgb
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/davidyorr/LuccaGB/internal/debug"
+	"github.com/davidyorr/LuccaGB/internal/logger"
+)
+
+// See: https://gbdev.io/pandocs/SGB_Command_Summary.html
+const (
+	commandPal01   = 0x00
+	commandPal23   = 0x01
+	commandPal03   = 0x02
+	commandPal12   = 0x03
+	commandAttrBlk = 0x04
+	commandAttrLin = 0x05
+	commandAttrDiv = 0x06
+	commandAttrChr = 0x07
+	commandPalSet  = 0x0A
+	commandPalTrn  = 0x0B
+	commandMltReq  = 0x11
+	commandChrTrn  = 0x13
+	commandPctTrn  = 0x14
+	commandAttrTrn = 0x15
+	commandAttrSet = 0x16
+	commandMaskEn  = 0x17
+)
+
+func (sgb *SGB) executeCommand(data []uint8) {
+	command := data[0] >> 3
+
+	if debug.Enabled {
+		logger.Info("SGB COMMAND", "COMMAND", fmt.Sprintf("0x%02X", command))
+	}
+
+	switch command {
+	case commandPal01:
+		sgb.setPalettes(data, 0, 1)
+	case commandPal23:
+		sgb.setPalettes(data, 2, 3)
+	case commandPal03:
+		sgb.setPalettes(data, 0, 3)
+	case commandPal12:
+		sgb.setPalettes(data, 1, 2)
+	case commandAttrBlk:
+		sgb.attributeBlocks(data)
+	case commandAttrLin:
+		sgb.attributeLines(data)
+	case commandAttrDiv:
+		sgb.attributeDivide(data)
+	case commandAttrChr:
+		sgb.attributeCharacters(data)
+	case commandPalSet:
+		sgb.setSystemPalettes(data)
+	case commandPalTrn:
+		sgb.pendingTransfer = transferPal
+	case commandMltReq:
+		sgb.multiplayerRequest(data[1])
+	case commandChrTrn:
+		if data[1]&1 == 0 {
+			sgb.pendingTransfer = transferChrLow
+		} else {
+			sgb.pendingTransfer = transferChrHigh
+		}
+	case commandPctTrn:
+		sgb.pendingTransfer = transferPct
+	case commandAttrTrn:
+		sgb.pendingTransfer = transferAttr
+	case commandAttrSet:
+		sgb.applyAttributeFile(data[1] & 0b0011_1111)
+		if data[1]&0b0100_0000 != 0 {
+			sgb.mask = maskCancel
+		}
+	case commandMaskEn:
+		sgb.mask = data[1] & 0b11
+	default:
+		if debug.Enabled {
+			logger.Info("UNSUPPORTED SGB COMMAND", "COMMAND", fmt.Sprintf("0x%02X", command))
+		}
+	}
+}
+
+// PAL01, PAL23, PAL03, PAL12: color 0 followed by colors 1-3 of each palette.
+// Color 0 is shared by all 4 palettes.
+func (sgb *SGB) setPalettes(data []uint8, first int, second int) {
+	color0 := binary.LittleEndian.Uint16(data[1:])
+	for palette := range sgb.palettes {
+		sgb.palettes[palette][0] = color0
+	}
+
+	for color := 1; color < 4; color++ {
+		sgb.palettes[first][color] = binary.LittleEndian.Uint16(data[1+color*2:])
+		sgb.palettes[second][color] = binary.LittleEndian.Uint16(data[7+color*2:])
+	}
+}
+
+// ATTR_BLK: up to 18 rectangles, each with a palette for the cells inside it,
+// on its border and outside it.
+func (sgb *SGB) attributeBlocks(data []uint8) {
+	count := min(int(data[1]), 18, (len(data)-2)/6)
+
+	for i := range count {
+		block := data[2+i*6:]
+		control := block[0]
+		paletteInside := block[1] & 0b11
+		paletteBorder := (block[1] >> 2) & 0b11
+		paletteOutside := (block[1] >> 4) & 0b11
+		x1, y1, x2, y2 := int(block[2]), int(block[3]), int(block[4]), int(block[5])
+
+		changeInside := control&0b001 != 0
+		changeBorder := control&0b010 != 0
+		changeOutside := control&0b100 != 0
+		// with only the inside or the outside changed, the border follows it
+		if changeInside && !changeBorder && !changeOutside {
+			changeBorder = true
+			paletteBorder = paletteInside
+		}
+		if changeOutside && !changeBorder && !changeInside {
+			changeBorder = true
+			paletteBorder = paletteOutside
+		}
+
+		for y := range 18 {
+			for x := range 20 {
+				inside := x > x1 && x < x2 && y > y1 && y < y2
+				outside := x < x1 || x > x2 || y < y1 || y > y2
+				switch {
+				case inside && changeInside:
+					sgb.attributes[y][x] = paletteInside
+				case outside && changeOutside:
+					sgb.attributes[y][x] = paletteOutside
+				case !inside && !outside && changeBorder:
+					sgb.attributes[y][x] = paletteBorder
+				}
+			}
+		}
+	}
+}
+
+// ATTR_LIN: a palette for whole rows or columns of cells.
+//
+//	7 - 0 = vertical line (column); 1 = horizontal line (row)
+//	6-5 - Palette
+//	4-0 - Line number
+func (sgb *SGB) attributeLines(data []uint8) {
+	count := min(int(data[1]), len(data)-2)
+
+	for i := range count {
+		line := data[2+i]
+		number := int(line & 0b0001_1111)
+		palette := (line >> 5) & 0b11
+
+		if line&0b1000_0000 != 0 {
+			if number < 18 {
+				for x := range 20 {
+					sgb.attributes[number][x] = palette
+				}
+			}
+		} else if number < 20 {
+			for y := range 18 {
+				sgb.attributes[y][number] = palette
+			}
+		}
+	}
+}
+
+// ATTR_DIV: splits the screen in two at a row or column, with a third palette
+// for the cells on the line itself.
+//
+//	6 - 0 = split at a column; 1 = split at a row
+//	5-4 - Palette on the line
+//	3-2 - Palette left of or above the line
+//	1-0 - Palette right of or below the line
+func (sgb *SGB) attributeDivide(data []uint8) {
+	paletteAfter := data[1] & 0b11
+	paletteBefore := (data[1] >> 2) & 0b11
+	paletteLine := (data[1] >> 4) & 0b11
+	horizontal := data[1]&0b0100_0000 != 0
+	coordinate := int(data[2])
+
+	for y := range 18 {
+		for x := range 20 {
+			position := x
+			if horizontal {
+				position = y
+			}
+
+			switch {
+			case position < coordinate:
+				sgb.attributes[y][x] = paletteBefore
+			case position > coordinate:
+				sgb.attributes[y][x] = paletteAfter
+			default:
+				sgb.attributes[y][x] = paletteLine
+			}
+		}
+	}
+}
+
+// ATTR_CHR: a palette for each cell from a starting position, 2 bits per cell
+// with the first cell in the most significant bits.
+func (sgb *SGB) attributeCharacters(data []uint8) {
+	x := int(data[1])
+	y := int(data[2])
+	count := min(int(binary.LittleEndian.Uint16(data[3:])), 360)
+	topToBottom := data[5]&1 == 1
+
+	for i := range count {
+		if 6+i/4 >= len(data) || x >= 20 || y >= 18 {
+			return
+		}
+		shift := 6 - (i%4)*2
+		sgb.attributes[y][x] = (data[6+i/4] >> shift) & 0b11
+
+		if topToBottom {
+			y++
+			if y == 18 {
+				y = 0
+				x++
+			}
+		} else {
+			x++
+			if x == 20 {
+				x = 0
+				y++
+			}
+		}
+	}
+}
+
+// PAL_SET: copies 4 of the system palettes sent by PAL_TRN into the screen's
+// palettes, optionally applying an attribute file sent by ATTR_TRN.
+func (sgb *SGB) setSystemPalettes(data []uint8) {
+	for palette := range sgb.palettes {
+		number := int(binary.LittleEndian.Uint16(data[1+palette*2:]) & 0x01FF)
+		copy(sgb.palettes[palette][:], sgb.systemPalettes[number*4:number*4+4])
+	}
+	// color 0 is shared, taken from the first palette
+	for palette := 1; palette < 4; palette++ {
+		sgb.palettes[palette][0] = sgb.palettes[0][0]
+	}
+
+	if data[9]&0b1000_0000 != 0 {
+		sgb.applyAttributeFile(data[9] & 0b0011_1111)
+	}
+	if data[9]&0b0100_0000 != 0 {
+		sgb.mask = maskCancel
+	}
+}
+
+// applyAttributeFile sets the palette of every cell from one of the 45
+// attribute files, 4 cells per byte with the first cell in the most
+// significant bits.
+func (sgb *SGB) applyAttributeFile(file uint8) {
+	if file >= 45 {
+		return
+	}
+
+	attributes := sgb.attributeFiles[int(file)*90:]
+	for y := range 18 {
+		for x := range 20 {
+			cell := y*20 + x
+			shift := 6 - (cell%4)*2
+			sgb.attributes[y][x] = (attributes[cell/4] >> shift) & 0b11
+		}
+	}
+}
+
+// MLT_REQ: 0 = 1 player; 1 = 2 players; 3 = 4 players
+func (sgb *SGB) multiplayerRequest(value uint8) {
+	switch value & 0b11 {
+	case 0b00:
+		sgb.joypad.SetPlayers(1)
+	case 0b01:
+		sgb.joypad.SetPlayers(2)
+	case 0b11:
+		sgb.joypad.SetPlayers(4)
+	}
+}
@@ -0,0 +1,83 @@
+package sgb
+
+// Frame renders the SGB's 256x224 output in RGB555: the Game Boy screen
+// colored by the palette of each of its cells, with the border drawn over it.
+// Transparent border pixels show the Game Boy screen, or color 0 outside of it.
+func (sgb *SGB) Frame() [Height][Width]uint16 {
+	var frame [Height][Width]uint16
+
+	backdrop := sgb.palettes[0][0]
+	for y := range frame {
+		for x := range frame[y] {
+			frame[y][x] = backdrop
+		}
+	}
+
+	for y := range sgb.screen {
+		for x := range sgb.screen[y] {
+			frame[screenY+y][screenX+x] = sgb.screenColor(x, y)
+		}
+	}
+
+	sgb.drawBorder(&frame)
+
+	return frame
+}
+
+func (sgb *SGB) screenColor(x int, y int) uint16 {
+	switch sgb.mask {
+	case maskBlack:
+		return 0x0000
+	case maskColor0:
+		return sgb.palettes[0][0]
+	}
+
+	palette := sgb.attributes[y/8][x/8]
+	return sgb.palettes[palette][sgb.screen[y][x]]
+}
+
+// drawBorder draws the 32x28 tiles of the border. Tiles are in the SNES 4bpp
+// format: each row is 2 bytes of bitplanes 0 and 1, then 16 bytes later 2
+// bytes of bitplanes 2 and 3. Color 0 of each palette is transparent.
+func (sgb *SGB) drawBorder(frame *[Height][Width]uint16) {
+	for row := range Height / 8 {
+		for column := range Width / 8 {
+			entry := sgb.borderMap[row*32+column]
+			tile := sgb.borderTiles[int(entry&0xFF)*32:]
+			// the border uses palettes 4-7
+			palette := (entry >> 10) & 0b111
+			if palette < 4 {
+				continue
+			}
+			xFlip := entry&(1<<14) != 0
+			yFlip := entry&(1<<15) != 0
+
+			for tileY := range 8 {
+				tileRow := tileY
+				if yFlip {
+					tileRow = 7 - tileY
+				}
+				plane0 := tile[tileRow*2]
+				plane1 := tile[tileRow*2+1]
+				plane2 := tile[16+tileRow*2]
+				plane3 := tile[16+tileRow*2+1]
+
+				for tileX := range 8 {
+					bit := 7 - tileX
+					if xFlip {
+						bit = tileX
+					}
+					color := (plane0>>bit)&1 |
+						((plane1>>bit)&1)<<1 |
+						((plane2>>bit)&1)<<2 |
+						((plane3>>bit)&1)<<3
+					if color == 0 {
+						continue
+					}
+
+					frame[row*8+tileY][column*8+tileX] = sgb.borderPalettes[palette-4][color]
+				}
+			}
+		}
+	}
+}
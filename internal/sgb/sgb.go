@@ -0,0 +1,394 @@
+package sgb
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/davidyorr/LuccaGB/internal/debug"
+	"github.com/davidyorr/LuccaGB/internal/joypad"
+	"github.com/davidyorr/LuccaGB/internal/logger"
+	"github.com/davidyorr/LuccaGB/internal/ppu"
+)
+
+// The SGB's output, the Game Boy screen centered in a border
+const (
+	Width  = 256
+	Height = 224
+
+	screenX = 48
+	screenY = 40
+)
+
+const (
+	packetSize = 16
+	// a command is made of up to 7 packets
+	maxPackets = 7
+	// bits per packet, followed by a stop bit
+	packetBits = packetSize * 8
+)
+
+// SGB emulates the Super Game Boy's handling of the packets a game sends
+// through P1, and renders the Game Boy screen with the SGB's palettes and
+// border.
+// See: https://gbdev.io/pandocs/SGB_Functions.html
+type SGB struct {
+	joypad *joypad.Joypad
+	ppu    *ppu.PPU
+
+	// =======================
+	// === Packet decoding ===
+	// =======================
+
+	// set by a reset pulse, cleared once the stop bit is received
+	receiving bool
+	// a bit is only read on a pulse, after P14 and P15 have both gone high
+	waitingForIdle bool
+	bitIndex       int
+	// the packets of the command being received
+	packets     [maxPackets * packetSize]uint8
+	packetIndex int
+
+	// =======================
+	// ======== State ========
+	// =======================
+
+	// 4 palettes of 4 RGB555 colors for the Game Boy screen. Color 0 is shared
+	// by all palettes.
+	palettes [4][4]uint16
+	// palette of each 8x8 cell of the Game Boy screen
+	attributes [18][20]uint8
+	// MASK_EN
+	mask uint8
+	// VRAM transfer waiting for the next frame
+	pendingTransfer transfer
+	// CHR_TRN: 256 4bpp border tiles, 32 bytes each
+	borderTiles [256 * 32]uint8
+	// PCT_TRN: 32x32 border tile map entries
+	//	15 - Y flip
+	//	14 - X flip
+	//	12-10 - Palette 4-7
+	//	7-0 - Tile
+	borderMap [32 * 32]uint16
+	// PCT_TRN: palettes 4-7 of the border, 16 colors each
+	borderPalettes [4][16]uint16
+	// PAL_TRN: 512 system palettes of 4 colors, used by PAL_SET
+	systemPalettes [512 * 4]uint16
+	// ATTR_TRN: 45 attribute files of 90 bytes, used by PAL_SET and ATTR_SET
+	attributeFiles [45 * 90]uint8
+	// the Game Boy screen, kept while the mask freezes it
+	screen [144][160]uint8
+}
+
+type transfer uint8
+
+const (
+	transferNone transfer = iota
+	transferChrLow
+	transferChrHigh
+	transferPct
+	transferPal
+	transferAttr
+)
+
+// masks set by MASK_EN
+const (
+	maskCancel uint8 = iota
+	maskFreeze
+	maskBlack
+	maskColor0
+)
+
+func New(joypad *joypad.Joypad, ppu *ppu.PPU) *SGB {
+	sgb := &SGB{
+		joypad: joypad,
+		ppu:    ppu,
+	}
+
+	sgb.Reset()
+
+	return sgb
+}
+
+// Reset restores the SGB's power on state, with the default palette and no
+// border.
+func (sgb *SGB) Reset() {
+	sgb.receiving = false
+	sgb.waitingForIdle = false
+	sgb.bitIndex = 0
+	sgb.packetIndex = 0
+
+	// SGB palette 1-A
+	defaultPalette := [4]uint16{0x67BF, 0x265B, 0x10B5, 0x2866}
+	for i := range sgb.palettes {
+		sgb.palettes[i] = defaultPalette
+	}
+	sgb.attributes = [18][20]uint8{}
+	sgb.mask = maskCancel
+	sgb.pendingTransfer = transferNone
+	sgb.borderTiles = [256 * 32]uint8{}
+	sgb.borderMap = [32 * 32]uint16{}
+	sgb.borderPalettes = [4][16]uint16{}
+	sgb.systemPalettes = [512 * 4]uint16{}
+	sgb.attributeFiles = [45 * 90]uint8{}
+	sgb.screen = [144][160]uint8{}
+}
+
+// WriteP1 receives the values written to P1. Packets start with a reset pulse,
+// P14 and P15 both low, then each bit is a pulse of P14 low for 0 or P15 low
+// for 1, separated by both going high.
+// See: https://gbdev.io/pandocs/SGB_Command_Packet.html
+func (sgb *SGB) WriteP1(value uint8) {
+	lines := value & 0b0011_0000
+
+	switch lines {
+	// reset pulse
+	case 0b0000_0000:
+		sgb.receiving = true
+		sgb.waitingForIdle = true
+		sgb.bitIndex = 0
+		offset := sgb.packetIndex * packetSize
+		clear(sgb.packets[offset : offset+packetSize])
+	// both high, between pulses
+	case 0b0011_0000:
+		sgb.waitingForIdle = false
+	// P14 low for 0, P15 low for 1
+	case 0b0010_0000, 0b0001_0000:
+		if !sgb.receiving || sgb.waitingForIdle {
+			return
+		}
+		sgb.waitingForIdle = true
+		sgb.receiveBit(lines == 0b0001_0000)
+	}
+}
+
+func (sgb *SGB) receiveBit(bit bool) {
+	// the stop bit
+	if sgb.bitIndex == packetBits {
+		sgb.receiving = false
+		if bit {
+			if debug.Enabled {
+				logger.Info("SGB PACKET MISSING STOP BIT")
+			}
+			sgb.packetIndex = 0
+			return
+		}
+		sgb.receivePacket()
+		return
+	}
+
+	// bytes are sent least significant bit first
+	if bit {
+		address := sgb.packetIndex*packetSize + sgb.bitIndex/8
+		sgb.packets[address] |= 1 << (sgb.bitIndex % 8)
+	}
+	sgb.bitIndex++
+}
+
+func (sgb *SGB) receivePacket() {
+	sgb.packetIndex++
+
+	// the first byte of the first packet holds the command and its length
+	length := int(sgb.packets[0] & 0b111)
+	if length == 0 {
+		length = 1
+	}
+	if sgb.packetIndex < length {
+		return
+	}
+
+	sgb.packetIndex = 0
+	sgb.executeCommand(sgb.packets[:length*packetSize])
+}
+
+// OnFrame is called when the PPU finishes a frame. It performs any pending
+// VRAM transfer and keeps the frame unless the mask freezes the screen.
+func (sgb *SGB) OnFrame() {
+	if sgb.pendingTransfer != transferNone {
+		sgb.performTransfer()
+	}
+
+	if sgb.mask != maskFreeze {
+		sgb.screen = sgb.ppu.FrameBuffer()
+	}
+}
+
+// transferData reads the 4KB of data the game displays for a VRAM transfer,
+// the first 256 tiles of the background in screen order.
+// See: https://gbdev.io/pandocs/SGB_VRAM_Transfer.html
+func (sgb *SGB) transferData() [4096]uint8 {
+	var data [4096]uint8
+
+	lcdc := sgb.ppu.Lcdc()
+	var tileMapAreaStart uint16 = 0x9800
+	if (lcdc>>3)&1 == 1 {
+		tileMapAreaStart = 0x9C00
+	}
+
+	for tile := range 256 {
+		tileMapAddress := tileMapAreaStart + uint16(tile/20)*32 + uint16(tile%20)
+		tileNumber := sgb.ppu.ReadVram(tileMapAddress)
+
+		var tileAddress uint16
+		if (lcdc>>4)&1 == 1 {
+			tileAddress = 0x8000 + uint16(tileNumber)*16
+		} else {
+			tileAddress = 0x9000 + uint16(int16(int8(tileNumber)))*16
+		}
+
+		for i := range 16 {
+			data[tile*16+i] = sgb.ppu.ReadVram(tileAddress + uint16(i))
+		}
+	}
+
+	return data
+}
+
+func (sgb *SGB) performTransfer() {
+	data := sgb.transferData()
+
+	switch sgb.pendingTransfer {
+	case transferChrLow:
+		copy(sgb.borderTiles[:4096], data[:])
+	case transferChrHigh:
+		copy(sgb.borderTiles[4096:], data[:])
+	case transferPct:
+		for i := range sgb.borderMap {
+			sgb.borderMap[i] = binary.LittleEndian.Uint16(data[i*2:])
+		}
+		for palette := range sgb.borderPalettes {
+			for color := range sgb.borderPalettes[palette] {
+				address := 0x800 + palette*32 + color*2
+				sgb.borderPalettes[palette][color] = binary.LittleEndian.Uint16(data[address:])
+			}
+		}
+	case transferPal:
+		for i := range sgb.systemPalettes {
+			sgb.systemPalettes[i] = binary.LittleEndian.Uint16(data[i*2:])
+		}
+	case transferAttr:
+		copy(sgb.attributeFiles[:], data[:])
+	}
+
+	if debug.Enabled {
+		logger.Info("SGB VRAM TRANSFER", "TYPE", fmt.Sprintf("%d", sgb.pendingTransfer))
+	}
+	sgb.pendingTransfer = transferNone
+}
+
+func (sgb *SGB) Serialize(buf []byte) int {
+	offset := 0
+
+	if sgb.receiving {
+		buf[offset] = 1
+	} else {
+		buf[offset] = 0
+	}
+	offset++
+	if sgb.waitingForIdle {
+		buf[offset] = 1
+	} else {
+		buf[offset] = 0
+	}
+	offset++
+	buf[offset] = uint8(sgb.bitIndex)
+	offset++
+	buf[offset] = uint8(sgb.packetIndex)
+	offset++
+	n := copy(buf[offset:], sgb.packets[:])
+	offset += n
+
+	for palette := range sgb.palettes {
+		for color := range sgb.palettes[palette] {
+			binary.LittleEndian.PutUint16(buf[offset:], sgb.palettes[palette][color])
+			offset += 2
+		}
+	}
+	for row := range sgb.attributes {
+		n = copy(buf[offset:], sgb.attributes[row][:])
+		offset += n
+	}
+	buf[offset] = sgb.mask
+	offset++
+	buf[offset] = uint8(sgb.pendingTransfer)
+	offset++
+
+	n = copy(buf[offset:], sgb.borderTiles[:])
+	offset += n
+	for i := range sgb.borderMap {
+		binary.LittleEndian.PutUint16(buf[offset:], sgb.borderMap[i])
+		offset += 2
+	}
+	for palette := range sgb.borderPalettes {
+		for color := range sgb.borderPalettes[palette] {
+			binary.LittleEndian.PutUint16(buf[offset:], sgb.borderPalettes[palette][color])
+			offset += 2
+		}
+	}
+	for i := range sgb.systemPalettes {
+		binary.LittleEndian.PutUint16(buf[offset:], sgb.systemPalettes[i])
+		offset += 2
+	}
+	n = copy(buf[offset:], sgb.attributeFiles[:])
+	offset += n
+	for row := range sgb.screen {
+		n = copy(buf[offset:], sgb.screen[row][:])
+		offset += n
+	}
+
+	return offset
+}
+
+func (sgb *SGB) Deserialize(buf []byte) int {
+	offset := 0
+
+	sgb.receiving = buf[offset] == 1
+	offset++
+	sgb.waitingForIdle = buf[offset] == 1
+	offset++
+	sgb.bitIndex = int(buf[offset])
+	offset++
+	sgb.packetIndex = int(buf[offset])
+	offset++
+	n := copy(sgb.packets[:], buf[offset:])
+	offset += n
+
+	for palette := range sgb.palettes {
+		for color := range sgb.palettes[palette] {
+			sgb.palettes[palette][color] = binary.LittleEndian.Uint16(buf[offset:])
+			offset += 2
+		}
+	}
+	for row := range sgb.attributes {
+		n = copy(sgb.attributes[row][:], buf[offset:])
+		offset += n
+	}
+	sgb.mask = buf[offset]
+	offset++
+	sgb.pendingTransfer = transfer(buf[offset])
+	offset++
+
+	n = copy(sgb.borderTiles[:], buf[offset:])
+	offset += n
+	for i := range sgb.borderMap {
+		sgb.borderMap[i] = binary.LittleEndian.Uint16(buf[offset:])
+		offset += 2
+	}
+	for palette := range sgb.borderPalettes {
+		for color := range sgb.borderPalettes[palette] {
+			sgb.borderPalettes[palette][color] = binary.LittleEndian.Uint16(buf[offset:])
+			offset += 2
+		}
+	}
+	for i := range sgb.systemPalettes {
+		sgb.systemPalettes[i] = binary.LittleEndian.Uint16(buf[offset:])
+		offset += 2
+	}
+	n = copy(sgb.attributeFiles[:], buf[offset:])
+	offset += n
+	for row := range sgb.screen {
+		n = copy(sgb.screen[row][:], buf[offset:])
+		offset += n
+	}
+
+	return offset
+}
@@ -0,0 +1,224 @@
+package sgb
+
+import (
+	"testing"
+
+	"github.com/davidyorr/LuccaGB/internal/interrupt"
+	"github.com/davidyorr/LuccaGB/internal/joypad"
+	"github.com/davidyorr/LuccaGB/internal/ppu"
+)
+
+func newTestSgb() *SGB {
+	requestInterrupt := func(interrupt.Interrupt) {}
+	return New(joypad.New(requestInterrupt), ppu.New(requestInterrupt))
+}
+
+// sendPacket writes a reset pulse, the 128 bits of packet and the stop bit to
+// P1, the way a game does.
+func sendPacket(sgb *SGB, packet [packetSize]uint8) {
+	sgb.WriteP1(0x00)
+	sgb.WriteP1(0x30)
+	for _, value := range packet {
+		for bit := range 8 {
+			if (value>>bit)&1 == 1 {
+				sgb.WriteP1(0x10)
+			} else {
+				sgb.WriteP1(0x20)
+			}
+			sgb.WriteP1(0x30)
+		}
+	}
+	sgb.WriteP1(0x20)
+	sgb.WriteP1(0x30)
+}
+
+func TestPal01(t *testing.T) {
+	sgb := newTestSgb()
+	sendPacket(sgb, [packetSize]uint8{
+		commandPal01<<3 | 1,
+		0x11, 0x11,
+		0x01, 0x10, 0x02, 0x20, 0x03, 0x30,
+		0x04, 0x40, 0x05, 0x50, 0x06, 0x60,
+	})
+
+	expected := [4][4]uint16{
+		{0x1111, 0x1001, 0x2002, 0x3003},
+		{0x1111, 0x4004, 0x5005, 0x6006},
+	}
+	for palette := range 2 {
+		if sgb.palettes[palette] != expected[palette] {
+			t.Errorf("expected palette %d to be %04X, got %04X", palette, expected[palette], sgb.palettes[palette])
+		}
+	}
+	// color 0 is shared
+	for palette := 2; palette < 4; palette++ {
+		if color := sgb.palettes[palette][0]; color != 0x1111 {
+			t.Errorf("expected color 0 of palette %d to be 0x1111, got 0x%04X", palette, color)
+		}
+	}
+}
+
+func TestPacketBitsIgnoredWithoutIdle(t *testing.T) {
+	sgb := newTestSgb()
+	sgb.WriteP1(0x00)
+	sgb.WriteP1(0x30)
+	// P15 held low is a single 1 bit, not one per write
+	sgb.WriteP1(0x10)
+	sgb.WriteP1(0x10)
+	if sgb.bitIndex != 1 {
+		t.Errorf("expected 1 bit received, got %d", sgb.bitIndex)
+	}
+
+	// no bits are read before a reset pulse
+	sgb.Reset()
+	sgb.WriteP1(0x10)
+	sgb.WriteP1(0x30)
+	if sgb.bitIndex != 0 {
+		t.Errorf("expected no bits received before a reset pulse, got %d", sgb.bitIndex)
+	}
+}
+
+func TestPacketMissingStopBit(t *testing.T) {
+	sgb := newTestSgb()
+	sgb.WriteP1(0x00)
+	sgb.WriteP1(0x30)
+	for range packetBits {
+		sgb.WriteP1(0x20)
+		sgb.WriteP1(0x30)
+	}
+	// a 1 where the stop bit should be
+	sgb.WriteP1(0x10)
+	sgb.WriteP1(0x30)
+
+	if sgb.palettes[0][0] != 0x67BF {
+		t.Errorf("expected the packet to be dropped, got color 0 0x%04X", sgb.palettes[0][0])
+	}
+}
+
+func TestMultiplePacketCommand(t *testing.T) {
+	sgb := newTestSgb()
+	// ATTR_CHR over 2 packets, setting the first 40 cells to palette 2
+	first := [packetSize]uint8{commandAttrChr<<3 | 2, 0, 0, 40, 0, 0}
+	for i := 6; i < packetSize; i++ {
+		first[i] = 0xAA
+	}
+	second := [packetSize]uint8{}
+	for i := range second {
+		second[i] = 0xAA
+	}
+
+	sendPacket(sgb, first)
+	if sgb.attributes[0][0] != 0 {
+		t.Fatal("expected the command to wait for its second packet")
+	}
+
+	sendPacket(sgb, second)
+	for x := range 20 {
+		if sgb.attributes[0][x] != 2 || sgb.attributes[1][x] != 2 {
+			t.Fatalf("expected the first 2 rows set to palette 2, got %d and %d at column %d", sgb.attributes[0][x], sgb.attributes[1][x], x)
+		}
+	}
+	if sgb.attributes[2][0] != 0 {
+		t.Errorf("expected the third row unchanged, got %d", sgb.attributes[2][0])
+	}
+}
+
+func TestAttrBlk(t *testing.T) {
+	sgb := newTestSgb()
+	// inside 1, border 2, outside 3, around cells 2-5 by 3-6
+	sendPacket(sgb, [packetSize]uint8{
+		commandAttrBlk<<3 | 1, 1,
+		0b111, 0b11_10_01, 2, 3, 5, 6,
+	})
+
+	tests := []struct {
+		x, y    int
+		palette uint8
+	}{
+		{3, 4, 1},
+		{4, 5, 1},
+		{2, 3, 2},
+		{5, 4, 2},
+		{3, 6, 2},
+		{1, 3, 3},
+		{6, 6, 3},
+		{19, 17, 3},
+	}
+	for _, test := range tests {
+		if palette := sgb.attributes[test.y][test.x]; palette != test.palette {
+			t.Errorf("expected palette %d at %d,%d, got %d", test.palette, test.x, test.y, palette)
+		}
+	}
+}
+
+func TestAttrLinAndDiv(t *testing.T) {
+	sgb := newTestSgb()
+	// split at column 10: 1 left, 2 on the line, 3 right
+	sendPacket(sgb, [packetSize]uint8{commandAttrDiv<<3 | 1, 0b10_01_11, 10})
+	for x, palette := range map[int]uint8{0: 1, 9: 1, 10: 2, 11: 3, 19: 3} {
+		if sgb.attributes[0][x] != palette {
+			t.Errorf("expected palette %d at column %d, got %d", palette, x, sgb.attributes[0][x])
+		}
+	}
+
+	// row 4 to palette 3, then column 0 to palette 0
+	sendPacket(sgb, [packetSize]uint8{commandAttrLin<<3 | 1, 2, 0b1_11_00100, 0b0_00_00000})
+	if sgb.attributes[4][5] != 3 {
+		t.Errorf("expected row 4 set to palette 3, got %d", sgb.attributes[4][5])
+	}
+	if sgb.attributes[4][0] != 0 || sgb.attributes[17][0] != 0 {
+		t.Errorf("expected column 0 set to palette 0, got %d and %d", sgb.attributes[4][0], sgb.attributes[17][0])
+	}
+}
+
+func TestPalSetAndAttrSet(t *testing.T) {
+	sgb := newTestSgb()
+	for i := range sgb.systemPalettes {
+		sgb.systemPalettes[i] = uint16(i)
+	}
+	// the second attribute file sets every cell to palette 1
+	for i := 90; i < 180; i++ {
+		sgb.attributeFiles[i] = 0b01_01_01_01
+	}
+	sgb.mask = maskFreeze
+
+	// palettes 1, 2, 3 and 511, with attribute file 1 and the mask cancelled
+	sendPacket(sgb, [packetSize]uint8{commandPalSet<<3 | 1, 1, 0, 2, 0, 3, 0, 0xFF, 0x01, 0b1100_0001})
+	expected := [4][4]uint16{
+		{4, 5, 6, 7},
+		{4, 9, 10, 11},
+		{4, 13, 14, 15},
+		{4, 2045, 2046, 2047},
+	}
+	if sgb.palettes != expected {
+		t.Errorf("expected palettes %v, got %v", expected, sgb.palettes)
+	}
+	if sgb.attributes[17][19] != 1 {
+		t.Errorf("expected attribute file 1 applied, got palette %d", sgb.attributes[17][19])
+	}
+	if sgb.mask != maskCancel {
+		t.Errorf("expected the mask cancelled, got %d", sgb.mask)
+	}
+
+	sendPacket(sgb, [packetSize]uint8{commandAttrSet<<3 | 1, 0})
+	if sgb.attributes[17][19] != 0 {
+		t.Errorf("expected attribute file 0 applied, got palette %d", sgb.attributes[17][19])
+	}
+}
+
+func TestMaskEnAndTransfer(t *testing.T) {
+	sgb := newTestSgb()
+	sendPacket(sgb, [packetSize]uint8{commandMaskEn<<3 | 1, maskBlack})
+	if sgb.mask != maskBlack {
+		t.Errorf("expected the black mask, got %d", sgb.mask)
+	}
+
+	sendPacket(sgb, [packetSize]uint8{commandChrTrn<<3 | 1, 1})
+	if sgb.pendingTransfer != transferChrHigh {
+		t.Errorf("expected a pending CHR_TRN of the high tiles, got %d", sgb.pendingTransfer)
+	}
+	sgb.OnFrame()
+	if sgb.pendingTransfer != transferNone {
+		t.Errorf("expected the transfer done on the next frame, got %d", sgb.pendingTransfer)
+	}
+}
@@ -1,6 +1,10 @@
 package timer
 
-import "encoding/binary"
+import (
+	"encoding/binary"
+
+	"github.com/davidyorr/LuccaGB/internal/model"
+)
 
 type Timer struct {
 	// 0xFF05 timer counter
@@ -14,6 +18,9 @@ type Timer struct {
 	previousTimerBitState bool
 	timaReloading         bool
 	timaReloadDelay       uint8
+	// the model and mode select the DIV phase left by the boot ROM
+	model model.Model
+	cgb   bool
 }
 
 func New() *Timer {
@@ -26,10 +33,48 @@ func (timer *Timer) Reset() {
 	timer.tima = 0x00
 	timer.tma = 0x00
 	timer.tac = 0xF8
-	timer.counter = 0xABCC
+	timer.counter = bootCounter(timer.model, timer.cgb)
 	timer.previousTimerBitState = false
 }
 
+// ColdReset puts the timer in its power on state, for a boot ROM to run.
+func (timer *Timer) ColdReset() {
+	timer.Reset()
+	timer.counter = 0x0000
+}
+
+// bootCounter returns the internal counter, whose upper byte is DIV, when the
+// boot ROM hands over to the game. It depends on how long each boot ROM runs.
+// The SGB's boot ROM waits on the SNES, so its value is only an approximation.
+func bootCounter(gbModel model.Model, cgb bool) uint16 {
+	switch gbModel {
+	case model.Dmg0:
+		return 0x182C
+	case model.Sgb, model.Sgb2:
+		return 0xD85C
+	case model.Cgb, model.Agb:
+		if cgb {
+			return 0x1EA0
+		}
+		// the boot ROM spends longer setting up the compatibility palettes
+		return 0x267C
+	}
+
+	return 0xABCC
+}
+
+// SetModel selects the model whose DIV phase the timer starts with. It applies
+// from the next Reset.
+func (timer *Timer) SetModel(model model.Model) {
+	timer.model = model
+}
+
+// SetCgbMode switches between DMG and CGB mode. It applies from the next
+// Reset.
+func (timer *Timer) SetCgbMode(cgb bool) {
+	timer.cgb = cgb
+}
+
 // Step performs 1 T-cycle of work
 func (timer *Timer) Step() (requestInterrupt bool) {
 	// there is a 4 cycle delay before the TIMA register is reloaded with the
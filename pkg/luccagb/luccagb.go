@@ -0,0 +1,343 @@
+// Package luccagb is the public API of the LuccaGB Game Boy emulator, for
+// programs which embed it: frontends, test bots, tool-assisted runs.
+//
+// # Compatibility
+//
+// The package follows semantic versioning, reported by Version. Within a
+// major version exported identifiers are not removed or changed in a way that
+// breaks callers: new functions, methods, options and struct fields may be
+// added in minor versions. Emulation accuracy fixes are not breaking changes,
+// even when they change the frames or samples produced.
+//
+// Save states record the ROM, model and format version they were made with, a
+// state which doesn't match is rejected rather than loaded. Battery saves use
+// the common .sav layout and are always compatible.
+package luccagb
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/davidyorr/LuccaGB/internal/cartridge"
+	"github.com/davidyorr/LuccaGB/internal/gameboy"
+	"github.com/davidyorr/LuccaGB/internal/joypad"
+)
+
+// Version is the semantic version of this API.
+const Version = "1.0.0"
+
+// Emulator is a Game Boy with a cartridge slot. It isn't safe for concurrent
+// use.
+type Emulator struct {
+	gb *gameboy.Gameboy
+	// the model asked for, ModelAuto to pick one for every ROM
+	model     Model
+	bootRom   []byte
+	romLoaded bool
+	romInfo   RomInfo
+}
+
+// New returns an Emulator with no ROM loaded, configured by the options.
+func New(options ...Option) *Emulator {
+	emulator := &Emulator{
+		model: ModelAuto,
+	}
+	for _, option := range options {
+		option(emulator)
+	}
+
+	emulator.gb = gameboy.New(emulator.modelFor(nil))
+
+	return emulator
+}
+
+// Option configures an Emulator created by New.
+type Option func(*Emulator)
+
+// WithModel selects the hardware to emulate. By default the model is picked
+// for each ROM: the CGB for CGB enhanced games, the SGB for games with SGB
+// functions and the DMG otherwise.
+func WithModel(model Model) Option {
+	return func(emulator *Emulator) {
+		emulator.model = model
+	}
+}
+
+// WithBootRom runs a boot ROM before every loaded ROM, instead of starting the
+// game with the state the boot ROM leaves behind. It has to match the model,
+// LoadRom returns a *BootRomSizeError or *BootRomModelError if it doesn't.
+func WithBootRom(bootRom []byte) Option {
+	return func(emulator *Emulator) {
+		emulator.bootRom = append([]byte(nil), bootRom...)
+	}
+}
+
+// Model is a Game Boy hardware revision.
+type Model = gameboy.Model
+
+// ModelAuto picks the model for each ROM, see WithModel.
+const ModelAuto Model = 0xFF
+
+const (
+	ModelDmg  = gameboy.ModelDmg
+	ModelDmg0 = gameboy.ModelDmg0
+	ModelMgb  = gameboy.ModelMgb
+	ModelSgb  = gameboy.ModelSgb
+	ModelSgb2 = gameboy.ModelSgb2
+	ModelCgb  = gameboy.ModelCgb
+	ModelAgb  = gameboy.ModelAgb
+)
+
+func (emulator *Emulator) modelFor(rom []byte) Model {
+	if emulator.model != ModelAuto {
+		return emulator.model
+	}
+	if rom == nil {
+		return ModelDmg
+	}
+
+	return gameboy.ModelForRom(rom)
+}
+
+// Model returns the hardware being emulated.
+func (emulator *Emulator) Model() Model {
+	return emulator.gb.Model()
+}
+
+// Errors returned by the Emulator. They are the types the emulator core
+// returns, use errors.As to inspect them.
+type (
+	TruncatedRomError         = cartridge.TruncatedRomError
+	UnsupportedCartridgeError = cartridge.UnsupportedCartridgeError
+	SaveSizeError             = cartridge.SaveSizeError
+	BootRomSizeError          = gameboy.BootRomSizeError
+	BootRomModelError         = gameboy.BootRomModelError
+	CpuLockedError            = gameboy.CpuLockedError
+	StateFormatError          = gameboy.StateFormatError
+	StateVersionError         = gameboy.StateVersionError
+	StateRomError             = gameboy.StateRomError
+	StateModelError           = gameboy.StateModelError
+)
+
+// ErrNoRom is returned by the methods which need a ROM to be loaded first.
+var ErrNoRom = errors.New("luccagb: no ROM loaded")
+
+// ErrInvalidFrameCount is returned by StepFrames for a count of frames which
+// isn't positive.
+var ErrInvalidFrameCount = errors.New("luccagb: frame count must be positive")
+
+// ErrInvalidState is returned by LoadState for data which isn't a save state
+// of the loaded ROM. It wraps a *StateFormatError, *StateVersionError,
+// *StateRomError or *StateModelError saying why.
+var ErrInvalidState = errors.New("luccagb: invalid save state")
+
+// RomInfo describes a loaded ROM, from its header.
+type RomInfo struct {
+	Title string
+	// name of the cartridge type, e.g. "MBC1+RAM+BATTERY"
+	CartridgeType string
+	// in bytes
+	RomSize int
+	RamSize int
+	// whether the cartridge keeps its RAM, see SaveRam
+	HasBattery  bool
+	CgbEnhanced bool
+	CgbOnly     bool
+	SupportsSgb bool
+}
+
+// LoadRom powers the Game Boy on with a ROM. The error is a
+// *TruncatedRomError or *UnsupportedCartridgeError if the ROM can't be run, a
+// *BootRomSizeError or *BootRomModelError if the boot ROM doesn't match the
+// model. A ROM which fails to load leaves no ROM loaded.
+func (emulator *Emulator) LoadRom(rom []byte) (RomInfo, error) {
+	emulator.romLoaded = false
+	emulator.romInfo = RomInfo{}
+	emulator.gb = gameboy.New(emulator.modelFor(rom))
+
+	if emulator.bootRom != nil {
+		if err := emulator.gb.LoadBootRom(emulator.bootRom); err != nil {
+			return RomInfo{}, err
+		}
+	}
+
+	info, err := emulator.gb.LoadRom(rom)
+	if err != nil {
+		return RomInfo{}, err
+	}
+
+	emulator.romInfo = RomInfo{
+		Title:         info.Title,
+		CartridgeType: info.Header.MapperName,
+		RomSize:       info.Header.RomSize,
+		RamSize:       info.RamSize,
+		HasBattery:    info.HasBattery,
+		CgbEnhanced:   info.Header.IsCgbEnhanced(),
+		CgbOnly:       info.Header.IsCgbOnly(),
+		SupportsSgb:   info.Header.SupportsSgb(),
+	}
+	emulator.romLoaded = true
+
+	return emulator.romInfo, nil
+}
+
+// RomInfo returns the loaded ROM's info, the zero RomInfo if no ROM is loaded.
+func (emulator *Emulator) RomInfo() RomInfo {
+	return emulator.romInfo
+}
+
+// Step runs the Game Boy for 1 M-cycle, 4 T-cycles, and reports whether a
+// frame was completed. The error is a *CpuLockedError once an illegal opcode
+// has hung the CPU, the rest of the Game Boy keeps running as on hardware.
+func (emulator *Emulator) Step() (frameReady bool, err error) {
+	if !emulator.romLoaded {
+		return false, ErrNoRom
+	}
+
+	_, frameReady, err = emulator.gb.Step()
+
+	return frameReady, err
+}
+
+// StepFrames runs the Game Boy until it has completed the given number of
+// frames, as fast as possible. While the LCD is off or the Game Boy is in STOP
+// mode, the length of a frame counts as one. The error is ErrInvalidFrameCount
+// unless frames is positive, and a *CpuLockedError once an illegal opcode has
+// hung the CPU, which stops it early.
+func (emulator *Emulator) StepFrames(frames int) error {
+	if !emulator.romLoaded {
+		return ErrNoRom
+	}
+	if frames <= 0 {
+		return ErrInvalidFrameCount
+	}
+
+	return emulator.gb.StepFrames(frames)
+}
+
+// Button is a button of the Game Boy, or a set of them.
+type Button uint8
+
+const (
+	ButtonRight Button = 1 << iota
+	ButtonLeft
+	ButtonUp
+	ButtonDown
+	ButtonA
+	ButtonB
+	ButtonSelect
+	ButtonStart
+)
+
+var joypadInputs = [8]joypad.JoypadInput{
+	joypad.JoypadInputRight,
+	joypad.JoypadInputLeft,
+	joypad.JoypadInputUp,
+	joypad.JoypadInputDown,
+	joypad.JoypadInputA,
+	joypad.JoypadInputB,
+	joypad.JoypadInputSelect,
+	joypad.JoypadInputStart,
+}
+
+// SetButtons holds the given buttons and releases all others.
+func (emulator *Emulator) SetButtons(buttons Button) {
+	emulator.gb.SetJoypadState(uint8(buttons))
+}
+
+// Press holds the given buttons, leaving the others as they are.
+func (emulator *Emulator) Press(buttons Button) {
+	for i, input := range joypadInputs {
+		if buttons&(1<<i) != 0 {
+			emulator.gb.PressJoypadInput(input)
+		}
+	}
+}
+
+// Release lets go of the given buttons, leaving the others as they are.
+func (emulator *Emulator) Release(buttons Button) {
+	for i, input := range joypadInputs {
+		if buttons&(1<<i) != 0 {
+			emulator.gb.ReleaseJoypadInput(input)
+		}
+	}
+}
+
+const (
+	ScreenWidth  = 160
+	ScreenHeight = 144
+)
+
+// Frame is a frame in the DMG's 4 shades, 0 for the lightest to 3 for the
+// darkest. In CGB mode the colors are converted to the nearest shade.
+type Frame [ScreenHeight][ScreenWidth]uint8
+
+// ColorFrame is a frame in RGB555: bits 0-4 red, 5-9 green and 10-14 blue. In
+// DMG mode the shades are shown in the colors of the model's screen.
+type ColorFrame [ScreenHeight][ScreenWidth]uint16
+
+// Frame returns the last completed frame in shades.
+func (emulator *Emulator) Frame() Frame {
+	return emulator.gb.FrameBuffer()
+}
+
+// ColorFrame returns the last completed frame in color.
+func (emulator *Emulator) ColorFrame() ColorFrame {
+	return emulator.gb.ColorFrameBuffer()
+}
+
+// SampleRate is the number of audio samples per second and channel.
+const SampleRate = 48_000
+
+// ReadSamples copies up to len(dst) of the audio samples generated since the
+// last call into dst, returning how many it copied. The samples are stereo,
+// interleaved left then right.
+func (emulator *Emulator) ReadSamples(dst []int16) int {
+	return emulator.gb.ReadSamples(dst)
+}
+
+// ReadMemory reads a byte from the CPU's address space, without side effects.
+func (emulator *Emulator) ReadMemory(address uint16) uint8 {
+	return emulator.gb.ReadMemory(address)
+}
+
+// SaveRam returns the battery backed RAM in the .sav layout, or nil if the
+// cartridge has no battery.
+func (emulator *Emulator) SaveRam() []byte {
+	return emulator.gb.CartridgeRam()
+}
+
+// LoadRam loads battery backed RAM in the .sav layout. The error is a
+// *SaveSizeError if the size doesn't fit the cartridge.
+func (emulator *Emulator) LoadRam(ram []byte) error {
+	if !emulator.romLoaded {
+		return ErrNoRom
+	}
+
+	return emulator.gb.SetCartridgeRam(ram)
+}
+
+// SaveState returns a snapshot of the whole Game Boy, exact to the M-cycle:
+// loading it resumes the CPU in the middle of an instruction if it was in one.
+func (emulator *Emulator) SaveState() ([]byte, error) {
+	if !emulator.romLoaded {
+		return nil, ErrNoRom
+	}
+
+	return emulator.gb.SerializeState(make([]byte, gameboy.StateBufferSize)), nil
+}
+
+// LoadState restores a snapshot taken by SaveState with the same ROM loaded.
+// The error is ErrInvalidState if the data isn't one, and the Game Boy is left
+// as it was.
+func (emulator *Emulator) LoadState(state []byte) error {
+	if !emulator.romLoaded {
+		return ErrNoRom
+	}
+
+	if err := emulator.gb.DeserializeState(state); err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidState, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,151 @@
+package luccagb
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/davidyorr/LuccaGB/tools"
+)
+
+func loadTestRom(t *testing.T, emulator *Emulator, romName string) {
+	t.Helper()
+
+	rom, err := os.ReadFile("../../roms/test/" + romName + ".gb")
+	if err != nil {
+		t.Fatal("Error reading file:", err)
+	}
+	if _, err := emulator.LoadRom(rom); err != nil {
+		t.Fatal("Error loading ROM:", err)
+	}
+}
+
+func TestStepFrames(t *testing.T) {
+	emulator := New(WithModel(ModelDmg))
+	loadTestRom(t, emulator, "boop/solid-color-1-background")
+
+	if err := emulator.StepFrames(1); err != nil {
+		t.Fatal(err)
+	}
+
+	const expectedHash = "fcbaf6ec8a002c189a1fa22a6c92b537d59ecb0eb54b833d614627b232f66f75"
+	if hash := tools.HashFrameBuffer(emulator.Frame()); hash != expectedHash {
+		t.Errorf("expected frame hash %s, got %s", expectedHash, hash)
+	}
+}
+
+// newTestRom returns a ROM only cartridge running code from the entry point.
+func newTestRom(code ...uint8) []uint8 {
+	rom := make([]uint8, 0x8000)
+	copy(rom[0x0100:], code)
+
+	return rom
+}
+
+func TestStepFramesLimits(t *testing.T) {
+	emulator := New(WithModel(ModelDmg))
+	loadTestRom(t, emulator, "boop/solid-color-1-background")
+	for _, frames := range []int{0, -1} {
+		if err := emulator.StepFrames(frames); !errors.Is(err, ErrInvalidFrameCount) {
+			t.Errorf("expected ErrInvalidFrameCount for %d frames, got %v", frames, err)
+		}
+	}
+
+	// XOR A, LDH (LCDC),A, JR -2: the LCD is off and no frames are drawn
+	if _, err := emulator.LoadRom(newTestRom(0xAF, 0xE0, 0x40, 0x18, 0xFE)); err != nil {
+		t.Fatal(err)
+	}
+	if err := emulator.StepFrames(3); err != nil {
+		t.Fatal(err)
+	}
+
+	// an illegal opcode hangs the CPU
+	if _, err := emulator.LoadRom(newTestRom(0xD3)); err != nil {
+		t.Fatal(err)
+	}
+	var lockedError *CpuLockedError
+	if err := emulator.StepFrames(1_000_000); !errors.As(err, &lockedError) {
+		t.Errorf("expected a *CpuLockedError, got %v", err)
+	}
+}
+
+func TestModelAuto(t *testing.T) {
+	emulator := New()
+	loadTestRom(t, emulator, "boop/solid-color-1-background")
+
+	if emulator.Model() != ModelDmg {
+		t.Errorf("expected the DMG, got %v", emulator.Model())
+	}
+}
+
+func TestLoadRomErrors(t *testing.T) {
+	emulator := New()
+
+	var truncated *TruncatedRomError
+	if _, err := emulator.LoadRom(make([]byte, 0x100)); !errors.As(err, &truncated) {
+		t.Errorf("expected a *TruncatedRomError, got %v", err)
+	}
+	if err := emulator.StepFrames(1); !errors.Is(err, ErrNoRom) {
+		t.Errorf("expected ErrNoRom, got %v", err)
+	}
+}
+
+func TestSaveAndLoadState(t *testing.T) {
+	emulator := New()
+	loadTestRom(t, emulator, "boop/sprite-8x8")
+
+	state, err := emulator.SaveState()
+	if err != nil {
+		t.Fatal(err)
+	}
+	emulator.StepFrames(3)
+	expected := emulator.Frame()
+
+	if err := emulator.LoadState(state); err != nil {
+		t.Fatal(err)
+	}
+	emulator.StepFrames(3)
+	if emulator.Frame() != expected {
+		t.Error("expected the same frame after loading the state")
+	}
+
+	if err := emulator.LoadState(state[:16]); !errors.Is(err, ErrInvalidState) {
+		t.Errorf("expected ErrInvalidState, got %v", err)
+	}
+}
+
+// the chunks of a save state, without the header and its timestamp
+func stateChunks(state []byte) []byte {
+	return state[bytes.Index(state, []byte("CPU ")):]
+}
+
+func TestLoadStateErrors(t *testing.T) {
+	emulator := New()
+	loadTestRom(t, emulator, "boop/sprite-8x8")
+	emulator.StepFrames(1)
+	state, err := emulator.SaveState()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	emulator.StepFrames(2)
+	before, _ := emulator.SaveState()
+
+	corrupted := append([]byte(nil), state...)
+	corrupted[len(corrupted)-1] ^= 0xFF
+	var formatError *StateFormatError
+	if err := emulator.LoadState(corrupted); !errors.As(err, &formatError) {
+		t.Errorf("expected a *StateFormatError, got %v", err)
+	}
+	if after, _ := emulator.SaveState(); !bytes.Equal(stateChunks(after), stateChunks(before)) {
+		t.Error("expected a failed load to leave the Game Boy as it was")
+	}
+
+	other := New()
+	loadTestRom(t, other, "boop/solid-color-1-background")
+	var romError *StateRomError
+	if err := other.LoadState(state); !errors.As(err, &romError) {
+		t.Errorf("expected a *StateRomError, got %v", err)
+	}
+}
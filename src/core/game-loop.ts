@@ -33,6 +33,8 @@ class GameLoop {
 			this.animationFrameId = undefined;
 			this.lastFrameTime = 0;
 		}
+
+		this._inputManager?.stopRumble();
 	}
 
 	public renderer() {
@@ -64,11 +66,27 @@ class GameLoop {
 		// run emulator steps
 		const tCyclesToAdd = this.SYSTEM_CLOCK_FREQUENCY * deltaSeconds;
 		this.tCycleAccumulator += tCyclesToAdd;
-		const { tCyclesUsed } = window.processEmulatorCycles(
+		const { tCyclesUsed, error } = window.processEmulatorCycles(
 			this.tCycleAccumulator,
 		);
 		this.tCycleAccumulator -= tCyclesUsed;
 
+		if (error) {
+			this.stop();
+			this.tCycleAccumulator = 0;
+			const frame = window.pollFrame();
+			if (frame) {
+				this._renderer!.drawFrame(frame);
+			}
+			alert(`Emulation stopped: ${error}`);
+			return;
+		}
+
+		// drive gamepad vibration
+		if (this._inputManager) {
+			this._inputManager.rumble(window.pollRumbleEvents());
+		}
+
 		// render video
 		const frame = window.pollFrame();
 		if (frame) {
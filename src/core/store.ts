@@ -26,8 +26,10 @@ export type State = {
 		isDebuggerOpen: boolean;
 		scale: number | "fit";
 		updatedAt?: number;
-		/** How many frames to save */
-		rewindBufferSize: number;
+		/** How much memory the rewind history may use */
+		rewindBufferMegabytes: number;
+		/** How many frames apart rewind snapshots are */
+		rewindFrameInterval: number;
 		/** How many frames to rewind per tick */
 		rewindIncrement: number;
 	};
@@ -44,7 +46,8 @@ const defaultSettings = {
 	audioChannelsEnabled: [false, true, true, true, true],
 	isDebuggerOpen: false,
 	scale: 3 as const,
-	rewindBufferSize: 600,
+	rewindBufferMegabytes: 16,
+	rewindFrameInterval: 1,
 	rewindIncrement: 1,
 };
 
@@ -97,7 +100,7 @@ const actions = {
 		setState("currentRomHash", hash);
 	},
 
-	setCartridgeInfo: (info: CartridgeInfo) => {
+	setCartridgeInfo: (info: CartridgeInfo | null) => {
 		setState("cartridgeInfo", info);
 	},
 
@@ -135,8 +138,12 @@ const actions = {
 		setState("ui", "isControlsOpen", isOpen);
 	},
 
-	setRewindBufferSize: (size: number) => {
-		setState("settings", "rewindBufferSize", size);
+	setRewindBufferMegabytes: (megabytes: number) => {
+		setState("settings", "rewindBufferMegabytes", megabytes);
+	},
+
+	setRewindFrameInterval: (frames: number) => {
+		setState("settings", "rewindFrameInterval", frames);
 	},
 
 	setRewindIncrement: (increment: number) => {
@@ -170,7 +177,7 @@ createEffect(
 			if (
 				paused &&
 				state.cartridgeInfo?.hasBattery &&
-				state.cartridgeInfo.ramSize > 0
+				state.cartridgeInfo.saveSize > 0
 			) {
 				const ram = window.getCartridgeRam();
 				persistCartridgeRam(state.currentRomHash, ram, {
@@ -220,10 +227,17 @@ createEffect(function pauseWhenFileInputOpen() {
 
 createEffect(
 	on(
-		() => [state.settings.rewindBufferSize, state.isRomLoaded],
-		([rewindBufferSize, isRomLoaded]) => {
+		() => [
+			state.settings.rewindBufferMegabytes,
+			state.settings.rewindFrameInterval,
+			state.isRomLoaded,
+		],
+		([rewindBufferMegabytes, rewindFrameInterval, isRomLoaded]) => {
 			if (isRomLoaded && window.setRewindBufferSize) {
-				window.setRewindBufferSize(rewindBufferSize as number);
+				window.setRewindBufferSize(
+					(rewindBufferMegabytes as number) * 1024 * 1024,
+				);
+				window.setRewindFrameInterval(rewindFrameInterval as number);
 			}
 		},
 	),
@@ -1,32 +1,77 @@
 declare global {
 	interface Window {
 		loadRom: (data: Uint8Array) => CartridgeInfo;
-		setCartridgeRam: (data: Uint8Array | null) => void;
+		/** boot ROM to run before the next loaded ROM, null to remove it */
+		setBootRom: (data: Uint8Array | null) => void;
+		setCartridgeRam: (data: Uint8Array | null) => string | null;
 		getCartridgeRam: () => Uint8Array;
 		processEmulatorCycles: (cycles: number) => {
 			tCyclesUsed: number;
+			/** why emulation can't continue, like the CPU locking up */
+			error: string | null;
 		};
 		pollFrame: () => Uint8Array;
 		pollAudioBuffer: () => Array<number>;
 		handleJoypadButtonPressed: (button: string) => void;
 		handleJoypadButtonReleased: (button: string) => void;
+		setTilt: (x: number, y: number) => void;
+		/** 128x112 greyscale bytes seen by the Pocket Camera */
+		setCameraFrame: (data: Uint8Array) => string | null;
 		enableTraceLogging: () => void;
 		disableTraceLogging: () => void;
 		getTraceLogs: () => Uint8Array;
 		getSerializedState: () => Uint8Array;
-		loadSerializedState: (data: Uint8Array) => void;
+		loadSerializedState: (data: Uint8Array) => string | null;
 		setAudioChannelEnabled: (channel: number, enabled: boolean) => void;
 		getAudioChannelEnabled: (channel: number) => boolean;
 		getDebugInfo: () => GameboyDebugInfo | null;
+		/** the memory budget of the rewind buffer in bytes */
 		setRewindBufferSize: (size: number) => boolean;
+		setRewindFrameInterval: (frames: number) => boolean;
 		rewindFrames: (frames: number) => number;
+		getRewindBufferUsage: () => RewindBufferUsage | null;
+		pollRumbleEvents: () => RumbleEvent[] | null;
 	}
 }
 
 export interface CartridgeInfo {
+	/** why the ROM can't be run, or null if it loaded */
+	error: string | null;
 	title: string;
 	ramSize: number;
 	hasBattery: boolean;
+	saveSize: number;
+	header: CartridgeHeader;
+}
+
+export interface CartridgeHeader {
+	title: string;
+	manufacturerCode: string;
+	cgbFlag: number;
+	sgbFlag: number;
+	licensee: string;
+	cartridgeType: number;
+	mapperName: string;
+	/** in bytes, 0 if the size code is unknown */
+	romSize: number;
+	/** in bytes */
+	ramSize: number;
+	version: number;
+	headerChecksumValid: boolean;
+	globalChecksumValid: boolean;
+	supported: boolean;
+}
+
+export interface RewindBufferUsage {
+	/** the bytes used by the snapshots */
+	bytes: number;
+	snapshots: number;
+}
+
+export interface RumbleEvent {
+	on: boolean;
+	/** T-cycles elapsed since power on when the motor was switched */
+	tCycle: number;
 }
 
 export interface GameboyDebugInfo {
@@ -1,3 +1,5 @@
+import type { RumbleEvent } from "../core/wasm";
+
 type Shortcut = {
 	keydown?: () => void;
 	keyup?: () => void;
@@ -39,6 +41,16 @@ export class InputManager {
 	// Map<Button, isPressed>
 	private emulatedButtonState: Map<string, boolean> = new Map();
 
+	// the tilt last sent to the emulator, taken from the left stick
+	private tilt = { x: 0, y: 0 };
+
+	// rumble motor state reported by the emulator
+	private rumbleOn = false;
+	private rumbleMagnitude = 0;
+	private rumbleRefreshedAt = 0;
+	// how long each vibration effect lasts, refreshed while the motor is on
+	private readonly RUMBLE_EFFECT_MS = 200;
+
 	constructor() {
 		this.joypadButtonToButtonIndex = Object.fromEntries(
 			Object.entries(this.buttonIndexToJoypadButton).map(([k, v]) => [
@@ -62,8 +74,7 @@ export class InputManager {
 		// Even if no gamepad is connected, we must run this loop
 		// to process the keyboard state captured in handleKeyDown/Up
 
-		const gamepads = navigator.getGamepads ? navigator.getGamepads() : [];
-		const gamepad = Array.from(gamepads).find((gamepad) => gamepad !== null);
+		const gamepad = this.connectedGamepad();
 
 		this.allInputs.forEach((action) => {
 			let gamepadPressed = false;
@@ -101,6 +112,79 @@ export class InputManager {
 
 			this.emulatedButtonState.set(action, isPressedNow);
 		});
+
+		// the left stick stands in for the accelerometer on tilt sensing carts
+		const x = gamepad?.axes[0] ?? 0;
+		const y = gamepad?.axes[1] ?? 0;
+		if (forceSync || x !== this.tilt.x || y !== this.tilt.y) {
+			window.setTilt(x, y);
+			this.tilt = { x, y };
+		}
+	}
+
+	/**
+	 * Drives the gamepad vibration from the rumble motor changes since the last
+	 * frame. Games control the strength by toggling the motor rapidly, so the
+	 * fraction of time it was on becomes the vibration magnitude.
+	 */
+	public rumble(events: RumbleEvent[] | null) {
+		let magnitude = this.rumbleOn ? 1 : 0;
+
+		if (events && events.length > 0) {
+			const first = events[0];
+			const last = events[events.length - 1];
+			const span = last.tCycle - first.tCycle;
+
+			let onCycles = 0;
+			for (let i = 1; i < events.length; i++) {
+				if (events[i - 1].on) {
+					onCycles += events[i].tCycle - events[i - 1].tCycle;
+				}
+			}
+
+			this.rumbleOn = last.on;
+			magnitude = span > 0 ? onCycles / span : this.rumbleOn ? 1 : 0;
+		}
+
+		const actuator = this.connectedGamepad()?.vibrationActuator;
+		if (!actuator) {
+			return;
+		}
+
+		if (magnitude === 0) {
+			if (this.rumbleRefreshedAt !== 0) {
+				actuator.reset();
+				this.rumbleRefreshedAt = 0;
+			}
+			return;
+		}
+
+		// keep re-triggering the effect before it runs out, or when the strength changes
+		const now = performance.now();
+		if (
+			magnitude !== this.rumbleMagnitude ||
+			now - this.rumbleRefreshedAt >= this.RUMBLE_EFFECT_MS / 2
+		) {
+			actuator.playEffect("dual-rumble", {
+				duration: this.RUMBLE_EFFECT_MS,
+				strongMagnitude: magnitude,
+				weakMagnitude: magnitude,
+			});
+			this.rumbleMagnitude = magnitude;
+			this.rumbleRefreshedAt = now;
+		}
+	}
+
+	public stopRumble() {
+		this.rumbleOn = false;
+		this.rumbleMagnitude = 0;
+		this.rumbleRefreshedAt = 0;
+		this.connectedGamepad()?.vibrationActuator?.reset();
+	}
+
+	private connectedGamepad() {
+		const gamepads = navigator.getGamepads ? navigator.getGamepads() : [];
+		return Array.from(gamepads).find((gamepad) => gamepad !== null);
 	}
 
 	public syncJoypadState() {
@@ -13,7 +13,7 @@ export function startRewind() {
 		return;
 	}
 
-	const currentBuffer = store.state.settings.rewindBufferSize;
+	const currentBuffer = store.state.settings.rewindBufferMegabytes;
 	if (currentBuffer === 0 || !window.rewindFrames) {
 		return;
 	}
@@ -25,21 +25,24 @@ export async function handleRomLoad(arrayBuffer: ArrayBuffer) {
 
 	// Load into Go
 	cartridgeInfo = window.loadRom(romData);
-	store.actions.setCartridgeInfo(cartridgeInfo);
 	console.log("Cartridge Info:", cartridgeInfo);
+	if (cartridgeInfo.error) {
+		store.actions.setRomLoaded(false);
+		store.actions.setCartridgeInfo(null);
+		alert(`Unable to load ROM: ${cartridgeInfo.error}`);
+		return;
+	}
+	store.actions.setCartridgeInfo(cartridgeInfo);
 
 	// Attempt to load existing RAM
-	if (cartridgeInfo.hasBattery && cartridgeInfo.ramSize > 0) {
+	if (cartridgeInfo.hasBattery && cartridgeInfo.saveSize > 0) {
 		try {
 			const ram = await loadCartridgeRam(store.state.currentRomHash);
 			if (ram) {
-				// Ensure the loaded RAM size matches what the cartridge expects
-				if (ram.length !== cartridgeInfo.ramSize) {
-					console.warn(
-						`Save file size mismatch. Expected ${cartridgeInfo.ramSize}, got ${ram.length}`,
-					);
+				const error = window.setCartridgeRam(ram);
+				if (error) {
+					console.warn(`Failed to load save data: ${error}`);
 				}
-				window.setCartridgeRam(ram);
 			}
 		} catch (e) {
 			console.error("Failed to load save data:", e);
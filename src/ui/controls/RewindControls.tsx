@@ -1,12 +1,49 @@
-import type { Component } from "solid-js";
+import {
+	createSignal,
+	onCleanup,
+	onMount,
+	Show,
+	type Component,
+} from "solid-js";
 import styles from "./RewindControls.module.css";
 import { store } from "../../core/store";
+import type { RewindBufferUsage } from "../../core/wasm";
 
 export const RewindControls: Component = () => {
+	const [usage, setUsage] = createSignal<RewindBufferUsage | null>(null);
+
+	onMount(() => {
+		const pollUsage = () => setUsage(window.getRewindBufferUsage?.() ?? null);
+		pollUsage();
+		const interval = setInterval(pollUsage, 1000);
+		onCleanup(() => clearInterval(interval));
+	});
+
+	// The frames of history the budget holds once full, estimated from the
+	// average size of the snapshots taken so far
+	const historyFrames = () => {
+		const current = usage();
+		if (!current || current.snapshots === 0) {
+			return null;
+		}
+		const bytesPerSnapshot = current.bytes / current.snapshots;
+		const budget = store.state.settings.rewindBufferMegabytes * 1024 * 1024;
+		return (
+			Math.floor(budget / bytesPerSnapshot) *
+			store.state.settings.rewindFrameInterval
+		);
+	};
+
 	const handleBufferSizeChange = (event: Event) => {
 		const target = event.target as HTMLInputElement;
 		const value = Math.max(0, Number.parseInt(target.value) || 0);
-		store.actions.setRewindBufferSize(value);
+		store.actions.setRewindBufferMegabytes(value);
+	};
+
+	const handleFrameIntervalChange = (event: Event) => {
+		const target = event.target as HTMLInputElement;
+		const value = Math.max(1, Number.parseInt(target.value) || 1);
+		store.actions.setRewindFrameInterval(value);
 	};
 
 	const handleIncrementChange = (event: Event) => {
@@ -23,18 +60,32 @@ export const RewindControls: Component = () => {
 	return (
 		<div class={styles.rewindControl}>
 			<div class={styles.inputGroup}>
-				<label for="rewind-buffer-size">Rewind History Buffer (frames):</label>
+				<label for="rewind-buffer-size">Rewind History Buffer (MB):</label>
 				<input
 					type="number"
 					id="rewind-buffer-size"
 					class={styles.numberInput}
 					min="0"
-					value={store.state.settings.rewindBufferSize}
+					value={store.state.settings.rewindBufferMegabytes}
 					onInput={handleBufferSizeChange}
 				/>
-				<span class={styles.timeValue}>
-					~{displaySeconds(store.state.settings.rewindBufferSize)}s
-				</span>
+				<Show when={historyFrames() !== null}>
+					<span class={styles.timeValue} title="History the buffer holds">
+						~{displaySeconds(historyFrames() as number)}s
+					</span>
+				</Show>
+			</div>
+
+			<div class={styles.inputGroup}>
+				<label for="rewind-frame-interval">Rewind Granularity (frames):</label>
+				<input
+					type="number"
+					id="rewind-frame-interval"
+					class={styles.numberInput}
+					min="1"
+					value={store.state.settings.rewindFrameInterval}
+					onInput={handleFrameIntervalChange}
+				/>
 			</div>
 
 			<div class={styles.inputGroup}>
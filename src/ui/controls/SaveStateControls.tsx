@@ -42,7 +42,11 @@ export const SaveStateControls = () => {
 				return;
 			}
 
-			window.loadSerializedState(stateData);
+			const error = window.loadSerializedState(stateData);
+			if (error) {
+				alert(`Unable to load save state: ${error}`);
+				return;
+			}
 			updateDebugger();
 		} catch (error) {
 			console.error("Load state error:", error);
@@ -96,8 +96,11 @@ func main() {
 		die(fmt.Errorf("failed to read ROM: %w", err))
 	}
 
-	gb := gameboy.New()
-	gb.LoadRom(romData)
+	// the test cases are recorded on a DMG
+	gb := gameboy.New(gameboy.ModelDmg)
+	if _, err := gb.LoadRom(romData); err != nil {
+		die(fmt.Errorf("failed to load ROM: %w", err))
+	}
 
 	for f := 1; f <= *maxFrames; f++ {
 		// Run until one frame is ready